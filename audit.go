@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"github.com/google/uuid"
+)
+
+var auditHeader = []string{"TIME", "ACTOR", "ACTION", "RECORD", "RECORD ID", "USER"}
+
+func auditRow(entry *db.AuditEntry) []string {
+	return []string{
+		entry.Time.Format(time.RFC3339),
+		entry.Actor,
+		string(entry.Action),
+		string(entry.Record),
+		entry.RecordID,
+		entry.Username,
+	}
+}
+
+// parseSince as either a duration before now, an RFC 3339 timestamp or a date
+// starting at midnight in the default time zone
+func (c *cli) parseSince(since string) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+
+	if ago, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+
+	timeZone, err := c.config.DefaultTimeZone()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid default time zone %s: %w", timeZone, err)
+	}
+
+	t, err := time.ParseInLocation(resumeDateLayout, since, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since '%s', must be a duration, a %s date or an RFC 3339 timestamp", since, resumeDateLayout)
+	}
+
+	return t, nil
+}
+
+// filterAuditUser of filter to the entries of the user with the given ID or
+// current name, or by the name at the time of the change for a user that was
+// removed since
+func filterAuditUser(b db.Store, filter *db.AuditFilter, user string) error {
+	if id, err := uuid.Parse(user); err == nil {
+		filter.IDUser = id
+		return nil
+	}
+
+	existing, err := b.GetUser(user)
+	if errors.Is(err, db.ErrNotFound) {
+		filter.Username = user
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	filter.IDUser = existing.ID
+
+	return nil
+}
+
+var auditCommand = &command{
+	name:    "audit",
+	summary: "Inspect the audit trail of every change to users, devices, medications and doses",
+	subcommands: []*command{
+		{
+			name:    "list",
+			summary: "List audit entries oldest first, use -output json for the records before and after each change",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				user := flagSet.String("user", "", "only list entries of the user with this ID or name, or of a removed user by its name at the time")
+				since := flagSet.String("since", "", "only list entries since a duration ago (e.g. 24h), a date (2006-01-02) or an RFC 3339 timestamp")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					sinceTime, err := c.parseSince(*since)
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					filter := db.AuditFilter{Since: sinceTime}
+					if *user != "" {
+						err = filterAuditUser(b, &filter, *user)
+						if err != nil {
+							return err
+						}
+					}
+
+					entries, err := b.ListAuditEntries(filter)
+					if err != nil {
+						return err
+					}
+
+					if entries == nil {
+						entries = []*db.AuditEntry{}
+					}
+
+					rows := make([][]string, 0, len(entries))
+					for _, entry := range entries {
+						rows = append(rows, auditRow(entry))
+					}
+
+					return c.print(*output, entries, auditHeader, rows)
+				}
+			},
+		},
+	},
+}
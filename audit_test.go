@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"github.com/google/uuid"
+)
+
+// listAudit entries of the user through the CLI
+func listAudit(t *testing.T, user string) []*db.AuditEntry {
+	t.Helper()
+
+	c, stdout := newTestCLI(t, "")
+
+	b, err := c.db()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := addUser(t, b)
+	bob := &db.User{ID: uuid.New(), Name: "bob"}
+
+	err = b.AddUser(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.RenameUser(alice, "alicia")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.RemoveUser(bob, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runCLI(c, "audit", "list", "-user", user, "-output", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []*db.AuditEntry
+	err = json.Unmarshal(stdout.Bytes(), &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entries
+}
+
+func TestAuditListUser(t *testing.T) {
+	// a user's current name matches all of its entries, a name no user has
+	// anymore the entries made while a user had it
+	for user, expected := range map[string]int{
+		"ALICIA": 2,
+		"alice":  1,
+		"bob":    2,
+	} {
+		entries := listAudit(t, user)
+		if len(entries) != expected {
+			t.Fatalf("expected %d audit entries of user %s, got %d", expected, user, len(entries))
+		}
+
+		for _, entry := range entries {
+			if entry.Actor != "tester" {
+				t.Fatalf("expected the entries to be made by tester, got %s", entry.Actor)
+			}
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/backup"
+	"git.0xdad.com/tblyler/meditime/config"
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/metrics"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+var backupHeader = []string{"NAME", "CHECKSUM"}
+
+type backupResult struct {
+	Name     string   `json:"name"`
+	Checksum string   `json:"checksum"`
+	Pruned   []string `json:"pruned,omitempty"`
+}
+
+// backupTarget configured to keep backups in, the S3 bucket when one is set
+// and the backup directory otherwise
+func backupTarget(config config.Config) (backup.Target, error) {
+	bucket, err := config.BackupS3Bucket()
+	if err != nil {
+		return nil, err
+	}
+
+	if bucket != "" {
+		endpoint, err := config.BackupS3Endpoint()
+		if err != nil {
+			return nil, err
+		}
+
+		prefix, err := config.BackupS3Prefix()
+		if err != nil {
+			return nil, err
+		}
+
+		accessKeyID, err := config.BackupS3AccessKeyID()
+		if err != nil {
+			return nil, err
+		}
+
+		secretAccessKey, err := config.BackupS3SecretAccessKey()
+		if err != nil {
+			return nil, err
+		}
+
+		return backup.NewS3(endpoint, bucket, prefix, accessKeyID, secretAccessKey)
+	}
+
+	dir, err := config.BackupDir()
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == "" {
+		return nil, errors.New("no backup target is configured, set a backup dir or S3 bucket")
+	}
+
+	return backup.Dir(dir), nil
+}
+
+// backupSource of the badger database, its backups are encrypted with the
+// encryption key of the database so they don't leave the host as plaintext
+func backupSource(config config.Config, b *db.Badger) (backup.Source, error) {
+	key, err := config.EncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey, err := db.ParseEncryptionKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encryptionKey) == 0 {
+		return b, nil
+	}
+
+	return backup.Encrypted(b, encryptionKey), nil
+}
+
+// backupKeep returns the configured number of backups to keep
+func backupKeep(config config.Config) (int, error) {
+	keep, err := config.BackupKeep()
+	if err != nil {
+		return 0, err
+	}
+
+	if keep < 1 {
+		return 0, fmt.Errorf("invalid backup keep %d, must be at least 1", keep)
+	}
+
+	return keep, nil
+}
+
+// fileTarget of a backup file given on the command line, along with its name
+func fileTarget(file string) (backup.Target, string) {
+	return backup.Dir(filepath.Dir(file)), filepath.Base(file)
+}
+
+// backupBadger returns the badger database of the CLI, which is the only
+// store that can be backed up
+func (c *cli) backupBadger() (*db.Badger, error) {
+	store, err := c.db()
+	if err != nil {
+		return nil, err
+	}
+
+	b, ok := db.Unwrap(store).(*db.Badger)
+	if !ok {
+		return nil, fmt.Errorf("only the %s store can be backed up", storeBadger)
+	}
+
+	return b, nil
+}
+
+// scheduledBackup takes a backup of source to target and prunes all but the
+// newest keep backups
+func scheduledBackup(ctx context.Context, source backup.Source, target backup.Target, keep int) {
+	name := backup.Name(time.Now())
+	entry := logger.WithFields(logrus.Fields{
+		"backup": name,
+		"target": fmt.Sprint(target),
+	})
+
+	checksum, err := backup.Create(ctx, source, target, name)
+	if err != nil {
+		metrics.Backups.WithLabelValues("failure").Inc()
+		entry.WithError(err).Error("failed to backup database")
+		return
+	}
+
+	metrics.Backups.WithLabelValues("success").Inc()
+	metrics.LastBackup.SetToCurrentTime()
+	entry.WithField("checksum", checksum).Info("backed up database")
+
+	pruned, err := backup.Prune(ctx, target, keep)
+	if err != nil {
+		entry.WithError(err).Error("failed to prune backups")
+		return
+	}
+
+	for _, name := range pruned {
+		entry.WithField("pruned", name).Info("pruned backup")
+	}
+}
+
+// scheduleBackups adds the configured backup schedule to scheduler, if any
+func scheduleBackups(ctx context.Context, config config.Config, scheduler *cron.Cron, health *health, store db.Store) error {
+	schedule, err := config.BackupSchedule()
+	if err != nil {
+		return err
+	}
+
+	if schedule == "" {
+		return nil
+	}
+
+	b, ok := db.Unwrap(store).(*db.Badger)
+	if !ok {
+		return fmt.Errorf("scheduled backups are only supported by the %s store", storeBadger)
+	}
+
+	source, err := backupSource(config, b)
+	if err != nil {
+		return err
+	}
+
+	target, err := backupTarget(config)
+	if err != nil {
+		return err
+	}
+
+	keep, err := backupKeep(config)
+	if err != nil {
+		return err
+	}
+
+	var entryID cron.EntryID
+	entryID, err = scheduler.AddFunc(schedule, func() {
+		health.fired(entryID, time.Now())
+		scheduledBackup(ctx, source, target, keep)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid backup schedule '%s': %w", schedule, err)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"cron_entry_id": entryID,
+		"crontab":       schedule,
+		"target":        fmt.Sprint(target),
+		"keep":          keep,
+	}).Info("scheduled backups")
+
+	health.watch(entryID, scheduler.Entry(entryID).Schedule, "database backup")
+
+	return nil
+}
+
+var backupCommand = &command{
+	name:    "backup",
+	summary: "Manage backups of the badger database",
+	subcommands: []*command{
+		{
+			name:    "create",
+			summary: "Backup the database to the configured target and prune old backups",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				file := flagSet.String("file", "", "file to write the backup to instead of the configured target, a .sha256 checksum file is written next to it")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					b, err := c.backupBadger()
+					if err != nil {
+						return err
+					}
+
+					source, err := backupSource(c.config, b)
+					if err != nil {
+						return err
+					}
+
+					var target backup.Target
+					var keep int
+					name := backup.Name(time.Now())
+					if *file != "" {
+						target, name = fileTarget(*file)
+					} else {
+						target, err = backupTarget(c.config)
+						if err != nil {
+							return err
+						}
+
+						// checked before backing up so an invalid keep doesn't
+						// leave a backup that is never pruned
+						keep, err = backupKeep(c.config)
+						if err != nil {
+							return err
+						}
+					}
+
+					checksum, err := backup.Create(c.ctx, source, target, name)
+					if err != nil {
+						return err
+					}
+
+					result := backupResult{
+						Name:     name,
+						Checksum: checksum,
+					}
+
+					if *file == "" {
+						result.Pruned, err = backup.Prune(c.ctx, target, keep)
+						if err != nil {
+							return err
+						}
+					}
+
+					return c.print(*output, result, backupHeader, [][]string{{name, checksum}})
+				}
+			},
+		},
+		{
+			name:    "list",
+			summary: "List the scheduled backups in the configured target, oldest first",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				output := outputFlag(flagSet)
+
+				return func() error {
+					target, err := backupTarget(c.config)
+					if err != nil {
+						return err
+					}
+
+					names, err := backup.List(c.ctx, target)
+					if err != nil {
+						return err
+					}
+
+					rows := make([][]string, 0, len(names))
+					for _, name := range names {
+						rows = append(rows, []string{name})
+					}
+
+					return c.print(*output, names, []string{"NAME"}, rows)
+				}
+			},
+		},
+		{
+			name:    "verify",
+			summary: "Verify a backup matches its checksum",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				name := flagSet.String("name", "", "name of the backup in the configured target, the newest when neither -name or -file is given")
+				file := flagSet.String("file", "", "backup file to verify instead of one in the configured target")
+
+				return func() error {
+					target, name, err := c.findBackup(*name, *file)
+					if err != nil {
+						return err
+					}
+
+					err = backup.Verify(c.ctx, target, name)
+					if err != nil {
+						return err
+					}
+
+					fmt.Fprintf(c.stdout, "backup %s is intact\n", name)
+
+					return nil
+				}
+			},
+		},
+	},
+}
+
+var restoreCommand = &command{
+	name:    "restore",
+	summary: "Restore a verified backup into an empty badger database",
+	setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+		name := flagSet.String("name", "", "name of the backup in the configured target, the newest when neither -name or -file is given")
+		file := flagSet.String("file", "", "backup file to restore instead of one in the configured target")
+		keyFile := flagSet.String("key-file", "", "file with the hex encoded key the backup was encrypted with, the configured encryption key when empty")
+
+		return func() error {
+			target, name, err := c.findBackup(*name, *file)
+			if err != nil {
+				return err
+			}
+
+			key, err := c.encryptionKey()
+			if err != nil {
+				return err
+			}
+
+			if *keyFile != "" {
+				key, err = readEncryptionKeyFile(*keyFile)
+				if err != nil {
+					return err
+				}
+			}
+
+			b, err := c.unmigratedDB()
+			if err != nil {
+				return err
+			}
+
+			err = backup.Restore(c.ctx, target, name, func(r io.Reader) error {
+				decrypted, err := backup.Decrypt(r, key)
+				if err != nil {
+					return err
+				}
+
+				return b.Restore(decrypted)
+			})
+			if err != nil {
+				return err
+			}
+
+			version, err := b.SchemaVersion()
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(c.stdout, "restored backup %s at schema version %s\n", name, strconv.FormatUint(version, 10))
+
+			return nil
+		}
+	},
+}
+
+// findBackup given by the -name or -file flag, defaulting to the newest
+// scheduled backup in the configured target
+func (c *cli) findBackup(name, file string) (backup.Target, string, error) {
+	if name != "" && file != "" {
+		return nil, "", errors.New("only one of -name or -file may be given")
+	}
+
+	if file != "" {
+		target, name := fileTarget(file)
+		return target, name, nil
+	}
+
+	target, err := backupTarget(c.config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if name != "" {
+		return target, name, nil
+	}
+
+	names, err := backup.List(c.ctx, target)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(names) == 0 {
+		return nil, "", fmt.Errorf("no backups in %v", target)
+	}
+
+	return target, names[len(names)-1], nil
+}
@@ -0,0 +1,234 @@
+// Package backup writes database backups to a target, verifies them with a
+// SHA-256 checksum kept next to each backup and restores them
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	namePrefix     = "meditime-"
+	nameSuffix     = ".badger"
+	nameTimeLayout = "20060102T150405Z"
+	// ChecksumSuffix of the file holding the SHA-256 checksum of a backup
+	ChecksumSuffix = ".sha256"
+)
+
+// ErrChecksumMismatch occurs when a backup doesn't match its checksum
+var ErrChecksumMismatch = errors.New("backup doesn't match its checksum")
+
+// Target stores backups by name
+type Target interface {
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// List the names of everything in the target
+	List(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Source of backups, e.g. *db.Badger
+type Source interface {
+	Backup(w io.Writer) error
+}
+
+// Name of a scheduled backup taken at t, which sorts by time
+func Name(t time.Time) string {
+	return namePrefix + t.UTC().Format(nameTimeLayout) + nameSuffix
+}
+
+// Create a backup of source named name in target along with its checksum and
+// verify it by reading it back, returning the checksum
+func Create(ctx context.Context, source Source, target Target, name string) (string, error) {
+	f, err := ioutil.TempFile("", "meditime-backup-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary backup file: %w", err)
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+
+	err = source.Backup(io.MultiWriter(f, hash))
+	if err != nil {
+		return "", err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	err = target.Put(ctx, name, f, size)
+	if err != nil {
+		return "", fmt.Errorf("failed to store backup %s: %w", name, err)
+	}
+
+	// the same format as sha256sum so backups can be checked without meditime
+	checksumFile := checksum + "  " + name + "\n"
+
+	err = target.Put(ctx, name+ChecksumSuffix, strings.NewReader(checksumFile), int64(len(checksumFile)))
+	if err != nil {
+		return "", fmt.Errorf("failed to store checksum of backup %s: %w", name, err)
+	}
+
+	err = Verify(ctx, target, name)
+	if err != nil {
+		return "", err
+	}
+
+	return checksum, nil
+}
+
+// Verify that the backup named name in target matches its checksum
+func Verify(ctx context.Context, target Target, name string) error {
+	return read(ctx, target, name, func(r io.Reader) error {
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	})
+}
+
+// Restore the backup named name in target with load once it is verified
+func Restore(ctx context.Context, target Target, name string, load func(r io.Reader) error) error {
+	f, err := ioutil.TempFile("", "meditime-restore-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary restore file: %w", err)
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	err = read(ctx, target, name, func(r io.Reader) error {
+		_, err := io.Copy(f, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return load(f)
+}
+
+// read the backup named name from target with fn, failing with
+// ErrChecksumMismatch once all of it was read when it doesn't match its
+// checksum
+func read(ctx context.Context, target Target, name string, fn func(r io.Reader) error) error {
+	expected, err := readChecksum(ctx, target, name)
+	if err != nil {
+		return err
+	}
+
+	r, err := target.Get(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to get backup %s: %w", name, err)
+	}
+
+	defer r.Close()
+
+	hash := sha256.New()
+
+	err = fn(io.TeeReader(r, hash))
+	if err != nil {
+		return fmt.Errorf("failed to read backup %s: %w", name, err)
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("backup %s has checksum %s, expected %s: %w", name, actual, expected, ErrChecksumMismatch)
+	}
+
+	return nil
+}
+
+func readChecksum(ctx context.Context, target Target, name string) (string, error) {
+	r, err := target.Get(ctx, name+ChecksumSuffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to get checksum of backup %s: %w", name, err)
+	}
+
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, 1024))
+	if err != nil {
+		return "", fmt.Errorf("failed to read checksum of backup %s: %w", name, err)
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum of backup %s is empty", name)
+	}
+
+	return fields[0], nil
+}
+
+// List the scheduled backups in target, oldest first
+func List(ctx context.Context, target Target) ([]string, error) {
+	names, err := target.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var backups []string
+	for _, name := range names {
+		if strings.HasPrefix(name, namePrefix) && strings.HasSuffix(name, nameSuffix) {
+			backups = append(backups, name)
+		}
+	}
+
+	sort.Strings(backups)
+
+	return backups, nil
+}
+
+// Prune all but the newest keep scheduled backups from target, returning the
+// names of the removed backups
+func Prune(ctx context.Context, target Target, keep int) ([]string, error) {
+	if keep < 1 {
+		return nil, fmt.Errorf("invalid number of backups to keep %d, must be at least 1", keep)
+	}
+
+	backups, err := List(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(backups) <= keep {
+		return nil, nil
+	}
+
+	pruned := backups[:len(backups)-keep]
+	for _, name := range pruned {
+		err = target.Delete(ctx, name+ChecksumSuffix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete checksum of backup %s: %w", name, err)
+		}
+
+		err = target.Delete(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete backup %s: %w", name, err)
+		}
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,149 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/backup"
+)
+
+// source backing up data
+type source []byte
+
+func (s source) Backup(w io.Writer) error {
+	_, err := w.Write(s)
+	return err
+}
+
+// testTarget creates, verifies, restores and prunes backups in target,
+// tamper replaces the stored contents of a backup
+func testTarget(t *testing.T, target backup.Target, tamper func(name string, data []byte)) {
+	ctx := context.Background()
+	start := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	// created out of order to check they are listed and pruned by time
+	var names []string
+	for _, i := range []int{2, 0, 3, 1} {
+		name := backup.Name(start.Add(time.Hour * time.Duration(i)))
+		names = append(names, name)
+
+		checksum, err := backup.Create(ctx, source("backup "+name), target, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(checksum) != 64 {
+			t.Fatalf("expected a hex SHA-256 checksum, got %s", checksum)
+		}
+	}
+
+	// not a scheduled backup, so it is neither listed nor pruned
+	err := target.Put(ctx, "manual.badger", bytes.NewReader([]byte("manual")), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err := backup.List(ctx, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldest := []string{names[1], names[3], names[0], names[2]}
+	if !reflect.DeepEqual(listed, oldest) {
+		t.Fatalf("expected the backups oldest first %v, got %v", oldest, listed)
+	}
+
+	var restored []byte
+	err = backup.Restore(ctx, target, names[0], func(r io.Reader) error {
+		restored, err = ioutil.ReadAll(r)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(restored) != "backup "+names[0] {
+		t.Fatalf("expected the backup to be restored, got %q", restored)
+	}
+
+	_, err = backup.Prune(ctx, target, 0)
+	if err == nil {
+		t.Fatal("expected pruning every backup to fail")
+	}
+
+	pruned, err := backup.Prune(ctx, target, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(pruned, oldest[:2]) {
+		t.Fatalf("expected the oldest backups %v to be pruned, got %v", oldest[:2], pruned)
+	}
+
+	remaining, err := target.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		"manual.badger":                   true,
+		oldest[2]:                         true,
+		oldest[2] + backup.ChecksumSuffix: true,
+		oldest[3]:                         true,
+		oldest[3] + backup.ChecksumSuffix: true,
+	}
+
+	if len(remaining) != len(expected) {
+		t.Fatalf("expected %d files to remain, got %v", len(expected), remaining)
+	}
+
+	for _, name := range remaining {
+		if !expected[name] {
+			t.Fatalf("expected %s to be pruned, got %v", name, remaining)
+		}
+	}
+
+	tamper(oldest[3], []byte("tampered backup"))
+
+	err = backup.Verify(ctx, target, oldest[3])
+	if !errors.Is(err, backup.ErrChecksumMismatch) {
+		t.Fatalf("expected verifying a tampered backup to fail with %v, got %v", backup.ErrChecksumMismatch, err)
+	}
+
+	loaded := false
+	err = backup.Restore(ctx, target, oldest[3], func(r io.Reader) error {
+		loaded = true
+		return nil
+	})
+	if !errors.Is(err, backup.ErrChecksumMismatch) {
+		t.Fatalf("expected restoring a tampered backup to fail with %v, got %v", backup.ErrChecksumMismatch, err)
+	}
+
+	if loaded {
+		t.Fatal("expected a tampered backup to not be loaded")
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+
+	testTarget(t, backup.Dir(filepath.Join(dir, "backups")), func(name string, data []byte) {
+		err := ioutil.WriteFile(filepath.Join(dir, "backups", name), data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	_, err := backup.Dir(dir).Get(context.Background(), "missing")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected getting a missing backup to fail, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Dir Target of a local directory
+type Dir string
+
+// Put a file in the directory, which only appears once all of it is written
+func (d Dir) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	err := os.MkdirAll(string(d), 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(string(d), "."+name+".")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), d.path(name))
+}
+
+// Get a file from the directory
+func (d Dir) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(d.path(name))
+}
+
+// List the files in the directory
+func (d Dir) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// Delete a file from the directory
+func (d Dir) Delete(ctx context.Context, name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d Dir) path(name string) string {
+	return filepath.Join(string(d), filepath.Base(name))
+}
+
+func (d Dir) String() string {
+	return fmt.Sprintf("directory %s", string(d))
+}
@@ -0,0 +1,276 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// encryptedMagic starts every encrypted backup
+	encryptedMagic = "meditime-backup-aes-gcm-1\n"
+	saltSize       = 32
+	// chunkSize of the plaintext sealed at a time
+	chunkSize = 64 << 10
+)
+
+var (
+	// ErrEncrypted occurs when restoring an encrypted backup without a key
+	ErrEncrypted = errors.New("backup is encrypted, an encryption key is required")
+	// ErrDecrypt occurs when an encrypted backup can't be decrypted with the
+	// key, either because it is the wrong key or the backup was altered
+	ErrDecrypt = errors.New("failed to decrypt backup, the key doesn't match or the backup was altered")
+)
+
+// encryptedSource writes the backups of a source encrypted with a key
+type encryptedSource struct {
+	source Source
+	key    []byte
+}
+
+// Encrypted source whose backups are encrypted with key, an AES key of 16, 24
+// or 32 bytes. Every backup is encrypted with its own key derived from key,
+// in chunks sealed with AES-GCM so altered or truncated backups fail to
+// decrypt.
+func Encrypted(source Source, key []byte) Source {
+	return &encryptedSource{
+		source: source,
+		key:    key,
+	}
+}
+
+func (e *encryptedSource) Backup(w io.Writer) error {
+	salt := make([]byte, saltSize)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return fmt.Errorf("failed to generate backup salt: %w", err)
+	}
+
+	aead, err := backupCipher(e.key, salt)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, encryptedMagic)
+	if err == nil {
+		_, err = w.Write(salt)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	chunks := &chunkWriter{w: w, aead: aead}
+
+	err = e.source.Backup(chunks)
+	if err != nil {
+		return err
+	}
+
+	return chunks.close()
+}
+
+// backupCipher of a backup with its own key derived from key and the salt
+func backupCipher(key, salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(salt)
+
+	block, err := aes.NewCipher(mac.Sum(nil)[:len(key)])
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup encryption key: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce of the nth chunk, the last chunk is marked so a backup can't be
+// truncated at a chunk boundary
+func chunkNonce(aead cipher.AEAD, n uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[1:9], n)
+	if last {
+		nonce[0] = 1
+	}
+
+	return nonce
+}
+
+// chunkWriter seals what is written to it chunk by chunk, each chunk is
+// written as its big endian uint32 length followed by the sealed chunk
+type chunkWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	n     uint64
+	chunk []byte
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more follows, the last one is
+		// sealed by close
+		if len(c.chunk) == chunkSize {
+			err := c.seal(false)
+			if err != nil {
+				return written, err
+			}
+		}
+
+		n := chunkSize - len(c.chunk)
+		if n > len(p) {
+			n = len(p)
+		}
+
+		c.chunk = append(c.chunk, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (c *chunkWriter) seal(last bool) error {
+	sealed := c.aead.Seal(nil, chunkNonce(c.aead, c.n, last), c.chunk, nil)
+
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(sealed)))
+
+	_, err := c.w.Write(header)
+	if err == nil {
+		_, err = c.w.Write(sealed)
+	}
+
+	c.n++
+	c.chunk = c.chunk[:0]
+
+	return err
+}
+
+func (c *chunkWriter) close() error {
+	return c.seal(true)
+}
+
+// Decrypt a backup that was written by an Encrypted source with key, a
+// backup that isn't encrypted is read as it is. Decrypting fails with
+// ErrDecrypt once an altered chunk is read, so what was read should only be
+// used once all of it was read without an error.
+func Decrypt(r io.Reader, key []byte) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+
+	magic, err := buffered.Peek(len(encryptedMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	if !bytes.Equal(magic, []byte(encryptedMagic)) {
+		return buffered, nil
+	}
+
+	if len(key) == 0 {
+		return nil, ErrEncrypted
+	}
+
+	_, err = buffered.Discard(len(encryptedMagic))
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	_, err = io.ReadFull(buffered, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup salt: %w", ErrDecrypt)
+	}
+
+	aead, err := backupCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunkReader{r: buffered, aead: aead}, nil
+}
+
+// chunkReader opens the chunks written by chunkWriter
+type chunkReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	n     uint64
+	chunk []byte
+	done  bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+
+		err := c.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+
+	return n, nil
+}
+
+func (c *chunkReader) open() error {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(c.r, header)
+	if err != nil {
+		return fmt.Errorf("failed to read chunk %d: %w", c.n, ErrDecrypt)
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > chunkSize+uint32(c.aead.Overhead()) {
+		return fmt.Errorf("chunk %d is too large: %w", c.n, ErrDecrypt)
+	}
+
+	sealed := make([]byte, size)
+	_, err = io.ReadFull(c.r, sealed)
+	if err != nil {
+		return fmt.Errorf("failed to read chunk %d: %w", c.n, ErrDecrypt)
+	}
+
+	// whether it is the last chunk is only known by which nonce opens it
+	for _, last := range []bool{false, true} {
+		c.chunk, err = c.aead.Open(nil, chunkNonce(c.aead, c.n, last), sealed, nil)
+		if err == nil {
+			c.done = last
+			c.n++
+
+			if last {
+				return c.checkEnd()
+			}
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed to open chunk %d: %w", c.n, ErrDecrypt)
+}
+
+// checkEnd of the backup after its last chunk
+func (c *chunkReader) checkEnd() error {
+	_, err := io.ReadFull(c.r, make([]byte, 1))
+	switch {
+	case err == nil:
+		return fmt.Errorf("data after the last chunk: %w", ErrDecrypt)
+
+	case errors.Is(err, io.EOF):
+		return nil
+
+	default:
+		return err
+	}
+}
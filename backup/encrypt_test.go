@@ -0,0 +1,110 @@
+package backup_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"git.0xdad.com/tblyler/meditime/backup"
+)
+
+func encrypt(t *testing.T, data, key []byte) []byte {
+	t.Helper()
+
+	encrypted := &bytes.Buffer{}
+
+	err := backup.Encrypted(source(data), key).Backup(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encrypted.Bytes()
+}
+
+func decrypt(data, key []byte) ([]byte, error) {
+	r, err := backup.Decrypt(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+func TestEncrypted(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	// spans several chunks, ending in a partial one
+	data := make([]byte, 200<<10)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	for _, size := range []int{0, 10, 64 << 10, len(data)} {
+		encrypted := encrypt(t, data[:size], key)
+		if size > 0 && bytes.Contains(encrypted, data[:size]) {
+			t.Fatalf("expected a backup of %d bytes to be encrypted", size)
+		}
+
+		decrypted, err := decrypt(encrypted, key)
+		if err != nil {
+			t.Fatalf("failed to decrypt a backup of %d bytes: %v", size, err)
+		}
+
+		if !bytes.Equal(decrypted, data[:size]) {
+			t.Fatalf("expected a backup of %d bytes to decrypt to what was backed up", size)
+		}
+	}
+
+	encrypted := encrypt(t, data, key)
+	if bytes.Equal(encrypted, encrypt(t, data, key)) {
+		t.Fatal("expected every backup to be encrypted with its own key")
+	}
+
+	_, err := decrypt(encrypted, bytes.Repeat([]byte{0x43}, 32))
+	if !errors.Is(err, backup.ErrDecrypt) {
+		t.Fatalf("expected decrypting with the wrong key to fail with %v, got %v", backup.ErrDecrypt, err)
+	}
+
+	_, err = decrypt(encrypted, nil)
+	if !errors.Is(err, backup.ErrEncrypted) {
+		t.Fatalf("expected decrypting without a key to fail with %v, got %v", backup.ErrEncrypted, err)
+	}
+
+	altered := append([]byte{}, encrypted...)
+	altered[len(altered)/2] ^= 1
+
+	_, err = decrypt(altered, key)
+	if !errors.Is(err, backup.ErrDecrypt) {
+		t.Fatalf("expected decrypting an altered backup to fail with %v, got %v", backup.ErrDecrypt, err)
+	}
+
+	// an empty backup is the header and an empty last chunk, both chunks
+	// are preceded by their length and sealed with a 16 byte tag
+	header := len(encrypt(t, nil, key)) - 4 - 16
+	first := header + 4 + 64<<10 + 16
+	for _, truncated := range [][]byte{encrypted[:len(encrypted)-1], encrypted[:first]} {
+		_, err = decrypt(truncated, key)
+		if !errors.Is(err, backup.ErrDecrypt) {
+			t.Fatalf("expected decrypting a backup truncated to %d bytes to fail with %v, got %v", len(truncated), backup.ErrDecrypt, err)
+		}
+	}
+}
+
+func TestDecryptPlaintext(t *testing.T) {
+	for _, data := range []string{"", "short", "a plaintext backup taken before backups were encrypted"} {
+		r, err := backup.Decrypt(bytes.NewReader([]byte(data)), bytes.Repeat([]byte{0x42}, 16))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(decrypted) != data {
+			t.Fatalf("expected a plaintext backup to be read as it is, got %q", decrypted)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3 Target of a bucket on an S3 compatible endpoint, e.g. AWS or MinIO
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 target for the bucket on the endpoint, an http or https URL, keeping
+// the backups under prefix
+func NewS3(endpoint, bucket, prefix, accessKeyID, secretAccessKey string) (*S3, error) {
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %s: %w", endpoint, err)
+	}
+
+	if endpointURL.Scheme != "http" && endpointURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint %s, must be an http or https URL", endpoint)
+	}
+
+	client, err := minio.New(endpointURL.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
+		Secure: endpointURL.Scheme == "https",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client for %s: %w", endpoint, err)
+	}
+
+	return &S3{
+		client: client,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+	}, nil
+}
+
+func (s *S3) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+// Put an object in the bucket
+func (s *S3) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(name), r, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+
+	return err
+}
+
+// Get an object from the bucket
+func (s *S3) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// GetObject is lazy, stat it so a missing object fails here
+	_, err = object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+
+	return object, nil
+}
+
+// List the objects under the prefix in the bucket
+func (s *S3) List(ctx context.Context) ([]string, error) {
+	prefix := s.prefix
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var names []string
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		names = append(names, strings.TrimPrefix(object.Key, prefix))
+	}
+
+	return names, nil
+}
+
+// Delete an object from the bucket
+func (s *S3) Delete(ctx context.Context, name string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.key(name), minio.RemoveObjectOptions{})
+}
+
+func (s *S3) String() string {
+	return fmt.Sprintf("S3 bucket %s at %s", s.bucket, s.client.EndpointURL().String())
+}
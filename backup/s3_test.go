@@ -0,0 +1,206 @@
+package backup_test
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/backup"
+)
+
+// fakeS3 is an S3 endpoint serving a single bucket from memory, enough of
+// the API for the S3 target
+type fakeS3 struct {
+	bucket  string
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+type listBucketResult struct {
+	XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	MaxKeys     int
+	IsTruncated bool
+	Contents    []listedObject
+}
+
+type listedObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := r.URL.Path[1:], ""
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+
+	if bucket != f.bucket {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, location := r.URL.Query()["location"]
+
+	switch {
+	case key == "" && location:
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r.URL.Query().Get("prefix"))
+
+	case r.Method == http.MethodPut:
+		data, err := readPayload(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f.objects[key] = data
+		w.Header().Set("ETag", `"etag"`)
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, `<Error><Code>NoSuchKey</Code><Message>no such key</Message><Key>%s</Key></Error>`, key)
+			}
+
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	result := listBucketResult{
+		Name:    f.bucket,
+		Prefix:  prefix,
+		MaxKeys: 1000,
+	}
+
+	for key, data := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, listedObject{
+				Key:          key,
+				LastModified: time.Now().UTC().Format(time.RFC3339),
+				ETag:         `"etag"`,
+				Size:         len(data),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// readPayload of a put request, which is chunked with a signature per chunk
+// when it is streamed
+func readPayload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return ioutil.ReadAll(r.Body)
+	}
+
+	var data []byte
+	body := bufio.NewReader(r.Body)
+	for {
+		header, err := body.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(header), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk header %q: %w", header, err)
+		}
+
+		chunk := make([]byte, size+2)
+		_, err = io.ReadFull(body, chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return data, nil
+		}
+
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func TestS3(t *testing.T) {
+	fake := &fakeS3{
+		bucket:  "backups",
+		objects: make(map[string][]byte),
+	}
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	target, err := backup.NewS3(server.URL, "backups", "/meditime/", "access", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTarget(t, target, func(name string, data []byte) {
+		fake.lock.Lock()
+		defer fake.lock.Unlock()
+
+		fake.objects["meditime/"+name] = data
+	})
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	for key := range fake.objects {
+		if !strings.HasPrefix(key, "meditime/") {
+			t.Fatalf("expected every object to be kept under the prefix, got %s", key)
+		}
+	}
+
+	_, err = backup.NewS3("ftp://example.com", "backups", "", "access", "secret")
+	if err == nil {
+		t.Fatal("expected an endpoint that isn't http or https to fail")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.0xdad.com/tblyler/meditime/backup"
+	"git.0xdad.com/tblyler/meditime/config"
+)
+
+// backupConfig of an encrypted badger store backed up to a directory
+type backupConfig struct {
+	config.Defaults
+	badgerPath    string
+	backupDir     string
+	encryptionKey string
+	keep          int
+}
+
+func (c *backupConfig) BadgerPath() (string, error) {
+	return c.badgerPath, nil
+}
+
+func (c *backupConfig) EncryptionKey() (string, error) {
+	return c.encryptionKey, nil
+}
+
+func (c *backupConfig) BackupDir() (string, error) {
+	return c.backupDir, nil
+}
+
+func (c *backupConfig) BackupKeep() (int, error) {
+	return c.keep, nil
+}
+
+func (c *backupConfig) Actor() (string, error) {
+	return "tester", nil
+}
+
+func runBackupCLI(t *testing.T, config *backupConfig, args ...string) error {
+	t.Helper()
+
+	c := newCLI(context.Background(), config)
+	c.stdout = &bytes.Buffer{}
+	c.stderr = &bytes.Buffer{}
+	c.interactive = false
+	defer c.close()
+
+	return runCLI(c, args...)
+}
+
+func TestEncryptedBackup(t *testing.T) {
+	dir := t.TempDir()
+	config := &backupConfig{
+		badgerPath:    filepath.Join(dir, "db"),
+		backupDir:     filepath.Join(dir, "backups"),
+		encryptionKey: hex.EncodeToString(bytes.Repeat([]byte{0x42}, 32)),
+		keep:          0,
+	}
+
+	c := newCLI(context.Background(), config)
+	b, err := c.db()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addUser(t, b)
+	c.close()
+
+	err = runBackupCLI(t, config, "backup", "create")
+	if err == nil || !strings.Contains(err.Error(), "keep") {
+		t.Fatalf("expected backing up without keeping any backup to fail, got %v", err)
+	}
+
+	// the backup directory is only created by the first backup
+	names, _ := backup.List(context.Background(), backup.Dir(config.backupDir))
+	if len(names) != 0 {
+		t.Fatalf("expected no backup to be taken with an invalid keep, got %v", names)
+	}
+
+	config.keep = 1
+
+	err = runBackupCLI(t, config, "backup", "create")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err = backup.List(context.Background(), backup.Dir(config.backupDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 1 {
+		t.Fatalf("expected a single backup, got %v", names)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(config.backupDir, names[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(data, []byte("alice")) {
+		t.Fatal("expected the backup of an encrypted database to be encrypted")
+	}
+
+	plaintext := *config
+	plaintext.badgerPath = filepath.Join(dir, "plaintext")
+	plaintext.encryptionKey = ""
+
+	err = runBackupCLI(t, &plaintext, "restore")
+	if !errors.Is(err, backup.ErrEncrypted) {
+		t.Fatalf("expected restoring without a key to fail with %v, got %v", backup.ErrEncrypted, err)
+	}
+
+	keyFile := filepath.Join(dir, "key")
+	err = ioutil.WriteFile(keyFile, []byte(config.encryptionKey+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := *config
+	restored.badgerPath = filepath.Join(dir, "restored")
+
+	err = runBackupCLI(t, &restored, "restore", "-key-file", keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = newCLI(context.Background(), &restored)
+	defer c.close()
+
+	b, err = c.db()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.GetUser("alice")
+	if err != nil {
+		t.Fatalf("expected the restored database to have the backed up user: %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/config"
+	"git.0xdad.com/tblyler/meditime/db"
+	"golang.org/x/term"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+
+	storeBadger = "badger"
+	storeSQLite = "sqlite"
+	storeMemory = "memory"
+)
+
+// command of the CLI, either a group of subcommands or a leaf with an action
+type command struct {
+	name        string
+	summary     string
+	subcommands []*command
+	// flags of a group command to include in its usage
+	flags *flag.FlagSet
+	// setup registers the flags of a leaf command and returns its action
+	setup func(c *cli, flagSet *flag.FlagSet) func() error
+}
+
+// cli state shared by every command
+type cli struct {
+	ctx         context.Context
+	config      config.Config
+	stdin       *bufio.Scanner
+	stdout      io.Writer
+	stderr      io.Writer
+	interactive bool
+	store       db.Store
+	audited     *db.Audited
+	// actor changes are attributed to instead of the configured one
+	actor string
+}
+
+func newCLI(ctx context.Context, config config.Config) *cli {
+	return &cli{
+		ctx:         ctx,
+		config:      config,
+		stdin:       bufio.NewScanner(os.Stdin),
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
+		interactive: term.IsTerminal(int(os.Stdin.Fd())),
+	}
+}
+
+// db opens the configured store on first use, recording every change made
+// through it in the audit trail
+func (c *cli) db() (db.Store, error) {
+	if c.audited != nil {
+		return c.audited, nil
+	}
+
+	store, err := c.openStore()
+	if err != nil {
+		return nil, err
+	}
+
+	actor := c.actor
+	if actor == "" {
+		actor, err = c.config.Actor()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.audited = db.NewAudited(store, actor)
+
+	return c.audited, nil
+}
+
+// openStore opens the configured store on first use, migrating it when it is
+// badger
+func (c *cli) openStore() (db.Store, error) {
+	if c.store != nil {
+		return c.store, nil
+	}
+
+	store, err := c.config.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	switch store {
+	case storeBadger:
+		return c.openBadger(db.NewBadger)
+
+	case storeSQLite:
+		sqlitePath, err := c.config.SQLitePath()
+		if err != nil {
+			return nil, err
+		}
+
+		c.store, err = db.NewSQLite(sqlitePath)
+		if err != nil {
+			return nil, err
+		}
+
+		return c.store, nil
+
+	case storeMemory:
+		c.store = db.NewMemory()
+
+		return c.store, nil
+
+	default:
+		return nil, fmt.Errorf("invalid store %s, must be %s, %s or %s", store, storeBadger, storeSQLite, storeMemory)
+	}
+}
+
+// unmigratedDB opens the badger database on first use without migrating it
+func (c *cli) unmigratedDB() (*db.Badger, error) {
+	store, err := c.config.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	if store != storeBadger {
+		return nil, fmt.Errorf("only the %s store has schema migrations, the configured store is %s", storeBadger, store)
+	}
+
+	return c.openBadger(db.NewUnmigratedBadger)
+}
+
+func (c *cli) openBadger(newBadger func(dbPath string, gcInterval time.Duration, encryptionKey []byte) (*db.Badger, error)) (*db.Badger, error) {
+	badgerPath, err := c.config.BadgerPath()
+	if err != nil {
+		return nil, err
+	}
+
+	gcInterval, err := c.config.GCInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey, err := c.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := newBadger(badgerPath, gcInterval, encryptionKey)
+	switch {
+	case errors.Is(err, db.ErrLegacyFormat):
+		return nil, fmt.Errorf("%w, run 'meditime db upgrade' to convert it", err)
+
+	case errors.Is(err, db.ErrWrongEncryptionKey):
+		return nil, fmt.Errorf("%w, check the configured encryption key or that the database was encrypted with 'meditime db encrypt'", err)
+
+	case errors.Is(err, db.ErrLocked):
+		return nil, fmt.Errorf("%w, stop 'meditime run' first or configure the %s store, which commands can use while the daemon runs", err, storeSQLite)
+
+	case err != nil:
+		return nil, err
+	}
+
+	c.store = b
+
+	return b, nil
+}
+
+// encryptionKey of the badger database, nil when it isn't encrypted
+func (c *cli) encryptionKey() ([]byte, error) {
+	key, err := c.config.EncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return db.ParseEncryptionKey(key)
+}
+
+func (c *cli) close() error {
+	if c.store == nil {
+		return nil
+	}
+
+	return c.store.Close()
+}
+
+// prompt for the value of a flag that was not given, which is only allowed
+// when STDIN is a terminal
+func (c *cli) prompt(value *string, flagName, label string) error {
+	if *value != "" {
+		return nil
+	}
+
+	if !c.interactive {
+		return fmt.Errorf("the -%s flag is required when STDIN is not a terminal", flagName)
+	}
+
+	fmt.Fprintf(c.stdout, "%s: ", label)
+	c.stdin.Scan()
+
+	*value = strings.TrimSpace(c.stdin.Text())
+	if *value == "" {
+		if err := c.stdin.Err(); err != nil {
+			return fmt.Errorf("failed to get %s from STDIN prompt: %w", label, err)
+		}
+
+		return fmt.Errorf("no %s given at STDIN prompt", label)
+	}
+
+	return nil
+}
+
+// promptUser for the username flag and look the user up
+func (c *cli) promptUser(username *string) (*db.User, error) {
+	err := c.prompt(username, "username", "username")
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.db()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := b.GetUser(*username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup username %s: %w", *username, err)
+	}
+
+	return user, nil
+}
+
+// outputFlag registers the -output flag on a command's flag set
+func outputFlag(flagSet *flag.FlagSet) *string {
+	return flagSet.String("output", outputTable, "output format, either table or json")
+}
+
+// print value as JSON or the header and rows as an aligned table
+func (c *cli) print(output string, value interface{}, header []string, rows [][]string) error {
+	switch output {
+	case outputJSON:
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(value)
+
+	case outputTable:
+		tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+
+		return tw.Flush()
+
+	default:
+		return fmt.Errorf("invalid output format %s, must be %s or %s", output, outputTable, outputJSON)
+	}
+}
+
+// execute the command found by walking args down the command tree
+func (cmd *command) execute(c *cli, path []string, args []string) error {
+	path = append(path[:len(path):len(path)], cmd.name)
+
+	if cmd.setup != nil {
+		flagSet := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+		flagSet.SetOutput(c.stderr)
+
+		action := cmd.setup(c, flagSet)
+		flagSet.Usage = func() {
+			fmt.Fprintf(flagSet.Output(), "%s\n\nUsage: %s [flags]\n\nFlags:\n", cmd.summary, strings.Join(path, " "))
+			flagSet.PrintDefaults()
+		}
+
+		err := flagSet.Parse(args)
+		if err != nil {
+			return err
+		}
+
+		if flagSet.NArg() > 0 {
+			flagSet.Usage()
+			return fmt.Errorf("unexpected arguments: %s", strings.Join(flagSet.Args(), " "))
+		}
+
+		return action()
+	}
+
+	if len(args) == 0 {
+		cmd.usage(c.stderr, path)
+		return fmt.Errorf("%s requires a subcommand", strings.Join(path, " "))
+	}
+
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		cmd.usage(c.stdout, path)
+		return flag.ErrHelp
+	}
+
+	for _, subcommand := range cmd.subcommands {
+		if subcommand.name == args[0] {
+			return subcommand.execute(c, path, args[1:])
+		}
+	}
+
+	cmd.usage(c.stderr, path)
+
+	return fmt.Errorf("unknown command %s %s", strings.Join(path, " "), args[0])
+}
+
+// usage of a group command found at path in the command tree
+func (cmd *command) usage(w io.Writer, path []string) {
+	fmt.Fprintf(w, "%s\n\nUsage: %s <command> [flags]\n\nCommands:\n", cmd.summary, strings.Join(path, " "))
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, subcommand := range cmd.subcommands {
+		fmt.Fprintf(tw, "  %s\t%s\n", subcommand.name, subcommand.summary)
+	}
+	tw.Flush()
+
+	if cmd.flags != nil {
+		fmt.Fprintln(w, "\nFlags:")
+		cmd.flags.SetOutput(w)
+		cmd.flags.PrintDefaults()
+	}
+
+	fmt.Fprintf(w, "\nRun '%s <command> -help' for more information on a command.\n", strings.Join(path, " "))
+}
+
+// exitError of a command that exits with a specific code rather than 1
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// isHelp reports whether err is only the result of asking for help
+func isHelp(err error) bool {
+	return errors.Is(err, flag.ErrHelp)
+}
@@ -1,7 +1,51 @@
 package config
 
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotSet occurs when a Config implementation has no value for a setting
+	ErrNotSet = errors.New("config value is not set")
+)
+
 // Config for application setup
 type Config interface {
+	// Reload values that are cached by the implementation, e.g. a config file
+	Reload() error
+	Store() (string, error)
 	BadgerPath() (string, error)
+	EncryptionKey() (string, error)
+	Actor() (string, error)
+	SQLitePath() (string, error)
 	PushoverAPIToken() (string, error)
+	PushoverURL() (string, error)
+	PushoverTimeout() (time.Duration, error)
+	PushoverValidateInterval() (time.Duration, error)
+	ListenAddress() (string, error)
+	AdminPushoverDeviceToken() (string, error)
+	LogLevel() (string, error)
+	LogFormat() (string, error)
+	DefaultTimeZone() (string, error)
+	GCInterval() (time.Duration, error)
+	NotificationRetry() (time.Duration, error)
+	NotificationExpire() (time.Duration, error)
+	NotificationSound() (string, error)
+	BackupSchedule() (string, error)
+	BackupDir() (string, error)
+	BackupKeep() (int, error)
+	BackupS3Endpoint() (string, error)
+	BackupS3Bucket() (string, error)
+	BackupS3Prefix() (string, error)
+	BackupS3AccessKeyID() (string, error)
+	BackupS3SecretAccessKey() (string, error)
+	QueueWorkers() (int, error)
+	QueueMaxAttempts() (int, error)
+	QueueBackoff() (time.Duration, error)
+	QueueMaxBackoff() (time.Duration, error)
+	QuotaWarnThresholds() (string, error)
+	QuotaReserve() (int, error)
+	QuotaPolicy() (string, error)
+	QuotaFallbackURL() (string, error)
 }
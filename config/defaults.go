@@ -0,0 +1,241 @@
+package config
+
+import (
+	"fmt"
+	"os/user"
+	"time"
+)
+
+const (
+	// DefaultStore backend
+	DefaultStore = "badger"
+	// DefaultListenAddress for the HTTP server
+	DefaultListenAddress = ":8080"
+	// DefaultLogLevel of the logger
+	DefaultLogLevel = "info"
+	// DefaultLogFormat of the logger
+	DefaultLogFormat = "text"
+	// DefaultDefaultTimeZone for medication schedules
+	DefaultDefaultTimeZone = "Local"
+	// DefaultGCInterval between badger value log garbage collections
+	DefaultGCInterval = time.Hour
+	// DefaultPushoverURL of the pushover API
+	DefaultPushoverURL = "https://api.pushover.net/1/"
+	// DefaultPushoverTimeout of every request to the pushover API
+	DefaultPushoverTimeout = time.Second * 10
+	// DefaultPushoverValidateInterval between validations of every device
+	// token
+	DefaultPushoverValidateInterval = time.Hour * 24
+	// DefaultNotificationRetry interval of unacknowledged reminders
+	DefaultNotificationRetry = time.Minute * 5
+	// DefaultNotificationExpire duration after which unacknowledged reminders stop retrying
+	DefaultNotificationExpire = time.Hour * 24
+	// DefaultBackupKeep number of the newest scheduled backups to keep
+	DefaultBackupKeep = 7
+	// DefaultBackupS3Endpoint is AWS S3
+	DefaultBackupS3Endpoint = "https://s3.amazonaws.com"
+	// DefaultQueueWorkers delivering queued notifications concurrently
+	DefaultQueueWorkers = 4
+	// DefaultQueueMaxAttempts to deliver a queued notification before it is
+	// dead-lettered
+	DefaultQueueMaxAttempts = 10
+	// DefaultQueueBackoff before the first retry of a queued notification
+	DefaultQueueBackoff = time.Second * 15
+	// DefaultQueueMaxBackoff between retries of a queued notification
+	DefaultQueueMaxBackoff = time.Minute * 10
+	// DefaultQuotaWarnThresholds of the used pushover monthly quota in percent
+	// the admin device is warned at
+	DefaultQuotaWarnThresholds = "75,90,100"
+	// DefaultQuotaReserve of pushover messages kept for critical reminders
+	// and alerts once the quota policy applies
+	DefaultQuotaReserve = 250
+	// DefaultQuotaPolicy keeps sending non-critical reminders through pushover
+	DefaultQuotaPolicy = "send"
+	// DefaultActor when the operating system user is unknown
+	DefaultActor = "unknown"
+)
+
+// Defaults Config implementation for settings with sensible default values
+type Defaults struct {
+}
+
+// Reload is a no-op as defaults never change
+func (d *Defaults) Reload() error {
+	return nil
+}
+
+// Store backend of the users, medications and doses
+func (d *Defaults) Store() (string, error) {
+	return DefaultStore, nil
+}
+
+// SQLitePath has no default
+func (d *Defaults) SQLitePath() (string, error) {
+	return "", fmt.Errorf("sqlite path has no default: %w", ErrNotSet)
+}
+
+// BadgerPath has no default
+func (d *Defaults) BadgerPath() (string, error) {
+	return "", fmt.Errorf("badger path has no default: %w", ErrNotSet)
+}
+
+// PushoverAPIToken has no default
+func (d *Defaults) PushoverAPIToken() (string, error) {
+	return "", fmt.Errorf("pushover API token has no default: %w", ErrNotSet)
+}
+
+// ListenAddress for the HTTP server
+func (d *Defaults) ListenAddress() (string, error) {
+	return DefaultListenAddress, nil
+}
+
+// AdminPushoverDeviceToken is empty by default so alerts are only logged
+func (d *Defaults) AdminPushoverDeviceToken() (string, error) {
+	return "", nil
+}
+
+// LogLevel of the logger
+func (d *Defaults) LogLevel() (string, error) {
+	return DefaultLogLevel, nil
+}
+
+// LogFormat of the logger
+func (d *Defaults) LogFormat() (string, error) {
+	return DefaultLogFormat, nil
+}
+
+// DefaultTimeZone for medication schedules
+func (d *Defaults) DefaultTimeZone() (string, error) {
+	return DefaultDefaultTimeZone, nil
+}
+
+// GCInterval between badger value log garbage collections
+func (d *Defaults) GCInterval() (time.Duration, error) {
+	return DefaultGCInterval, nil
+}
+
+// PushoverURL of the pushover API
+func (d *Defaults) PushoverURL() (string, error) {
+	return DefaultPushoverURL, nil
+}
+
+// PushoverTimeout of every request to the pushover API
+func (d *Defaults) PushoverTimeout() (time.Duration, error) {
+	return DefaultPushoverTimeout, nil
+}
+
+// PushoverValidateInterval between validations of every device token, zero
+// disables them
+func (d *Defaults) PushoverValidateInterval() (time.Duration, error) {
+	return DefaultPushoverValidateInterval, nil
+}
+
+// NotificationRetry interval of unacknowledged reminders
+func (d *Defaults) NotificationRetry() (time.Duration, error) {
+	return DefaultNotificationRetry, nil
+}
+
+// NotificationExpire duration after which unacknowledged reminders stop retrying
+func (d *Defaults) NotificationExpire() (time.Duration, error) {
+	return DefaultNotificationExpire, nil
+}
+
+// NotificationSound of reminders is left to the pushover default
+func (d *Defaults) NotificationSound() (string, error) {
+	return "", nil
+}
+
+// BackupSchedule is empty by default so backups are only taken on demand
+func (d *Defaults) BackupSchedule() (string, error) {
+	return "", nil
+}
+
+// BackupDir is empty by default so backups are kept in S3 or nowhere
+func (d *Defaults) BackupDir() (string, error) {
+	return "", nil
+}
+
+// BackupKeep number of the newest scheduled backups to keep
+func (d *Defaults) BackupKeep() (int, error) {
+	return DefaultBackupKeep, nil
+}
+
+// BackupS3Endpoint is AWS S3
+func (d *Defaults) BackupS3Endpoint() (string, error) {
+	return DefaultBackupS3Endpoint, nil
+}
+
+// BackupS3Bucket is empty by default so backups are not kept in S3
+func (d *Defaults) BackupS3Bucket() (string, error) {
+	return "", nil
+}
+
+// BackupS3Prefix is empty by default so backups are kept at the bucket's root
+func (d *Defaults) BackupS3Prefix() (string, error) {
+	return "", nil
+}
+
+// BackupS3AccessKeyID has no default
+func (d *Defaults) BackupS3AccessKeyID() (string, error) {
+	return "", fmt.Errorf("backup S3 access key ID has no default: %w", ErrNotSet)
+}
+
+// BackupS3SecretAccessKey has no default
+func (d *Defaults) BackupS3SecretAccessKey() (string, error) {
+	return "", fmt.Errorf("backup S3 secret access key has no default: %w", ErrNotSet)
+}
+
+// EncryptionKey is empty by default so the badger database is plaintext
+func (d *Defaults) EncryptionKey() (string, error) {
+	return "", nil
+}
+
+// Actor is the operating system user running meditime
+func (d *Defaults) Actor() (string, error) {
+	current, err := user.Current()
+	if err != nil {
+		return DefaultActor, nil
+	}
+
+	return current.Username, nil
+}
+
+// QueueWorkers delivering queued notifications concurrently
+func (d *Defaults) QueueWorkers() (int, error) {
+	return DefaultQueueWorkers, nil
+}
+
+// QueueMaxAttempts to deliver a queued notification before it is dead-lettered
+func (d *Defaults) QueueMaxAttempts() (int, error) {
+	return DefaultQueueMaxAttempts, nil
+}
+
+// QueueBackoff before the first retry of a queued notification, doubled after every failed attempt
+func (d *Defaults) QueueBackoff() (time.Duration, error) {
+	return DefaultQueueBackoff, nil
+}
+
+// QueueMaxBackoff between retries of a queued notification
+func (d *Defaults) QueueMaxBackoff() (time.Duration, error) {
+	return DefaultQueueMaxBackoff, nil
+}
+
+// QuotaWarnThresholds of the used pushover monthly quota in percent
+func (d *Defaults) QuotaWarnThresholds() (string, error) {
+	return DefaultQuotaWarnThresholds, nil
+}
+
+// QuotaReserve of pushover messages below which the quota policy applies
+func (d *Defaults) QuotaReserve() (int, error) {
+	return DefaultQuotaReserve, nil
+}
+
+// QuotaPolicy for non-critical reminders once the pushover quota runs low
+func (d *Defaults) QuotaPolicy() (string, error) {
+	return DefaultQuotaPolicy, nil
+}
+
+// QuotaFallbackURL has no default, the fallback policy requires one
+func (d *Defaults) QuotaFallbackURL() (string, error) {
+	return "", nil
+}
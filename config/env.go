@@ -4,31 +4,103 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
 const (
+	// FileEnvSuffix of env variables holding a path to read a secret from
+	// instead of the secret itself, e.g. PUSHOVER_API_TOKEN_FILE
+	FileEnvSuffix = "_FILE"
+
+	// ConfigFileEnv name
+	ConfigFileEnv = "CONFIG_FILE"
+	// StoreEnv name
+	StoreEnv = "STORE"
+	// SQLitePathEnv name
+	SQLitePathEnv = "SQLITE_PATH"
 	// BadgerPathEnv name
 	BadgerPathEnv = "BADGER_PATH"
+	// EncryptionKeyEnv name
+	EncryptionKeyEnv = "ENCRYPTION_KEY"
+	// ActorEnv name
+	ActorEnv = "ACTOR"
 	// PushoverAPITokenEnv name
 	PushoverAPITokenEnv = "PUSHOVER_API_TOKEN"
+	// PushoverURLEnv name
+	PushoverURLEnv = "PUSHOVER_URL"
+	// PushoverTimeoutEnv name
+	PushoverTimeoutEnv = "PUSHOVER_TIMEOUT"
+	// PushoverValidateIntervalEnv name
+	PushoverValidateIntervalEnv = "PUSHOVER_VALIDATE_INTERVAL"
+	// ListenAddressEnv name
+	ListenAddressEnv = "LISTEN_ADDRESS"
+	// AdminPushoverDeviceTokenEnv name
+	AdminPushoverDeviceTokenEnv = "ADMIN_PUSHOVER_DEVICE_TOKEN"
+	// LogLevelEnv name
+	LogLevelEnv = "LOG_LEVEL"
+	// LogFormatEnv name
+	LogFormatEnv = "LOG_FORMAT"
+	// DefaultTimeZoneEnv name
+	DefaultTimeZoneEnv = "DEFAULT_TIME_ZONE"
+	// GCIntervalEnv name
+	GCIntervalEnv = "GC_INTERVAL"
+	// NotificationRetryEnv name
+	NotificationRetryEnv = "NOTIFICATION_RETRY"
+	// NotificationExpireEnv name
+	NotificationExpireEnv = "NOTIFICATION_EXPIRE"
+	// NotificationSoundEnv name
+	NotificationSoundEnv = "NOTIFICATION_SOUND"
+	// BackupScheduleEnv name
+	BackupScheduleEnv = "BACKUP_SCHEDULE"
+	// BackupDirEnv name
+	BackupDirEnv = "BACKUP_DIR"
+	// BackupKeepEnv name
+	BackupKeepEnv = "BACKUP_KEEP"
+	// BackupS3EndpointEnv name
+	BackupS3EndpointEnv = "BACKUP_S3_ENDPOINT"
+	// BackupS3BucketEnv name
+	BackupS3BucketEnv = "BACKUP_S3_BUCKET"
+	// BackupS3PrefixEnv name
+	BackupS3PrefixEnv = "BACKUP_S3_PREFIX"
+	// BackupS3AccessKeyIDEnv name
+	BackupS3AccessKeyIDEnv = "BACKUP_S3_ACCESS_KEY_ID"
+	// BackupS3SecretAccessKeyEnv name
+	BackupS3SecretAccessKeyEnv = "BACKUP_S3_SECRET_ACCESS_KEY"
+	// QueueWorkersEnv name
+	QueueWorkersEnv = "QUEUE_WORKERS"
+	// QueueMaxAttemptsEnv name
+	QueueMaxAttemptsEnv = "QUEUE_MAX_ATTEMPTS"
+	// QueueBackoffEnv name
+	QueueBackoffEnv = "QUEUE_BACKOFF"
+	// QueueMaxBackoffEnv name
+	QueueMaxBackoffEnv = "QUEUE_MAX_BACKOFF"
+	// QuotaWarnThresholdsEnv name
+	QuotaWarnThresholdsEnv = "QUOTA_WARN_THRESHOLDS"
+	// QuotaReserveEnv name
+	QuotaReserveEnv = "QUOTA_RESERVE"
+	// QuotaPolicyEnv name
+	QuotaPolicyEnv = "QUOTA_POLICY"
+	// QuotaFallbackURLEnv name
+	QuotaFallbackURLEnv = "QUOTA_FALLBACK_URL"
 )
 
 var (
 	// ErrEnvVariableNotSet occurs when an environment variable is not set
-	ErrEnvVariableNotSet = errors.New("environment variable is not set")
+	ErrEnvVariableNotSet = fmt.Errorf("environment variable is not set: %w", ErrNotSet)
 )
 
 // Env variable Config implementation
 type Env struct {
 }
 
-// BadgerPath for the database directory
-func (e *Env) BadgerPath() (string, error) {
-	val, ok := os.LookupEnv(BadgerPathEnv)
+func (e *Env) lookup(name, description string) (string, error) {
+	val, ok := os.LookupEnv(name)
 	if !ok {
 		return "", fmt.Errorf(
-			"unable to get badger path from env variable %s: %w",
-			BadgerPathEnv,
+			"unable to get %s from env variable %s: %w",
+			description,
+			name,
 			ErrEnvVariableNotSet,
 		)
 	}
@@ -36,16 +108,228 @@ func (e *Env) BadgerPath() (string, error) {
 	return val, nil
 }
 
-// PushoverAPIToken getter
-func (e *Env) PushoverAPIToken() (string, error) {
-	val, ok := os.LookupEnv(PushoverAPITokenEnv)
+// lookupSecret from the named env variable or from the file at the path in the
+// env variable of the same name with FileEnvSuffix appended
+func (e *Env) lookupSecret(name, description string) (string, error) {
+	val, err := e.lookup(name, description)
+	if !errors.Is(err, ErrNotSet) {
+		return val, err
+	}
+
+	path, ok := os.LookupEnv(name + FileEnvSuffix)
 	if !ok {
 		return "", fmt.Errorf(
-			"unable to get pushover API token from env variable %s: %w",
-			PushoverAPITokenEnv,
+			"unable to get %s from env variable %s or %s: %w",
+			description,
+			name,
+			name+FileEnvSuffix,
 			ErrEnvVariableNotSet,
 		)
 	}
 
-	return val, nil
+	return readSecretFile(path, description)
+}
+
+func (e *Env) lookupInt(name, description string) (int, error) {
+	val, err := e.lookup(name, description)
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s in env variable %s: %w", description, name, err)
+	}
+
+	return i, nil
+}
+
+func (e *Env) lookupDuration(name, description string) (time.Duration, error) {
+	val, err := e.lookup(name, description)
+	if err != nil {
+		return 0, err
+	}
+
+	duration, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s in env variable %s: %w", description, name, err)
+	}
+
+	return duration, nil
+}
+
+// Reload is a no-op as env variables are read on every call
+func (e *Env) Reload() error {
+	return nil
+}
+
+// Store backend of the users, medications and doses
+func (e *Env) Store() (string, error) {
+	return e.lookup(StoreEnv, "store")
+}
+
+// SQLitePath for the SQLite database file
+func (e *Env) SQLitePath() (string, error) {
+	return e.lookup(SQLitePathEnv, "sqlite path")
+}
+
+// BadgerPath for the database directory
+func (e *Env) BadgerPath() (string, error) {
+	return e.lookup(BadgerPathEnv, "badger path")
+}
+
+// PushoverAPIToken getter
+func (e *Env) PushoverAPIToken() (string, error) {
+	return e.lookupSecret(PushoverAPITokenEnv, "pushover API token")
+}
+
+// ListenAddress for the HTTP server
+func (e *Env) ListenAddress() (string, error) {
+	return e.lookup(ListenAddressEnv, "listen address")
+}
+
+// AdminPushoverDeviceToken to send self-monitoring alerts to
+func (e *Env) AdminPushoverDeviceToken() (string, error) {
+	return e.lookupSecret(AdminPushoverDeviceTokenEnv, "admin pushover device token")
+}
+
+// LogLevel of the logger
+func (e *Env) LogLevel() (string, error) {
+	return e.lookup(LogLevelEnv, "log level")
+}
+
+// LogFormat of the logger
+func (e *Env) LogFormat() (string, error) {
+	return e.lookup(LogFormatEnv, "log format")
+}
+
+// DefaultTimeZone for medication schedules
+func (e *Env) DefaultTimeZone() (string, error) {
+	return e.lookup(DefaultTimeZoneEnv, "default time zone")
+}
+
+// GCInterval between badger value log garbage collections
+func (e *Env) GCInterval() (time.Duration, error) {
+	return e.lookupDuration(GCIntervalEnv, "GC interval")
+}
+
+// PushoverURL of the pushover API
+func (e *Env) PushoverURL() (string, error) {
+	return e.lookup(PushoverURLEnv, "pushover URL")
+}
+
+// PushoverTimeout of every request to the pushover API
+func (e *Env) PushoverTimeout() (time.Duration, error) {
+	return e.lookupDuration(PushoverTimeoutEnv, "pushover timeout")
+}
+
+// PushoverValidateInterval between validations of every device token, zero
+// disables them
+func (e *Env) PushoverValidateInterval() (time.Duration, error) {
+	return e.lookupDuration(PushoverValidateIntervalEnv, "pushover validate interval")
+}
+
+// NotificationRetry interval of unacknowledged reminders
+func (e *Env) NotificationRetry() (time.Duration, error) {
+	return e.lookupDuration(NotificationRetryEnv, "notification retry")
+}
+
+// NotificationExpire duration after which unacknowledged reminders stop retrying
+func (e *Env) NotificationExpire() (time.Duration, error) {
+	return e.lookupDuration(NotificationExpireEnv, "notification expire")
+}
+
+// NotificationSound of reminders
+func (e *Env) NotificationSound() (string, error) {
+	return e.lookup(NotificationSoundEnv, "notification sound")
+}
+
+// BackupSchedule cron expression of scheduled backups, empty to disable them
+func (e *Env) BackupSchedule() (string, error) {
+	return e.lookup(BackupScheduleEnv, "backup schedule")
+}
+
+// BackupDir to keep backups in
+func (e *Env) BackupDir() (string, error) {
+	return e.lookup(BackupDirEnv, "backup dir")
+}
+
+// BackupKeep number of the newest scheduled backups to keep
+func (e *Env) BackupKeep() (int, error) {
+	return e.lookupInt(BackupKeepEnv, "backup keep")
+}
+
+// BackupS3Endpoint URL of the S3 compatible endpoint to keep backups in
+func (e *Env) BackupS3Endpoint() (string, error) {
+	return e.lookup(BackupS3EndpointEnv, "backup S3 endpoint")
+}
+
+// BackupS3Bucket to keep backups in
+func (e *Env) BackupS3Bucket() (string, error) {
+	return e.lookup(BackupS3BucketEnv, "backup S3 bucket")
+}
+
+// BackupS3Prefix of the backup object keys
+func (e *Env) BackupS3Prefix() (string, error) {
+	return e.lookup(BackupS3PrefixEnv, "backup S3 prefix")
+}
+
+// BackupS3AccessKeyID getter
+func (e *Env) BackupS3AccessKeyID() (string, error) {
+	return e.lookup(BackupS3AccessKeyIDEnv, "backup S3 access key ID")
+}
+
+// BackupS3SecretAccessKey getter
+func (e *Env) BackupS3SecretAccessKey() (string, error) {
+	return e.lookupSecret(BackupS3SecretAccessKeyEnv, "backup S3 secret access key")
+}
+
+// EncryptionKey of the badger database, hex encoded
+func (e *Env) EncryptionKey() (string, error) {
+	return e.lookupSecret(EncryptionKeyEnv, "encryption key")
+}
+
+// Actor the changes made through the CLI are attributed to
+func (e *Env) Actor() (string, error) {
+	return e.lookup(ActorEnv, "actor")
+}
+
+// QueueWorkers delivering queued notifications concurrently
+func (e *Env) QueueWorkers() (int, error) {
+	return e.lookupInt(QueueWorkersEnv, "queue workers")
+}
+
+// QueueMaxAttempts to deliver a queued notification before it is dead-lettered
+func (e *Env) QueueMaxAttempts() (int, error) {
+	return e.lookupInt(QueueMaxAttemptsEnv, "queue max attempts")
+}
+
+// QueueBackoff before the first retry of a queued notification, doubled after every failed attempt
+func (e *Env) QueueBackoff() (time.Duration, error) {
+	return e.lookupDuration(QueueBackoffEnv, "queue backoff")
+}
+
+// QueueMaxBackoff between retries of a queued notification
+func (e *Env) QueueMaxBackoff() (time.Duration, error) {
+	return e.lookupDuration(QueueMaxBackoffEnv, "queue max backoff")
+}
+
+// QuotaWarnThresholds of the used pushover monthly quota in percent
+func (e *Env) QuotaWarnThresholds() (string, error) {
+	return e.lookup(QuotaWarnThresholdsEnv, "quota warn thresholds")
+}
+
+// QuotaReserve of pushover messages below which the quota policy applies
+func (e *Env) QuotaReserve() (int, error) {
+	return e.lookupInt(QuotaReserveEnv, "quota reserve")
+}
+
+// QuotaPolicy for non-critical reminders once the pushover quota runs low
+func (e *Env) QuotaPolicy() (string, error) {
+	return e.lookup(QuotaPolicyEnv, "quota policy")
+}
+
+// QuotaFallbackURL of the webhook non-critical reminders are posted to
+func (e *Env) QuotaFallbackURL() (string, error) {
+	return e.lookup(QuotaFallbackURLEnv, "quota fallback URL")
 }
@@ -0,0 +1,331 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// fileValues as they are laid out in the YAML config file
+type fileValues struct {
+	Store                        string `yaml:"store"`
+	BadgerPath                   string `yaml:"badger_path"`
+	SQLitePath                   string `yaml:"sqlite_path"`
+	EncryptionKey                string `yaml:"encryption_key"`
+	EncryptionKeyFile            string `yaml:"encryption_key_file"`
+	Actor                        string `yaml:"actor"`
+	PushoverAPIToken             string `yaml:"pushover_api_token"`
+	PushoverAPITokenFile         string `yaml:"pushover_api_token_file"`
+	PushoverURL                  string `yaml:"pushover_url"`
+	PushoverTimeout              string `yaml:"pushover_timeout"`
+	PushoverValidateInterval     string `yaml:"pushover_validate_interval"`
+	ListenAddress                string `yaml:"listen_address"`
+	AdminPushoverDeviceToken     string `yaml:"admin_pushover_device_token"`
+	AdminPushoverDeviceTokenFile string `yaml:"admin_pushover_device_token_file"`
+	DefaultTimeZone              string `yaml:"default_time_zone"`
+	GCInterval                   string `yaml:"gc_interval"`
+	Log                          struct {
+		Level  string `yaml:"level"`
+		Format string `yaml:"format"`
+	} `yaml:"log"`
+	Notification struct {
+		Retry  string `yaml:"retry"`
+		Expire string `yaml:"expire"`
+		Sound  string `yaml:"sound"`
+	} `yaml:"notification"`
+	Backup struct {
+		Schedule string `yaml:"schedule"`
+		Dir      string `yaml:"dir"`
+		Keep     int    `yaml:"keep"`
+		S3       struct {
+			Endpoint            string `yaml:"endpoint"`
+			Bucket              string `yaml:"bucket"`
+			Prefix              string `yaml:"prefix"`
+			AccessKeyID         string `yaml:"access_key_id"`
+			SecretAccessKey     string `yaml:"secret_access_key"`
+			SecretAccessKeyFile string `yaml:"secret_access_key_file"`
+		} `yaml:"s3"`
+	} `yaml:"backup"`
+	Queue struct {
+		Workers     int    `yaml:"workers"`
+		MaxAttempts int    `yaml:"max_attempts"`
+		Backoff     string `yaml:"backoff"`
+		MaxBackoff  string `yaml:"max_backoff"`
+	} `yaml:"queue"`
+	Quota struct {
+		WarnThresholds string `yaml:"warn_thresholds"`
+		Reserve        int    `yaml:"reserve"`
+		Policy         string `yaml:"policy"`
+		FallbackURL    string `yaml:"fallback_url"`
+	} `yaml:"quota"`
+}
+
+// File YAML Config implementation
+type File struct {
+	path   string
+	lock   sync.RWMutex
+	values fileValues
+}
+
+// NewFile reads the YAML config file at the given path
+func NewFile(path string) (*File, error) {
+	f := &File{
+		path: path,
+	}
+
+	err := f.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Reload the config file from disk, keeping the previous values on failure
+func (f *File) Reload() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", f.path, err)
+	}
+
+	values := fileValues{}
+	err = yaml.UnmarshalStrict(data, &values)
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", f.path, err)
+	}
+
+	f.lock.Lock()
+	f.values = values
+	f.lock.Unlock()
+
+	return nil
+}
+
+func (f *File) get() fileValues {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.values
+}
+
+func (f *File) lookup(val, key string) (string, error) {
+	if val == "" {
+		return "", fmt.Errorf("%s is not set in config file %s: %w", key, f.path, ErrNotSet)
+	}
+
+	return val, nil
+}
+
+// lookupSecret from the value itself or the file at path when the value is
+// not set
+func (f *File) lookupSecret(val, path, key string) (string, error) {
+	if val != "" {
+		return val, nil
+	}
+
+	if path == "" {
+		return "", fmt.Errorf("neither %s nor %s_file are set in config file %s: %w", key, key, f.path, ErrNotSet)
+	}
+
+	return readSecretFile(path, key)
+}
+
+func (f *File) lookupInt(val int, key string) (int, error) {
+	if val == 0 {
+		return 0, fmt.Errorf("%s is not set in config file %s: %w", key, f.path, ErrNotSet)
+	}
+
+	return val, nil
+}
+
+func (f *File) lookupDuration(val, key string) (time.Duration, error) {
+	val, err := f.lookup(val, key)
+	if err != nil {
+		return 0, err
+	}
+
+	duration, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s in config file %s: %w", key, f.path, err)
+	}
+
+	return duration, nil
+}
+
+// Store backend of the users, medications and doses
+func (f *File) Store() (string, error) {
+	return f.lookup(f.get().Store, "store")
+}
+
+// SQLitePath for the SQLite database file
+func (f *File) SQLitePath() (string, error) {
+	return f.lookup(f.get().SQLitePath, "sqlite_path")
+}
+
+// BadgerPath for the database directory
+func (f *File) BadgerPath() (string, error) {
+	return f.lookup(f.get().BadgerPath, "badger_path")
+}
+
+// PushoverAPIToken getter
+func (f *File) PushoverAPIToken() (string, error) {
+	values := f.get()
+	return f.lookupSecret(values.PushoverAPIToken, values.PushoverAPITokenFile, "pushover_api_token")
+}
+
+// ListenAddress for the HTTP server
+func (f *File) ListenAddress() (string, error) {
+	return f.lookup(f.get().ListenAddress, "listen_address")
+}
+
+// AdminPushoverDeviceToken to send self-monitoring alerts to
+func (f *File) AdminPushoverDeviceToken() (string, error) {
+	values := f.get()
+	return f.lookupSecret(values.AdminPushoverDeviceToken, values.AdminPushoverDeviceTokenFile, "admin_pushover_device_token")
+}
+
+// LogLevel of the logger
+func (f *File) LogLevel() (string, error) {
+	return f.lookup(f.get().Log.Level, "log.level")
+}
+
+// LogFormat of the logger
+func (f *File) LogFormat() (string, error) {
+	return f.lookup(f.get().Log.Format, "log.format")
+}
+
+// DefaultTimeZone for medication schedules
+func (f *File) DefaultTimeZone() (string, error) {
+	return f.lookup(f.get().DefaultTimeZone, "default_time_zone")
+}
+
+// GCInterval between badger value log garbage collections
+func (f *File) GCInterval() (time.Duration, error) {
+	return f.lookupDuration(f.get().GCInterval, "gc_interval")
+}
+
+// PushoverURL of the pushover API
+func (f *File) PushoverURL() (string, error) {
+	return f.lookup(f.get().PushoverURL, "pushover_url")
+}
+
+// PushoverTimeout of every request to the pushover API
+func (f *File) PushoverTimeout() (time.Duration, error) {
+	return f.lookupDuration(f.get().PushoverTimeout, "pushover_timeout")
+}
+
+// PushoverValidateInterval between validations of every device token, zero
+// disables them
+func (f *File) PushoverValidateInterval() (time.Duration, error) {
+	return f.lookupDuration(f.get().PushoverValidateInterval, "pushover_validate_interval")
+}
+
+// NotificationRetry interval of unacknowledged reminders
+func (f *File) NotificationRetry() (time.Duration, error) {
+	return f.lookupDuration(f.get().Notification.Retry, "notification.retry")
+}
+
+// NotificationExpire duration after which unacknowledged reminders stop retrying
+func (f *File) NotificationExpire() (time.Duration, error) {
+	return f.lookupDuration(f.get().Notification.Expire, "notification.expire")
+}
+
+// NotificationSound of reminders
+func (f *File) NotificationSound() (string, error) {
+	return f.lookup(f.get().Notification.Sound, "notification.sound")
+}
+
+// BackupSchedule cron expression of scheduled backups, empty to disable them
+func (f *File) BackupSchedule() (string, error) {
+	return f.lookup(f.get().Backup.Schedule, "backup.schedule")
+}
+
+// BackupDir to keep backups in
+func (f *File) BackupDir() (string, error) {
+	return f.lookup(f.get().Backup.Dir, "backup.dir")
+}
+
+// BackupKeep number of the newest scheduled backups to keep
+func (f *File) BackupKeep() (int, error) {
+	return f.lookupInt(f.get().Backup.Keep, "backup.keep")
+}
+
+// BackupS3Endpoint URL of the S3 compatible endpoint to keep backups in
+func (f *File) BackupS3Endpoint() (string, error) {
+	return f.lookup(f.get().Backup.S3.Endpoint, "backup.s3.endpoint")
+}
+
+// BackupS3Bucket to keep backups in
+func (f *File) BackupS3Bucket() (string, error) {
+	return f.lookup(f.get().Backup.S3.Bucket, "backup.s3.bucket")
+}
+
+// BackupS3Prefix of the backup object keys
+func (f *File) BackupS3Prefix() (string, error) {
+	return f.lookup(f.get().Backup.S3.Prefix, "backup.s3.prefix")
+}
+
+// BackupS3AccessKeyID getter
+func (f *File) BackupS3AccessKeyID() (string, error) {
+	return f.lookup(f.get().Backup.S3.AccessKeyID, "backup.s3.access_key_id")
+}
+
+// BackupS3SecretAccessKey getter
+func (f *File) BackupS3SecretAccessKey() (string, error) {
+	s3 := f.get().Backup.S3
+	return f.lookupSecret(s3.SecretAccessKey, s3.SecretAccessKeyFile, "backup.s3.secret_access_key")
+}
+
+// EncryptionKey of the badger database, hex encoded
+func (f *File) EncryptionKey() (string, error) {
+	values := f.get()
+	return f.lookupSecret(values.EncryptionKey, values.EncryptionKeyFile, "encryption_key")
+}
+
+// Actor the changes made through the CLI are attributed to
+func (f *File) Actor() (string, error) {
+	return f.lookup(f.get().Actor, "actor")
+}
+
+// QueueWorkers delivering queued notifications concurrently
+func (f *File) QueueWorkers() (int, error) {
+	return f.lookupInt(f.get().Queue.Workers, "queue.workers")
+}
+
+// QueueMaxAttempts to deliver a queued notification before it is dead-lettered
+func (f *File) QueueMaxAttempts() (int, error) {
+	return f.lookupInt(f.get().Queue.MaxAttempts, "queue.max_attempts")
+}
+
+// QueueBackoff before the first retry of a queued notification, doubled after every failed attempt
+func (f *File) QueueBackoff() (time.Duration, error) {
+	return f.lookupDuration(f.get().Queue.Backoff, "queue.backoff")
+}
+
+// QueueMaxBackoff between retries of a queued notification
+func (f *File) QueueMaxBackoff() (time.Duration, error) {
+	return f.lookupDuration(f.get().Queue.MaxBackoff, "queue.max_backoff")
+}
+
+// QuotaWarnThresholds of the used pushover monthly quota in percent
+func (f *File) QuotaWarnThresholds() (string, error) {
+	return f.lookup(f.get().Quota.WarnThresholds, "quota.warn_thresholds")
+}
+
+// QuotaReserve of pushover messages below which the quota policy applies
+func (f *File) QuotaReserve() (int, error) {
+	return f.lookupInt(f.get().Quota.Reserve, "quota.reserve")
+}
+
+// QuotaPolicy for non-critical reminders once the pushover quota runs low
+func (f *File) QuotaPolicy() (string, error) {
+	return f.lookup(f.get().Quota.Policy, "quota.policy")
+}
+
+// QuotaFallbackURL of the webhook non-critical reminders are posted to
+func (f *File) QuotaFallbackURL() (string, error) {
+	return f.lookup(f.get().Quota.FallbackURL, "quota.fallback_url")
+}
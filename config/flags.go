@@ -0,0 +1,342 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+const (
+	// ConfigFileFlag name
+	ConfigFileFlag = "config"
+	// StoreFlag name
+	StoreFlag = "store"
+	// SQLitePathFlag name
+	SQLitePathFlag = "sqlite-path"
+	// BadgerPathFlag name
+	BadgerPathFlag = "badger-path"
+	// ListenAddressFlag name
+	ListenAddressFlag = "listen-address"
+	// LogLevelFlag name
+	LogLevelFlag = "log-level"
+	// LogFormatFlag name
+	LogFormatFlag = "log-format"
+	// DefaultTimeZoneFlag name
+	DefaultTimeZoneFlag = "default-time-zone"
+	// GCIntervalFlag name
+	GCIntervalFlag = "gc-interval"
+	// PushoverURLFlag name
+	PushoverURLFlag = "pushover-url"
+	// PushoverTimeoutFlag name
+	PushoverTimeoutFlag = "pushover-timeout"
+	// PushoverValidateIntervalFlag name
+	PushoverValidateIntervalFlag = "pushover-validate-interval"
+	// NotificationRetryFlag name
+	NotificationRetryFlag = "notification-retry"
+	// NotificationExpireFlag name
+	NotificationExpireFlag = "notification-expire"
+	// NotificationSoundFlag name
+	NotificationSoundFlag = "notification-sound"
+	// ActorFlag name
+	ActorFlag = "actor"
+	// BackupScheduleFlag name
+	BackupScheduleFlag = "backup-schedule"
+	// BackupDirFlag name
+	BackupDirFlag = "backup-dir"
+	// BackupKeepFlag name
+	BackupKeepFlag = "backup-keep"
+	// BackupS3EndpointFlag name
+	BackupS3EndpointFlag = "backup-s3-endpoint"
+	// BackupS3BucketFlag name
+	BackupS3BucketFlag = "backup-s3-bucket"
+	// BackupS3PrefixFlag name
+	BackupS3PrefixFlag = "backup-s3-prefix"
+	// QueueWorkersFlag name
+	QueueWorkersFlag = "queue-workers"
+	// QueueMaxAttemptsFlag name
+	QueueMaxAttemptsFlag = "queue-max-attempts"
+	// QueueBackoffFlag name
+	QueueBackoffFlag = "queue-backoff"
+	// QueueMaxBackoffFlag name
+	QueueMaxBackoffFlag = "queue-max-backoff"
+	// QuotaWarnThresholdsFlag name
+	QuotaWarnThresholdsFlag = "quota-warn-thresholds"
+	// QuotaReserveFlag name
+	QuotaReserveFlag = "quota-reserve"
+	// QuotaPolicyFlag name
+	QuotaPolicyFlag = "quota-policy"
+	// QuotaFallbackURLFlag name
+	QuotaFallbackURLFlag = "quota-fallback-url"
+)
+
+// Flags command line Config implementation, secrets are deliberately not
+// accepted as flags so they do not show up in process listings
+type Flags struct {
+	flagSet            *flag.FlagSet
+	configFile         string
+	store              string
+	badgerPath         string
+	sqlitePath         string
+	listenAddress      string
+	logLevel           string
+	logFormat          string
+	defaultTimeZone    string
+	gcInterval         time.Duration
+	pushoverURL        string
+	pushoverTimeout    time.Duration
+	validateInterval   time.Duration
+	notificationRetry  time.Duration
+	notificationExpire time.Duration
+	notificationSound  string
+	actor              string
+	backupSchedule     string
+	backupDir          string
+	backupKeep         int
+	backupS3Endpoint   string
+	backupS3Bucket     string
+	backupS3Prefix     string
+	queueWorkers       int
+	queueMaxAttempts   int
+	queueBackoff       time.Duration
+	queueMaxBackoff    time.Duration
+	quotaThresholds    string
+	quotaReserve       int
+	quotaPolicy        string
+	quotaFallbackURL   string
+}
+
+// NewFlags registers the config flags on the given flag set
+func NewFlags(flagSet *flag.FlagSet) *Flags {
+	f := &Flags{
+		flagSet: flagSet,
+	}
+
+	flagSet.StringVar(&f.configFile, ConfigFileFlag, "", "path to a YAML config file")
+	flagSet.StringVar(&f.store, StoreFlag, "", "store backend (badger, sqlite, memory)")
+	flagSet.StringVar(&f.badgerPath, BadgerPathFlag, "", "path to the badger database directory")
+	flagSet.StringVar(&f.sqlitePath, SQLitePathFlag, "", "path to the SQLite database file")
+	flagSet.StringVar(&f.listenAddress, ListenAddressFlag, "", "address for the HTTP server to listen on")
+	flagSet.StringVar(&f.logLevel, LogLevelFlag, "", "log level (trace, debug, info, warn, error)")
+	flagSet.StringVar(&f.logFormat, LogFormatFlag, "", "log format (text, json)")
+	flagSet.StringVar(&f.defaultTimeZone, DefaultTimeZoneFlag, "", "default time zone for medication schedules")
+	flagSet.DurationVar(&f.gcInterval, GCIntervalFlag, 0, "interval between badger value log garbage collections")
+	flagSet.StringVar(&f.pushoverURL, PushoverURLFlag, "", "URL of the pushover API, e.g. of a fake server for testing")
+	flagSet.DurationVar(&f.pushoverTimeout, PushoverTimeoutFlag, 0, "timeout of every request to the pushover API")
+	flagSet.DurationVar(&f.validateInterval, PushoverValidateIntervalFlag, 0, "interval between validations of every pushover device token, 0 disables them")
+	flagSet.DurationVar(&f.notificationRetry, NotificationRetryFlag, 0, "retry interval of unacknowledged reminders")
+	flagSet.DurationVar(&f.notificationExpire, NotificationExpireFlag, 0, "duration after which unacknowledged reminders stop retrying")
+	flagSet.StringVar(&f.notificationSound, NotificationSoundFlag, "", "pushover sound for reminders")
+	flagSet.StringVar(&f.actor, ActorFlag, "", "who changes made through the CLI are attributed to in the audit trail, defaults to the OS user")
+	flagSet.StringVar(&f.backupSchedule, BackupScheduleFlag, "", "cron schedule of backups taken by the daemon, e.g. '0 3 * * *'")
+	flagSet.StringVar(&f.backupDir, BackupDirFlag, "", "directory to keep backups in")
+	flagSet.IntVar(&f.backupKeep, BackupKeepFlag, 0, "number of the newest scheduled backups to keep")
+	flagSet.StringVar(&f.backupS3Endpoint, BackupS3EndpointFlag, "", "URL of the S3 compatible endpoint to keep backups in")
+	flagSet.StringVar(&f.backupS3Bucket, BackupS3BucketFlag, "", "S3 bucket to keep backups in instead of the backup directory")
+	flagSet.StringVar(&f.backupS3Prefix, BackupS3PrefixFlag, "", "prefix of the backup object keys in the S3 bucket")
+	flagSet.IntVar(&f.queueWorkers, QueueWorkersFlag, 0, "number of workers delivering queued notifications concurrently")
+	flagSet.IntVar(&f.queueMaxAttempts, QueueMaxAttemptsFlag, 0, "number of attempts to deliver a queued notification before it is dead-lettered")
+	flagSet.DurationVar(&f.queueBackoff, QueueBackoffFlag, 0, "backoff before the first retry of a queued notification, doubled after every failed attempt")
+	flagSet.DurationVar(&f.queueMaxBackoff, QueueMaxBackoffFlag, 0, "maximum backoff between retries of a queued notification")
+	flagSet.StringVar(&f.quotaThresholds, QuotaWarnThresholdsFlag, "", "comma separated percentages of the used pushover monthly quota to warn the admin device at")
+	flagSet.IntVar(&f.quotaReserve, QuotaReserveFlag, 0, "number of remaining pushover messages below which the quota policy applies to non-critical reminders")
+	flagSet.StringVar(&f.quotaPolicy, QuotaPolicyFlag, "", "policy for non-critical reminders once the pushover quota runs low (send, suppress, fallback)")
+	flagSet.StringVar(&f.quotaFallbackURL, QuotaFallbackURLFlag, "", "URL of the webhook non-critical reminders are posted to by the fallback quota policy")
+
+	return f
+}
+
+func (f *Flags) isSet(name string) error {
+	set := false
+	f.flagSet.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			set = true
+		}
+	})
+
+	if !set {
+		return fmt.Errorf("flag -%s is not set: %w", name, ErrNotSet)
+	}
+
+	return nil
+}
+
+// Reload is a no-op as flags are only parsed once
+func (f *Flags) Reload() error {
+	return nil
+}
+
+// ConfigFile path given on the command line
+func (f *Flags) ConfigFile() (string, error) {
+	return f.configFile, f.isSet(ConfigFileFlag)
+}
+
+// Store backend of the users, medications and doses
+func (f *Flags) Store() (string, error) {
+	return f.store, f.isSet(StoreFlag)
+}
+
+// SQLitePath for the SQLite database file
+func (f *Flags) SQLitePath() (string, error) {
+	return f.sqlitePath, f.isSet(SQLitePathFlag)
+}
+
+// BadgerPath for the database directory
+func (f *Flags) BadgerPath() (string, error) {
+	return f.badgerPath, f.isSet(BadgerPathFlag)
+}
+
+// PushoverAPIToken is not accepted as a flag
+func (f *Flags) PushoverAPIToken() (string, error) {
+	return "", fmt.Errorf("pushover API token is not accepted as a flag: %w", ErrNotSet)
+}
+
+// ListenAddress for the HTTP server
+func (f *Flags) ListenAddress() (string, error) {
+	return f.listenAddress, f.isSet(ListenAddressFlag)
+}
+
+// AdminPushoverDeviceToken is not accepted as a flag
+func (f *Flags) AdminPushoverDeviceToken() (string, error) {
+	return "", fmt.Errorf("admin pushover device token is not accepted as a flag: %w", ErrNotSet)
+}
+
+// LogLevel of the logger
+func (f *Flags) LogLevel() (string, error) {
+	return f.logLevel, f.isSet(LogLevelFlag)
+}
+
+// LogFormat of the logger
+func (f *Flags) LogFormat() (string, error) {
+	return f.logFormat, f.isSet(LogFormatFlag)
+}
+
+// DefaultTimeZone for medication schedules
+func (f *Flags) DefaultTimeZone() (string, error) {
+	return f.defaultTimeZone, f.isSet(DefaultTimeZoneFlag)
+}
+
+// GCInterval between badger value log garbage collections
+func (f *Flags) GCInterval() (time.Duration, error) {
+	return f.gcInterval, f.isSet(GCIntervalFlag)
+}
+
+// PushoverURL of the pushover API
+func (f *Flags) PushoverURL() (string, error) {
+	return f.pushoverURL, f.isSet(PushoverURLFlag)
+}
+
+// PushoverTimeout of every request to the pushover API
+func (f *Flags) PushoverTimeout() (time.Duration, error) {
+	return f.pushoverTimeout, f.isSet(PushoverTimeoutFlag)
+}
+
+// PushoverValidateInterval between validations of every device token, zero
+// disables them
+func (f *Flags) PushoverValidateInterval() (time.Duration, error) {
+	return f.validateInterval, f.isSet(PushoverValidateIntervalFlag)
+}
+
+// NotificationRetry interval of unacknowledged reminders
+func (f *Flags) NotificationRetry() (time.Duration, error) {
+	return f.notificationRetry, f.isSet(NotificationRetryFlag)
+}
+
+// NotificationExpire duration after which unacknowledged reminders stop retrying
+func (f *Flags) NotificationExpire() (time.Duration, error) {
+	return f.notificationExpire, f.isSet(NotificationExpireFlag)
+}
+
+// NotificationSound of reminders
+func (f *Flags) NotificationSound() (string, error) {
+	return f.notificationSound, f.isSet(NotificationSoundFlag)
+}
+
+// BackupSchedule cron expression of scheduled backups, empty to disable them
+func (f *Flags) BackupSchedule() (string, error) {
+	return f.backupSchedule, f.isSet(BackupScheduleFlag)
+}
+
+// BackupDir to keep backups in
+func (f *Flags) BackupDir() (string, error) {
+	return f.backupDir, f.isSet(BackupDirFlag)
+}
+
+// BackupKeep number of the newest scheduled backups to keep
+func (f *Flags) BackupKeep() (int, error) {
+	return f.backupKeep, f.isSet(BackupKeepFlag)
+}
+
+// BackupS3Endpoint URL of the S3 compatible endpoint to keep backups in
+func (f *Flags) BackupS3Endpoint() (string, error) {
+	return f.backupS3Endpoint, f.isSet(BackupS3EndpointFlag)
+}
+
+// BackupS3Bucket to keep backups in
+func (f *Flags) BackupS3Bucket() (string, error) {
+	return f.backupS3Bucket, f.isSet(BackupS3BucketFlag)
+}
+
+// BackupS3Prefix of the backup object keys
+func (f *Flags) BackupS3Prefix() (string, error) {
+	return f.backupS3Prefix, f.isSet(BackupS3PrefixFlag)
+}
+
+// BackupS3AccessKeyID is not accepted as a flag
+func (f *Flags) BackupS3AccessKeyID() (string, error) {
+	return "", fmt.Errorf("backup S3 access key ID is not accepted as a flag: %w", ErrNotSet)
+}
+
+// BackupS3SecretAccessKey is not accepted as a flag
+func (f *Flags) BackupS3SecretAccessKey() (string, error) {
+	return "", fmt.Errorf("backup S3 secret access key is not accepted as a flag: %w", ErrNotSet)
+}
+
+// EncryptionKey is not accepted as a flag
+func (f *Flags) EncryptionKey() (string, error) {
+	return "", fmt.Errorf("encryption key is not accepted as a flag: %w", ErrNotSet)
+}
+
+// Actor the changes made through the CLI are attributed to
+func (f *Flags) Actor() (string, error) {
+	return f.actor, f.isSet(ActorFlag)
+}
+
+// QueueWorkers delivering queued notifications concurrently
+func (f *Flags) QueueWorkers() (int, error) {
+	return f.queueWorkers, f.isSet(QueueWorkersFlag)
+}
+
+// QueueMaxAttempts to deliver a queued notification before it is dead-lettered
+func (f *Flags) QueueMaxAttempts() (int, error) {
+	return f.queueMaxAttempts, f.isSet(QueueMaxAttemptsFlag)
+}
+
+// QueueBackoff before the first retry of a queued notification, doubled after every failed attempt
+func (f *Flags) QueueBackoff() (time.Duration, error) {
+	return f.queueBackoff, f.isSet(QueueBackoffFlag)
+}
+
+// QueueMaxBackoff between retries of a queued notification
+func (f *Flags) QueueMaxBackoff() (time.Duration, error) {
+	return f.queueMaxBackoff, f.isSet(QueueMaxBackoffFlag)
+}
+
+// QuotaWarnThresholds of the used pushover monthly quota in percent
+func (f *Flags) QuotaWarnThresholds() (string, error) {
+	return f.quotaThresholds, f.isSet(QuotaWarnThresholdsFlag)
+}
+
+// QuotaReserve of pushover messages below which the quota policy applies
+func (f *Flags) QuotaReserve() (int, error) {
+	return f.quotaReserve, f.isSet(QuotaReserveFlag)
+}
+
+// QuotaPolicy for non-critical reminders once the pushover quota runs low
+func (f *Flags) QuotaPolicy() (string, error) {
+	return f.quotaPolicy, f.isSet(QuotaPolicyFlag)
+}
+
+// QuotaFallbackURL of the webhook non-critical reminders are posted to
+func (f *Flags) QuotaFallbackURL() (string, error) {
+	return f.quotaFallbackURL, f.isSet(QuotaFallbackURLFlag)
+}
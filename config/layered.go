@@ -0,0 +1,261 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Layered Config implementation that resolves each setting from the first
+// layer that has it set, e.g. Layered{flags, env, file, &Defaults{}}
+type Layered []Config
+
+func (l Layered) resolveString(description string, get func(Config) (string, error)) (string, error) {
+	for _, layer := range l {
+		val, err := get(layer)
+		if err == nil {
+			return val, nil
+		}
+
+		if !errors.Is(err, ErrNotSet) {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("%s is not set in any config layer: %w", description, ErrNotSet)
+}
+
+func (l Layered) resolveDuration(description string, get func(Config) (time.Duration, error)) (time.Duration, error) {
+	for _, layer := range l {
+		val, err := get(layer)
+		if err == nil {
+			return val, nil
+		}
+
+		if !errors.Is(err, ErrNotSet) {
+			return 0, err
+		}
+	}
+
+	return 0, fmt.Errorf("%s is not set in any config layer: %w", description, ErrNotSet)
+}
+
+func (l Layered) resolveInt(description string, get func(Config) (int, error)) (int, error) {
+	for _, layer := range l {
+		val, err := get(layer)
+		if err == nil {
+			return val, nil
+		}
+
+		if !errors.Is(err, ErrNotSet) {
+			return 0, err
+		}
+	}
+
+	return 0, fmt.Errorf("%s is not set in any config layer: %w", description, ErrNotSet)
+}
+
+// Reload every layer
+func (l Layered) Reload() error {
+	for _, layer := range l {
+		err := layer.Reload()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Store backend of the users, medications and doses
+func (l Layered) Store() (string, error) {
+	return l.resolveString("store", Config.Store)
+}
+
+// SQLitePath for the SQLite database file
+func (l Layered) SQLitePath() (string, error) {
+	return l.resolveString("sqlite path", Config.SQLitePath)
+}
+
+// BadgerPath for the database directory
+func (l Layered) BadgerPath() (string, error) {
+	return l.resolveString("badger path", Config.BadgerPath)
+}
+
+// PushoverAPIToken getter
+func (l Layered) PushoverAPIToken() (string, error) {
+	return l.resolveString("pushover API token", Config.PushoverAPIToken)
+}
+
+// ListenAddress for the HTTP server
+func (l Layered) ListenAddress() (string, error) {
+	return l.resolveString("listen address", Config.ListenAddress)
+}
+
+// AdminPushoverDeviceToken to send self-monitoring alerts to
+func (l Layered) AdminPushoverDeviceToken() (string, error) {
+	return l.resolveString("admin pushover device token", Config.AdminPushoverDeviceToken)
+}
+
+// LogLevel of the logger
+func (l Layered) LogLevel() (string, error) {
+	return l.resolveString("log level", Config.LogLevel)
+}
+
+// LogFormat of the logger
+func (l Layered) LogFormat() (string, error) {
+	return l.resolveString("log format", Config.LogFormat)
+}
+
+// DefaultTimeZone for medication schedules
+func (l Layered) DefaultTimeZone() (string, error) {
+	return l.resolveString("default time zone", Config.DefaultTimeZone)
+}
+
+// GCInterval between badger value log garbage collections
+func (l Layered) GCInterval() (time.Duration, error) {
+	return l.resolveDuration("GC interval", Config.GCInterval)
+}
+
+// PushoverURL of the pushover API
+func (l Layered) PushoverURL() (string, error) {
+	return l.resolveString("pushover URL", Config.PushoverURL)
+}
+
+// PushoverTimeout of every request to the pushover API
+func (l Layered) PushoverTimeout() (time.Duration, error) {
+	return l.resolveDuration("pushover timeout", Config.PushoverTimeout)
+}
+
+// PushoverValidateInterval between validations of every device token, zero
+// disables them
+func (l Layered) PushoverValidateInterval() (time.Duration, error) {
+	return l.resolveDuration("pushover validate interval", Config.PushoverValidateInterval)
+}
+
+// NotificationRetry interval of unacknowledged reminders
+func (l Layered) NotificationRetry() (time.Duration, error) {
+	return l.resolveDuration("notification retry", Config.NotificationRetry)
+}
+
+// NotificationExpire duration after which unacknowledged reminders stop retrying
+func (l Layered) NotificationExpire() (time.Duration, error) {
+	return l.resolveDuration("notification expire", Config.NotificationExpire)
+}
+
+// NotificationSound of reminders
+func (l Layered) NotificationSound() (string, error) {
+	return l.resolveString("notification sound", Config.NotificationSound)
+}
+
+// EncryptionKey of the badger database, hex encoded
+func (l Layered) EncryptionKey() (string, error) {
+	return l.resolveString("encryption key", Config.EncryptionKey)
+}
+
+// Actor the changes made through the CLI are attributed to
+func (l Layered) Actor() (string, error) {
+	return l.resolveString("actor", Config.Actor)
+}
+
+// BackupSchedule cron expression of scheduled backups, empty to disable them
+func (l Layered) BackupSchedule() (string, error) {
+	return l.resolveString("backup schedule", Config.BackupSchedule)
+}
+
+// BackupDir to keep backups in
+func (l Layered) BackupDir() (string, error) {
+	return l.resolveString("backup dir", Config.BackupDir)
+}
+
+// BackupKeep number of the newest scheduled backups to keep
+func (l Layered) BackupKeep() (int, error) {
+	return l.resolveInt("backup keep", Config.BackupKeep)
+}
+
+// BackupS3Endpoint URL of the S3 compatible endpoint to keep backups in
+func (l Layered) BackupS3Endpoint() (string, error) {
+	return l.resolveString("backup S3 endpoint", Config.BackupS3Endpoint)
+}
+
+// BackupS3Bucket to keep backups in
+func (l Layered) BackupS3Bucket() (string, error) {
+	return l.resolveString("backup S3 bucket", Config.BackupS3Bucket)
+}
+
+// BackupS3Prefix of the backup object keys
+func (l Layered) BackupS3Prefix() (string, error) {
+	return l.resolveString("backup S3 prefix", Config.BackupS3Prefix)
+}
+
+// BackupS3AccessKeyID getter
+func (l Layered) BackupS3AccessKeyID() (string, error) {
+	return l.resolveString("backup S3 access key ID", Config.BackupS3AccessKeyID)
+}
+
+// BackupS3SecretAccessKey getter
+func (l Layered) BackupS3SecretAccessKey() (string, error) {
+	return l.resolveString("backup S3 secret access key", Config.BackupS3SecretAccessKey)
+}
+
+// Load the Config layers in order of precedence: flags, environment variables,
+// the config file given by either of those and finally the defaults
+func Load(flags *Flags) (Layered, error) {
+	env := &Env{}
+
+	path, err := flags.ConfigFile()
+	if errors.Is(err, ErrNotSet) {
+		path, err = env.lookup(ConfigFileEnv, "config file")
+	}
+
+	if errors.Is(err, ErrNotSet) {
+		return Layered{flags, env, &Defaults{}}, nil
+	}
+
+	file, err := NewFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Layered{flags, env, file, &Defaults{}}, nil
+}
+
+// QueueWorkers delivering queued notifications concurrently
+func (l Layered) QueueWorkers() (int, error) {
+	return l.resolveInt("queue workers", Config.QueueWorkers)
+}
+
+// QueueMaxAttempts to deliver a queued notification before it is dead-lettered
+func (l Layered) QueueMaxAttempts() (int, error) {
+	return l.resolveInt("queue max attempts", Config.QueueMaxAttempts)
+}
+
+// QueueBackoff before the first retry of a queued notification, doubled after every failed attempt
+func (l Layered) QueueBackoff() (time.Duration, error) {
+	return l.resolveDuration("queue backoff", Config.QueueBackoff)
+}
+
+// QueueMaxBackoff between retries of a queued notification
+func (l Layered) QueueMaxBackoff() (time.Duration, error) {
+	return l.resolveDuration("queue max backoff", Config.QueueMaxBackoff)
+}
+
+// QuotaWarnThresholds of the used pushover monthly quota in percent
+func (l Layered) QuotaWarnThresholds() (string, error) {
+	return l.resolveString("quota warn thresholds", Config.QuotaWarnThresholds)
+}
+
+// QuotaReserve of pushover messages below which the quota policy applies
+func (l Layered) QuotaReserve() (int, error) {
+	return l.resolveInt("quota reserve", Config.QuotaReserve)
+}
+
+// QuotaPolicy for non-critical reminders once the pushover quota runs low
+func (l Layered) QuotaPolicy() (string, error) {
+	return l.resolveString("quota policy", Config.QuotaPolicy)
+}
+
+// QuotaFallbackURL of the webhook non-critical reminders are posted to
+func (l Layered) QuotaFallbackURL() (string, error) {
+	return l.resolveString("quota fallback URL", Config.QuotaFallbackURL)
+}
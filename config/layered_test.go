@@ -0,0 +1,234 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// setenv for the duration of the test
+func setenv(t *testing.T, name, value string) {
+	t.Helper()
+
+	previous, ok := os.LookupEnv(name)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+
+	err := os.Setenv(name, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeFile in the test's temporary directory, returning its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// load the layers from the command line arguments
+func load(t *testing.T, args ...string) Layered {
+	t.Helper()
+
+	flagSet := flag.NewFlagSet("meditime", flag.ContinueOnError)
+	flags := NewFlags(flagSet)
+
+	err := flagSet.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Load(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	for _, setting := range []struct {
+		name string
+		flag string
+		env  string
+		// file is the YAML of the setting with the value formatted in
+		file     string
+		values   [3]string
+		defaults string
+		get      func(Config) (string, error)
+	}{
+		{
+			name:     "string",
+			flag:     LogLevelFlag,
+			env:      LogLevelEnv,
+			file:     "log:\n  level: %s\n",
+			values:   [3]string{"debug", "warn", "error"},
+			defaults: DefaultLogLevel,
+			get:      Config.LogLevel,
+		},
+		{
+			name:     "duration",
+			flag:     GCIntervalFlag,
+			env:      GCIntervalEnv,
+			file:     "gc_interval: %s\n",
+			values:   [3]string{"1m0s", "2m0s", "3m0s"},
+			defaults: DefaultGCInterval.String(),
+			get: func(c Config) (string, error) {
+				interval, err := c.GCInterval()
+				return interval.String(), err
+			},
+		},
+		{
+			name:     "int",
+			flag:     BackupKeepFlag,
+			env:      BackupKeepEnv,
+			file:     "backup:\n  keep: %s\n",
+			values:   [3]string{"1", "2", "3"},
+			defaults: strconv.Itoa(DefaultBackupKeep),
+			get: func(c Config) (string, error) {
+				keep, err := c.BackupKeep()
+				return strconv.Itoa(keep), err
+			},
+		},
+	} {
+		for _, test := range []struct {
+			name     string
+			flag     bool
+			env      bool
+			file     bool
+			expected string
+		}{
+			{name: "flag overrides env and file", flag: true, env: true, file: true, expected: setting.values[0]},
+			{name: "flag alone", flag: true, expected: setting.values[0]},
+			{name: "env overrides file", env: true, file: true, expected: setting.values[1]},
+			{name: "env alone", env: true, expected: setting.values[1]},
+			{name: "file overrides defaults", file: true, expected: setting.values[2]},
+			{name: "defaults", expected: setting.defaults},
+		} {
+			t.Run(setting.name+" "+test.name, func(t *testing.T) {
+				var args []string
+				if test.flag {
+					args = append(args, "-"+setting.flag, setting.values[0])
+				}
+
+				if test.env {
+					setenv(t, setting.env, setting.values[1])
+				}
+
+				content := ""
+				if test.file {
+					content = fmt.Sprintf(setting.file, setting.values[2])
+				}
+
+				args = append(args, "-"+ConfigFileFlag, writeFile(t, "meditime.yaml", content))
+
+				val, err := setting.get(load(t, args...))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if val != test.expected {
+					t.Fatalf("expected %s, got %s", test.expected, val)
+				}
+			})
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	flagFile := writeFile(t, "flag.yaml", "log:\n  level: debug\n")
+	envFile := writeFile(t, "env.yaml", "log:\n  level: warn\n")
+
+	setenv(t, ConfigFileEnv, envFile)
+
+	for args, expected := range map[string]string{
+		"":       "warn",
+		flagFile: "debug",
+	} {
+		var config Layered
+		if args == "" {
+			config = load(t)
+		} else {
+			config = load(t, "-"+ConfigFileFlag, args)
+		}
+
+		level, err := config.LogLevel()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if level != expected {
+			t.Fatalf("expected the config file given by %q to set log level %s, got %s", args, expected, level)
+		}
+	}
+}
+
+func TestLayeredNotSet(t *testing.T) {
+	// neither flags nor defaults have a pushover API token, so it falls
+	// through to the file below the unset env variable
+	config := load(t, "-"+ConfigFileFlag, writeFile(t, "meditime.yaml", "pushover_api_token: token\n"))
+
+	token, err := config.PushoverAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "token" {
+		t.Fatalf("expected the token of the config file, got %s", token)
+	}
+
+	config = load(t, "-"+ConfigFileFlag, writeFile(t, "meditime.yaml", ""))
+
+	_, err = config.PushoverAPIToken()
+	if !errors.Is(err, ErrNotSet) {
+		t.Fatalf("expected a token set in no layer to fail with %v, got %v", ErrNotSet, err)
+	}
+
+	// an empty value in the config file is the same as leaving it out
+	_, err = config.SQLitePath()
+	if !errors.Is(err, ErrNotSet) {
+		t.Fatalf("expected a path set in no layer to fail with %v, got %v", ErrNotSet, err)
+	}
+}
+
+func TestLayeredInvalid(t *testing.T) {
+	setenv(t, GCIntervalEnv, "soon")
+
+	config := load(t, "-"+ConfigFileFlag, writeFile(t, "meditime.yaml", "gc_interval: 1m\n"))
+
+	// an invalid value stops the resolution instead of falling through
+	_, err := config.GCInterval()
+	if err == nil || errors.Is(err, ErrNotSet) {
+		t.Fatalf("expected an invalid env variable to fail, got %v", err)
+	}
+
+	config = load(t, "-"+GCIntervalFlag, fmt.Sprint(time.Minute))
+
+	interval, err := config.GCInterval()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if interval != time.Minute {
+		t.Fatalf("expected the flag to override the invalid env variable, got %s", interval)
+	}
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readSecretFile such as a Docker or Kubernetes secret, ignoring surrounding
+// whitespace like a trailing newline
+func readSecretFile(path, description string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from file %s: %w", description, path, err)
+	}
+
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s file %s is empty", description, path)
+	}
+
+	return secret, nil
+}
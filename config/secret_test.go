@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvSecretFile(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		value    *string
+		file     *string
+		expected string
+		notSet   bool
+	}{
+		{name: "variable", value: stringPtr("token"), expected: "token"},
+		{name: "file", file: stringPtr("token"), expected: "token"},
+		{name: "trailing newline", file: stringPtr("token\n"), expected: "token"},
+		{name: "surrounding whitespace", file: stringPtr("  token \r\n"), expected: "token"},
+		{name: "variable over file", value: stringPtr("token"), file: stringPtr("other\n"), expected: "token"},
+		{name: "empty file", file: stringPtr("\n")},
+		{name: "neither", notSet: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.value != nil {
+				setenv(t, PushoverAPITokenEnv, *test.value)
+			}
+
+			if test.file != nil {
+				setenv(t, PushoverAPITokenEnv+FileEnvSuffix, writeFile(t, "token", *test.file))
+			}
+
+			expectSecret(t, (&Env{}).PushoverAPIToken, test.expected, test.notSet)
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		setenv(t, PushoverAPITokenEnv+FileEnvSuffix, filepath.Join(t.TempDir(), "missing"))
+
+		expectSecret(t, (&Env{}).PushoverAPIToken, "", false)
+	})
+}
+
+func TestFileSecretFile(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		yaml     string
+		file     *string
+		expected string
+		notSet   bool
+	}{
+		{name: "value", yaml: "pushover_api_token: token\n", expected: "token"},
+		{name: "file", file: stringPtr("token\n"), expected: "token"},
+		{name: "value over file", yaml: "pushover_api_token: token\n", file: stringPtr("other\n"), expected: "token"},
+		{name: "empty file", file: stringPtr("")},
+		{name: "neither", notSet: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			yaml := test.yaml
+			if test.file != nil {
+				yaml += "pushover_api_token_file: " + writeFile(t, "token", *test.file) + "\n"
+			}
+
+			file, err := NewFile(writeFile(t, "meditime.yaml", yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectSecret(t, file.PushoverAPIToken, test.expected, test.notSet)
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// expectSecret from get, an empty expected secret expects get to fail
+func expectSecret(t *testing.T, get func() (string, error), expected string, notSet bool) {
+	t.Helper()
+
+	secret, err := get()
+	if expected == "" {
+		if err == nil {
+			t.Fatalf("expected to fail, got secret %q", secret)
+		}
+
+		if errors.Is(err, ErrNotSet) != notSet {
+			t.Fatalf("expected the secret not to be set to be %t, got %v", notSet, err)
+		}
+
+		return
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if secret != expected {
+		t.Fatalf("expected secret %q, got %q", expected, secret)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"git.0xdad.com/tblyler/meditime/db"
+)
+
+var migrationHeader = []string{"VERSION", "DESCRIPTION"}
+
+var checkHeader = []string{"KEY", "PROBLEM", "REPAIR", "REPAIRED"}
+
+// exitCheckProblems is the exit code of db check when problems are left in
+// the database
+const exitCheckProblems = 2
+
+// readEncryptionKeyFile with a hex encoded encryption key at path, which is
+// how keys other than the configured one are given since secrets aren't
+// accepted as flags
+func readEncryptionKeyFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("the -new-key-file flag is required")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key from file %s: %w", path, err)
+	}
+
+	return db.ParseEncryptionKey(strings.TrimSpace(string(data)))
+}
+
+// offlineBadger is the path and encryption key of the badger database for
+// commands that rewrite it and so must run while it isn't open
+func (c *cli) offlineBadger() (string, []byte, error) {
+	store, err := c.config.Store()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if store != storeBadger {
+		return "", nil, fmt.Errorf("only the %s store is encrypted, the configured store is %s", storeBadger, store)
+	}
+
+	badgerPath, err := c.config.BadgerPath()
+	if err != nil {
+		return "", nil, err
+	}
+
+	encryptionKey, err := c.encryptionKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return badgerPath, encryptionKey, nil
+}
+
+type schemaVersion struct {
+	Database uint64 `json:"database"`
+	Binary   uint64 `json:"binary"`
+}
+
+var dbCommand = &command{
+	name:    "db",
+	summary: "Manage the database",
+	subcommands: []*command{
+		{
+			name:    "version",
+			summary: "Show the schema version of the database and of this binary",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				output := outputFlag(flagSet)
+
+				return func() error {
+					b, err := c.unmigratedDB()
+					if err != nil {
+						return err
+					}
+
+					version, err := b.SchemaVersion()
+					if err != nil {
+						return err
+					}
+
+					return c.print(*output, schemaVersion{
+						Database: version,
+						Binary:   db.SchemaVersion(),
+					}, []string{"DATABASE", "BINARY"}, [][]string{{
+						strconv.FormatUint(version, 10),
+						strconv.FormatUint(db.SchemaVersion(), 10),
+					}})
+				}
+			},
+		},
+		{
+			name:    "migrate",
+			summary: "Migrate the database to the schema version of this binary",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				dryRun := flagSet.Bool("dry-run", false, "run the pending migrations without saving them")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					b, err := c.unmigratedDB()
+					if err != nil {
+						return err
+					}
+
+					migrations, err := b.Migrate(*dryRun)
+					if err != nil {
+						return err
+					}
+
+					rows := make([][]string, 0, len(migrations))
+					for _, migration := range migrations {
+						rows = append(rows, []string{strconv.FormatUint(migration.Version, 10), migration.Description})
+					}
+
+					return c.print(*output, migrations, migrationHeader, rows)
+				}
+			},
+		},
+		{
+			name:    "check",
+			summary: "Check every key of the database for invalid values and dangling references, exiting with 2 when problems are left",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				repair := flagSet.Bool("repair", false, "fix the problems that can be fixed without losing referenced data")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					actor, err := c.config.Actor()
+					if err != nil {
+						return err
+					}
+
+					b, err := c.unmigratedDB()
+					if err != nil {
+						return err
+					}
+
+					report, err := b.Check(*repair, actor)
+					if err != nil {
+						return err
+					}
+
+					rows := make([][]string, 0, len(report.Problems))
+					for _, problem := range report.Problems {
+						rows = append(rows, []string{problem.Key, problem.Problem, problem.Repair, strconv.FormatBool(problem.Repaired)})
+					}
+
+					err = c.print(*output, report, checkHeader, rows)
+					if err != nil {
+						return err
+					}
+
+					if unrepaired := report.Unrepaired(); unrepaired > 0 {
+						return &exitError{
+							code: exitCheckProblems,
+							err:  fmt.Errorf("%d problems left in the %d keys checked", unrepaired, report.Keys),
+						}
+					}
+
+					return nil
+				}
+			},
+		},
+		{
+			name:    "encrypt",
+			summary: "Rewrite the database encrypted with a new key, which also encrypts a plaintext database",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				newKeyFile := flagSet.String("new-key-file", "", "file with the new hex encoded encryption key, e.g. from 'openssl rand -hex 32'")
+
+				return func() error {
+					newKey, err := readEncryptionKeyFile(*newKeyFile)
+					if err != nil {
+						return err
+					}
+
+					badgerPath, encryptionKey, err := c.offlineBadger()
+					if err != nil {
+						return err
+					}
+
+					err = db.EncryptBadger(badgerPath, encryptionKey, newKey)
+					if err != nil {
+						return err
+					}
+
+					fmt.Fprintf(c.stdout, "encrypted %s, configure the new key as the encryption key before starting meditime again\n", badgerPath)
+
+					return nil
+				}
+			},
+		},
+		{
+			name:    "rotate-key",
+			summary: "Re-encrypt the data keys of an encrypted database with a new key",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				newKeyFile := flagSet.String("new-key-file", "", "file with the new hex encoded encryption key, e.g. from 'openssl rand -hex 32'")
+
+				return func() error {
+					newKey, err := readEncryptionKeyFile(*newKeyFile)
+					if err != nil {
+						return err
+					}
+
+					badgerPath, encryptionKey, err := c.offlineBadger()
+					if err != nil {
+						return err
+					}
+
+					err = db.RotateBadgerKey(badgerPath, encryptionKey, newKey)
+					if err != nil {
+						return err
+					}
+
+					fmt.Fprintf(c.stdout, "rotated the encryption key of %s, configure the new key as the encryption key before starting meditime again\n", badgerPath)
+
+					return nil
+				}
+			},
+		},
+		{
+			name:    "upgrade",
+			summary: "Convert a database written by meditime releases using badger v1 to the current format",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				return func() error {
+					badgerPath, encryptionKey, err := c.offlineBadger()
+					if err != nil {
+						return err
+					}
+
+					err = db.UpgradeBadger(badgerPath, encryptionKey)
+					if err != nil {
+						return err
+					}
+
+					fmt.Fprintf(c.stdout, "upgraded %s\n", badgerPath)
+
+					return nil
+				}
+			},
+		},
+	},
+}
@@ -0,0 +1,406 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditAction taken on a record
+type AuditAction string
+
+const (
+	// AuditCreate of a record
+	AuditCreate AuditAction = "create"
+	// AuditUpdate of a record
+	AuditUpdate AuditAction = "update"
+	// AuditDelete of a record
+	AuditDelete AuditAction = "delete"
+)
+
+// AuditRecord is the kind of record an audit entry is about
+type AuditRecord string
+
+const (
+	// AuditRecordUser is a user, with the keys of its devices redacted
+	AuditRecordUser AuditRecord = "user"
+	// AuditRecordDevice is a pushover device of a user identified by its name
+	AuditRecordDevice AuditRecord = "device"
+	// AuditRecordMedication is a medication
+	AuditRecordMedication AuditRecord = "medication"
+	// AuditRecordMedicationVersion is a prior version of a medication
+	AuditRecordMedicationVersion AuditRecord = "medication_version"
+	// AuditRecordDose is a dose
+	AuditRecordDose AuditRecord = "dose"
+)
+
+// redactedToken replaces the pushover keys of devices in audit entries
+const redactedToken = "redacted"
+
+// AuditEntry of a single change to a record, entries are append-only
+type AuditEntry struct {
+	ID       uuid.UUID   `json:"id"`
+	Time     time.Time   `json:"time"`
+	Actor    string      `json:"actor"`
+	Action   AuditAction `json:"action"`
+	Record   AuditRecord `json:"record"`
+	RecordID string      `json:"record_id"`
+	IDUser   uuid.UUID   `json:"id_user"`
+	// Username of the user the record belongs to at the time of the change
+	Username string          `json:"username"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+}
+
+func (e *AuditEntry) badgerKey() []byte {
+	return append(badgerPrefixKeyForAuditSince(e.Time), e.ID[:]...)
+}
+
+func badgerPrefixKeyForAuditSince(since time.Time) []byte {
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(since.UnixNano()))
+
+	return append([]byte(badgerPrefixKeyAudit), timestamp...)
+}
+
+const badgerPrefixKeyAudit = "audit:"
+
+// AuditFilter of ListAuditEntries
+type AuditFilter struct {
+	// IDUser of the user the entries belong to, uuid.Nil for every user
+	IDUser uuid.UUID
+	// Username is the case-insensitive name at the time of the change of the
+	// user the entries belong to, for users that were removed since. Empty
+	// for every user.
+	Username string
+	// Since only lists entries made at or after it
+	Since time.Time
+}
+
+// since is Since moved up to the Unix epoch, before which there are no
+// entries and timestamps don't fit keys
+func (f AuditFilter) since() time.Time {
+	if epoch := time.Unix(0, 0); f.Since.Before(epoch) {
+		return epoch
+	}
+
+	return f.Since
+}
+
+func (f AuditFilter) matches(entry *AuditEntry) bool {
+	if entry.Time.Before(f.Since) {
+		return false
+	}
+
+	if f.IDUser != uuid.Nil && f.IDUser != entry.IDUser {
+		return false
+	}
+
+	return f.Username == "" || normalizeUsername(f.Username) == normalizeUsername(entry.Username)
+}
+
+var _ Store = (*Audited)(nil)
+
+// Audited store that adds an AuditEntry attributed to Actor for every change
+// made through it, in the same transaction as the change
+type Audited struct {
+	Store
+	Actor string
+}
+
+// NewAudited store attributing every change made through it to actor, which
+// must not be empty
+func NewAudited(store Store, actor string) *Audited {
+	return &Audited{
+		Store: store.withActor(actor),
+		Actor: actor,
+	}
+}
+
+// Unwrap the store an Audited store records the changes to, any other store
+// is returned as is
+func Unwrap(store Store) Store {
+	if audited, ok := store.(*Audited); ok {
+		return audited.Store
+	}
+
+	return store
+}
+
+type auditDevice struct {
+	Name string `json:"name"`
+}
+
+// redactUser copies user without the keys of its devices
+func redactUser(user *User) *User {
+	redacted := *user
+	redacted.PushoverDevices = make(map[string]*PushoverDevice, len(user.PushoverDevices))
+	for name, device := range user.PushoverDevices {
+		device := *device
+		device.Key = redactedToken
+		redacted.PushoverDevices[name] = &device
+	}
+
+	return &redacted
+}
+
+// newAuditEntry made now, before and after are left out when nil
+func newAuditEntry(actor string, action AuditAction, record AuditRecord, recordID string, idUser uuid.UUID, username string, before, after interface{}) (*AuditEntry, error) {
+	entry := &AuditEntry{
+		ID:       uuid.New(),
+		Time:     time.Now(),
+		Actor:    actor,
+		Action:   action,
+		Record:   record,
+		RecordID: recordID,
+		IDUser:   idUser,
+		Username: username,
+	}
+
+	var err error
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+		if err != nil {
+			return nil, fmt.Errorf("failed to JSON marshal audited %s: %w", record, err)
+		}
+	}
+
+	if after != nil {
+		entry.After, err = json.Marshal(after)
+		if err != nil {
+			return nil, fmt.Errorf("failed to JSON marshal audited %s: %w", record, err)
+		}
+	}
+
+	return entry, nil
+}
+
+// auditLog of the changes made in a single transaction of a store, which the
+// store writes along with them. A nil auditLog records nothing, for stores
+// that aren't audited.
+type auditLog struct {
+	actor string
+	// getUserByID in the transaction, for the usernames of entries
+	getUserByID func(id uuid.UUID) (*User, error)
+	entries     []*AuditEntry
+}
+
+// newAuditLog attributed to actor, nil when actor is empty
+func newAuditLog(actor string, getUserByID func(id uuid.UUID) (*User, error)) *auditLog {
+	if actor == "" {
+		return nil
+	}
+
+	return &auditLog{
+		actor:       actor,
+		getUserByID: getUserByID,
+	}
+}
+
+// write each recorded entry with add
+func (l *auditLog) write(add func(entry *AuditEntry) error) error {
+	if l == nil {
+		return nil
+	}
+
+	for _, entry := range l.entries {
+		err := add(entry)
+		if err != nil {
+			return fmt.Errorf("failed to add audit entry for %s %s %s: %w", entry.Action, entry.Record, entry.RecordID, err)
+		}
+	}
+
+	return nil
+}
+
+// record an audit entry, before and after are left out when nil
+func (l *auditLog) record(action AuditAction, record AuditRecord, recordID string, idUser uuid.UUID, username string, before, after interface{}) error {
+	if l == nil {
+		return nil
+	}
+
+	entry, err := newAuditEntry(l.actor, action, record, recordID, idUser, username, before, after)
+	if err != nil {
+		return err
+	}
+
+	l.entries = append(l.entries, entry)
+
+	return nil
+}
+
+// username of the user with the given ID, empty when it doesn't exist
+func (l *auditLog) username(idUser uuid.UUID) (string, error) {
+	user, err := l.getUserByID(idUser)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return user.Name, nil
+}
+
+func (l *auditLog) user(action AuditAction, before, after *User) error {
+	if l == nil {
+		return nil
+	}
+
+	user := after
+	if user == nil {
+		user = before
+	}
+
+	var beforeValue, afterValue interface{}
+	if before != nil {
+		beforeValue = redactUser(before)
+	}
+
+	if after != nil {
+		afterValue = redactUser(after)
+	}
+
+	return l.record(action, AuditRecordUser, user.ID.String(), user.ID, user.Name, beforeValue, afterValue)
+}
+
+func (l *auditLog) medication(action AuditAction, before, after *Medication) error {
+	if l == nil {
+		return nil
+	}
+
+	medication := after
+	if medication == nil {
+		medication = before
+	}
+
+	username, err := l.username(medication.IDUser)
+	if err != nil {
+		return err
+	}
+
+	var beforeValue, afterValue interface{}
+	if before != nil {
+		beforeValue = before
+	}
+
+	if after != nil {
+		afterValue = after
+	}
+
+	return l.record(action, AuditRecordMedication, medication.ID.String(), medication.IDUser, username, beforeValue, afterValue)
+}
+
+func (l *auditLog) medicationVersion(version *MedicationVersion) error {
+	if l == nil {
+		return nil
+	}
+
+	username, err := l.username(version.IDUser)
+	if err != nil {
+		return err
+	}
+
+	return l.record(AuditCreate, AuditRecordMedicationVersion, version.ID.String(), version.IDUser, username, nil, version)
+}
+
+func (l *auditLog) dose(action AuditAction, before, after *Dose) error {
+	if l == nil {
+		return nil
+	}
+
+	username, err := l.username(after.IDUser)
+	if err != nil {
+		return err
+	}
+
+	var beforeValue interface{}
+	if before != nil {
+		beforeValue = before
+	}
+
+	return l.record(action, AuditRecordDose, after.ID.String(), after.IDUser, username, beforeValue, after)
+}
+
+func (l *auditLog) device(action AuditAction, user *User, before, after string) error {
+	var beforeValue, afterValue interface{}
+	if before != "" {
+		beforeValue = auditDevice{Name: before}
+	}
+
+	if after != "" {
+		afterValue = auditDevice{Name: after}
+	}
+
+	recordID := after
+	if recordID == "" {
+		recordID = before
+	}
+
+	return l.record(action, AuditRecordDevice, recordID, user.ID, user.Name, beforeValue, afterValue)
+}
+
+// updateUser records the change made to user by update
+func (l *auditLog) updateUser(user *User, update func() error) error {
+	if l == nil {
+		return update()
+	}
+
+	before := cloneUser(user)
+
+	err := update()
+	if err != nil {
+		return err
+	}
+
+	return l.user(AuditUpdate, before, user)
+}
+
+// updateMedication records the change made to medication by update
+func (l *auditLog) updateMedication(medication *Medication, update func() error) error {
+	if l == nil {
+		return update()
+	}
+
+	before := cloneMedication(medication)
+
+	err := update()
+	if err != nil {
+		return err
+	}
+
+	return l.medication(AuditUpdate, before, medication)
+}
+
+// updateMedications records the change made to each of the medications that
+// update returns as changed, such as by renaming or removing a device they
+// remind
+func (l *auditLog) updateMedications(medications []*Medication, update func() ([]*Medication, error)) ([]*Medication, error) {
+	if l == nil {
+		return update()
+	}
+
+	before := make(map[uuid.UUID]*Medication, len(medications))
+	for _, medication := range medications {
+		before[medication.ID] = cloneMedication(medication)
+	}
+
+	changed, err := update()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, medication := range changed {
+		err = l.medication(AuditUpdate, before[medication.ID], medication)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return changed, nil
+}
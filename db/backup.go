@@ -0,0 +1,66 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ErrNotEmpty occurs when restoring into a database that already has data
+var ErrNotEmpty = errors.New("database is not empty")
+
+// Backup a full snapshot of the database to w in badger's backup format, the
+// snapshot is plaintext even when the database is encrypted
+func (b *Badger) Backup(w io.Writer) error {
+	_, err := b.db.Backup(w, 0)
+	if err != nil {
+		return fmt.Errorf("failed to backup badger db: %w", err)
+	}
+
+	return nil
+}
+
+// Restore a backup written by Backup into the database, which must be empty
+// and should be opened with NewUnmigratedBadger, migrating the restored data
+// to the schema version of this binary afterwards. The database is left
+// empty again when the backup fails to load or migrate, e.g. since it is of
+// a newer schema version.
+func (b *Badger) Restore(r io.Reader) error {
+	err := b.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		it.Rewind()
+		if it.Valid() {
+			return ErrNotEmpty
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.db.Load(r, 256)
+	if err != nil {
+		err = fmt.Errorf("failed to load backup into badger db: %w", err)
+	} else {
+		_, err = b.Migrate(false)
+	}
+
+	if err != nil {
+		dropErr := b.db.DropAll()
+		if dropErr != nil {
+			return fmt.Errorf("%w, then failed to drop the restored data: %v", err, dropErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
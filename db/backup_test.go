@@ -0,0 +1,106 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// backupWithUser of a new database with the user alice
+func backupWithUser(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	b, err := NewBadger(t.TempDir(), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	err = b.AddUser(&User{ID: uuid.New(), Name: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup := &bytes.Buffer{}
+
+	err = b.Backup(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return backup
+}
+
+func TestRestore(t *testing.T) {
+	backup := backupWithUser(t)
+
+	b, err := NewUnmigratedBadger(t.TempDir(), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	err = b.Restore(bytes.NewReader(backup.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.GetUser("alice")
+	if err != nil {
+		t.Fatalf("expected the restored database to have the backed up user: %v", err)
+	}
+
+	err = b.Restore(bytes.NewReader(backup.Bytes()))
+	if !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("expected restoring into a database with data to fail with %v, got %v", ErrNotEmpty, err)
+	}
+}
+
+func TestRestoreSchemaTooNew(t *testing.T) {
+	current := SchemaVersion()
+
+	var applied []uint64
+	withMigrations(t, &applied, 1)
+
+	backup := backupWithUser(t)
+
+	// the binary without the test migration is older than the backup
+	migrations = migrations[:current]
+
+	b, err := NewUnmigratedBadger(t.TempDir(), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	err = b.Restore(backup)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected restoring a newer backup to fail with %v, got %v", ErrSchemaTooNew, err)
+	}
+
+	users, err := b.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := b.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 0 || version != 0 {
+		t.Fatalf("expected the newer backup to be dropped, got %d users at schema version %d", len(users), version)
+	}
+
+	// the database is empty so an older backup can be restored instead
+	err = b.Restore(backupWithUser(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,25 +3,64 @@ package db
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
-	"github.com/dgraph-io/badger"
+	"git.0xdad.com/tblyler/meditime/metrics"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
 )
 
+var (
+	// ErrUserHasMedications occurs when removing a user that still has medications
+	ErrUserHasMedications = errors.New("user has medications")
+	// ErrDeviceInUse occurs when removing a device would leave a medication
+	// without any device to remind
+	ErrDeviceInUse = errors.New("device is in use")
+)
+
+var _ Store = (*Badger)(nil)
+
 // Badger db implementation
 type Badger struct {
 	db       *badger.DB
 	cancelGC func()
-	wg       sync.WaitGroup
+	wg       *sync.WaitGroup
+	// actor the changes are audited as made by, none are when empty
+	actor string
+}
+
+// NewBadger creates a new badger instance for the given path that garbage
+// collects its value log every gcInterval, migrating the database to the
+// schema version of this binary first. The database is encrypted with
+// encryptionKey unless it is empty, see openBadger.
+func NewBadger(dbPath string, gcInterval time.Duration, encryptionKey []byte) (*Badger, error) {
+	b, err := NewUnmigratedBadger(dbPath, gcInterval, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = b.Migrate(false)
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("failed to migrate badger db at path %s: %w", dbPath, err)
+	}
+
+	return b, nil
 }
 
-// NewBadger creates a new badger instance for the given path
-func NewBadger(dbPath string) (*Badger, error) {
-	db, err := badger.Open(badger.DefaultOptions(dbPath).WithLogger(nil))
+// NewUnmigratedBadger is NewBadger without migrating the database, only for
+// inspecting or migrating it through Migrate
+func NewUnmigratedBadger(dbPath string, gcInterval time.Duration, encryptionKey []byte) (*Badger, error) {
+	if gcInterval <= 0 {
+		return nil, fmt.Errorf("badger GC interval must be positive, got %s", gcInterval)
+	}
+
+	db, err := openBadger(dbPath, encryptionKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open badger db at path %s: %w", dbPath, err)
+		return nil, err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -29,19 +68,33 @@ func NewBadger(dbPath string) (*Badger, error) {
 	b := &Badger{
 		db:       db,
 		cancelGC: cancel,
+		wg:       &sync.WaitGroup{},
 	}
 
 	b.wg.Add(1)
 	go func() {
 		defer b.wg.Done()
 
-		ticker := time.NewTicker(time.Hour)
+		ticker := time.NewTicker(gcInterval)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ticker.C:
-				for b.db.RunValueLogGC(0.5) == nil && ctx.Err() == nil {
+				for ctx.Err() == nil {
+					err := b.db.RunValueLogGC(0.5)
+					if err == nil {
+						metrics.BadgerGCRuns.WithLabelValues("rewritten").Inc()
+						continue
+					}
+
+					if errors.Is(err, badger.ErrNoRewrite) {
+						metrics.BadgerGCRuns.WithLabelValues("noop").Inc()
+					} else {
+						metrics.BadgerGCRuns.WithLabelValues("error").Inc()
+					}
+
+					break
 				}
 
 			case <-ctx.Done():
@@ -61,51 +114,405 @@ func (b *Badger) Close() error {
 	return b.db.Close()
 }
 
+// Ping checks that the database can serve reads
+func (b *Badger) Ping() error {
+	return b.db.View(func(tx *badger.Txn) error {
+		_, err := tx.Get([]byte("ping"))
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return fmt.Errorf("failed to read from badger: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// withActor copy of the database auditing the changes made through it
+func (b *Badger) withActor(actor string) Store {
+	audited := *b
+	audited.actor = actor
+
+	return &audited
+}
+
+// updateAudited runs fn in a transaction that also adds the audit entries fn
+// records when the database is audited
+func (b *Badger) updateAudited(fn func(tx *badger.Txn, audit *auditLog) error) error {
+	return b.db.Update(func(tx *badger.Txn) error {
+		audit := newAuditLog(b.actor, func(id uuid.UUID) (*User, error) {
+			return getUserByID(tx, id)
+		})
+
+		err := fn(tx, audit)
+		if err != nil {
+			return err
+		}
+
+		return audit.write(func(entry *AuditEntry) error {
+			return setAuditEntry(tx, entry)
+		})
+	})
+}
+
+// Size of the database's LSM tree and value log in bytes
+func (b *Badger) Size() (lsm, vlog int64) {
+	return b.db.Size()
+}
+
 // AddUser to the database
 func (b *Badger) AddUser(user *User) error {
-	return b.db.Update(func(tx *badger.Txn) error {
-		data, err := json.Marshal(user)
+	return b.updateAudited(func(tx *badger.Txn, audit *auditLog) error {
+		if _, err := tx.Get(badgerKeyForUsername(user.Name)); err == nil {
+			return fmt.Errorf("user %s %w", user.Name, ErrAlreadyExists)
+		}
+
+		if _, err := tx.Get(user.badgerKey()); err == nil {
+			return fmt.Errorf("id user %s %w", user.ID.String(), ErrAlreadyExists)
+		}
+
+		err := tx.Set(badgerKeyForUsername(user.Name), user.ID[:])
 		if err != nil {
-			return fmt.Errorf("failed to JSON marshal user: %w", err)
+			return err
 		}
 
-		key := user.badgerKey()
-		if _, err = tx.Get(key); err == nil {
-			return fmt.Errorf("user %s already exists", user.Name)
+		err = setUser(tx, user)
+		if err != nil {
+			return err
 		}
 
-		return tx.Set(key, data)
+		return audit.user(AuditCreate, nil, user)
 	})
 }
 
-// GetUser from the database
+// GetUser from the database by its case-insensitive username
 func (b *Badger) GetUser(username string) (user *User, err error) {
 	err = b.db.View(func(tx *badger.Txn) error {
-		item, err := tx.Get(badgerKeyForUsername(username))
+		user, err = getUser(tx, username)
+		return err
+	})
+
+	return
+}
+
+// GetUserByID from the database
+func (b *Badger) GetUserByID(id uuid.UUID) (user *User, err error) {
+	err = b.db.View(func(tx *badger.Txn) error {
+		user, err = getUserByID(tx, id)
+		return err
+	})
+
+	return
+}
+
+func getUser(tx *badger.Txn, username string) (*User, error) {
+	item, err := tx.Get(badgerKeyForUsername(username))
+	if err != nil {
+		err = notFound(err)
+		return nil, fmt.Errorf("failed to get user id for username %s: %w", username, err)
+	}
+
+	var id uuid.UUID
+	err = item.Value(func(val []byte) error {
+		id, err = uuid.FromBytes(val)
 		if err != nil {
-			return fmt.Errorf("failed to get user value for username %s: %w", username, err)
+			return fmt.Errorf("failed to parse user id for username %s: %w", username, err)
 		}
 
-		user = &User{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		return item.Value(func(val []byte) error {
-			err = json.Unmarshal(val, user)
+	return getUserByID(tx, id)
+}
+
+func getUserByID(tx *badger.Txn, id uuid.UUID) (*User, error) {
+	item, err := tx.Get(badgerKeyForUserID(id))
+	if err != nil {
+		err = notFound(err)
+		return nil, fmt.Errorf("failed to get user value for id user %s: %w", id.String(), err)
+	}
+
+	user := &User{}
+
+	err = item.Value(func(val []byte) error {
+		err := json.Unmarshal(val, user)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal user value for id user %s: %w", id.String(), err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func setUser(tx *badger.Txn, user *User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal user: %w", err)
+	}
+
+	return tx.Set(user.badgerKey(), data)
+}
+
+// RemoveUser from the database, refusing with ErrUserHasMedications when the
+// user still has medications unless cascade is set, in which case the
+// medications and doses of the user are removed as well
+func (b *Badger) RemoveUser(user *User, cascade bool) error {
+	return b.updateAudited(func(tx *badger.Txn, audit *auditLog) error {
+		user, err := getUserByID(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		medications, err := listMedicationsForUser(tx, user)
+		if err != nil {
+			return err
+		}
+
+		if len(medications) > 0 && !cascade {
+			return fmt.Errorf("user %s has %d medications: %w", user.Name, len(medications), ErrUserHasMedications)
+		}
+
+		for _, medication := range medications {
+			err = tx.Delete(badgerKeyForMedicationUser(medication.ID))
 			if err != nil {
-				return fmt.Errorf("failed to unmarshal user value for username %s: %w", username, err)
+				return err
 			}
 
-			return nil
+			err = audit.record(AuditDelete, AuditRecordMedication, medication.ID.String(), user.ID, user.Name, medication, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		prefixes := [][]byte{
+			badgerPrefixKeyForMedicationUser(user),
+			badgerPrefixKeyForMedicationHistoryUser(user),
+			badgerPrefixKeyForDoseUser(user),
+		}
+
+		for _, prefix := range prefixes {
+			err = deletePrefix(tx, prefix)
+			if err != nil {
+				return err
+			}
+		}
+
+		notifications, err := listNotifications(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, notification := range notifications {
+			if notification.IDUser != user.ID {
+				continue
+			}
+
+			err = tx.Delete(notification.badgerKey())
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Delete(badgerKeyForUsername(user.Name))
+		if err != nil {
+			return err
+		}
+
+		err = tx.Delete(user.badgerKey())
+		if err != nil {
+			return err
+		}
+
+		return audit.user(AuditDelete, user, nil)
+	})
+}
+
+// RenameUser in the database
+func (b *Badger) RenameUser(user *User, newName string) error {
+	return b.updateUser(user, func(tx *badger.Txn, user *User) error {
+		existing, err := getUser(tx, newName)
+		if err == nil && existing.ID != user.ID {
+			return fmt.Errorf("user %s %w", newName, ErrAlreadyExists)
+		}
+
+		err = tx.Delete(badgerKeyForUsername(user.Name))
+		if err != nil {
+			return err
+		}
+
+		user.Name = newName
+
+		return tx.Set(badgerKeyForUsername(user.Name), user.ID[:])
+	})
+}
+
+// AddUserDevice to the pushover devices of a user
+func (b *Badger) AddUserDevice(user *User, name string, device *PushoverDevice) error {
+	return b.updateUserAudited(user, func(tx *badger.Txn, audit *auditLog, user *User) error {
+		err := addUserDevice(user, name, device)
+		if err != nil {
+			return err
+		}
+
+		return audit.device(AuditCreate, user, "", name)
+	})
+}
+
+// UpdateUserDevice of a user
+func (b *Badger) UpdateUserDevice(user *User, name string, device *PushoverDevice) error {
+	return b.updateUser(user, func(tx *badger.Txn, user *User) error {
+		return updateUserDevice(user, name, device)
+	})
+}
+
+// RenameUserDevice of a user along with every medication reminding it
+func (b *Badger) RenameUserDevice(user *User, device, newDevice string) error {
+	return b.updateUserAudited(user, func(tx *badger.Txn, audit *auditLog, user *User) error {
+		medications, err := listMedicationsForUser(tx, user)
+		if err != nil {
+			return err
+		}
+
+		err = audit.device(AuditUpdate, user, device, newDevice)
+		if err != nil {
+			return err
+		}
+
+		medications, err = audit.updateMedications(medications, func() ([]*Medication, error) {
+			return renameUserDevice(user, medications, device, newDevice)
 		})
+		if err != nil {
+			return err
+		}
+
+		return setMedications(tx, medications)
 	})
+}
 
-	return
+// RemoveUserDevice of a user and from every medication reminding it, refusing
+// with ErrDeviceInUse when a medication would be left without any device
+func (b *Badger) RemoveUserDevice(user *User, device string) error {
+	return b.updateUserAudited(user, func(tx *badger.Txn, audit *auditLog, user *User) error {
+		medications, err := listMedicationsForUser(tx, user)
+		if err != nil {
+			return err
+		}
+
+		err = audit.device(AuditDelete, user, device, "")
+		if err != nil {
+			return err
+		}
+
+		medications, err = audit.updateMedications(medications, func() ([]*Medication, error) {
+			return removeUserDevice(user, medications, device)
+		})
+		if err != nil {
+			return err
+		}
+
+		return setMedications(tx, medications)
+	})
+}
+
+// PauseUser reminders for all of the user's medications until resumeAt, or
+// until resumed when resumeAt is nil
+func (b *Badger) PauseUser(user *User, resumeAt *time.Time) error {
+	return b.updateUser(user, func(tx *badger.Txn, user *User) error {
+		user.Pause = Pause{
+			Paused:   true,
+			ResumeAt: resumeAt,
+		}
+
+		return nil
+	})
+}
+
+// ResumeUser reminders for all of the user's medications
+func (b *Badger) ResumeUser(user *User) error {
+	return b.updateUser(user, func(tx *badger.Txn, user *User) error {
+		user.Pause = Pause{}
+
+		return nil
+	})
+}
+
+// updateUser applies update to the stored copy of user in a transaction,
+// recording the change, and copies the result back into user once it is saved
+func (b *Badger) updateUser(user *User, update func(tx *badger.Txn, user *User) error) error {
+	return b.updateUserAudited(user, func(tx *badger.Txn, audit *auditLog, user *User) error {
+		return audit.updateUser(user, func() error {
+			return update(tx, user)
+		})
+	})
+}
+
+// updateUserAudited is updateUser leaving recording the change to update
+func (b *Badger) updateUserAudited(user *User, update func(tx *badger.Txn, audit *auditLog, user *User) error) error {
+	return b.updateAudited(func(tx *badger.Txn, audit *auditLog) error {
+		existing, err := getUserByID(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = update(tx, audit, existing)
+		if err != nil {
+			return err
+		}
+
+		err = setUser(tx, existing)
+		if err != nil {
+			return err
+		}
+
+		*user = *existing
+
+		return nil
+	})
+}
+
+// notFound converts badger's missing key error to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func deletePrefix(tx *badger.Txn, prefix []byte) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+
+	it := tx.NewIterator(opts)
+	defer it.Close()
+
+	var keys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+
+	for _, key := range keys {
+		err := tx.Delete(key)
+		if err != nil {
+			return fmt.Errorf("failed to delete key %s: %w", string(key), err)
+		}
+	}
+
+	return nil
 }
 
 // ListUsers from the database
 func (b *Badger) ListUsers() (users []*User, err error) {
 	err = b.db.View(func(tx *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte("user:")
+		opts.Prefix = []byte(badgerPrefixKeyUserID)
 
 		it := tx.NewIterator(opts)
 		defer it.Close()
@@ -138,43 +545,448 @@ func (b *Badger) ListUsers() (users []*User, err error) {
 
 // AddMedication to the database
 func (b *Badger) AddMedication(medication *Medication) error {
-	return b.db.Update(func(tx *badger.Txn) error {
-		data, err := json.Marshal(medication)
+	return b.updateAudited(func(tx *badger.Txn, audit *auditLog) error {
+		if _, err := tx.Get(badgerKeyForMedicationUser(medication.ID)); err == nil {
+			return fmt.Errorf("medication %s %w", medication.ID.String(), ErrAlreadyExists)
+		}
+
+		err := tx.Set(badgerKeyForMedicationUser(medication.ID), medication.IDUser[:])
+		if err != nil {
+			return err
+		}
+
+		err = setMedication(tx, medication)
+		if err != nil {
+			return err
+		}
+
+		return audit.medication(AuditCreate, nil, medication)
+	})
+}
+
+// GetMedication from the database by its ID alone
+func (b *Badger) GetMedication(id uuid.UUID) (medication *Medication, err error) {
+	err = b.db.View(func(tx *badger.Txn) error {
+		item, err := tx.Get(badgerKeyForMedicationUser(id))
+		if err != nil {
+			err = notFound(err)
+			return fmt.Errorf("failed to get id user for medication %s: %w", id.String(), err)
+		}
+
+		var idUser uuid.UUID
+		err = item.Value(func(val []byte) error {
+			idUser, err = uuid.FromBytes(val)
+			if err != nil {
+				return fmt.Errorf("failed to parse id user for medication %s: %w", id.String(), err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		medication, err = getMedicationForUser(tx, &User{ID: idUser}, id)
+		return err
+	})
+
+	return
+}
+
+// GetMedicationForUser from the database
+func (b *Badger) GetMedicationForUser(user *User, id uuid.UUID) (medication *Medication, err error) {
+	err = b.db.View(func(tx *badger.Txn) error {
+		medication, err = getMedicationForUser(tx, user, id)
+		return err
+	})
+
+	return
+}
+
+func getMedicationForUser(tx *badger.Txn, user *User, id uuid.UUID) (*Medication, error) {
+	item, err := tx.Get(badgerKeyForMedication(user, id))
+	if err != nil {
+		err = notFound(err)
+		return nil, fmt.Errorf("failed to get medication value for medication %s: %w", id.String(), err)
+	}
+
+	medication := &Medication{}
+
+	err = item.Value(func(val []byte) error {
+		err := json.Unmarshal(val, medication)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal medication value for medication %s: %w", id.String(), err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return medication, nil
+}
+
+// UpdateMedication that already exists in the database, keeping the version
+// it replaces in the medication's history attributed to author
+func (b *Badger) UpdateMedication(medication *Medication, author string) error {
+	return b.updateAudited(func(tx *badger.Txn, audit *auditLog) error {
+		existing, err := getMedicationForUser(tx, &User{ID: medication.IDUser}, medication.ID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		version := &MedicationVersion{
+			Medication: *existing,
+			ReplacedAt: now,
+			ReplacedBy: author,
+		}
+
+		data, err := json.Marshal(version)
+		if err != nil {
+			return fmt.Errorf("failed to JSON marshal medication version: %w", err)
+		}
+
+		err = tx.Set(version.badgerKey(), data)
+		if err != nil {
+			return err
+		}
+
+		medication.CreatedAt = existing.CreatedAt
+		medication.UpdatedAt = now
+
+		err = setMedication(tx, medication)
+		if err != nil {
+			return err
+		}
+
+		return audit.medication(AuditUpdate, existing, medication)
+	})
+}
+
+// PauseMedication reminders until resumeAt, or until resumed when resumeAt is nil
+func (b *Badger) PauseMedication(medication *Medication, resumeAt *time.Time) error {
+	return b.updateMedication(medication, func(medication *Medication) {
+		medication.Pause = Pause{
+			Paused:   true,
+			ResumeAt: resumeAt,
+		}
+	})
+}
+
+// ResumeMedication reminders
+func (b *Badger) ResumeMedication(medication *Medication) error {
+	return b.updateMedication(medication, func(medication *Medication) {
+		medication.Pause = Pause{}
+	})
+}
+
+// updateMedication applies update to the stored copy of medication in a
+// transaction and copies the result back into medication once it is saved
+func (b *Badger) updateMedication(medication *Medication, update func(medication *Medication)) error {
+	return b.updateAudited(func(tx *badger.Txn, audit *auditLog) error {
+		existing, err := getMedicationForUser(tx, &User{ID: medication.IDUser}, medication.ID)
+		if err != nil {
+			return err
+		}
+
+		err = audit.updateMedication(existing, func() error {
+			update(existing)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = setMedication(tx, existing)
 		if err != nil {
-			return fmt.Errorf("failed to JSON marshal medication: %w", err)
+			return err
 		}
 
-		return tx.Set(medication.badgerKey(), data)
+		*medication = *existing
+
+		return nil
 	})
 }
 
-// RemoveMedication from the database
+// AddMedicationVersion to the history of a medication as it is
+func (b *Badger) AddMedicationVersion(version *MedicationVersion) error {
+	return b.updateAudited(func(tx *badger.Txn, audit *auditLog) error {
+		data, err := json.Marshal(version)
+		if err != nil {
+			return fmt.Errorf("failed to JSON marshal medication version: %w", err)
+		}
+
+		err = tx.Set(version.badgerKey(), data)
+		if err != nil {
+			return err
+		}
+
+		return audit.medicationVersion(version)
+	})
+}
+
+// ListMedicationHistory of prior versions of a medication, oldest first
+func (b *Badger) ListMedicationHistory(medication *Medication) (versions []*MedicationVersion, err error) {
+	err = b.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = badgerPrefixKeyForMedicationHistory(medication.IDUser, medication.ID)
+
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+
+			err := item.Value(func(val []byte) error {
+				version := &MedicationVersion{}
+				err := json.Unmarshal(val, version)
+				if err != nil {
+					return fmt.Errorf("failed to unmarshal medication version for key %s: %w", string(item.Key()), err)
+				}
+
+				versions = append(versions, version)
+
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// RemoveMedication and its history from the database
 func (b *Badger) RemoveMedication(medication *Medication) error {
-	return b.db.Update(func(tx *badger.Txn) error {
-		return tx.Delete(medication.badgerKey())
+	return b.updateAudited(func(tx *badger.Txn, audit *auditLog) error {
+		// only the medications of the user are removed
+		existing, err := getMedicationForUser(tx, &User{ID: medication.IDUser}, medication.ID)
+		if err != nil {
+			return err
+		}
+
+		err = deletePrefix(tx, badgerPrefixKeyForMedicationHistory(medication.IDUser, medication.ID))
+		if err != nil {
+			return err
+		}
+
+		err = tx.Delete(badgerKeyForMedicationUser(medication.ID))
+		if err != nil {
+			return err
+		}
+
+		err = tx.Delete(medication.badgerKey())
+		if err != nil {
+			return err
+		}
+
+		return audit.medication(AuditDelete, existing, nil)
 	})
 }
 
 // ListMedicationsForUser from the database
 func (b *Badger) ListMedicationsForUser(user *User) (medications []*Medication, err error) {
+	err = b.db.View(func(tx *badger.Txn) error {
+		medications, err = listMedicationsForUser(tx, user)
+		return err
+	})
+
+	return
+}
+
+func listMedicationsForUser(tx *badger.Txn, user *User) (medications []*Medication, err error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = badgerPrefixKeyForMedicationUser(user)
+
+	it := tx.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+
+		err := item.Value(func(val []byte) error {
+			medication := &Medication{}
+			err := json.Unmarshal(val, medication)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal medication value for medication key %s: %w", string(item.Key()), err)
+			}
+
+			medications = append(medications, medication)
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return medications, nil
+}
+
+func setMedications(tx *badger.Txn, medications []*Medication) error {
+	for _, medication := range medications {
+		err := setMedication(tx, medication)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setMedication(tx *badger.Txn, medication *Medication) error {
+	data, err := json.Marshal(medication)
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal medication: %w", err)
+	}
+
+	return tx.Set(medication.badgerKey(), data)
+}
+
+// AddDose to the database
+func (b *Badger) AddDose(dose *Dose) error {
+	return b.updateAudited(func(tx *badger.Txn, audit *auditLog) error {
+		data, err := json.Marshal(dose)
+		if err != nil {
+			return fmt.Errorf("failed to JSON marshal dose: %w", err)
+		}
+
+		err = tx.Set(dose.badgerKey(), data)
+		if err != nil {
+			return err
+		}
+
+		return audit.dose(AuditCreate, nil, dose)
+	})
+}
+
+// UpdateDose that already exists in the database
+func (b *Badger) UpdateDose(dose *Dose) error {
+	return b.updateDose(dose, false)
+}
+
+// UpdatePendingDose that is still pending in the database
+func (b *Badger) UpdatePendingDose(dose *Dose) error {
+	return b.updateDose(dose, true)
+}
+
+func (b *Badger) updateDose(dose *Dose, pendingOnly bool) error {
+	return b.updateAudited(func(tx *badger.Txn, audit *auditLog) error {
+		data, err := json.Marshal(dose)
+		if err != nil {
+			return fmt.Errorf("failed to JSON marshal dose: %w", err)
+		}
+
+		key := dose.badgerKey()
+		item, err := tx.Get(key)
+		if err != nil {
+			err = notFound(err)
+			return fmt.Errorf("failed to get dose %s: %w", dose.ID.String(), err)
+		}
+
+		existing := &Dose{}
+		err = item.Value(func(val []byte) error {
+			return json.Unmarshal(val, existing)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal dose %s: %w", dose.ID.String(), err)
+		}
+
+		err = checkPending(existing, pendingOnly)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Set(key, data)
+		if err != nil {
+			return err
+		}
+
+		return audit.dose(AuditUpdate, existing, dose)
+	})
+}
+
+// ListDosesForUser from the database that were scheduled at or after since
+func (b *Badger) ListDosesForUser(user *User, since time.Time) (doses []*Dose, err error) {
 	err = b.db.View(func(tx *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
-		opts.Prefix = badgerPrefixKeyForMedicationUser(user)
+		opts.Prefix = badgerPrefixKeyForDoseUser(user)
 
 		it := tx.NewIterator(opts)
 		defer it.Close()
 
-		for it.Rewind(); it.Valid(); it.Next() {
+		for it.Seek(badgerPrefixKeyForDoseUserSince(user.ID, since)); it.Valid(); it.Next() {
+			item := it.Item()
+
+			err := item.Value(func(val []byte) error {
+				dose := &Dose{}
+				err := json.Unmarshal(val, dose)
+				if err != nil {
+					return fmt.Errorf("failed to unmarshal dose value for dose key %s: %w", string(item.Key()), err)
+				}
+
+				doses = append(doses, dose)
+
+				return nil
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// AddAuditEntry to the database
+func (b *Badger) AddAuditEntry(entry *AuditEntry) error {
+	return b.db.Update(func(tx *badger.Txn) error {
+		return setAuditEntry(tx, entry)
+	})
+}
+
+func setAuditEntry(tx *badger.Txn, entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal audit entry: %w", err)
+	}
+
+	return tx.Set(entry.badgerKey(), data)
+}
+
+// ListAuditEntries from the database matching filter, oldest first
+func (b *Badger) ListAuditEntries(filter AuditFilter) (entries []*AuditEntry, err error) {
+	err = b.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(badgerPrefixKeyAudit)
+
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(badgerPrefixKeyForAuditSince(filter.since())); it.Valid(); it.Next() {
 			item := it.Item()
 
 			err := item.Value(func(val []byte) error {
-				medication := &Medication{}
-				err := json.Unmarshal(val, medication)
+				entry := &AuditEntry{}
+				err := json.Unmarshal(val, entry)
 				if err != nil {
-					return fmt.Errorf("failed to unmarshal medication value for medication key %s: %w", string(item.Key()), err)
+					return fmt.Errorf("failed to unmarshal audit entry for key %s: %w", string(item.Key()), err)
 				}
 
-				medications = append(medications, medication)
+				if filter.matches(entry) {
+					entries = append(entries, entry)
+				}
 
 				return nil
 			})
@@ -189,3 +1001,86 @@ func (b *Badger) ListMedicationsForUser(user *User) (medications []*Medication,
 
 	return
 }
+
+// AddNotification to the outbound queue in the database
+func (b *Badger) AddNotification(notification *Notification) error {
+	return b.db.Update(func(tx *badger.Txn) error {
+		data, err := json.Marshal(notification)
+		if err != nil {
+			return fmt.Errorf("failed to JSON marshal notification: %w", err)
+		}
+
+		return tx.Set(notification.badgerKey(), data)
+	})
+}
+
+// UpdateNotification that is still in the outbound queue in the database
+func (b *Badger) UpdateNotification(notification *Notification) error {
+	return b.db.Update(func(tx *badger.Txn) error {
+		data, err := json.Marshal(notification)
+		if err != nil {
+			return fmt.Errorf("failed to JSON marshal notification: %w", err)
+		}
+
+		key := notification.badgerKey()
+		if _, err = tx.Get(key); err != nil {
+			err = notFound(err)
+			return fmt.Errorf("failed to get notification %s: %w", notification.ID.String(), err)
+		}
+
+		return tx.Set(key, data)
+	})
+}
+
+// ListNotifications in the outbound queue in the database, oldest first
+func (b *Badger) ListNotifications() (notifications []*Notification, err error) {
+	err = b.db.View(func(tx *badger.Txn) error {
+		notifications, err = listNotifications(tx)
+		return err
+	})
+
+	return
+}
+
+func listNotifications(tx *badger.Txn) (notifications []*Notification, err error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(badgerPrefixKeyNotification)
+
+	it := tx.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+
+		err := item.Value(func(val []byte) error {
+			notification := &Notification{}
+			err := json.Unmarshal(val, notification)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal notification for key %s: %w", printableKey(item.Key()), err)
+			}
+
+			notifications = append(notifications, notification)
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return notifications, nil
+}
+
+// RemoveNotification from the outbound queue in the database
+func (b *Badger) RemoveNotification(notification *Notification) error {
+	return b.db.Update(func(tx *badger.Txn) error {
+		key := notification.badgerKey()
+		if _, err := tx.Get(key); err != nil {
+			err = notFound(err)
+			return fmt.Errorf("failed to get notification %s: %w", notification.ID.String(), err)
+		}
+
+		return tx.Delete(key)
+	})
+}
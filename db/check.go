@@ -0,0 +1,609 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// CheckProblem found in the database by Check
+type CheckProblem struct {
+	// Key the problem was found at, the binary part after its prefix is hex
+	// encoded
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+	// Repair that fixes the problem, empty when it can't be fixed safely
+	Repair   string `json:"repair,omitempty"`
+	Repaired bool   `json:"repaired"`
+
+	repair func(tx *badger.Txn) error
+}
+
+// CheckReport of the problems found in the database by Check
+type CheckReport struct {
+	Keys     int             `json:"keys"`
+	Problems []*CheckProblem `json:"problems"`
+}
+
+// Unrepaired counts the problems that are left in the database
+func (r *CheckReport) Unrepaired() int {
+	count := 0
+	for _, problem := range r.Problems {
+		if !problem.Repaired {
+			count++
+		}
+	}
+
+	return count
+}
+
+// printableKey with the binary part after its prefix hex encoded, keys that
+// are text like the username index are kept as they are
+func printableKey(key []byte) string {
+	if isText(key) {
+		return string(key)
+	}
+
+	i := bytes.IndexByte(key, ':')
+	if i < 0 {
+		return hex.EncodeToString(key)
+	}
+
+	return string(key[:i+1]) + hex.EncodeToString(key[i+1:])
+}
+
+func isText(key []byte) bool {
+	for _, c := range key {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkState of every record in the database, read before looking for
+// problems so cross-references can be resolved in any order
+type checkState struct {
+	report *CheckReport
+	actor  string
+
+	users       map[uuid.UUID]*User
+	usernames   map[string]uuid.UUID
+	medications map[uuid.UUID]*Medication
+	// medicationKeys of every medication ID, which may be stored under more
+	// than one user when the database is damaged
+	medicationKeys  map[uuid.UUID][][]byte
+	medicationUsers map[uuid.UUID]uuid.UUID
+	historyKeys     [][]byte
+	doseKeys        [][]byte
+	doses           map[string]*Dose
+	notifications   []*Notification
+	// historyIDUser of every medication version by its key
+	historyIDUser map[string]uuid.UUID
+}
+
+func (s *checkState) problem(key []byte, problem string, args ...interface{}) *CheckProblem {
+	p := &CheckProblem{
+		Key:     printableKey(key),
+		Problem: fmt.Sprintf(problem, args...),
+	}
+
+	s.report.Problems = append(s.report.Problems, p)
+
+	return p
+}
+
+// repairable sets how p is repaired
+func (p *CheckProblem) repairable(description string, repair func(tx *badger.Txn) error) {
+	p.Repair = description
+	p.repair = repair
+}
+
+func deleteKeys(keys ...[]byte) func(tx *badger.Txn) error {
+	return func(tx *badger.Txn) error {
+		for _, key := range keys {
+			err := tx.Delete(key)
+			if err != nil {
+				return fmt.Errorf("failed to delete key %s: %w", printableKey(key), err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// Check every key in the database for invalid values and references to
+// records that don't exist, repairing the problems that can be fixed without
+// losing data that is still referenced when repair is set. Repairs that
+// delete or change users, medications or doses are recorded in the audit
+// trail attributed to actor.
+func (b *Badger) Check(repair bool, actor string) (*CheckReport, error) {
+	state := &checkState{
+		report:          &CheckReport{Problems: []*CheckProblem{}},
+		actor:           actor,
+		users:           make(map[uuid.UUID]*User),
+		usernames:       make(map[string]uuid.UUID),
+		medications:     make(map[uuid.UUID]*Medication),
+		medicationKeys:  make(map[uuid.UUID][][]byte),
+		medicationUsers: make(map[uuid.UUID]uuid.UUID),
+		doses:           make(map[string]*Dose),
+		historyIDUser:   make(map[string]uuid.UUID),
+	}
+
+	err := b.db.View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := item.KeyCopy(nil)
+
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return fmt.Errorf("failed to read value of key %s: %w", printableKey(key), err)
+			}
+
+			state.report.Keys++
+			state.read(key, val)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	state.checkUsers()
+	state.checkMedications()
+	state.checkHistory()
+	state.checkDoses()
+	state.checkNotifications()
+
+	sort.Slice(state.report.Problems, func(i, j int) bool {
+		return state.report.Problems[i].Key < state.report.Problems[j].Key
+	})
+
+	if !repair {
+		return state.report, nil
+	}
+
+	var pending []*CheckProblem
+	for _, problem := range state.report.Problems {
+		if problem.repair != nil {
+			pending = append(pending, problem)
+		}
+	}
+
+	for len(pending) > 0 {
+		repaired, err := b.repairBatch(pending)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, problem := range pending[:repaired] {
+			problem.Repaired = true
+		}
+
+		pending = pending[repaired:]
+	}
+
+	return state.report, nil
+}
+
+// repairBatch of the problems from the start of problems in a single
+// transaction, as many of them as fit into it, returning how many of them
+// were repaired
+func (b *Badger) repairBatch(problems []*CheckProblem) (int, error) {
+	tx := b.db.NewTransaction(true)
+	defer tx.Discard()
+
+	for i, problem := range problems {
+		err := problem.repair(tx)
+		if errors.Is(err, badger.ErrTxnTooBig) && i > 0 {
+			// the transaction holds part of the repair that didn't fit, so
+			// the repairs before it are applied again without it
+			tx.Discard()
+
+			return b.repairBatch(problems[:i])
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("failed to repair %s at key %s: %w", problem.Problem, problem.Key, err)
+		}
+	}
+
+	err := tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit repairs: %w", err)
+	}
+
+	return len(problems), nil
+}
+
+// read a key and its value into the state, reporting values that can't be
+// parsed
+func (s *checkState) read(key, val []byte) {
+	switch {
+	case bytes.HasPrefix(key, []byte(badgerPrefixKeyUserID)):
+		user := &User{}
+		if err := json.Unmarshal(val, user); err != nil {
+			s.problem(key, "invalid user JSON: %s", err)
+			return
+		}
+
+		if !bytes.Equal(key, user.badgerKey()) {
+			s.problem(key, "user %s (%s) is stored under the key of another ID", user.Name, user.ID.String())
+			return
+		}
+
+		s.users[user.ID] = user
+
+	case bytes.HasPrefix(key, []byte(badgerPrefixKeyUsername)):
+		id, err := uuid.FromBytes(val)
+		if err != nil {
+			p := s.problem(key, "invalid user ID in the username index: %s", err)
+			p.repairable("delete the index entry", deleteKeys(key))
+			return
+		}
+
+		s.usernames[string(key[len(badgerPrefixKeyUsername):])] = id
+
+	case bytes.HasPrefix(key, []byte(badgerPrefixKeyMedicationUser)):
+		id, err := uuid.FromBytes(key[len(badgerPrefixKeyMedicationUser):])
+		if err != nil {
+			p := s.problem(key, "invalid medication ID in the medication index key: %s", err)
+			p.repairable("delete the index entry", deleteKeys(key))
+			return
+		}
+
+		idUser, err := uuid.FromBytes(val)
+		if err != nil {
+			p := s.problem(key, "invalid user ID in the medication index: %s", err)
+			p.repairable("delete the index entry", deleteKeys(key))
+			return
+		}
+
+		s.medicationUsers[id] = idUser
+
+	case bytes.HasPrefix(key, []byte(badgerPrefixKeyMedicationHistory)):
+		version := &MedicationVersion{}
+		if err := json.Unmarshal(val, version); err != nil {
+			s.problem(key, "invalid medication history JSON: %s", err)
+			return
+		}
+
+		if !bytes.Equal(key, version.badgerKey()) {
+			s.problem(key, "medication version of %s (%s) is stored under the key of another medication", version.Name, version.ID.String())
+			return
+		}
+
+		s.historyKeys = append(s.historyKeys, key)
+		s.historyIDUser[string(key)] = version.IDUser
+
+	case bytes.HasPrefix(key, []byte(badgerPrefixKeyMedication)):
+		medication := &Medication{}
+		if err := json.Unmarshal(val, medication); err != nil {
+			s.problem(key, "invalid medication JSON: %s", err)
+			return
+		}
+
+		if !bytes.Equal(key, medication.badgerKey()) {
+			s.problem(key, "medication %s (%s) is stored under the key of another medication or user", medication.Name, medication.ID.String())
+			return
+		}
+
+		s.medications[medication.ID] = medication
+		s.medicationKeys[medication.ID] = append(s.medicationKeys[medication.ID], key)
+
+	case bytes.HasPrefix(key, []byte(badgerPrefixKeyDose)):
+		dose := &Dose{}
+		if err := json.Unmarshal(val, dose); err != nil {
+			s.problem(key, "invalid dose JSON: %s", err)
+			return
+		}
+
+		if !bytes.Equal(key, dose.badgerKey()) {
+			s.problem(key, "dose %s is stored under the key of another dose or user", dose.ID.String())
+			return
+		}
+
+		s.doseKeys = append(s.doseKeys, key)
+		s.doses[string(key)] = dose
+
+	case bytes.HasPrefix(key, []byte(badgerPrefixKeyNotification)):
+		notification := &Notification{}
+		if err := json.Unmarshal(val, notification); err != nil {
+			p := s.problem(key, "invalid notification JSON: %s", err)
+			p.repairable("delete the notification", deleteKeys(key))
+			return
+		}
+
+		if !bytes.Equal(key, notification.badgerKey()) {
+			p := s.problem(key, "notification %s is stored under the key of another notification", notification.ID.String())
+			p.repairable("delete the notification", deleteKeys(key))
+			return
+		}
+
+		s.notifications = append(s.notifications, notification)
+
+	case bytes.HasPrefix(key, []byte(badgerPrefixKeyAudit)):
+		entry := &AuditEntry{}
+		if err := json.Unmarshal(val, entry); err != nil {
+			s.problem(key, "invalid audit entry JSON: %s", err)
+		}
+
+	case bytes.Equal(key, badgerKeySchemaVersion):
+		if len(val) != 8 {
+			s.problem(key, "invalid schema version of %d bytes", len(val))
+			return
+		}
+
+		if version := binary.BigEndian.Uint64(val); version != SchemaVersion() {
+			s.problem(key, "schema version %d doesn't match version %d of this binary, migrate the database", version, SchemaVersion())
+		}
+
+	case bytes.HasPrefix(key, badgerLegacyPrefixKeyUser):
+		s.problem(key, "user in the layout from before schema version 1, migrate the database")
+
+	default:
+		s.problem(key, "unknown key")
+	}
+}
+
+func (s *checkState) checkUsers() {
+	for name, id := range s.usernames {
+		key := []byte(badgerPrefixKeyUsername + name)
+
+		user, ok := s.users[id]
+		switch {
+		case !ok:
+			p := s.problem(key, "username index of missing id user %s", id.String())
+			p.repairable("delete the index entry", deleteKeys(key))
+
+		case normalizeUsername(user.Name) != name:
+			p := s.problem(key, "username index of id user %s who is named %s", id.String(), user.Name)
+			p.repairable("delete the index entry", deleteKeys(key))
+		}
+	}
+
+	for id, user := range s.users {
+		key := user.badgerKey()
+
+		if user.Name == "" {
+			s.problem(key, "user %s has no name", id.String())
+			continue
+		}
+
+		name := normalizeUsername(user.Name)
+		indexed, ok := s.usernames[name]
+		switch {
+		case !ok:
+			id := id
+			p := s.problem(key, "user %s is missing from the username index", user.Name)
+			p.repairable("add the index entry", func(tx *badger.Txn) error {
+				return tx.Set(badgerKeyForUsername(name), id[:])
+			})
+
+		case indexed != id:
+			if _, ok := s.users[indexed]; ok {
+				s.problem(key, "user %s has the same name as id user %s", user.Name, indexed.String())
+			}
+		}
+
+		s.checkUserDevices(user)
+	}
+}
+
+// checkUserDevices reports devices of the user without a pushover key
+func (s *checkState) checkUserDevices(user *User) {
+	var keyless []string
+	for name, device := range user.PushoverDevices {
+		if device == nil || device.Key == "" {
+			keyless = append(keyless, name)
+		}
+	}
+
+	if len(keyless) == 0 {
+		return
+	}
+
+	sort.Strings(keyless)
+
+	s.problem(user.badgerKey(), "user %s has devices %v without a pushover key", user.Name, keyless)
+}
+
+func (s *checkState) checkMedications() {
+	for id, idUser := range s.medicationUsers {
+		key := badgerKeyForMedicationUser(id)
+
+		if _, ok := s.medications[id]; !ok {
+			p := s.problem(key, "medication index of missing medication %s", id.String())
+			p.repairable("delete the index entry", deleteKeys(key))
+			continue
+		}
+
+		if len(s.medicationKeys[id]) > 1 {
+			s.problem(key, "medication %s is stored under %d users", id.String(), len(s.medicationKeys[id]))
+			continue
+		}
+
+		if medication := s.medications[id]; medication.IDUser != idUser {
+			p := s.problem(key, "medication index of medication %s points to id user %s instead of %s", id.String(), idUser.String(), medication.IDUser.String())
+			p.repairable("point the index entry to the medication's user", func(tx *badger.Txn) error {
+				return tx.Set(key, medication.IDUser[:])
+			})
+		}
+	}
+
+	for id, medication := range s.medications {
+		medication := medication
+		key := medication.badgerKey()
+
+		user, ok := s.users[medication.IDUser]
+		if !ok {
+			p := s.problem(key, "medication %s (%s) of missing id user %s", medication.Name, id.String(), medication.IDUser.String())
+			p.repairable("delete the medication and its history", func(tx *badger.Txn) error {
+				err := deletePrefix(tx, badgerPrefixKeyForMedicationHistory(medication.IDUser, medication.ID))
+				if err != nil {
+					return err
+				}
+
+				if s.medicationUsers[medication.ID] == medication.IDUser {
+					err = tx.Delete(badgerKeyForMedicationUser(medication.ID))
+					if err != nil {
+						return err
+					}
+				}
+
+				err = tx.Delete(key)
+				if err != nil {
+					return err
+				}
+
+				return s.audit(tx, AuditDelete, AuditRecordMedication, medication.ID.String(), medication.IDUser, "", medication, nil)
+			})
+
+			continue
+		}
+
+		if _, ok := s.medicationUsers[id]; !ok && len(s.medicationKeys[id]) == 1 {
+			p := s.problem(key, "medication %s (%s) is missing from the medication index", medication.Name, id.String())
+			p.repairable("add the index entry", func(tx *badger.Txn) error {
+				return tx.Set(badgerKeyForMedicationUser(medication.ID), medication.IDUser[:])
+			})
+		}
+
+		if medication.Name == "" {
+			s.problem(key, "medication %s has no name", id.String())
+		}
+
+		if _, err := cron.ParseStandard(medication.IntervalCrontab); err != nil {
+			s.problem(key, "medication %s (%s) has invalid cron schedule '%s': %s", medication.Name, id.String(), medication.IntervalCrontab, err)
+		}
+
+		if medication.IntervalQuantity == 0 {
+			s.problem(key, "medication %s (%s) has no interval quantity", medication.Name, id.String())
+		}
+
+		s.checkMedicationDevices(user, medication)
+	}
+}
+
+// checkMedicationDevices reports devices the medication reminds that its user
+// doesn't have, which are removed when the medication still reminds another
+func (s *checkState) checkMedicationDevices(user *User, medication *Medication) {
+	var missing, existing []string
+	for _, device := range medication.IntervalPushoverDevices {
+		if user.HasDevice(device) {
+			existing = append(existing, device)
+		} else {
+			missing = append(missing, device)
+		}
+	}
+
+	if len(missing) == 0 && len(existing) > 0 {
+		return
+	}
+
+	p := s.problem(medication.badgerKey(), "medication %s (%s) reminds devices %v that user %s doesn't have", medication.Name, medication.ID.String(), missing, user.Name)
+	if len(existing) == 0 {
+		return
+	}
+
+	p.repairable(fmt.Sprintf("only remind devices %v", existing), func(tx *badger.Txn) error {
+		before := *medication
+
+		repaired := *medication
+		repaired.IntervalPushoverDevices = existing
+
+		err := setMedication(tx, &repaired)
+		if err != nil {
+			return err
+		}
+
+		return s.audit(tx, AuditUpdate, AuditRecordMedication, medication.ID.String(), user.ID, user.Name, &before, &repaired)
+	})
+}
+
+func (s *checkState) checkHistory() {
+	for _, key := range s.historyKeys {
+		prefixLength := len(badgerPrefixKeyMedicationHistory) + 16
+		id, err := uuid.FromBytes(key[prefixLength : prefixLength+16])
+		if err != nil {
+			continue
+		}
+
+		medication, ok := s.medications[id]
+		if !ok || medication.IDUser != s.historyIDUser[string(key)] {
+			p := s.problem(key, "history of missing medication %s", id.String())
+			p.repairable("delete the medication version", deleteKeys(key))
+		}
+	}
+}
+
+func (s *checkState) checkDoses() {
+	for _, key := range s.doseKeys {
+		key := key
+		dose := s.doses[string(key)]
+
+		if _, ok := s.users[dose.IDUser]; !ok {
+			p := s.problem(key, "dose %s of missing id user %s", dose.ID.String(), dose.IDUser.String())
+			p.repairable("delete the dose", func(tx *badger.Txn) error {
+				err := tx.Delete(key)
+				if err != nil {
+					return err
+				}
+
+				return s.audit(tx, AuditDelete, AuditRecordDose, dose.ID.String(), dose.IDUser, "", dose, nil)
+			})
+
+			continue
+		}
+
+		switch dose.Status {
+		case DoseStatusPending, DoseStatusTaken, DoseStatusMissed, DoseStatusSkipped:
+		default:
+			s.problem(key, "dose %s has invalid status %s", dose.ID.String(), dose.Status)
+		}
+	}
+}
+
+func (s *checkState) checkNotifications() {
+	for _, notification := range s.notifications {
+		key := notification.badgerKey()
+
+		if !notification.IsAlert() {
+			if _, ok := s.users[notification.IDUser]; !ok {
+				p := s.problem(key, "notification %s of missing id user %s", notification.ID.String(), notification.IDUser.String())
+				p.repairable("delete the notification", deleteKeys(key))
+				continue
+			}
+		}
+
+		switch notification.Status {
+		case NotificationStatusPending, NotificationStatusDead:
+		default:
+			p := s.problem(key, "notification %s has invalid status %s", notification.ID.String(), notification.Status)
+			p.repairable("delete the notification", deleteKeys(key))
+		}
+	}
+}
+
+// audit a repair in the transaction making it
+func (s *checkState) audit(tx *badger.Txn, action AuditAction, record AuditRecord, recordID string, idUser uuid.UUID, username string, before, after interface{}) error {
+	entry, err := newAuditEntry(s.actor, action, record, recordID, idUser, username, before, after)
+	if err != nil {
+		return err
+	}
+
+	return setAuditEntry(tx, entry)
+}
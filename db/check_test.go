@@ -0,0 +1,201 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+)
+
+func TestCheck(t *testing.T) {
+	b, err := NewBadger(t.TempDir(), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	alice := &User{ID: uuid.New(), Name: "alice", PushoverDevices: map[string]*PushoverDevice{"phone": {Key: "token", Kind: PushoverKeyUser}}}
+	if err = b.AddUser(alice); err != nil {
+		t.Fatal(err)
+	}
+
+	medication := &Medication{
+		IDUser:                  alice.ID,
+		ID:                      uuid.New(),
+		Name:                    "aspirin",
+		IntervalCrontab:         "0 8 * * *",
+		IntervalQuantity:        1,
+		IntervalPushoverDevices: []string{"phone", "tablet"},
+	}
+	if err = b.AddMedication(medication); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := b.Check(false, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Problems) != 1 || report.Problems[0].Repair == "" {
+		t.Fatalf("expected only the missing tablet device to be a repairable problem, got %s", checkProblems(report))
+	}
+
+	// orphan a medication, a dose and the username index of a removed user
+	bob := &User{ID: uuid.New(), Name: "bob"}
+	orphan := &Medication{IDUser: bob.ID, ID: uuid.New(), Name: "orphan", IntervalCrontab: "bad", IntervalQuantity: 1}
+	dose := &Dose{IDUser: bob.ID, ID: uuid.New(), Status: DoseStatusPending, ScheduledAt: time.Now()}
+
+	err = b.db.Update(func(tx *badger.Txn) error {
+		if err := setMedication(tx, orphan); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(dose)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Set(dose.badgerKey(), data); err != nil {
+			return err
+		}
+
+		if err := tx.Set(badgerKeyForUsername(bob.Name), bob.ID[:]); err != nil {
+			return err
+		}
+
+		return tx.Set([]byte("garbage"), []byte("?"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = b.Check(true, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the missing device, the orphaned medication, dose and username index
+	// are repaired while the unknown key is left alone
+	if len(report.Problems) != 5 || report.Unrepaired() != 1 {
+		t.Fatalf("expected 5 problems with 1 left unrepaired, got %s", checkProblems(report))
+	}
+
+	report, err = b.Check(false, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Problems) != 1 || report.Problems[0].Key != "garbage" {
+		t.Fatalf("expected only the unknown key to be left, got %s", checkProblems(report))
+	}
+
+	repaired, err := b.GetMedication(medication.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repaired.IntervalPushoverDevices) != 1 || repaired.IntervalPushoverDevices[0] != "phone" {
+		t.Fatalf("expected the medication to only remind the phone, got %v", repaired.IntervalPushoverDevices)
+	}
+
+	entries, err := b.ListAuditEntries(AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("expected the 3 repaired medications and doses to be audited, got %d entries", len(entries))
+	}
+}
+
+func checkProblems(report *CheckReport) string {
+	problems := make([]string, 0, len(report.Problems))
+	for _, problem := range report.Problems {
+		problems = append(problems, fmt.Sprintf("%s: %s", problem.Key, problem.Problem))
+	}
+
+	return strings.Join(problems, ", ")
+}
+
+func TestCheckRepairsInBatches(t *testing.T) {
+	// a small memtable limits transactions to about a thousand writes
+	db, err := badger.Open(badgerOptions(t.TempDir(), nil).WithMemTableSize(1 << 20).WithValueThreshold(1 << 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &Badger{db: db, cancelGC: func() {}, wg: &sync.WaitGroup{}}
+	defer b.Close()
+
+	// every orphaned dose is deleted and audited, more than fit into a single
+	// transaction
+	const orphans = 3000
+
+	batch := db.NewWriteBatch()
+	for i := 0; i < orphans; i++ {
+		dose := &Dose{IDUser: uuid.New(), ID: uuid.New(), Status: DoseStatusPending, ScheduledAt: time.Now()}
+
+		data, err := json.Marshal(dose)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = batch.Set(dose.badgerKey(), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = batch.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *badger.Txn) error {
+		for i := 0; i < orphans; i++ {
+			err := tx.Delete([]byte(fmt.Sprintf("test:%d", i)))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if !errors.Is(err, badger.ErrTxnTooBig) {
+		t.Fatalf("expected a transaction of %d writes to be too big, got %v", orphans, err)
+	}
+
+	report, err := b.Check(true, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Problems) != orphans || report.Unrepaired() != 0 {
+		t.Fatalf("expected the %d orphaned doses to be repaired, got %d problems with %d unrepaired", orphans, len(report.Problems), report.Unrepaired())
+	}
+
+	report, err = b.Check(false, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Problems) != 0 {
+		t.Fatalf("expected no problems to be left, got %d", len(report.Problems))
+	}
+
+	entries, err := b.ListAuditEntries(AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != orphans {
+		t.Fatalf("expected every repair to be audited once, got %d entries", len(entries))
+	}
+}
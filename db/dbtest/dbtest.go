@@ -0,0 +1,760 @@
+// Package dbtest is the conformance suite every db.Store implementation must
+// pass
+package dbtest
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"github.com/google/uuid"
+)
+
+// TestStore runs the conformance suite against stores created by newStore,
+// which must return an empty store for every call
+func TestStore(t *testing.T, newStore func(t *testing.T) db.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store db.Store)
+	}{
+		{"Users", testUsers},
+		{"RenameUser", testRenameUser},
+		{"RemoveUser", testRemoveUser},
+		{"Devices", testDevices},
+		{"PauseUser", testPauseUser},
+		{"Medications", testMedications},
+		{"UpdateMedication", testUpdateMedication},
+		{"PauseMedication", testPauseMedication},
+		{"RemoveMedication", testRemoveMedication},
+		{"Doses", testDoses},
+		{"Audit", testAudit},
+		{"Notifications", testNotifications},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			store := newStore(t)
+			defer func() {
+				err := store.Close()
+				if err != nil {
+					t.Errorf("failed to close store: %s", err)
+				}
+			}()
+
+			test.test(t, store)
+		})
+	}
+}
+
+func newUser(t *testing.T, store db.Store, name string, devices ...string) *db.User {
+	t.Helper()
+
+	user := &db.User{
+		ID:              uuid.New(),
+		Name:            name,
+		PushoverDevices: make(map[string]*db.PushoverDevice),
+		CreatedAt:       time.Now().Truncate(time.Second),
+	}
+
+	for _, device := range devices {
+		user.PushoverDevices[device] = &db.PushoverDevice{Key: device + "-token", Kind: db.PushoverKeyUser}
+	}
+
+	err := store.AddUser(user)
+	if err != nil {
+		t.Fatalf("failed to add user %s: %s", name, err)
+	}
+
+	return user
+}
+
+func newMedication(t *testing.T, store db.Store, user *db.User, name string, devices ...string) *db.Medication {
+	t.Helper()
+
+	medication := &db.Medication{
+		IDUser:                  user.ID,
+		ID:                      uuid.New(),
+		Name:                    name,
+		IntervalCrontab:         "0 8 * * *",
+		IntervalQuantity:        1,
+		IntervalPushoverDevices: devices,
+		CreatedAt:               time.Now().Truncate(time.Second),
+	}
+
+	err := store.AddMedication(medication)
+	if err != nil {
+		t.Fatalf("failed to add medication %s: %s", name, err)
+	}
+
+	return medication
+}
+
+func expectError(t *testing.T, err, target error, action string) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Fatalf("expected %s to fail with %q, got %v", action, target, err)
+	}
+}
+
+func expectNoError(t *testing.T, err error, action string) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("failed to %s: %s", action, err)
+	}
+}
+
+func testUsers(t *testing.T, store db.Store) {
+	users, err := store.ListUsers()
+	expectNoError(t, err, "list users of an empty store")
+	if len(users) != 0 {
+		t.Fatalf("expected no users in an empty store, got %d", len(users))
+	}
+
+	alice := newUser(t, store, "Alice", "phone")
+	newUser(t, store, "bob", "phone")
+
+	user, err := store.GetUser("aLiCe")
+	expectNoError(t, err, "get user by username of another case")
+	if user.ID != alice.ID || user.Name != "Alice" || user.DeviceKey("phone") != "phone-token" {
+		t.Fatalf("got user %+v, expected %+v", user, alice)
+	}
+
+	if !user.CreatedAt.Equal(alice.CreatedAt) {
+		t.Fatalf("got created at %s, expected %s", user.CreatedAt, alice.CreatedAt)
+	}
+
+	user, err = store.GetUserByID(alice.ID)
+	expectNoError(t, err, "get user by id")
+	if user.Name != "Alice" {
+		t.Fatalf("got user %s by id, expected Alice", user.Name)
+	}
+
+	user.PushoverDevices["phone"].Key = "changed"
+	user, err = store.GetUserByID(alice.ID)
+	expectNoError(t, err, "get user by id")
+	if user.DeviceKey("phone") != "phone-token" {
+		t.Fatal("changing a returned user changed the stored user")
+	}
+
+	_, err = store.GetUser("carol")
+	expectError(t, err, db.ErrNotFound, "getting a missing username")
+
+	_, err = store.GetUserByID(uuid.New())
+	expectError(t, err, db.ErrNotFound, "getting a missing user id")
+
+	err = store.AddUser(&db.User{ID: uuid.New(), Name: "ALICE"})
+	expectError(t, err, db.ErrAlreadyExists, "adding a username that differs only in case")
+
+	err = store.AddUser(&db.User{ID: alice.ID, Name: "carol"})
+	expectError(t, err, db.ErrAlreadyExists, "adding an existing user id")
+
+	users, err = store.ListUsers()
+	expectNoError(t, err, "list users")
+	if len(users) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(users))
+	}
+}
+
+func testRenameUser(t *testing.T, store db.Store) {
+	alice := newUser(t, store, "alice", "phone")
+	newUser(t, store, "bob", "phone")
+
+	err := store.RenameUser(alice, "Bob")
+	expectError(t, err, db.ErrAlreadyExists, "renaming to a taken username")
+
+	err = store.RenameUser(alice, "Alice")
+	expectNoError(t, err, "rename user to a different case of its own name")
+
+	err = store.RenameUser(alice, "carol")
+	expectNoError(t, err, "rename user")
+	if alice.Name != "carol" {
+		t.Fatalf("expected renamed user to be updated in place, got name %s", alice.Name)
+	}
+
+	_, err = store.GetUser("alice")
+	expectError(t, err, db.ErrNotFound, "getting the previous username")
+
+	user, err := store.GetUser("CAROL")
+	expectNoError(t, err, "get renamed user")
+	if user.ID != alice.ID {
+		t.Fatalf("got id user %s for renamed user, expected %s", user.ID, alice.ID)
+	}
+
+	err = store.AddUser(&db.User{ID: uuid.New(), Name: "alice"})
+	expectNoError(t, err, "add user with the previous username")
+}
+
+func testRemoveUser(t *testing.T, store db.Store) {
+	alice := newUser(t, store, "alice", "phone")
+	medication := newMedication(t, store, alice, "aspirin", "phone")
+
+	dose := &db.Dose{
+		IDUser:       alice.ID,
+		IDMedication: medication.ID,
+		ID:           uuid.New(),
+		Quantity:     1,
+		Status:       db.DoseStatusPending,
+		ScheduledAt:  time.Now(),
+	}
+	expectNoError(t, store.AddDose(dose), "add dose")
+
+	err := store.RemoveUser(alice, false)
+	expectError(t, err, db.ErrUserHasMedications, "removing a user with medications")
+
+	err = store.RemoveUser(alice, true)
+	expectNoError(t, err, "cascade remove user")
+
+	_, err = store.GetUser("alice")
+	expectError(t, err, db.ErrNotFound, "getting a removed user")
+
+	_, err = store.GetMedication(medication.ID)
+	expectError(t, err, db.ErrNotFound, "getting a medication of a removed user")
+
+	doses, err := store.ListDosesForUser(alice, time.Time{})
+	expectNoError(t, err, "list doses of a removed user")
+	if len(doses) != 0 {
+		t.Fatalf("expected the doses of a removed user to be removed, got %d", len(doses))
+	}
+
+	newUser(t, store, "alice", "phone")
+
+	bob := newUser(t, store, "bob", "phone")
+	expectNoError(t, store.RemoveUser(bob, false), "remove user without medications")
+
+	err = store.RemoveUser(bob, false)
+	expectError(t, err, db.ErrNotFound, "removing a removed user")
+}
+
+func testDevices(t *testing.T, store db.Store) {
+	alice := newUser(t, store, "alice", "phone")
+	both := newMedication(t, store, alice, "aspirin", "phone", "tablet")
+	phone := newMedication(t, store, alice, "ibuprofen", "phone")
+
+	err := store.AddUserDevice(alice, "phone", &db.PushoverDevice{Key: "token"})
+	expectError(t, err, db.ErrAlreadyExists, "adding an existing device")
+
+	err = store.AddUserDevice(alice, "watch", &db.PushoverDevice{})
+	if err == nil {
+		t.Fatal("expected adding a device without a pushover key to fail")
+	}
+
+	err = store.AddUserDevice(alice, "tablet", &db.PushoverDevice{Key: "tablet-token", Kind: db.PushoverKeyGroup, Devices: []string{"ipad"}})
+	expectNoError(t, err, "add device")
+	if alice.DeviceKey("tablet") != "tablet-token" || len(alice.PushoverDevices["tablet"].Devices) != 1 {
+		t.Fatal("expected added device to be updated in place")
+	}
+
+	err = store.UpdateUserDevice(alice, "watch", &db.PushoverDevice{Key: "watch-token"})
+	expectError(t, err, db.ErrNotFound, "updating a missing device")
+
+	err = store.UpdateUserDevice(alice, "phone", &db.PushoverDevice{Key: "phone-token", Restrict: []string{"pixel", "watch"}, Disabled: true, DisabledReason: "invalid"})
+	expectNoError(t, err, "update device")
+
+	user, err := store.GetUserByID(alice.ID)
+	expectNoError(t, err, "get user")
+	if user.PushoverDevices["tablet"].Kind != db.PushoverKeyGroup {
+		t.Fatalf("expected device tablet to be of a group key, got %v", user.PushoverDevices["tablet"])
+	}
+
+	if !user.DeviceDisabled("phone") || user.DeviceDisabled("tablet") {
+		t.Fatalf("expected only device phone to be disabled, got %v", user.PushoverDevices)
+	}
+
+	if restrict := user.DeviceRestrictions("phone"); len(restrict) != 2 || restrict[0] != "pixel" || restrict[1] != "watch" || user.DeviceRestrictions("tablet") != nil {
+		t.Fatalf("expected only device phone to be restricted, got %v", user.PushoverDevices)
+	}
+
+	err = store.RenameUserDevice(alice, "watch", "clock")
+	expectError(t, err, db.ErrNotFound, "renaming a missing device")
+
+	err = store.RenameUserDevice(alice, "phone", "tablet")
+	expectError(t, err, db.ErrAlreadyExists, "renaming to an existing device")
+
+	err = store.RenameUserDevice(alice, "phone", "mobile")
+	expectNoError(t, err, "rename device")
+
+	user, err = store.GetUserByID(alice.ID)
+	expectNoError(t, err, "get user")
+	if user.HasDevice("phone") || user.DeviceKey("mobile") != "phone-token" || !user.DeviceDisabled("mobile") {
+		t.Fatalf("expected device phone to be renamed mobile, got %v", user.PushoverDevices)
+	}
+
+	medication, err := store.GetMedication(phone.ID)
+	expectNoError(t, err, "get medication")
+	if !medication.RemindsDevice("mobile") || medication.RemindsDevice("phone") {
+		t.Fatalf("expected medication to remind the renamed device, got %v", medication.IntervalPushoverDevices)
+	}
+
+	err = store.RemoveUserDevice(alice, "mobile")
+	expectError(t, err, db.ErrDeviceInUse, "removing the only device of a medication")
+
+	user, err = store.GetUserByID(alice.ID)
+	expectNoError(t, err, "get user")
+	if !user.HasDevice("mobile") {
+		t.Fatal("expected a refused device removal to change nothing")
+	}
+
+	expectNoError(t, store.RemoveMedication(phone), "remove medication")
+
+	err = store.RemoveUserDevice(alice, "mobile")
+	expectNoError(t, err, "remove device")
+
+	user, err = store.GetUserByID(alice.ID)
+	expectNoError(t, err, "get user")
+	if user.HasDevice("mobile") {
+		t.Fatal("expected the removed device to be removed")
+	}
+
+	medication, err = store.GetMedication(both.ID)
+	expectNoError(t, err, "get medication")
+	if len(medication.IntervalPushoverDevices) != 1 || !medication.RemindsDevice("tablet") {
+		t.Fatalf("expected medication to only remind tablet, got %v", medication.IntervalPushoverDevices)
+	}
+
+	err = store.RemoveUserDevice(alice, "mobile")
+	expectError(t, err, db.ErrNotFound, "removing a missing device")
+}
+
+func testPauseUser(t *testing.T, store db.Store) {
+	alice := newUser(t, store, "alice", "phone")
+	now := time.Now()
+	resumeAt := now.Add(time.Hour).Truncate(time.Second)
+
+	err := store.PauseUser(alice, &resumeAt)
+	expectNoError(t, err, "pause user")
+
+	user, err := store.GetUserByID(alice.ID)
+	expectNoError(t, err, "get user")
+	if !user.IsPaused(now) || user.IsPaused(resumeAt) {
+		t.Fatalf("expected user to be paused until %s, got %+v", resumeAt, user.Pause)
+	}
+
+	err = store.ResumeUser(alice)
+	expectNoError(t, err, "resume user")
+
+	user, err = store.GetUserByID(alice.ID)
+	expectNoError(t, err, "get user")
+	if user.IsPaused(now) {
+		t.Fatal("expected resumed user to not be paused")
+	}
+
+	err = store.PauseUser(&db.User{ID: uuid.New()}, nil)
+	expectError(t, err, db.ErrNotFound, "pausing a missing user")
+}
+
+func testMedications(t *testing.T, store db.Store) {
+	alice := newUser(t, store, "alice", "phone")
+	bob := newUser(t, store, "bob", "phone")
+
+	medications, err := store.ListMedicationsForUser(alice)
+	expectNoError(t, err, "list medications of a user without any")
+	if len(medications) != 0 {
+		t.Fatalf("expected no medications, got %d", len(medications))
+	}
+
+	aspirin := newMedication(t, store, alice, "aspirin", "phone")
+	newMedication(t, store, alice, "ibuprofen", "phone")
+	newMedication(t, store, bob, "aspirin", "phone")
+
+	err = store.AddMedication(aspirin)
+	expectError(t, err, db.ErrAlreadyExists, "adding an existing medication")
+
+	medication, err := store.GetMedication(aspirin.ID)
+	expectNoError(t, err, "get medication")
+	if medication.IDUser != alice.ID || medication.Name != "aspirin" || medication.IntervalCrontab != aspirin.IntervalCrontab {
+		t.Fatalf("got medication %+v, expected %+v", medication, aspirin)
+	}
+
+	_, err = store.GetMedicationForUser(alice, aspirin.ID)
+	expectNoError(t, err, "get medication for user")
+
+	_, err = store.GetMedicationForUser(bob, aspirin.ID)
+	expectError(t, err, db.ErrNotFound, "getting a medication of another user")
+
+	_, err = store.GetMedication(uuid.New())
+	expectError(t, err, db.ErrNotFound, "getting a missing medication")
+
+	medications, err = store.ListMedicationsForUser(alice)
+	expectNoError(t, err, "list medications")
+	if len(medications) != 2 {
+		t.Fatalf("expected 2 medications, got %d", len(medications))
+	}
+
+	for _, medication := range medications {
+		if medication.IDUser != alice.ID {
+			t.Fatalf("listed medication %s of id user %s for id user %s", medication.ID, medication.IDUser, alice.ID)
+		}
+	}
+}
+
+func testUpdateMedication(t *testing.T, store db.Store) {
+	alice := newUser(t, store, "alice", "phone")
+	original := newMedication(t, store, alice, "aspirin", "phone")
+
+	updated := *original
+	updated.Name = "aspirin 81mg"
+	updated.CreatedAt = time.Time{}
+
+	err := store.UpdateMedication(&updated, "bob")
+	expectNoError(t, err, "update medication")
+	if !updated.CreatedAt.Equal(original.CreatedAt) || updated.UpdatedAt.IsZero() {
+		t.Fatalf("expected created at to be kept and updated at to be set, got %+v", updated)
+	}
+
+	updated.IntervalQuantity = 2
+	err = store.UpdateMedication(&updated, "carol")
+	expectNoError(t, err, "update medication")
+
+	medication, err := store.GetMedication(original.ID)
+	expectNoError(t, err, "get medication")
+	if medication.Name != "aspirin 81mg" || medication.IntervalQuantity != 2 {
+		t.Fatalf("got medication %+v after updates", medication)
+	}
+
+	versions, err := store.ListMedicationHistory(medication)
+	expectNoError(t, err, "list medication history")
+	if len(versions) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(versions))
+	}
+
+	if versions[0].Name != "aspirin" || versions[0].ReplacedBy != "bob" {
+		t.Fatalf("expected the oldest version first, got %+v", versions[0])
+	}
+
+	if versions[1].Name != "aspirin 81mg" || versions[1].IntervalQuantity != 1 || versions[1].ReplacedBy != "carol" {
+		t.Fatalf("got second version %+v", versions[1])
+	}
+
+	imported := &db.MedicationVersion{
+		Medication: *original,
+		ReplacedAt: versions[0].ReplacedAt.Add(-time.Hour),
+		ReplacedBy: "import",
+	}
+	err = store.AddMedicationVersion(imported)
+	expectNoError(t, err, "add medication version")
+
+	versions, err = store.ListMedicationHistory(medication)
+	expectNoError(t, err, "list medication history")
+	if len(versions) != 3 || versions[0].ReplacedBy != "import" {
+		t.Fatalf("expected the added version to be listed first by when it was replaced, got %d versions", len(versions))
+	}
+
+	missing := *original
+	missing.ID = uuid.New()
+	err = store.UpdateMedication(&missing, "bob")
+	expectError(t, err, db.ErrNotFound, "updating a missing medication")
+}
+
+func testPauseMedication(t *testing.T, store db.Store) {
+	alice := newUser(t, store, "alice", "phone")
+	aspirin := newMedication(t, store, alice, "aspirin", "phone")
+	now := time.Now()
+
+	err := store.PauseMedication(aspirin, nil)
+	expectNoError(t, err, "pause medication")
+
+	medication, err := store.GetMedication(aspirin.ID)
+	expectNoError(t, err, "get medication")
+	if !medication.IsPaused(now.Add(time.Hour * 24 * 365)) {
+		t.Fatal("expected medication to be paused indefinitely")
+	}
+
+	err = store.ResumeMedication(aspirin)
+	expectNoError(t, err, "resume medication")
+
+	medication, err = store.GetMedication(aspirin.ID)
+	expectNoError(t, err, "get medication")
+	if medication.IsPaused(now) {
+		t.Fatal("expected resumed medication to not be paused")
+	}
+
+	versions, err := store.ListMedicationHistory(medication)
+	expectNoError(t, err, "list medication history")
+	if len(versions) != 0 {
+		t.Fatalf("expected pausing to not add to the history, got %d versions", len(versions))
+	}
+}
+
+func testRemoveMedication(t *testing.T, store db.Store) {
+	alice := newUser(t, store, "alice", "phone")
+	aspirin := newMedication(t, store, alice, "aspirin", "phone")
+
+	updated := *aspirin
+	updated.IntervalQuantity = 2
+	expectNoError(t, store.UpdateMedication(&updated, "bob"), "update medication")
+
+	bob := newUser(t, store, "bob", "phone")
+	err := store.RemoveMedication(&db.Medication{IDUser: bob.ID, ID: aspirin.ID})
+	expectError(t, err, db.ErrNotFound, "removing the medication of another user")
+
+	_, err = store.GetMedicationForUser(alice, aspirin.ID)
+	expectNoError(t, err, "get medication another user failed to remove")
+
+	versions, err := store.ListMedicationHistory(aspirin)
+	expectNoError(t, err, "list history of a medication another user failed to remove")
+	if len(versions) != 1 {
+		t.Fatalf("expected the history to be kept when another user fails to remove the medication, got %d versions", len(versions))
+	}
+
+	err = store.RemoveMedication(aspirin)
+	expectNoError(t, err, "remove medication")
+
+	err = store.RemoveMedication(aspirin)
+	expectError(t, err, db.ErrNotFound, "removing a removed medication")
+
+	_, err = store.GetMedication(aspirin.ID)
+	expectError(t, err, db.ErrNotFound, "getting a removed medication")
+
+	versions, err = store.ListMedicationHistory(aspirin)
+	expectNoError(t, err, "list history of a removed medication")
+	if len(versions) != 0 {
+		t.Fatalf("expected the history of a removed medication to be removed, got %d versions", len(versions))
+	}
+
+	expectNoError(t, store.RemoveUser(alice, false), "remove user without medications")
+}
+
+func testDoses(t *testing.T, store db.Store) {
+	alice := newUser(t, store, "alice", "phone")
+	bob := newUser(t, store, "bob", "phone")
+	aspirin := newMedication(t, store, alice, "aspirin", "phone")
+
+	start := time.Now().Truncate(time.Second)
+
+	var doses []*db.Dose
+	for i := 0; i < 3; i++ {
+		dose := &db.Dose{
+			IDUser:       alice.ID,
+			IDMedication: aspirin.ID,
+			ID:           uuid.New(),
+			Quantity:     1,
+			Status:       db.DoseStatusPending,
+			Receipts:     []string{"receipt"},
+			ScheduledAt:  start.Add(time.Hour * time.Duration(2-i)),
+		}
+
+		expectNoError(t, store.AddDose(dose), "add dose")
+		doses = append(doses, dose)
+	}
+
+	listed, err := store.ListDosesForUser(alice, start.Add(time.Hour))
+	expectNoError(t, err, "list doses")
+	if len(listed) != 2 {
+		t.Fatalf("expected 2 doses scheduled since an hour from now, got %d", len(listed))
+	}
+
+	if listed[0].ID != doses[1].ID || listed[1].ID != doses[0].ID {
+		t.Fatal("expected doses to be listed oldest first")
+	}
+
+	listed, err = store.ListDosesForUser(alice, time.Time{})
+	expectNoError(t, err, "list doses since the zero time")
+	if len(listed) != 3 {
+		t.Fatalf("expected every dose to be listed since the zero time, got %d", len(listed))
+	}
+
+	listed, err = store.ListDosesForUser(bob, time.Time{})
+	expectNoError(t, err, "list doses of another user")
+	if len(listed) != 0 {
+		t.Fatalf("expected no doses for another user, got %d", len(listed))
+	}
+
+	resolvedAt := start.Add(time.Hour * 3)
+	doses[2].Status = db.DoseStatusTaken
+	doses[2].ResolvedAt = &resolvedAt
+	expectNoError(t, store.UpdateDose(doses[2]), "update dose")
+
+	listed, err = store.ListDosesForUser(alice, start)
+	expectNoError(t, err, "list doses")
+	if len(listed) != 3 || listed[0].Status != db.DoseStatusTaken || listed[0].ResolvedAt == nil || !listed[0].ResolvedAt.Equal(resolvedAt) {
+		t.Fatalf("expected the updated dose to be listed first as taken, got %+v", listed[0])
+	}
+
+	err = store.UpdateDose(&db.Dose{IDUser: alice.ID, ID: uuid.New(), ScheduledAt: start})
+	expectError(t, err, db.ErrNotFound, "updating a missing dose")
+
+	// a resolved dose isn't reverted to pending
+	doses[2].Status = db.DoseStatusPending
+	doses[2].ResolvedAt = nil
+	doses[2].Receipts = append(doses[2].Receipts, "late")
+	err = store.UpdatePendingDose(doses[2])
+	expectError(t, err, db.ErrDoseResolved, "updating a resolved dose while pending")
+
+	doses[1].Receipts = append(doses[1].Receipts, "late")
+	expectNoError(t, store.UpdatePendingDose(doses[1]), "update pending dose")
+
+	listed, err = store.ListDosesForUser(alice, start)
+	expectNoError(t, err, "list doses")
+	if listed[0].Status != db.DoseStatusTaken || len(listed[0].Receipts) != 1 || len(listed[1].Receipts) != 2 {
+		t.Fatalf("expected only the pending dose to be updated, got %+v and %+v", listed[0], listed[1])
+	}
+}
+
+func testAudit(t *testing.T, store db.Store) {
+	start := time.Now()
+	audited := db.NewAudited(store, "tester")
+
+	alice := newUser(t, audited, "alice", "phone")
+	bob := newUser(t, audited, "bob", "phone")
+	aspirin := newMedication(t, audited, alice, "aspirin", "phone")
+
+	expectNoError(t, audited.RenameUser(alice, "Alicia"), "rename user")
+	expectNoError(t, audited.AddUserDevice(alice, "tablet", &db.PushoverDevice{Key: "tablet-token", Kind: db.PushoverKeyUser}), "add device")
+
+	aspirin.IntervalQuantity = 2
+	expectNoError(t, audited.UpdateMedication(aspirin, "tester"), "update medication")
+	expectNoError(t, audited.RenameUserDevice(alice, "phone", "handset"), "rename device")
+	expectNoError(t, audited.RemoveUser(bob, false), "remove user")
+
+	// the entries of a change that fails are not added
+	err := audited.RemoveUserDevice(alice, "handset")
+	expectError(t, err, db.ErrDeviceInUse, "removing the only device of a medication")
+
+	entries, err := store.ListAuditEntries(db.AuditFilter{})
+	expectNoError(t, err, "list audit entries")
+
+	expected := []struct {
+		action db.AuditAction
+		record db.AuditRecord
+	}{
+		{db.AuditCreate, db.AuditRecordUser},
+		{db.AuditCreate, db.AuditRecordUser},
+		{db.AuditCreate, db.AuditRecordMedication},
+		{db.AuditUpdate, db.AuditRecordUser},
+		{db.AuditCreate, db.AuditRecordDevice},
+		{db.AuditUpdate, db.AuditRecordMedication},
+		{db.AuditUpdate, db.AuditRecordDevice},
+		{db.AuditUpdate, db.AuditRecordMedication},
+		{db.AuditDelete, db.AuditRecordUser},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d audit entries, got %d", len(expected), len(entries))
+	}
+
+	for i, entry := range entries {
+		if entry.Action != expected[i].action || entry.Record != expected[i].record {
+			t.Fatalf("expected audit entry %d to %s a %s, got %s a %s", i, expected[i].action, expected[i].record, entry.Action, entry.Record)
+		}
+
+		if entry.Actor != "tester" || entry.Time.Before(start) {
+			t.Fatalf("got audit entry %d by %s at %s, expected tester after %s", i, entry.Actor, entry.Time, start)
+		}
+	}
+
+	if strings.Contains(string(entries[0].After), "phone-token") {
+		t.Fatal("expected device tokens to be redacted from audit entries")
+	}
+
+	if entries[5].Before == nil || entries[5].After == nil || entries[8].After != nil {
+		t.Fatal("expected updates to keep before and after and deletes to only keep before")
+	}
+
+	before, after := &db.Medication{}, &db.Medication{}
+	expectNoError(t, json.Unmarshal(entries[7].Before, before), "unmarshal the medication before renaming its device")
+	expectNoError(t, json.Unmarshal(entries[7].After, after), "unmarshal the medication after renaming its device")
+	if entries[7].RecordID != aspirin.ID.String() || !before.RemindsDevice("phone") || !after.RemindsDevice("handset") {
+		t.Fatalf("expected renaming the device to be recorded on the medication reminding it, got %s to %s", entries[7].Before, entries[7].After)
+	}
+
+	entries, err = store.ListAuditEntries(db.AuditFilter{IDUser: alice.ID})
+	expectNoError(t, err, "list audit entries of a user by id")
+	if len(entries) != 7 {
+		t.Fatalf("expected 7 audit entries of the user, got %d", len(entries))
+	}
+
+	entries, err = store.ListAuditEntries(db.AuditFilter{Username: "ALICIA"})
+	expectNoError(t, err, "list audit entries of a user by name")
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 audit entries by the user's name at the time of the change, got %d", len(entries))
+	}
+
+	entries, err = store.ListAuditEntries(db.AuditFilter{Username: "bob"})
+	expectNoError(t, err, "list audit entries of a removed user by name")
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries of the removed user, got %d", len(entries))
+	}
+
+	entries, err = store.ListAuditEntries(db.AuditFilter{Since: time.Now().Add(time.Hour)})
+	expectNoError(t, err, "list audit entries since the future")
+	if len(entries) != 0 {
+		t.Fatalf("expected no audit entries since the future, got %d", len(entries))
+	}
+}
+
+func newNotification(idUser uuid.UUID, createdAt time.Time) *db.Notification {
+	return &db.Notification{
+		ID:            uuid.New(),
+		IDUser:        idUser,
+		IDDose:        uuid.New(),
+		Device:        "phone",
+		Message:       "take 1 dose(s) of aspirin",
+		Priority:      2,
+		Retry:         time.Minute,
+		Expire:        time.Hour,
+		Status:        db.NotificationStatusPending,
+		CreatedAt:     createdAt,
+		NextAttemptAt: createdAt,
+	}
+}
+
+func testNotifications(t *testing.T, store db.Store) {
+	alice := newUser(t, store, "alice", "phone")
+	now := time.Now()
+
+	second := newNotification(alice.ID, now)
+	first := newNotification(alice.ID, now.Add(-time.Minute))
+	alert := newNotification(uuid.Nil, now.Add(time.Minute))
+	alert.IDDose = uuid.Nil
+
+	for _, notification := range []*db.Notification{second, first, alert} {
+		expectNoError(t, store.AddNotification(notification), "add notification")
+	}
+
+	notifications, err := store.ListNotifications()
+	expectNoError(t, err, "list notifications")
+	if len(notifications) != 3 || notifications[0].ID != first.ID || notifications[1].ID != second.ID || notifications[2].ID != alert.ID {
+		t.Fatalf("expected the notifications oldest first, got %+v", notifications)
+	}
+
+	if !notifications[2].IsAlert() || notifications[0].IsAlert() {
+		t.Fatal("expected only the notification without a user to be an alert")
+	}
+
+	if notifications[0].Retry != time.Minute || notifications[0].Device != "phone" || !notifications[0].CreatedAt.Equal(first.CreatedAt) {
+		t.Fatalf("unexpected listed notification %+v", notifications[0])
+	}
+
+	first.Status = db.NotificationStatusDead
+	first.Attempts = 3
+	first.LastError = "failed"
+	expectNoError(t, store.UpdateNotification(first), "update notification")
+
+	notifications, err = store.ListNotifications()
+	expectNoError(t, err, "list updated notifications")
+	if notifications[0].Status != db.NotificationStatusDead || notifications[0].Attempts != 3 || notifications[0].LastError != "failed" {
+		t.Fatalf("expected the notification to be updated, got %+v", notifications[0])
+	}
+
+	expectNoError(t, store.RemoveNotification(first), "remove notification")
+
+	err = store.RemoveNotification(first)
+	expectError(t, err, db.ErrNotFound, "removing a removed notification")
+
+	err = store.UpdateNotification(first)
+	expectError(t, err, db.ErrNotFound, "updating a removed notification")
+
+	expectNoError(t, store.RemoveUser(alice, false), "remove user")
+
+	notifications, err = store.ListNotifications()
+	expectNoError(t, err, "list notifications of a removed user")
+	if len(notifications) != 1 || notifications[0].ID != alert.ID {
+		t.Fatalf("expected only the alert to be left after removing the user, got %+v", notifications)
+	}
+}
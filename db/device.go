@@ -0,0 +1,204 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// addUserDevice to user
+func addUserDevice(user *User, name string, device *PushoverDevice) error {
+	if user.HasDevice(name) {
+		return fmt.Errorf("device %s of user %s %w", name, user.Name, ErrAlreadyExists)
+	}
+
+	return setUserDevice(user, name, device)
+}
+
+// updateUserDevice of user
+func updateUserDevice(user *User, name string, device *PushoverDevice) error {
+	if !user.HasDevice(name) {
+		return fmt.Errorf("device %s of user %s %w", name, user.Name, ErrNotFound)
+	}
+
+	return setUserDevice(user, name, device)
+}
+
+func setUserDevice(user *User, name string, device *PushoverDevice) error {
+	if device.Key == "" {
+		return fmt.Errorf("device %s of user %s has no pushover key", name, user.Name)
+	}
+
+	if user.PushoverDevices == nil {
+		user.PushoverDevices = make(map[string]*PushoverDevice)
+	}
+
+	stored := *device
+	user.PushoverDevices[name] = &stored
+
+	return nil
+}
+
+// renameUserDevice of user and in its medications, returning the medications
+// that changed
+func renameUserDevice(user *User, medications []*Medication, device, newDevice string) ([]*Medication, error) {
+	details, ok := user.PushoverDevices[device]
+	if !ok {
+		return nil, fmt.Errorf("device %s of user %s %w", device, user.Name, ErrNotFound)
+	}
+
+	if user.HasDevice(newDevice) {
+		return nil, fmt.Errorf("device %s of user %s %w", newDevice, user.Name, ErrAlreadyExists)
+	}
+
+	delete(user.PushoverDevices, device)
+	user.PushoverDevices[newDevice] = details
+
+	var changed []*Medication
+	for _, medication := range medications {
+		if !medication.RemindsDevice(device) {
+			continue
+		}
+
+		for i, intervalDevice := range medication.IntervalPushoverDevices {
+			if intervalDevice == device {
+				medication.IntervalPushoverDevices[i] = newDevice
+			}
+		}
+
+		changed = append(changed, medication)
+	}
+
+	return changed, nil
+}
+
+// removeUserDevice of user and from its medications, returning the
+// medications that changed
+func removeUserDevice(user *User, medications []*Medication, device string) ([]*Medication, error) {
+	if !user.HasDevice(device) {
+		return nil, fmt.Errorf("device %s of user %s %w", device, user.Name, ErrNotFound)
+	}
+
+	delete(user.PushoverDevices, device)
+
+	var changed []*Medication
+	for _, medication := range medications {
+		if !medication.RemindsDevice(device) {
+			continue
+		}
+
+		devices := make([]string, 0, len(medication.IntervalPushoverDevices))
+		for _, intervalDevice := range medication.IntervalPushoverDevices {
+			if intervalDevice != device {
+				devices = append(devices, intervalDevice)
+			}
+		}
+
+		if len(devices) == 0 {
+			return nil, fmt.Errorf(
+				"medication %s (%s) only reminds device %s: %w",
+				medication.Name,
+				medication.ID.String(),
+				device,
+				ErrDeviceInUse,
+			)
+		}
+
+		medication.IntervalPushoverDevices = devices
+
+		changed = append(changed, medication)
+	}
+
+	return changed, nil
+}
+
+// legacyPushoverDevice details of a device from before they were kept along
+// with its pushover key
+type legacyPushoverDevice struct {
+	Restrict       []string  `json:"restrict"`
+	Group          bool      `json:"group"`
+	Devices        []string  `json:"devices"`
+	ValidatedAt    time.Time `json:"validated_at"`
+	Disabled       bool      `json:"disabled"`
+	DisabledReason string    `json:"disabled_reason"`
+}
+
+// upgradeUserDevices of the JSON object of a user from before its devices
+// were kept as one entry each, when their keys were in pushover_device_tokens
+// and their details in pushover_devices. It reports whether the user was
+// upgraded, which users without pushover_device_tokens aren't.
+func upgradeUserDevices(user map[string]json.RawMessage) (bool, error) {
+	tokensJSON, ok := user["pushover_device_tokens"]
+	if !ok {
+		return false, nil
+	}
+
+	var tokens map[string]string
+	err := json.Unmarshal(tokensJSON, &tokens)
+	if err != nil {
+		return false, fmt.Errorf("failed to unmarshal pushover_device_tokens: %w", err)
+	}
+
+	var details map[string]*legacyPushoverDevice
+	if detailsJSON, ok := user["pushover_devices"]; ok {
+		err = json.Unmarshal(detailsJSON, &details)
+		if err != nil {
+			return false, fmt.Errorf("failed to unmarshal pushover_devices: %w", err)
+		}
+	}
+
+	// details of devices without a key are dropped along with the map
+	devices := make(map[string]*PushoverDevice, len(tokens))
+	for name, key := range tokens {
+		device := &PushoverDevice{
+			Key:  key,
+			Kind: PushoverKeyUser,
+		}
+
+		if legacy := details[name]; legacy != nil {
+			if legacy.Group {
+				device.Kind = PushoverKeyGroup
+			}
+
+			device.Restrict = legacy.Restrict
+			device.Devices = legacy.Devices
+			device.ValidatedAt = legacy.ValidatedAt
+			device.Disabled = legacy.Disabled
+			device.DisabledReason = legacy.DisabledReason
+		}
+
+		devices[name] = device
+	}
+
+	devicesJSON, err := json.Marshal(devices)
+	if err != nil {
+		return false, fmt.Errorf("failed to JSON marshal pushover_devices: %w", err)
+	}
+
+	user["pushover_devices"] = devicesJSON
+	delete(user, "pushover_device_tokens")
+
+	return true, nil
+}
+
+// upgradeUserDevicesJSON is upgradeUserDevices of the JSON of a user,
+// returning it upgraded
+func upgradeUserDevicesJSON(data []byte) ([]byte, bool, error) {
+	var user map[string]json.RawMessage
+	err := json.Unmarshal(data, &user)
+	if err != nil {
+		return nil, false, err
+	}
+
+	upgraded, err := upgradeUserDevices(user)
+	if err != nil || !upgraded {
+		return nil, false, err
+	}
+
+	data, err = json.Marshal(user)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return data, true, nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DoseStatus of a scheduled dose
+type DoseStatus string
+
+const (
+	// DoseStatusPending is a dose that has been reminded but not acknowledged
+	DoseStatusPending DoseStatus = "pending"
+	// DoseStatusTaken is a dose whose reminder was acknowledged or that was
+	// recorded as taken
+	DoseStatusTaken DoseStatus = "taken"
+	// DoseStatusMissed is a dose whose reminders all expired unacknowledged
+	DoseStatusMissed DoseStatus = "missed"
+	// DoseStatusSkipped is a dose that was recorded as deliberately not taken
+	DoseStatusSkipped DoseStatus = "skipped"
+)
+
+// ErrDoseResolved occurs when updating a pending dose that was resolved in
+// the meantime, e.g. recorded through the CLI while the daemon watched it
+var ErrDoseResolved = errors.New("dose is already resolved")
+
+// Dose is a single scheduled reminder of a medication for a user
+type Dose struct {
+	IDUser       uuid.UUID  `json:"id_user"`
+	IDMedication uuid.UUID  `json:"id_medication"`
+	ID           uuid.UUID  `json:"id"`
+	Quantity     uint       `json:"quantity"`
+	Status       DoseStatus `json:"status"`
+	Receipts     []string   `json:"receipts"`
+	ScheduledAt  time.Time  `json:"scheduled_at"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+}
+
+// checkPending of the stored dose when only a pending one may be updated
+func checkPending(stored *Dose, pendingOnly bool) error {
+	if pendingOnly && stored.Status != DoseStatusPending {
+		return fmt.Errorf("dose %s is %s: %w", stored.ID.String(), stored.Status, ErrDoseResolved)
+	}
+
+	return nil
+}
+
+const badgerPrefixKeyDose = "dose:"
+
+func (d *Dose) badgerKey() []byte {
+	return append(badgerPrefixKeyForDoseUserSince(d.IDUser, d.ScheduledAt), d.ID[:]...)
+}
+
+func badgerPrefixKeyForDoseUser(user *User) []byte {
+	return append([]byte(badgerPrefixKeyDose), user.ID[:]...)
+}
+
+func badgerPrefixKeyForDoseUserSince(idUser uuid.UUID, since time.Time) []byte {
+	key := append([]byte(badgerPrefixKeyDose), idUser[:]...)
+
+	// times before the epoch, e.g. the zero time, would wrap around
+	if epoch := time.Unix(0, 0); since.Before(epoch) {
+		since = epoch
+	}
+
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(since.UnixNano()))
+
+	return append(key, timestamp...)
+}
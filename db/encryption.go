@@ -0,0 +1,274 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	badgerv1 "github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/v3"
+)
+
+var (
+	// ErrWrongEncryptionKey occurs when opening a database with a key other
+	// than the one it is encrypted with, which includes giving a key for a
+	// plaintext database and no key for an encrypted one
+	ErrWrongEncryptionKey = errors.New("encryption key doesn't match the database")
+	// ErrLegacyFormat occurs when opening a database written by badger v1,
+	// which has to be converted with UpgradeBadger first
+	ErrLegacyFormat = errors.New("database is in the badger v1 format")
+	// ErrLocked occurs when opening a database another process, such as a
+	// running daemon, has open since badger only allows one at a time
+	ErrLocked = errors.New("database is in use by another process")
+)
+
+const (
+	// badgerV1ManifestVersion is the MANIFEST version written by badger v1
+	badgerV1ManifestVersion = 4
+	// encryptionKeyRotation is how often badger generates a new data key,
+	// the encryption key only encrypts the data keys
+	encryptionKeyRotation = time.Hour * 24 * 10
+	// badgerIndexCacheSize in bytes, badger requires an index cache for
+	// encrypted databases
+	badgerIndexCacheSize = 16 << 20
+)
+
+// ParseEncryptionKey from its hex encoding, it must decode to 16, 24 or 32
+// bytes for AES-128, AES-192 or AES-256. An empty key disables encryption.
+func ParseEncryptionKey(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	decoded, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key must be hex encoded: %w", err)
+	}
+
+	switch len(decoded) {
+	case 16, 24, 32:
+		return decoded, nil
+
+	default:
+		return nil, fmt.Errorf("encryption key must be 16, 24 or 32 bytes, got %d", len(decoded))
+	}
+}
+
+func badgerOptions(dbPath string, encryptionKey []byte) badger.Options {
+	return badger.DefaultOptions(dbPath).
+		WithLogger(nil).
+		WithEncryptionKey(encryptionKey).
+		WithEncryptionKeyRotationDuration(encryptionKeyRotation).
+		WithIndexCacheSize(badgerIndexCacheSize)
+}
+
+// openBadger at dbPath, encrypting it with encryptionKey unless it is empty
+func openBadger(dbPath string, encryptionKey []byte) (*badger.DB, error) {
+	legacy, err := isLegacyBadger(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if legacy {
+		return nil, fmt.Errorf("failed to open badger db at path %s: %w", dbPath, ErrLegacyFormat)
+	}
+
+	db, err := badger.Open(badgerOptions(dbPath, encryptionKey))
+	switch {
+	case errors.Is(err, badger.ErrEncryptionKeyMismatch):
+		err = ErrWrongEncryptionKey
+
+	// badger reports the lock it failed to get only through its message
+	case err != nil && strings.Contains(err.Error(), "Another process is using this Badger database"):
+		err = ErrLocked
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger db at path %s: %w", dbPath, err)
+	}
+
+	return db, nil
+}
+
+// isLegacyBadger reports whether the MANIFEST of the database at dbPath was
+// written by badger v1, a database that doesn't exist yet isn't
+func isLegacyBadger(dbPath string) (bool, error) {
+	f, err := os.Open(filepath.Join(dbPath, badger.ManifestFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to open badger manifest at path %s: %w", dbPath, err)
+	}
+
+	defer f.Close()
+
+	header := make([]byte, 8)
+	_, err = io.ReadFull(f, header)
+	if err != nil {
+		return false, fmt.Errorf("failed to read badger manifest at path %s: %w", dbPath, err)
+	}
+
+	return bytes.Equal(header[:4], []byte("Bdgr")) && binary.BigEndian.Uint32(header[4:]) == badgerV1ManifestVersion, nil
+}
+
+// EncryptBadger rewrites the database at dbPath, currently encrypted with
+// encryptionKey or plaintext when it is empty, encrypted with newKey instead.
+// Unlike RotateBadgerKey every value is re-encrypted, which is the only way
+// to encrypt a plaintext database. An empty newKey decrypts the database. The
+// database must not be open.
+func EncryptBadger(dbPath string, encryptionKey, newKey []byte) error {
+	return replaceBadger(dbPath, newKey, func(w io.Writer) error {
+		db, err := openBadger(dbPath, encryptionKey)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		_, err = db.Backup(w, 0)
+
+		return err
+	})
+}
+
+// UpgradeBadger converts the badger v1 database at dbPath to the current
+// format, encrypting it with encryptionKey unless it is empty. The database
+// must not be open.
+func UpgradeBadger(dbPath string, encryptionKey []byte) error {
+	legacy, err := isLegacyBadger(dbPath)
+	if err != nil {
+		return err
+	}
+
+	if !legacy {
+		return fmt.Errorf("badger db at path %s is not in the badger v1 format", dbPath)
+	}
+
+	return replaceBadger(dbPath, encryptionKey, func(w io.Writer) error {
+		db, err := badgerv1.Open(badgerv1.DefaultOptions(dbPath).WithLogger(nil))
+		if err != nil {
+			return fmt.Errorf("failed to open badger v1 db at path %s: %w", dbPath, err)
+		}
+
+		defer db.Close()
+
+		_, err = db.Backup(w, 0)
+
+		return err
+	})
+}
+
+// replaceBadger with a copy of it encrypted with encryptionKey, loading it
+// from the backup stream written by dump. The copy is built next to the
+// database and only swapped in once it is complete.
+func replaceBadger(dbPath string, encryptionKey []byte, dump func(w io.Writer) error) error {
+	dbPath = filepath.Clean(dbPath)
+	newPath := dbPath + ".new"
+	oldPath := dbPath + ".old"
+
+	for _, path := range []string{newPath, oldPath} {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s is left over from an earlier attempt, remove it first", path)
+		}
+	}
+
+	db, err := badger.Open(badgerOptions(newPath, encryptionKey))
+	if err != nil {
+		return fmt.Errorf("failed to create badger db at path %s: %w", newPath, err)
+	}
+
+	r, w := io.Pipe()
+	dumpErr := make(chan error, 1)
+	go func() {
+		err := dump(w)
+		w.CloseWithError(err)
+		dumpErr <- err
+	}()
+
+	err = db.Load(r, 256)
+	r.Close()
+
+	// the dump fails with a closed pipe when loading failed first
+	if dErr := <-dumpErr; dErr != nil && err == nil {
+		err = dErr
+	}
+
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.RemoveAll(newPath)
+		return fmt.Errorf("failed to copy badger db at path %s: %w", dbPath, err)
+	}
+
+	err = os.Rename(dbPath, oldPath)
+	if err != nil {
+		os.RemoveAll(newPath)
+		return fmt.Errorf("failed to move badger db at path %s aside: %w", dbPath, err)
+	}
+
+	err = os.Rename(newPath, dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to move the copy of badger db at path %s in place, the original is at %s: %w", dbPath, oldPath, err)
+	}
+
+	err = os.RemoveAll(oldPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove the original badger db at path %s: %w", oldPath, err)
+	}
+
+	return nil
+}
+
+// RotateBadgerKey of the encrypted database at dbPath from encryptionKey to
+// newKey. Only the data keys are re-encrypted, the values stay encrypted with
+// the data keys they were written with. The database must not be open.
+func RotateBadgerKey(dbPath string, encryptionKey, newKey []byte) error {
+	if len(encryptionKey) == 0 || len(newKey) == 0 {
+		return errors.New("both the current and the new encryption key are required to rotate keys, encrypt a plaintext database instead")
+	}
+
+	// opening the database checks encryptionKey and that it isn't in use
+	db, err := openBadger(dbPath, encryptionKey)
+	if err != nil {
+		return err
+	}
+
+	err = db.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close badger db at path %s: %w", dbPath, err)
+	}
+
+	opts := badger.KeyRegistryOptions{
+		Dir:                           dbPath,
+		ReadOnly:                      true,
+		EncryptionKey:                 encryptionKey,
+		EncryptionKeyRotationDuration: encryptionKeyRotation,
+	}
+
+	registry, err := badger.OpenKeyRegistry(opts)
+	if err != nil {
+		return fmt.Errorf("failed to open the key registry of badger db at path %s: %w", dbPath, err)
+	}
+
+	defer registry.Close()
+
+	opts.EncryptionKey = newKey
+
+	err = badger.WriteKeyRegistry(registry, opts)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite the key registry of badger db at path %s: %w", dbPath, err)
+	}
+
+	return nil
+}
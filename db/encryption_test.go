@@ -0,0 +1,159 @@
+package db_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	badgerv1 "github.com/dgraph-io/badger"
+	"github.com/google/uuid"
+)
+
+// expectUser alice in the badger database at dbPath opened with key
+func expectUser(t *testing.T, dbPath string, key []byte, alice *db.User) {
+	t.Helper()
+
+	b, err := db.NewBadger(dbPath, time.Hour, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	user, err := b.GetUser(alice.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.ID != alice.ID {
+		t.Fatalf("expected user %s, got %s", alice.ID, user.ID)
+	}
+}
+
+func expectWrongKey(t *testing.T, dbPath string, key []byte) {
+	t.Helper()
+
+	b, err := db.NewBadger(dbPath, time.Hour, key)
+	if err == nil {
+		b.Close()
+	}
+
+	if !errors.Is(err, db.ErrWrongEncryptionKey) {
+		t.Fatalf("expected opening with the wrong key to fail with %v, got %v", db.ErrWrongEncryptionKey, err)
+	}
+}
+
+func TestEncryptBadger(t *testing.T) {
+	dbPath := t.TempDir()
+	key := bytes.Repeat([]byte{0x42}, 32)
+	newKey := bytes.Repeat([]byte{0x43}, 16)
+
+	b, err := db.NewBadger(dbPath, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &db.User{ID: uuid.New(), Name: "alice"}
+	err = b.AddUser(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.EncryptBadger(dbPath, key, key)
+	if err == nil {
+		t.Fatal("expected encrypting an open database to fail")
+	}
+
+	b.Close()
+
+	err = db.EncryptBadger(dbPath, nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectWrongKey(t, dbPath, nil)
+	expectUser(t, dbPath, key, alice)
+
+	err = db.RotateBadgerKey(dbPath, newKey, newKey)
+	if !errors.Is(err, db.ErrWrongEncryptionKey) {
+		t.Fatalf("expected rotating from the wrong key to fail with %v, got %v", db.ErrWrongEncryptionKey, err)
+	}
+
+	err = db.RotateBadgerKey(dbPath, key, nil)
+	if err == nil {
+		t.Fatal("expected rotating to no key to fail")
+	}
+
+	err = db.RotateBadgerKey(dbPath, key, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectWrongKey(t, dbPath, key)
+	expectWrongKey(t, dbPath, nil)
+	expectUser(t, dbPath, newKey, alice)
+
+	// decrypting goes back to a plaintext database
+	err = db.EncryptBadger(dbPath, newKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectWrongKey(t, dbPath, newKey)
+	expectUser(t, dbPath, nil, alice)
+}
+
+func TestUpgradeBadger(t *testing.T) {
+	dbPath := t.TempDir()
+	key := bytes.Repeat([]byte{0x42}, 24)
+	alice := &db.User{ID: uuid.New(), Name: "alice"}
+
+	// written by a release using badger v1, with users keyed by username
+	legacy, err := badgerv1.Open(badgerv1.DefaultOptions(dbPath).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = legacy.Update(func(tx *badgerv1.Txn) error {
+		return tx.Set([]byte("user:alice"), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = legacy.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.NewBadger(dbPath, time.Hour, nil)
+	if !errors.Is(err, db.ErrLegacyFormat) {
+		t.Fatalf("expected opening a badger v1 database to fail with %v, got %v", db.ErrLegacyFormat, err)
+	}
+
+	err = db.EncryptBadger(dbPath, nil, key)
+	if !errors.Is(err, db.ErrLegacyFormat) {
+		t.Fatalf("expected encrypting a badger v1 database to fail with %v, got %v", db.ErrLegacyFormat, err)
+	}
+
+	err = db.UpgradeBadger(dbPath, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.UpgradeBadger(dbPath, key)
+	if err == nil {
+		t.Fatal("expected upgrading an upgraded database to fail")
+	}
+
+	expectWrongKey(t, dbPath, nil)
+	expectUser(t, dbPath, key, alice)
+}
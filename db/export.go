@@ -0,0 +1,559 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// ExportVersion of the export document written by NewExport and read by Import,
+// version 1 kept the keys and the details of the devices of a user apart
+const ExportVersion = 2
+
+// ImportMode decides what happens to the data already in the store
+type ImportMode string
+
+const (
+	// ImportMerge adds the users and medications that aren't in the store yet
+	// and leaves the ones that are untouched, doses and history are only
+	// imported along with the user or medication they belong to
+	ImportMerge ImportMode = "merge"
+	// ImportReplace removes everything in the store before importing
+	ImportReplace ImportMode = "replace"
+)
+
+// Export document of everything in a store
+type Export struct {
+	Version    int           `json:"version"`
+	ExportedAt time.Time     `json:"exported_at"`
+	Users      []*ExportUser `json:"users"`
+}
+
+// ExportUser is a user along with its medications and doses
+type ExportUser struct {
+	User
+	Medications []*ExportMedication `json:"medications"`
+	Doses       []*Dose             `json:"doses"`
+}
+
+// ExportMedication is a medication along with its history
+type ExportMedication struct {
+	Medication
+	History []*MedicationVersion `json:"history"`
+}
+
+// ImportOptions for Import
+type ImportOptions struct {
+	Mode ImportMode
+	// NewIDs gives every imported user, medication and dose a new ID instead
+	// of keeping the ones in the export
+	NewIDs bool
+	// DryRun only validates the export against the store
+	DryRun bool
+}
+
+// ImportResult counts the records that were, or would be for a dry run,
+// imported and skipped
+type ImportResult struct {
+	Users              int `json:"users"`
+	Medications        int `json:"medications"`
+	MedicationVersions int `json:"medication_versions"`
+	Doses              int `json:"doses"`
+	SkippedUsers       int `json:"skipped_users"`
+	SkippedMedications int `json:"skipped_medications"`
+}
+
+// ImportError lists every problem found while validating an export, nothing
+// is imported when it occurs
+type ImportError struct {
+	Problems []string
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("invalid export with %d problems:\n\t%s", len(e.Problems), strings.Join(e.Problems, "\n\t"))
+}
+
+// NewExport of everything in store
+func NewExport(store Store) (*Export, error) {
+	users, err := store.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	export := &Export{
+		Version:    ExportVersion,
+		ExportedAt: time.Now(),
+		Users:      make([]*ExportUser, 0, len(users)),
+	}
+
+	for _, user := range users {
+		medications, err := store.ListMedicationsForUser(user)
+		if err != nil {
+			return nil, err
+		}
+
+		doses, err := store.ListDosesForUser(user, time.Unix(0, 0))
+		if err != nil {
+			return nil, err
+		}
+
+		exportUser := &ExportUser{
+			User:        *user,
+			Medications: make([]*ExportMedication, 0, len(medications)),
+			Doses:       doses,
+		}
+
+		if exportUser.Doses == nil {
+			exportUser.Doses = []*Dose{}
+		}
+
+		for _, medication := range medications {
+			history, err := store.ListMedicationHistory(medication)
+			if err != nil {
+				return nil, err
+			}
+
+			if history == nil {
+				history = []*MedicationVersion{}
+			}
+
+			exportUser.Medications = append(exportUser.Medications, &ExportMedication{
+				Medication: *medication,
+				History:    history,
+			})
+		}
+
+		export.Users = append(export.Users, exportUser)
+	}
+
+	return export, nil
+}
+
+// ReadExport document from r, refusing unknown fields and upgrading exports of
+// an earlier version to ExportVersion
+func ReadExport(r io.Reader) (*Export, error) {
+	var document map[string]json.RawMessage
+	err := json.NewDecoder(r).Decode(&document)
+	if err != nil {
+		return nil, err
+	}
+
+	var version int
+	if versionJSON, ok := document["version"]; ok {
+		err = json.Unmarshal(versionJSON, &version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid export version: %w", err)
+		}
+	}
+
+	if version == 1 {
+		err = upgradeExportDevices(document)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upgrade export version 1: %w", err)
+		}
+	}
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &Export{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(export)
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// upgradeExportDevices of every user of an export document of version 1 to
+// ExportVersion
+func upgradeExportDevices(document map[string]json.RawMessage) error {
+	var users []map[string]json.RawMessage
+	if usersJSON, ok := document["users"]; ok {
+		err := json.Unmarshal(usersJSON, &users)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, user := range users {
+		_, err := upgradeUserDevices(user)
+		if err != nil {
+			return fmt.Errorf("user %d: %w", i, err)
+		}
+	}
+
+	usersJSON, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+
+	document["users"] = usersJSON
+	document["version"] = json.RawMessage(fmt.Sprint(ExportVersion))
+
+	return nil
+}
+
+// Import the export into store after validating all of it, failing with an
+// ImportError listing every problem found before anything is written. When
+// replacing the store fails halfway, what was in it is put back.
+func (export *Export) Import(store Store, options ImportOptions) (*ImportResult, error) {
+	if export.Version != ExportVersion {
+		return nil, fmt.Errorf("unsupported export version %d, only version %d is supported", export.Version, ExportVersion)
+	}
+
+	if options.Mode != ImportMerge && options.Mode != ImportReplace {
+		return nil, fmt.Errorf("invalid import mode %s, must be %s or %s", options.Mode, ImportMerge, ImportReplace)
+	}
+
+	if options.NewIDs {
+		renewIDs(export)
+	}
+
+	plan, err := planImport(store, export, options.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.DryRun {
+		return plan.result, nil
+	}
+
+	if options.Mode == ImportMerge {
+		err = plan.write(store)
+		if err != nil {
+			return nil, err
+		}
+
+		return plan.result, nil
+	}
+
+	// what is replaced is kept to put it back when the import fails halfway
+	previous, err := NewExport(store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export the store before replacing it: %w", err)
+	}
+
+	err = replace(store, plan)
+	if err != nil {
+		restoreErr := replace(store, previous.plan())
+		if restoreErr != nil {
+			return nil, fmt.Errorf("%w, restoring what was replaced failed as well: %v", err, restoreErr)
+		}
+
+		return nil, fmt.Errorf("%w, what was replaced was restored", err)
+	}
+
+	return plan.result, nil
+}
+
+// replace everything in store with the plan
+func replace(store Store, plan *importPlan) error {
+	users, err := store.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		err = store.RemoveUser(user, true)
+		if err != nil {
+			return fmt.Errorf("failed to remove user %s before importing: %w", user.Name, err)
+		}
+	}
+
+	return plan.write(store)
+}
+
+// write the records of the plan to store
+func (plan *importPlan) write(store Store) error {
+	for _, user := range plan.users {
+		err := store.AddUser(&user.User)
+		if err != nil {
+			return fmt.Errorf("failed to import user %s: %w", user.Name, err)
+		}
+	}
+
+	for _, medication := range plan.medications {
+		err := store.AddMedication(&medication.Medication)
+		if err != nil {
+			return fmt.Errorf("failed to import medication %s (%s): %w", medication.Name, medication.ID.String(), err)
+		}
+
+		for _, version := range medication.History {
+			err = store.AddMedicationVersion(version)
+			if err != nil {
+				return fmt.Errorf("failed to import history of medication %s (%s): %w", medication.Name, medication.ID.String(), err)
+			}
+		}
+	}
+
+	for _, dose := range plan.doses {
+		err := store.AddDose(dose)
+		if err != nil {
+			return fmt.Errorf("failed to import dose %s: %w", dose.ID.String(), err)
+		}
+	}
+
+	return nil
+}
+
+// renewIDs of every user, medication and dose in export, keeping the
+// references between them
+func renewIDs(export *Export) {
+	medicationIDs := make(map[uuid.UUID]uuid.UUID)
+
+	for _, user := range export.Users {
+		user.ID = uuid.New()
+
+		for _, medication := range user.Medications {
+			id := uuid.New()
+			medicationIDs[medication.ID] = id
+
+			medication.ID = id
+			medication.IDUser = user.ID
+
+			for _, version := range medication.History {
+				version.ID = id
+				version.IDUser = user.ID
+			}
+		}
+	}
+
+	for _, user := range export.Users {
+		for _, dose := range user.Doses {
+			dose.ID = uuid.New()
+			dose.IDUser = user.ID
+
+			// doses of removed medications keep referencing the old ID
+			if id, ok := medicationIDs[dose.IDMedication]; ok {
+				dose.IDMedication = id
+			}
+		}
+	}
+}
+
+type importPlan struct {
+	users       []*ExportUser
+	medications []*ExportMedication
+	doses       []*Dose
+	result      *ImportResult
+}
+
+// plan to import every record of the export as it is, without validating it
+func (export *Export) plan() *importPlan {
+	plan := &importPlan{
+		result: &ImportResult{},
+	}
+
+	for _, user := range export.Users {
+		plan.users = append(plan.users, user)
+		plan.medications = append(plan.medications, user.Medications...)
+		plan.doses = append(plan.doses, user.Doses...)
+	}
+
+	return plan
+}
+
+// planImport validates export and decides which of its records to import
+func planImport(store Store, export *Export, mode ImportMode) (*importPlan, error) {
+	plan := &importPlan{
+		result: &ImportResult{},
+	}
+
+	var problems []string
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	userIDs := make(map[uuid.UUID]bool)
+	usernames := make(map[string]bool)
+	medicationIDs := make(map[uuid.UUID]bool)
+	doseIDs := make(map[uuid.UUID]bool)
+
+	for i, user := range export.Users {
+		path := fmt.Sprintf("user %d (%s)", i, user.Name)
+
+		if user.Name == "" {
+			problem("%s: has no name", path)
+		}
+
+		if user.ID == uuid.Nil {
+			problem("%s: has no id", path)
+		}
+
+		if userIDs[user.ID] {
+			problem("%s: duplicate id %s", path, user.ID.String())
+		}
+
+		userIDs[user.ID] = true
+
+		if usernames[normalizeUsername(user.Name)] {
+			problem("%s: duplicate name when compared case-insensitively", path)
+		}
+
+		usernames[normalizeUsername(user.Name)] = true
+
+		for name, device := range user.PushoverDevices {
+			if device == nil || device.Key == "" {
+				problem("%s: device %s has no pushover key", path, name)
+			}
+		}
+
+		// the devices medications may remind, which are the ones of the user
+		// in the store when the user is already there
+		devices := user.PushoverDevices
+
+		existing := false
+		if mode == ImportMerge {
+			byID, err := store.GetUserByID(user.ID)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+
+			byName, err := store.GetUser(user.Name)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+
+			switch {
+			case byName != nil && byName.ID != user.ID:
+				problem("%s: name is taken by id user %s in the store", path, byName.ID.String())
+
+			case byID != nil && normalizeUsername(byID.Name) != normalizeUsername(user.Name):
+				problem("%s: id is taken by user %s in the store", path, byID.Name)
+			}
+
+			existing = byID != nil
+			if existing {
+				devices = byID.PushoverDevices
+			}
+		}
+
+		if existing {
+			plan.result.SkippedUsers++
+		} else {
+			plan.users = append(plan.users, user)
+			plan.result.Users++
+		}
+
+		for j, medication := range user.Medications {
+			medicationPath := fmt.Sprintf("%s medication %d (%s)", path, j, medication.Name)
+
+			if medication.ID == uuid.Nil {
+				problem("%s: has no id", medicationPath)
+			}
+
+			if medicationIDs[medication.ID] {
+				problem("%s: duplicate id %s", medicationPath, medication.ID.String())
+			}
+
+			medicationIDs[medication.ID] = true
+
+			if medication.IDUser != user.ID {
+				problem("%s: belongs to id user %s", medicationPath, medication.IDUser.String())
+			}
+
+			if medication.Name == "" {
+				problem("%s: has no name", medicationPath)
+			}
+
+			if _, err := cron.ParseStandard(medication.IntervalCrontab); err != nil {
+				problem("%s: invalid cron schedule '%s': %s", medicationPath, medication.IntervalCrontab, err)
+			}
+
+			if medication.IntervalQuantity == 0 {
+				problem("%s: interval quantity must be positive", medicationPath)
+			}
+
+			if len(medication.IntervalPushoverDevices) == 0 {
+				problem("%s: reminds no devices", medicationPath)
+			}
+
+			for _, device := range medication.IntervalPushoverDevices {
+				if _, ok := devices[device]; !ok {
+					problem("%s: reminds device %s the user doesn't have", medicationPath, device)
+				}
+			}
+
+			for k, version := range medication.History {
+				if version.ID != medication.ID || version.IDUser != user.ID {
+					problem("%s history %d: belongs to another medication or user", medicationPath, k)
+				}
+			}
+
+			existingMedication := false
+			if mode == ImportMerge {
+				byID, err := store.GetMedication(medication.ID)
+				if err != nil && !errors.Is(err, ErrNotFound) {
+					return nil, err
+				}
+
+				if byID != nil && byID.IDUser != user.ID {
+					problem("%s: id is taken by a medication of id user %s in the store", medicationPath, byID.IDUser.String())
+				}
+
+				existingMedication = byID != nil
+			}
+
+			if existingMedication {
+				plan.result.SkippedMedications++
+				continue
+			}
+
+			plan.medications = append(plan.medications, medication)
+			plan.result.Medications++
+			plan.result.MedicationVersions += len(medication.History)
+		}
+
+		for j, dose := range user.Doses {
+			dosePath := fmt.Sprintf("%s dose %d", path, j)
+
+			if dose.ID == uuid.Nil {
+				problem("%s: has no id", dosePath)
+			}
+
+			if doseIDs[dose.ID] {
+				problem("%s: duplicate id %s", dosePath, dose.ID.String())
+			}
+
+			doseIDs[dose.ID] = true
+
+			if dose.IDUser != user.ID {
+				problem("%s: belongs to id user %s", dosePath, dose.IDUser.String())
+			}
+
+			switch dose.Status {
+			case DoseStatusPending, DoseStatusTaken, DoseStatusMissed, DoseStatusSkipped:
+			default:
+				problem("%s: invalid status %s", dosePath, dose.Status)
+			}
+
+			// doses are only merged along with a user that is new to the store
+			if existing {
+				continue
+			}
+
+			plan.doses = append(plan.doses, dose)
+			plan.result.Doses++
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &ImportError{Problems: problems}
+	}
+
+	return plan, nil
+}
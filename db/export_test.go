@@ -0,0 +1,463 @@
+package db_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"github.com/google/uuid"
+)
+
+// seed store with alice, who takes aspirin on her phone and has a dose of
+// it, and bob without any medications
+func seed(t *testing.T, store db.Store) {
+	t.Helper()
+
+	alice := &db.User{
+		ID:   uuid.New(),
+		Name: "alice",
+		PushoverDevices: map[string]*db.PushoverDevice{
+			"phone":  {Key: "alice-key", Kind: db.PushoverKeyUser},
+			"tablet": {Key: "alice-key", Kind: db.PushoverKeyUser},
+		},
+	}
+
+	bob := &db.User{
+		ID:   uuid.New(),
+		Name: "bob",
+		PushoverDevices: map[string]*db.PushoverDevice{
+			"phone": {Key: "bob-key", Kind: db.PushoverKeyUser},
+		},
+	}
+
+	aspirin := &db.Medication{
+		IDUser:                  alice.ID,
+		ID:                      uuid.New(),
+		Name:                    "aspirin",
+		IntervalCrontab:         "0 8 * * *",
+		IntervalQuantity:        1,
+		IntervalPushoverDevices: []string{"phone"},
+	}
+
+	dose := &db.Dose{
+		IDUser:       alice.ID,
+		IDMedication: aspirin.ID,
+		ID:           uuid.New(),
+		Quantity:     1,
+		Status:       db.DoseStatusTaken,
+		ScheduledAt:  time.Now().Truncate(time.Second),
+	}
+
+	for _, err := range []error{
+		store.AddUser(alice),
+		store.AddUser(bob),
+		store.AddMedication(aspirin),
+		store.AddDose(dose),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updated := *aspirin
+	updated.IntervalQuantity = 2
+
+	err := store.UpdateMedication(&updated, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// export everything in store through JSON, the way the CLI reads it back
+func export(t *testing.T, store db.Store) *db.Export {
+	t.Helper()
+
+	exported, err := db.NewExport(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &db.Export{}
+
+	err = json.Unmarshal(data, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
+
+// exportedUser by name
+func exportedUser(t *testing.T, export *db.Export, name string) *db.ExportUser {
+	t.Helper()
+
+	for _, user := range export.Users {
+		if user.Name == name {
+			return user
+		}
+	}
+
+	t.Fatalf("no user %s in export", name)
+
+	return nil
+}
+
+// expectSameUsers in both exports, apart from their order
+func expectSameUsers(t *testing.T, want, got *db.Export) {
+	t.Helper()
+
+	if len(want.Users) != len(got.Users) {
+		t.Fatalf("expected %d users, got %d", len(want.Users), len(got.Users))
+	}
+
+	for _, user := range want.Users {
+		if other := exportedUser(t, got, user.Name); !reflect.DeepEqual(user, other) {
+			t.Fatalf("expected user %+v, got %+v", user, other)
+		}
+	}
+}
+
+func TestImportReplace(t *testing.T) {
+	source := db.NewMemory()
+	seed(t, source)
+
+	target := db.NewMemory()
+	err := target.AddUser(&db.User{ID: uuid.New(), Name: "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := export(t, source).Import(target, db.ImportOptions{Mode: db.ImportReplace})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &db.ImportResult{Users: 2, Medications: 1, MedicationVersions: 1, Doses: 1}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected result %+v, got %+v", expected, result)
+	}
+
+	_, err = target.GetUser("carol")
+	if !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected the replaced user to be removed, got %v", err)
+	}
+
+	expectSameUsers(t, export(t, source), export(t, target))
+}
+
+func TestImportMerge(t *testing.T) {
+	store := db.NewMemory()
+	seed(t, store)
+
+	exported := export(t, store)
+	alice := exportedUser(t, exported, "alice")
+
+	// the exported alice lost her tablet, but the one in the store still has
+	// it so a new medication may remind it
+	delete(alice.PushoverDevices, "tablet")
+	alice.Medications = append(alice.Medications, &db.ExportMedication{
+		Medication: db.Medication{
+			IDUser:                  alice.ID,
+			ID:                      uuid.New(),
+			Name:                    "vitamin d",
+			IntervalCrontab:         "0 9 * * *",
+			IntervalQuantity:        1,
+			IntervalPushoverDevices: []string{"tablet"},
+		},
+	})
+
+	dave := &db.ExportUser{
+		User: db.User{
+			ID:   uuid.New(),
+			Name: "dave",
+			PushoverDevices: map[string]*db.PushoverDevice{
+				"phone": {Key: "dave-key", Kind: db.PushoverKeyUser},
+			},
+		},
+	}
+	exported.Users = append(exported.Users, dave)
+
+	result, err := exported.Import(store, db.ImportOptions{Mode: db.ImportMerge})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &db.ImportResult{Users: 1, Medications: 1, SkippedUsers: 2, SkippedMedications: 1}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected result %+v, got %+v", expected, result)
+	}
+
+	stored, err := store.GetUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stored.HasDevice("tablet") {
+		t.Fatal("expected merging to leave the existing user untouched")
+	}
+
+	medications, err := store.ListMedicationsForUser(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(medications) != 2 {
+		t.Fatalf("expected the new medication to be merged, got %d medications", len(medications))
+	}
+
+	_, err = store.GetUser("dave")
+	if err != nil {
+		t.Fatalf("expected the new user to be merged: %v", err)
+	}
+
+	// a device neither the exported nor the stored user has
+	alice.Medications[1].ID = uuid.New()
+	alice.Medications[1].IntervalPushoverDevices = []string{"watch"}
+	alice.PushoverDevices["watch"] = &db.PushoverDevice{Key: "alice-key", Kind: db.PushoverKeyUser}
+
+	_, err = exported.Import(store, db.ImportOptions{Mode: db.ImportMerge})
+
+	var importErr *db.ImportError
+	if !errors.As(err, &importErr) || len(importErr.Problems) != 1 {
+		t.Fatalf("expected reminding a device the stored user doesn't have to be the only problem, got %v", err)
+	}
+}
+
+func TestImportNewIDs(t *testing.T) {
+	store := db.NewMemory()
+	seed(t, store)
+
+	exported := export(t, store)
+	for _, user := range exported.Users {
+		user.Name += "-copy"
+	}
+
+	result, err := exported.Import(store, db.ImportOptions{Mode: db.ImportMerge, NewIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Users != 2 || result.Medications != 1 || result.Doses != 1 || result.SkippedUsers != 0 {
+		t.Fatalf("expected every record to be imported as new, got %+v", result)
+	}
+
+	original, err := store.GetUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := store.GetUser("alice-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if copied.ID == original.ID {
+		t.Fatal("expected the copy to have a new id")
+	}
+
+	medications, err := store.ListMedicationsForUser(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	originals, err := store.ListMedicationsForUser(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(medications) != 1 || len(originals) != 1 || medications[0].ID == originals[0].ID {
+		t.Fatalf("expected the copy to have a medication of its own, got %+v", medications)
+	}
+
+	history, err := store.ListMedicationHistory(medications[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 1 {
+		t.Fatalf("expected the history to follow the medication, got %d versions", len(history))
+	}
+
+	doses, err := store.ListDosesForUser(copied, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doses) != 1 || doses[0].IDMedication != medications[0].ID {
+		t.Fatalf("expected the dose to reference the copied medication, got %+v", doses)
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	source := db.NewMemory()
+	seed(t, source)
+
+	target := db.NewMemory()
+	err := target.AddUser(&db.User{ID: uuid.New(), Name: "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := export(t, target)
+
+	for _, mode := range []db.ImportMode{db.ImportMerge, db.ImportReplace} {
+		result, err := export(t, source).Import(target, db.ImportOptions{Mode: mode, DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Users != 2 {
+			t.Fatalf("expected a %s dry run to report the users it would import, got %+v", mode, result)
+		}
+	}
+
+	expectSameUsers(t, before, export(t, target))
+}
+
+func TestImportProblems(t *testing.T) {
+	source := db.NewMemory()
+	seed(t, source)
+
+	exported := export(t, source)
+	alice := exportedUser(t, exported, "alice")
+	bob := exportedUser(t, exported, "bob")
+
+	bob.Name = "ALICE"
+	bob.PushoverDevices["phone"].Key = ""
+
+	aspirin := alice.Medications[0]
+	aspirin.IntervalCrontab = "never"
+	aspirin.IntervalQuantity = 0
+	aspirin.IntervalPushoverDevices = []string{"watch"}
+
+	alice.Doses[0].Status = "forgotten"
+
+	// users are exported in no particular order
+	exported.Users = []*db.ExportUser{alice, bob}
+
+	store := db.NewMemory()
+	_, err := exported.Import(store, db.ImportOptions{Mode: db.ImportReplace})
+
+	var importErr *db.ImportError
+	if !errors.As(err, &importErr) {
+		t.Fatalf("expected an import error, got %v", err)
+	}
+
+	alicePath := "user 0 (alice)"
+	bobPath := "user 1 (ALICE)"
+
+	expected := map[string]bool{
+		bobPath + ": duplicate name when compared case-insensitively":                                                     true,
+		bobPath + ": device phone has no pushover key":                                                                    true,
+		alicePath + " medication 0 (aspirin): invalid cron schedule 'never': expected exactly 5 fields, found 1: [never]": true,
+		alicePath + " medication 0 (aspirin): interval quantity must be positive":                                         true,
+		alicePath + " medication 0 (aspirin): reminds device watch the user doesn't have":                                 true,
+		alicePath + " dose 0: invalid status forgotten":                                                                   true,
+	}
+
+	if len(importErr.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d:\n%s", len(expected), len(importErr.Problems), importErr)
+	}
+
+	for _, problem := range importErr.Problems {
+		if !expected[problem] {
+			t.Fatalf("unexpected problem %q in:\n%s", problem, importErr)
+		}
+	}
+
+	users, err := store.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 0 {
+		t.Fatalf("expected nothing to be imported, got %d users", len(users))
+	}
+}
+
+// failingStore fails to add the dose with the ID
+type failingStore struct {
+	db.Store
+	dose uuid.UUID
+}
+
+func (s *failingStore) AddDose(dose *db.Dose) error {
+	if dose.ID == s.dose {
+		return errors.New("disk full")
+	}
+
+	return s.Store.AddDose(dose)
+}
+
+func TestImportReplaceRestores(t *testing.T) {
+	source := db.NewMemory()
+	seed(t, source)
+
+	exported := export(t, source)
+
+	target := db.NewMemory()
+	seed(t, target)
+	before := export(t, target)
+
+	store := &failingStore{
+		Store: target,
+		dose:  exportedUser(t, exported, "alice").Doses[0].ID,
+	}
+
+	_, err := exported.Import(store, db.ImportOptions{Mode: db.ImportReplace})
+	if err == nil {
+		t.Fatal("expected the import to fail")
+	}
+
+	expectSameUsers(t, before, export(t, target))
+}
+
+func TestReadExportVersion1(t *testing.T) {
+	exported, err := db.ReadExport(strings.NewReader(`{
+		"version": 1,
+		"users": [{
+			"id": "9b2ef5a4-7f4e-4c4b-8d4e-2f0b1c6a1e3d",
+			"name": "alice",
+			"pushover_device_tokens": {"phone": "alice-key", "family": "family-key"},
+			"pushover_devices": {
+				"family": {"restrict": ["tablet"], "group": true, "validated_at": "2021-01-02T03:04:05Z"},
+				"watch": {"disabled": true}
+			}
+		}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exported.Version != db.ExportVersion || len(exported.Users) != 1 {
+		t.Fatalf("expected an export of version %d with one user, got %+v", db.ExportVersion, exported)
+	}
+
+	devices := exported.Users[0].PushoverDevices
+	if len(devices) != 2 {
+		t.Fatalf("expected the details of a device without a key to be dropped, got %v", devices)
+	}
+
+	if phone := devices["phone"]; phone.Key != "alice-key" || phone.Kind != db.PushoverKeyUser || !phone.ValidatedAt.IsZero() {
+		t.Fatalf("expected phone to be an unvalidated user key, got %+v", phone)
+	}
+
+	family := devices["family"]
+	if family.Key != "family-key" || family.Kind != db.PushoverKeyGroup || !reflect.DeepEqual(family.Restrict, []string{"tablet"}) || family.ValidatedAt.IsZero() {
+		t.Fatalf("expected family to keep its details as a group key, got %+v", family)
+	}
+
+	_, err = db.ReadExport(strings.NewReader(`{"version": 2, "users": [{"name": "alice", "pushover_device_tokens": {}}]}`))
+	if err == nil {
+		t.Fatal("expected the legacy fields to be unknown in an export of the current version")
+	}
+}
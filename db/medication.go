@@ -1,6 +1,7 @@
 package db
 
 import (
+	"encoding/binary"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,13 +15,65 @@ type Medication struct {
 	IntervalCrontab         string    `json:"interval_crontab"`
 	IntervalQuantity        uint      `json:"interval_quantity"`
 	IntervalPushoverDevices []string  `json:"interval_pushover_devices"`
+	Critical                bool      `json:"critical,omitempty"`
 	CreatedAt               time.Time `json:"created_at"`
+	UpdatedAt               time.Time `json:"updated_at"`
+	Pause
 }
 
+// MedicationVersion is a prior version of a medication kept when it is edited
+type MedicationVersion struct {
+	Medication
+	ReplacedAt time.Time `json:"replaced_at"`
+	ReplacedBy string    `json:"replaced_by"`
+}
+
+// RemindsDevice reports whether the medication reminds the named device
+func (m *Medication) RemindsDevice(device string) bool {
+	for _, intervalDevice := range m.IntervalPushoverDevices {
+		if intervalDevice == device {
+			return true
+		}
+	}
+
+	return false
+}
+
+const (
+	badgerPrefixKeyMedication        = "medication:"
+	badgerPrefixKeyMedicationUser    = "medication_user:"
+	badgerPrefixKeyMedicationHistory = "medication_history:"
+)
+
 func (m *Medication) badgerKey() []byte {
-	return append(append([]byte("medication:"), m.IDUser[:]...), m.ID[:]...)
+	return append(append([]byte(badgerPrefixKeyMedication), m.IDUser[:]...), m.ID[:]...)
 }
 
 func badgerPrefixKeyForMedicationUser(user *User) []byte {
-	return append([]byte("medication:"), user.ID[:]...)
+	return append([]byte(badgerPrefixKeyMedication), user.ID[:]...)
+}
+
+func badgerKeyForMedication(user *User, id uuid.UUID) []byte {
+	return append(badgerPrefixKeyForMedicationUser(user), id[:]...)
+}
+
+func (v *MedicationVersion) badgerKey() []byte {
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(v.ReplacedAt.UnixNano()))
+
+	return append(badgerPrefixKeyForMedicationHistory(v.IDUser, v.ID), timestamp...)
+}
+
+func badgerPrefixKeyForMedicationHistoryUser(user *User) []byte {
+	return append([]byte(badgerPrefixKeyMedicationHistory), user.ID[:]...)
+}
+
+func badgerPrefixKeyForMedicationHistory(idUser, id uuid.UUID) []byte {
+	return append(append([]byte(badgerPrefixKeyMedicationHistory), idUser[:]...), id[:]...)
+}
+
+// badgerKeyForMedicationUser of the index from a medication ID to the ID of
+// the user it belongs to
+func badgerKeyForMedicationUser(id uuid.UUID) []byte {
+	return append([]byte(badgerPrefixKeyMedicationUser), id[:]...)
 }
@@ -0,0 +1,791 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var _ Store = (*Memory)(nil)
+
+// Memory store that keeps everything in memory until it is closed, for tests
+// and ephemeral demos
+type Memory struct {
+	*memory
+	// actor the changes are audited as made by, none are when empty
+	actor string
+}
+
+// memory shared by a Memory store and its audited copies
+type memory struct {
+	lock        sync.RWMutex
+	users       map[uuid.UUID]*User
+	usernames   map[string]uuid.UUID
+	medications map[uuid.UUID]*Medication
+	history     map[uuid.UUID][]*MedicationVersion
+	doses       map[uuid.UUID]*Dose
+	audit       []*AuditEntry
+	queue       map[uuid.UUID]*Notification
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		memory: &memory{
+			users:       make(map[uuid.UUID]*User),
+			usernames:   make(map[string]uuid.UUID),
+			medications: make(map[uuid.UUID]*Medication),
+			history:     make(map[uuid.UUID][]*MedicationVersion),
+			doses:       make(map[uuid.UUID]*Dose),
+			queue:       make(map[uuid.UUID]*Notification),
+		},
+	}
+}
+
+// clone src into dst through JSON so that stored values never share memory
+// with the caller's and round trip the same way they do in the other stores
+func clone(src, dst interface{}) {
+	data, err := json.Marshal(src)
+	if err != nil {
+		panic(fmt.Sprintf("failed to JSON marshal %T: %s", src, err))
+	}
+
+	err = json.Unmarshal(data, dst)
+	if err != nil {
+		panic(fmt.Sprintf("failed to JSON unmarshal %T: %s", dst, err))
+	}
+}
+
+func cloneUser(user *User) *User {
+	c := &User{}
+	clone(user, c)
+
+	return c
+}
+
+func cloneMedication(medication *Medication) *Medication {
+	c := &Medication{}
+	clone(medication, c)
+
+	return c
+}
+
+func cloneDose(dose *Dose) *Dose {
+	c := &Dose{}
+	clone(dose, c)
+
+	return c
+}
+
+func cloneNotification(notification *Notification) *Notification {
+	c := &Notification{}
+	clone(notification, c)
+
+	return c
+}
+
+// withActor copy of the store auditing the changes made through it
+func (m *Memory) withActor(actor string) Store {
+	return &Memory{
+		memory: m.memory,
+		actor:  actor,
+	}
+}
+
+// auditLog for a change made while holding the lock, nil when the store isn't
+// audited
+func (m *Memory) auditLog() *auditLog {
+	return newAuditLog(m.actor, func(id uuid.UUID) (*User, error) {
+		user, ok := m.users[id]
+		if !ok {
+			return nil, fmt.Errorf("failed to get user value for id user %s: %w", id.String(), ErrNotFound)
+		}
+
+		return user, nil
+	})
+}
+
+// addAuditEntries recorded by audit while holding the lock
+func (m *Memory) addAuditEntries(audit *auditLog) {
+	_ = audit.write(func(entry *AuditEntry) error {
+		m.audit = append(m.audit, entry)
+		return nil
+	})
+}
+
+// Ping always succeeds
+func (m *Memory) Ping() error {
+	return nil
+}
+
+// Close drops everything in the store
+func (m *Memory) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.users = make(map[uuid.UUID]*User)
+	m.usernames = make(map[string]uuid.UUID)
+	m.medications = make(map[uuid.UUID]*Medication)
+	m.history = make(map[uuid.UUID][]*MedicationVersion)
+	m.doses = make(map[uuid.UUID]*Dose)
+	m.audit = nil
+	m.queue = make(map[uuid.UUID]*Notification)
+
+	return nil
+}
+
+// AddUser to the store
+func (m *Memory) AddUser(user *User) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.usernames[normalizeUsername(user.Name)]; ok {
+		return fmt.Errorf("user %s %w", user.Name, ErrAlreadyExists)
+	}
+
+	if _, ok := m.users[user.ID]; ok {
+		return fmt.Errorf("id user %s %w", user.ID.String(), ErrAlreadyExists)
+	}
+
+	audit := m.auditLog()
+	err := audit.user(AuditCreate, nil, user)
+	if err != nil {
+		return err
+	}
+
+	m.usernames[normalizeUsername(user.Name)] = user.ID
+	m.users[user.ID] = cloneUser(user)
+	m.addAuditEntries(audit)
+
+	return nil
+}
+
+// GetUser from the store by its case-insensitive username
+func (m *Memory) GetUser(username string) (*User, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	id, ok := m.usernames[normalizeUsername(username)]
+	if !ok {
+		return nil, fmt.Errorf("failed to get user id for username %s: %w", username, ErrNotFound)
+	}
+
+	return cloneUser(m.users[id]), nil
+}
+
+// GetUserByID from the store
+func (m *Memory) GetUserByID(id uuid.UUID) (*User, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("failed to get user value for id user %s: %w", id.String(), ErrNotFound)
+	}
+
+	return cloneUser(user), nil
+}
+
+// ListUsers from the store
+func (m *Memory) ListUsers() ([]*User, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var users []*User
+	for _, user := range m.users {
+		users = append(users, cloneUser(user))
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return bytes.Compare(users[i].ID[:], users[j].ID[:]) < 0
+	})
+
+	return users, nil
+}
+
+// RemoveUser from the store, refusing with ErrUserHasMedications when the
+// user still has medications unless cascade is set, in which case the
+// medications and doses of the user are removed as well
+func (m *Memory) RemoveUser(user *User, cascade bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	existing, ok := m.users[user.ID]
+	if !ok {
+		return fmt.Errorf("failed to get user value for id user %s: %w", user.ID.String(), ErrNotFound)
+	}
+
+	medications := m.listMedicationsForUser(existing)
+	if len(medications) > 0 && !cascade {
+		return fmt.Errorf("user %s has %d medications: %w", existing.Name, len(medications), ErrUserHasMedications)
+	}
+
+	audit := m.auditLog()
+	for _, medication := range medications {
+		err := audit.record(AuditDelete, AuditRecordMedication, medication.ID.String(), existing.ID, existing.Name, medication, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := audit.user(AuditDelete, existing, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, medication := range medications {
+		delete(m.medications, medication.ID)
+		delete(m.history, medication.ID)
+	}
+
+	for id, dose := range m.doses {
+		if dose.IDUser == existing.ID {
+			delete(m.doses, id)
+		}
+	}
+
+	for id, notification := range m.queue {
+		if notification.IDUser == existing.ID {
+			delete(m.queue, id)
+		}
+	}
+
+	delete(m.usernames, normalizeUsername(existing.Name))
+	delete(m.users, existing.ID)
+	m.addAuditEntries(audit)
+
+	return nil
+}
+
+// RenameUser in the store
+func (m *Memory) RenameUser(user *User, newName string) error {
+	return m.updateUser(user, func(user *User) error {
+		if id, ok := m.usernames[normalizeUsername(newName)]; ok && id != user.ID {
+			return fmt.Errorf("user %s %w", newName, ErrAlreadyExists)
+		}
+
+		delete(m.usernames, normalizeUsername(user.Name))
+		user.Name = newName
+		m.usernames[normalizeUsername(user.Name)] = user.ID
+
+		return nil
+	})
+}
+
+// AddUserDevice to the pushover devices of a user
+func (m *Memory) AddUserDevice(user *User, name string, device *PushoverDevice) error {
+	return m.updateUserAudited(user, func(audit *auditLog, user *User) error {
+		err := addUserDevice(user, name, device)
+		if err != nil {
+			return err
+		}
+
+		return audit.device(AuditCreate, user, "", name)
+	})
+}
+
+// UpdateUserDevice of a user
+func (m *Memory) UpdateUserDevice(user *User, name string, device *PushoverDevice) error {
+	return m.updateUser(user, func(user *User) error {
+		return updateUserDevice(user, name, device)
+	})
+}
+
+// RenameUserDevice of a user along with every medication reminding it
+func (m *Memory) RenameUserDevice(user *User, device, newDevice string) error {
+	return m.updateUserAudited(user, func(audit *auditLog, user *User) error {
+		err := audit.device(AuditUpdate, user, device, newDevice)
+		if err != nil {
+			return err
+		}
+
+		medications := m.listMedicationsForUser(user)
+		medications, err = audit.updateMedications(medications, func() ([]*Medication, error) {
+			return renameUserDevice(user, medications, device, newDevice)
+		})
+		if err != nil {
+			return err
+		}
+
+		m.setMedications(medications)
+
+		return nil
+	})
+}
+
+// RemoveUserDevice of a user and from every medication reminding it, refusing
+// with ErrDeviceInUse when a medication would be left without any device
+func (m *Memory) RemoveUserDevice(user *User, device string) error {
+	return m.updateUserAudited(user, func(audit *auditLog, user *User) error {
+		err := audit.device(AuditDelete, user, device, "")
+		if err != nil {
+			return err
+		}
+
+		medications := m.listMedicationsForUser(user)
+		medications, err = audit.updateMedications(medications, func() ([]*Medication, error) {
+			return removeUserDevice(user, medications, device)
+		})
+		if err != nil {
+			return err
+		}
+
+		m.setMedications(medications)
+
+		return nil
+	})
+}
+
+// PauseUser reminders for all of the user's medications until resumeAt, or
+// until resumed when resumeAt is nil
+func (m *Memory) PauseUser(user *User, resumeAt *time.Time) error {
+	return m.updateUser(user, func(user *User) error {
+		user.Pause = Pause{
+			Paused:   true,
+			ResumeAt: resumeAt,
+		}
+
+		return nil
+	})
+}
+
+// ResumeUser reminders for all of the user's medications
+func (m *Memory) ResumeUser(user *User) error {
+	return m.updateUser(user, func(user *User) error {
+		user.Pause = Pause{}
+
+		return nil
+	})
+}
+
+// updateUser applies update to a copy of the stored user and saves it,
+// recording the change and copying the result back into user, unless update
+// fails
+func (m *Memory) updateUser(user *User, update func(user *User) error) error {
+	return m.updateUserAudited(user, func(audit *auditLog, user *User) error {
+		return audit.updateUser(user, func() error {
+			return update(user)
+		})
+	})
+}
+
+// updateUserAudited is updateUser leaving recording the change to update
+func (m *Memory) updateUserAudited(user *User, update func(audit *auditLog, user *User) error) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	existing, ok := m.users[user.ID]
+	if !ok {
+		return fmt.Errorf("failed to get user value for id user %s: %w", user.ID.String(), ErrNotFound)
+	}
+
+	existing = cloneUser(existing)
+
+	audit := m.auditLog()
+	err := update(audit, existing)
+	if err != nil {
+		return err
+	}
+
+	m.users[existing.ID] = existing
+	m.addAuditEntries(audit)
+	*user = *cloneUser(existing)
+
+	return nil
+}
+
+// AddMedication to the store
+func (m *Memory) AddMedication(medication *Medication) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.medications[medication.ID]; ok {
+		return fmt.Errorf("medication %s %w", medication.ID.String(), ErrAlreadyExists)
+	}
+
+	audit := m.auditLog()
+	err := audit.medication(AuditCreate, nil, medication)
+	if err != nil {
+		return err
+	}
+
+	m.medications[medication.ID] = cloneMedication(medication)
+	m.addAuditEntries(audit)
+
+	return nil
+}
+
+// GetMedication from the store by its ID alone
+func (m *Memory) GetMedication(id uuid.UUID) (*Medication, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	medication, ok := m.medications[id]
+	if !ok {
+		return nil, fmt.Errorf("failed to get id user for medication %s: %w", id.String(), ErrNotFound)
+	}
+
+	return cloneMedication(medication), nil
+}
+
+// GetMedicationForUser from the store
+func (m *Memory) GetMedicationForUser(user *User, id uuid.UUID) (*Medication, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	medication, ok := m.medications[id]
+	if !ok || medication.IDUser != user.ID {
+		return nil, fmt.Errorf("failed to get medication value for medication %s: %w", id.String(), ErrNotFound)
+	}
+
+	return cloneMedication(medication), nil
+}
+
+// ListMedicationsForUser from the store
+func (m *Memory) ListMedicationsForUser(user *User) ([]*Medication, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.listMedicationsForUser(user), nil
+}
+
+func (m *Memory) listMedicationsForUser(user *User) []*Medication {
+	var medications []*Medication
+	for _, medication := range m.medications {
+		if medication.IDUser == user.ID {
+			medications = append(medications, cloneMedication(medication))
+		}
+	}
+
+	sort.Slice(medications, func(i, j int) bool {
+		return bytes.Compare(medications[i].ID[:], medications[j].ID[:]) < 0
+	})
+
+	return medications
+}
+
+func (m *Memory) setMedications(medications []*Medication) {
+	for _, medication := range medications {
+		m.medications[medication.ID] = cloneMedication(medication)
+	}
+}
+
+// UpdateMedication that already exists in the store, keeping the version it
+// replaces in the medication's history attributed to author
+func (m *Memory) UpdateMedication(medication *Medication, author string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	existing, ok := m.medications[medication.ID]
+	if !ok || existing.IDUser != medication.IDUser {
+		return fmt.Errorf("failed to get medication value for medication %s: %w", medication.ID.String(), ErrNotFound)
+	}
+
+	now := time.Now()
+
+	medication.CreatedAt = existing.CreatedAt
+	medication.UpdatedAt = now
+
+	audit := m.auditLog()
+	err := audit.medication(AuditUpdate, existing, medication)
+	if err != nil {
+		return err
+	}
+
+	m.history[medication.ID] = append(m.history[medication.ID], &MedicationVersion{
+		Medication: *cloneMedication(existing),
+		ReplacedAt: now,
+		ReplacedBy: author,
+	})
+
+	m.medications[medication.ID] = cloneMedication(medication)
+	m.addAuditEntries(audit)
+
+	return nil
+}
+
+// PauseMedication reminders until resumeAt, or until resumed when resumeAt is nil
+func (m *Memory) PauseMedication(medication *Medication, resumeAt *time.Time) error {
+	return m.updateMedication(medication, func(medication *Medication) {
+		medication.Pause = Pause{
+			Paused:   true,
+			ResumeAt: resumeAt,
+		}
+	})
+}
+
+// ResumeMedication reminders
+func (m *Memory) ResumeMedication(medication *Medication) error {
+	return m.updateMedication(medication, func(medication *Medication) {
+		medication.Pause = Pause{}
+	})
+}
+
+func (m *Memory) updateMedication(medication *Medication, update func(medication *Medication)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	existing, ok := m.medications[medication.ID]
+	if !ok || existing.IDUser != medication.IDUser {
+		return fmt.Errorf("failed to get medication value for medication %s: %w", medication.ID.String(), ErrNotFound)
+	}
+
+	existing = cloneMedication(existing)
+
+	audit := m.auditLog()
+	err := audit.updateMedication(existing, func() error {
+		update(existing)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.medications[existing.ID] = existing
+	m.addAuditEntries(audit)
+	*medication = *cloneMedication(existing)
+
+	return nil
+}
+
+// AddMedicationVersion to the history of a medication as it is
+func (m *Memory) AddMedicationVersion(version *MedicationVersion) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	audit := m.auditLog()
+	err := audit.medicationVersion(version)
+	if err != nil {
+		return err
+	}
+
+	c := &MedicationVersion{}
+	clone(version, c)
+
+	versions := append(m.history[version.ID], c)
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].ReplacedAt.Before(versions[j].ReplacedAt)
+	})
+
+	m.history[version.ID] = versions
+	m.addAuditEntries(audit)
+
+	return nil
+}
+
+// ListMedicationHistory of prior versions of a medication, oldest first
+func (m *Memory) ListMedicationHistory(medication *Medication) ([]*MedicationVersion, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var versions []*MedicationVersion
+	for _, version := range m.history[medication.ID] {
+		if version.IDUser != medication.IDUser {
+			continue
+		}
+
+		c := &MedicationVersion{}
+		clone(version, c)
+		versions = append(versions, c)
+	}
+
+	return versions, nil
+}
+
+// RemoveMedication and its history from the store
+func (m *Memory) RemoveMedication(medication *Medication) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	existing, ok := m.medications[medication.ID]
+	if !ok || existing.IDUser != medication.IDUser {
+		return fmt.Errorf("failed to get medication value for medication %s: %w", medication.ID.String(), ErrNotFound)
+	}
+
+	audit := m.auditLog()
+	err := audit.medication(AuditDelete, existing, nil)
+	if err != nil {
+		return err
+	}
+
+	delete(m.history, medication.ID)
+	delete(m.medications, medication.ID)
+	m.addAuditEntries(audit)
+
+	return nil
+}
+
+// AddDose to the store
+func (m *Memory) AddDose(dose *Dose) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	audit := m.auditLog()
+	err := audit.dose(AuditCreate, nil, dose)
+	if err != nil {
+		return err
+	}
+
+	m.doses[dose.ID] = cloneDose(dose)
+	m.addAuditEntries(audit)
+
+	return nil
+}
+
+// UpdateDose that already exists in the store
+func (m *Memory) UpdateDose(dose *Dose) error {
+	return m.updateDose(dose, false)
+}
+
+// UpdatePendingDose that is still pending in the store
+func (m *Memory) UpdatePendingDose(dose *Dose) error {
+	return m.updateDose(dose, true)
+}
+
+func (m *Memory) updateDose(dose *Dose, pendingOnly bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	existing, ok := m.doses[dose.ID]
+	if !ok || existing.IDUser != dose.IDUser || !existing.ScheduledAt.Equal(dose.ScheduledAt) {
+		return fmt.Errorf("failed to get dose %s: %w", dose.ID.String(), ErrNotFound)
+	}
+
+	err := checkPending(existing, pendingOnly)
+	if err != nil {
+		return err
+	}
+
+	audit := m.auditLog()
+	err = audit.dose(AuditUpdate, existing, dose)
+	if err != nil {
+		return err
+	}
+
+	m.doses[dose.ID] = cloneDose(dose)
+	m.addAuditEntries(audit)
+
+	return nil
+}
+
+// ListDosesForUser from the store that were scheduled at or after since
+func (m *Memory) ListDosesForUser(user *User, since time.Time) ([]*Dose, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var doses []*Dose
+	for _, dose := range m.doses {
+		if dose.IDUser == user.ID && !dose.ScheduledAt.Before(since) {
+			doses = append(doses, cloneDose(dose))
+		}
+	}
+
+	sort.Slice(doses, func(i, j int) bool {
+		if doses[i].ScheduledAt.Equal(doses[j].ScheduledAt) {
+			return bytes.Compare(doses[i].ID[:], doses[j].ID[:]) < 0
+		}
+
+		return doses[i].ScheduledAt.Before(doses[j].ScheduledAt)
+	})
+
+	return doses, nil
+}
+
+// AddAuditEntry to the store
+func (m *Memory) AddAuditEntry(entry *AuditEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	c := &AuditEntry{}
+	clone(entry, c)
+
+	m.audit = append(m.audit, c)
+
+	return nil
+}
+
+// ListAuditEntries from the store matching filter, oldest first
+func (m *Memory) ListAuditEntries(filter AuditFilter) ([]*AuditEntry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var entries []*AuditEntry
+	for _, entry := range m.audit {
+		if filter.matches(entry) {
+			c := &AuditEntry{}
+			clone(entry, c)
+
+			entries = append(entries, c)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return entries, nil
+}
+
+// AddNotification to the outbound queue in the store
+func (m *Memory) AddNotification(notification *Notification) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.queue[notification.ID] = cloneNotification(notification)
+
+	return nil
+}
+
+// UpdateNotification that is still in the outbound queue in the store
+func (m *Memory) UpdateNotification(notification *Notification) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.queue[notification.ID]; !ok {
+		return fmt.Errorf("failed to get notification %s: %w", notification.ID.String(), ErrNotFound)
+	}
+
+	m.queue[notification.ID] = cloneNotification(notification)
+
+	return nil
+}
+
+// ListNotifications in the outbound queue in the store, oldest first
+func (m *Memory) ListNotifications() ([]*Notification, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var notifications []*Notification
+	for _, notification := range m.queue {
+		notifications = append(notifications, cloneNotification(notification))
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		if notifications[i].CreatedAt.Equal(notifications[j].CreatedAt) {
+			return bytes.Compare(notifications[i].ID[:], notifications[j].ID[:]) < 0
+		}
+
+		return notifications[i].CreatedAt.Before(notifications[j].CreatedAt)
+	})
+
+	return notifications, nil
+}
+
+// RemoveNotification from the outbound queue in the store
+func (m *Memory) RemoveNotification(notification *Notification) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.queue[notification.ID]; !ok {
+		return fmt.Errorf("failed to get notification %s: %w", notification.ID.String(), ErrNotFound)
+	}
+
+	delete(m.queue, notification.ID)
+
+	return nil
+}
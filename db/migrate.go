@@ -0,0 +1,163 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+)
+
+// migrateKeyLayout moves users keyed by username to being keyed by ID with a
+// username index and indexes every medication by its ID. It does nothing for
+// a database that is already migrated.
+func migrateKeyLayout(tx *badger.Txn) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = badgerLegacyPrefixKeyUser
+
+	it := tx.NewIterator(opts)
+
+	// users are moved as they are, only their ID and name are needed to key
+	// them and later migrations take care of the rest
+	var users []*User
+	var values, legacyKeys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			it.Close()
+			return err
+		}
+
+		user := &User{}
+		err = json.Unmarshal(val, user)
+		if err != nil {
+			it.Close()
+			return fmt.Errorf("failed to unmarshal user value for legacy user key %s: %w", string(item.Key()), err)
+		}
+
+		users = append(users, user)
+		values = append(values, val)
+		legacyKeys = append(legacyKeys, item.KeyCopy(nil))
+	}
+
+	it.Close()
+
+	for i, user := range users {
+		_, err := tx.Get(badgerKeyForUsername(user.Name))
+		if err == nil {
+			return fmt.Errorf("username %s conflicts with another user when compared case-insensitively, rename one of them with the previous version first", user.Name)
+		}
+
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		err = tx.Set(badgerKeyForUsername(user.Name), user.ID[:])
+		if err != nil {
+			return err
+		}
+
+		err = tx.Set(user.badgerKey(), values[i])
+		if err != nil {
+			return err
+		}
+
+		err = tx.Delete(legacyKeys[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	opts = badger.DefaultIteratorOptions
+	opts.Prefix = []byte(badgerPrefixKeyMedication)
+	opts.PrefetchValues = false
+
+	it = tx.NewIterator(opts)
+
+	var medicationKeys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		medicationKeys = append(medicationKeys, it.Item().KeyCopy(nil))
+	}
+
+	it.Close()
+
+	for _, key := range medicationKeys {
+		ids := key[len(opts.Prefix):]
+		if len(ids) != 32 {
+			return fmt.Errorf("invalid medication key %s", string(key))
+		}
+
+		idUser, err := uuid.FromBytes(ids[:16])
+		if err != nil {
+			return err
+		}
+
+		id, err := uuid.FromBytes(ids[16:])
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Get(badgerKeyForMedicationUser(id))
+		if err == nil {
+			continue
+		}
+
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		err = tx.Set(badgerKeyForMedicationUser(id), idUser[:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateUserDevices keeps each device of every user as a single entry of its
+// pushover key along with its restrictions and validation, instead of the
+// keys and the details of the devices in separate maps. It does nothing for
+// users that are already migrated.
+func migrateUserDevices(tx *badger.Txn) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(badgerPrefixKeyUserID)
+
+	it := tx.NewIterator(opts)
+
+	var keys, values [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			it.Close()
+			return err
+		}
+
+		upgraded, ok, err := upgradeUserDevicesJSON(val)
+		if err != nil {
+			it.Close()
+			return fmt.Errorf("failed to migrate the devices of user key %s: %w", printableKey(item.Key()), err)
+		}
+
+		if ok {
+			keys = append(keys, item.KeyCopy(nil))
+			values = append(values, upgraded)
+		}
+	}
+
+	it.Close()
+
+	for i, key := range keys {
+		err := tx.Set(key, values[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
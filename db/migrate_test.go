@@ -0,0 +1,268 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/uuid"
+)
+
+// newLegacyBadger with the users keyed by their username and the
+// medications without an ID index, as before schema versioning
+func newLegacyBadger(t *testing.T, users []*User, medications []*Medication) *Badger {
+	t.Helper()
+
+	b, err := NewUnmigratedBadger(t.TempDir(), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		b.Close()
+	})
+
+	err = b.db.Update(func(tx *badger.Txn) error {
+		for _, user := range users {
+			data, err := json.Marshal(user)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Set(append(append([]byte{}, badgerLegacyPrefixKeyUser...), user.Name...), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		return setMedications(tx, medications)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestMigrateKeyLayout(t *testing.T) {
+	alice := &User{ID: uuid.New(), Name: "Alice", PushoverDevices: map[string]*PushoverDevice{"phone": {Key: "token", Kind: PushoverKeyUser}}}
+	bob := &User{ID: uuid.New(), Name: "bob"}
+	medication := &Medication{IDUser: alice.ID, ID: uuid.New(), Name: "aspirin", IntervalCrontab: "0 8 * * *", IntervalQuantity: 1}
+
+	b := newLegacyBadger(t, []*User{alice, bob}, []*Medication{medication})
+
+	applied, err := b.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != int(SchemaVersion()) || applied[0].Version != 1 {
+		t.Fatalf("expected all migrations to be applied, got %+v", applied)
+	}
+
+	user, err := b.GetUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.ID != alice.ID || user.Name != "Alice" || user.DeviceKey("phone") != "token" {
+		t.Fatalf("expected the migrated user %+v, got %+v", alice, user)
+	}
+
+	users, err := b.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 2 {
+		t.Fatalf("expected 2 migrated users, got %d", len(users))
+	}
+
+	migrated, err := b.GetMedication(medication.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated.IDUser != alice.ID {
+		t.Fatalf("expected the medication to be indexed to its user, got %s", migrated.IDUser)
+	}
+
+	err = b.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = badgerLegacyPrefixKeyUser
+
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		if it.Rewind(); it.Valid() {
+			return fmt.Errorf("legacy user key %s was kept", string(it.Item().Key()))
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// migrating again does nothing
+	applied, err = b.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 0 {
+		t.Fatalf("expected no migrations to be pending, got %+v", applied)
+	}
+}
+
+func TestMigrateKeyLayoutCaseConflict(t *testing.T) {
+	b := newLegacyBadger(t, []*User{
+		{ID: uuid.New(), Name: "Alice"},
+		{ID: uuid.New(), Name: "alice"},
+	}, nil)
+
+	_, err := b.Migrate(false)
+	if err == nil || !strings.Contains(err.Error(), "case-insensitively") {
+		t.Fatalf("expected usernames differing only in case to fail the migration, got %v", err)
+	}
+
+	version, err := b.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != 0 {
+		t.Fatalf("expected the failed migration to leave schema version 0, got %d", version)
+	}
+
+	err = b.db.View(func(tx *badger.Txn) error {
+		for _, name := range []string{"Alice", "alice"} {
+			_, err := tx.Get(append(append([]byte{}, badgerLegacyPrefixKeyUser...), name...))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected the legacy users to be kept after the failed migration: %v", err)
+	}
+}
+
+// legacyUserJSON of alice with a user key on her phone and a group key on a
+// disabled family device restricted to the tablet, and the details of a
+// watch without a key, as kept before the devices were single entries
+func legacyUserJSON(id uuid.UUID) []byte {
+	return []byte(`{
+		"id": "` + id.String() + `",
+		"name": "alice",
+		"pushover_device_tokens": {"phone": "alice-key", "family": "family-key"},
+		"pushover_devices": {
+			"family": {"restrict": ["tablet"], "group": true, "disabled": true, "disabled_reason": "invalid"},
+			"watch": {"restrict": ["watch"]}
+		}
+	}`)
+}
+
+// expectUpgradedDevices of the alice of legacyUserJSON
+func expectUpgradedDevices(t *testing.T, user *User) {
+	t.Helper()
+
+	if len(user.PushoverDevices) != 2 {
+		t.Fatalf("expected the details of a device without a key to be dropped, got %v", user.PushoverDevices)
+	}
+
+	if phone := user.PushoverDevices["phone"]; phone.Key != "alice-key" || phone.Kind != PushoverKeyUser || phone.Restrict != nil {
+		t.Fatalf("expected phone to be an unrestricted user key, got %+v", phone)
+	}
+
+	family := user.PushoverDevices["family"]
+	if family.Key != "family-key" || family.Kind != PushoverKeyGroup || !family.Disabled || user.DeviceRestrictions("family")[0] != "tablet" {
+		t.Fatalf("expected family to keep its details as a group key, got %+v", family)
+	}
+}
+
+func TestMigrateUserDevices(t *testing.T) {
+	b, err := NewUnmigratedBadger(t.TempDir(), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	alice := &User{ID: uuid.New(), Name: "alice"}
+
+	err = b.db.Update(func(tx *badger.Txn) error {
+		if err := setSchemaVersion(tx, 1); err != nil {
+			return err
+		}
+
+		return tx.Set(alice.badgerKey(), legacyUserJSON(alice.ID))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := b.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 1 || applied[0].Version != 2 {
+		t.Fatalf("expected the device migration to be applied, got %+v", applied)
+	}
+
+	user, err := b.GetUserByID(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectUpgradedDevices(t, user)
+}
+
+func TestSQLiteMigrateUserDevices(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "meditime.db")
+
+	s, err := NewSQLite(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &User{ID: uuid.New(), Name: "alice"}
+
+	// back to the schema from before the devices were single entries
+	err = s.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO users (id, name_key, data) VALUES (?, ?, ?)", alice.ID.String(), normalizeUsername(alice.Name), legacyUserJSON(alice.ID))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("PRAGMA user_version = 3")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+
+	s, err = NewSQLite(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	user, err := s.GetUserByID(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectUpgradedDevices(t, user)
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NotificationStatus of a queued notification
+type NotificationStatus string
+
+const (
+	// NotificationStatusPending is a notification waiting to be delivered,
+	// possibly after failed attempts
+	NotificationStatusPending NotificationStatus = "pending"
+	// NotificationStatusDead is a notification that was given up on, it is
+	// kept until it is retried or purged
+	NotificationStatusDead NotificationStatus = "dead"
+)
+
+// Notification in the outbound queue, removed once it is delivered
+type Notification struct {
+	ID uuid.UUID `json:"id"`
+	// IDUser, IDDose and Device of the reminder the notification is for, the
+	// IDs are nil for alerts to the admin device
+	IDUser   uuid.UUID     `json:"id_user"`
+	IDDose   uuid.UUID     `json:"id_dose"`
+	Device   string        `json:"device,omitempty"`
+	Title    string        `json:"title,omitempty"`
+	Message  string        `json:"message"`
+	Priority int           `json:"priority"`
+	Sound    string        `json:"sound,omitempty"`
+	Retry    time.Duration `json:"retry,omitempty"`
+	Expire   time.Duration `json:"expire,omitempty"`
+	// Critical notifications are not subject to the quota policy
+	Critical bool `json:"critical,omitempty"`
+
+	Status        NotificationStatus `json:"status"`
+	Attempts      int                `json:"attempts"`
+	LastError     string             `json:"last_error,omitempty"`
+	CreatedAt     time.Time          `json:"created_at"`
+	NextAttemptAt time.Time          `json:"next_attempt_at"`
+}
+
+// IsAlert is true for notifications to the admin device rather than a user
+func (n *Notification) IsAlert() bool {
+	return n.IDUser == uuid.Nil
+}
+
+const badgerPrefixKeyNotification = "notification:"
+
+func (n *Notification) badgerKey() []byte {
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(n.CreatedAt.UnixNano()))
+
+	key := append([]byte(badgerPrefixKeyNotification), timestamp...)
+
+	return append(key, n.ID[:]...)
+}
@@ -0,0 +1,16 @@
+package db
+
+import (
+	"time"
+)
+
+// Pause of reminders that optionally resumes on its own
+type Pause struct {
+	Paused   bool       `json:"paused"`
+	ResumeAt *time.Time `json:"resume_at,omitempty"`
+}
+
+// IsPaused at the given time, taking the automatic resume into account
+func (p Pause) IsPaused(now time.Time) bool {
+	return p.Paused && (p.ResumeAt == nil || now.Before(*p.ResumeAt))
+}
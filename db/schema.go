@@ -0,0 +1,143 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ErrSchemaTooNew occurs when opening a database written by a newer version
+// of meditime than this one
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+var badgerKeySchemaVersion = []byte("schema_version")
+
+// Migration of the stored data from the previous schema version to Version
+type Migration struct {
+	Version     uint64 `json:"version"`
+	Description string `json:"description"`
+	migrate     func(tx *badger.Txn) error
+}
+
+// migrations in the order they are applied, a migration's version is its
+// position in the list plus one. Only ever append to this list, and keep
+// every migration idempotent since databases from before versioning start
+// at version 0.
+var migrations = []Migration{
+	{
+		Description: "key users by ID with a case-insensitive username index and index medications by ID",
+		migrate:     migrateKeyLayout,
+	},
+	{
+		Description: "keep the pushover key, restrictions and validation of each device of a user in a single entry",
+		migrate:     migrateUserDevices,
+	},
+}
+
+// SchemaVersion this binary reads and writes
+func SchemaVersion() uint64 {
+	return uint64(len(migrations))
+}
+
+// SchemaVersion of the database
+func (b *Badger) SchemaVersion() (version uint64, err error) {
+	err = b.db.View(func(tx *badger.Txn) error {
+		version, err = getSchemaVersion(tx)
+		return err
+	})
+
+	return
+}
+
+func getSchemaVersion(tx *badger.Txn) (uint64, error) {
+	item, err := tx.Get(badgerKeySchemaVersion)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	var version uint64
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return fmt.Errorf("invalid schema version value of %d bytes", len(val))
+		}
+
+		version = binary.BigEndian.Uint64(val)
+
+		return nil
+	})
+
+	return version, err
+}
+
+func setSchemaVersion(tx *badger.Txn, version uint64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, version)
+
+	return tx.Set(badgerKeySchemaVersion, value)
+}
+
+// Migrate the database to the schema version of this binary, each migration
+// in its own transaction, returning the migrations that were applied. When
+// dryRun is set the pending migrations are all run in a single transaction
+// that is discarded instead, so they are only checked.
+func (b *Badger) Migrate(dryRun bool) ([]Migration, error) {
+	version, err := b.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if version > SchemaVersion() {
+		return nil, fmt.Errorf("database schema version %d, binary schema version %d: %w", version, SchemaVersion(), ErrSchemaTooNew)
+	}
+
+	pending := make([]Migration, 0, SchemaVersion()-version)
+	for i := version; i < SchemaVersion(); i++ {
+		migration := migrations[i]
+		migration.Version = i + 1
+		pending = append(pending, migration)
+	}
+
+	if dryRun {
+		tx := b.db.NewTransaction(true)
+		defer tx.Discard()
+
+		for _, migration := range pending {
+			err = applyMigration(tx, migration)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return pending, nil
+	}
+
+	for i, migration := range pending {
+		err = b.db.Update(func(tx *badger.Txn) error {
+			return applyMigration(tx, migration)
+		})
+		if err != nil {
+			return pending[:i], err
+		}
+	}
+
+	return pending, nil
+}
+
+func applyMigration(tx *badger.Txn, migration Migration) error {
+	err := migration.migrate(tx)
+	if err == nil {
+		err = setSchemaVersion(tx, migration.Version)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to migrate to schema version %d (%s): %w", migration.Version, migration.Description, err)
+	}
+
+	return nil
+}
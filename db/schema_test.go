@@ -0,0 +1,171 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// withMigrations of the test appended to the ones of this binary, each
+// recording that it was applied in applied
+func withMigrations(t *testing.T, applied *[]uint64, count int) {
+	t.Helper()
+
+	previous := migrations
+	t.Cleanup(func() {
+		migrations = previous
+	})
+
+	migrations = append([]Migration{}, previous...)
+	for i := 0; i < count; i++ {
+		version := uint64(len(migrations) + 1)
+		migrations = append(migrations, Migration{
+			Description: "test migration",
+			migrate: func(tx *badger.Txn) error {
+				*applied = append(*applied, version)
+				return tx.Set([]byte("test_migration"), []byte{byte(version)})
+			},
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dbPath := t.TempDir()
+
+	b, err := NewBadger(dbPath, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := SchemaVersion()
+	version, err := b.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != current {
+		t.Fatalf("expected a new database to be at schema version %d, got %d", current, version)
+	}
+
+	var applied []uint64
+	withMigrations(t, &applied, 2)
+
+	pending, err := b.Migrate(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 2 || pending[0].Version != current+1 || pending[1].Version != current+2 {
+		t.Fatalf("expected a dry run to report the 2 pending migrations, got %+v", pending)
+	}
+
+	version, err = b.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != current {
+		t.Fatalf("expected a dry run to leave schema version %d, got %d", current, version)
+	}
+
+	err = b.db.View(func(tx *badger.Txn) error {
+		_, err := tx.Get([]byte("test_migration"))
+		return err
+	})
+	if !errors.Is(err, badger.ErrKeyNotFound) {
+		t.Fatalf("expected a dry run to discard what the migrations wrote, got %v", err)
+	}
+
+	applied = nil
+
+	pending, err = b.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 2 || !reflect.DeepEqual(applied, []uint64{current + 1, current + 2}) {
+		t.Fatalf("expected the 2 migrations to be applied in order, got %+v applied as %v", pending, applied)
+	}
+
+	version, err = b.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != current+2 {
+		t.Fatalf("expected schema version %d, got %d", current+2, version)
+	}
+
+	applied = nil
+
+	pending, err = b.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 0 || len(applied) != 0 {
+		t.Fatalf("expected no migrations to be pending, got %+v", pending)
+	}
+
+	b.Close()
+
+	// the binary without the test migrations is older than the database
+	migrations = migrations[:current]
+
+	_, err = NewBadger(dbPath, time.Hour, nil)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected opening a newer database to fail with %v, got %v", ErrSchemaTooNew, err)
+	}
+
+	b, err = NewUnmigratedBadger(dbPath, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	_, err = b.Migrate(true)
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected a dry run of a newer database to fail with %v, got %v", ErrSchemaTooNew, err)
+	}
+}
+
+func TestMigrateStopsAtFailure(t *testing.T) {
+	b, err := NewBadger(t.TempDir(), time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Close()
+
+	current := SchemaVersion()
+
+	var applied []uint64
+	withMigrations(t, &applied, 2)
+
+	failure := errors.New("failed")
+	migrations[current+1].migrate = func(tx *badger.Txn) error {
+		return failure
+	}
+
+	pending, err := b.Migrate(false)
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the failed migration to fail migrating, got %v", err)
+	}
+
+	if len(pending) != 1 || pending[0].Version != current+1 {
+		t.Fatalf("expected only the migration before the failed one to be applied, got %+v", pending)
+	}
+
+	version, err := b.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != current+1 {
+		t.Fatalf("expected schema version %d, got %d", current+1, version)
+	}
+}
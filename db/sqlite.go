@@ -0,0 +1,1076 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	// registers the sqlite3 database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var _ Store = (*SQLite)(nil)
+
+// sqliteSchemas in the order they are applied, the schema version kept in the
+// database's user_version pragma is the number of schemas applied. Every
+// schema keeps records as the same JSON the badger store uses, with only the
+// columns needed to look records up broken out of it.
+var sqliteSchemas = []string{`
+CREATE TABLE users (
+	id TEXT PRIMARY KEY,
+	name_key TEXT NOT NULL UNIQUE,
+	data TEXT NOT NULL
+);
+
+CREATE TABLE medications (
+	id TEXT PRIMARY KEY,
+	id_user TEXT NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX medications_id_user ON medications (id_user, id);
+
+CREATE TABLE medication_history (
+	id_medication TEXT NOT NULL,
+	id_user TEXT NOT NULL,
+	replaced_at INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX medication_history_id_medication ON medication_history (id_medication, replaced_at);
+
+CREATE TABLE doses (
+	id TEXT PRIMARY KEY,
+	id_user TEXT NOT NULL,
+	scheduled_at INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX doses_id_user ON doses (id_user, scheduled_at, id);
+
+PRAGMA user_version = 1;
+`, `
+CREATE TABLE audit (
+	id TEXT PRIMARY KEY,
+	time INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX audit_time ON audit (time);
+
+PRAGMA user_version = 2;
+`, `
+CREATE TABLE notifications (
+	id TEXT PRIMARY KEY,
+	id_user TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX notifications_created_at ON notifications (created_at, id);
+
+PRAGMA user_version = 3;
+`, `
+PRAGMA user_version = 4;
+`,
+}
+
+// sqliteMigrations of the JSON records by the schema version that runs them
+// once its SQL is applied
+var sqliteMigrations = map[int]func(tx *sql.Tx) error{
+	4: sqliteMigrateUserDevices,
+}
+
+// SQLite db implementation
+type SQLite struct {
+	db *sql.DB
+	// actor the changes are audited as made by, none are when empty
+	actor string
+}
+
+// NewSQLite opens the SQLite database at the given path, creating it when it
+// doesn't exist yet
+func NewSQLite(dbPath string) (*SQLite, error) {
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite db at path %s: %w", dbPath, err)
+	}
+
+	// a single connection serializes the transactions like badger's do
+	db.SetMaxOpenConns(1)
+
+	s := &SQLite{db: db}
+
+	err = s.update(func(tx *sql.Tx) error {
+		var version int
+		err := tx.QueryRow("PRAGMA user_version").Scan(&version)
+		if err != nil {
+			return err
+		}
+
+		if version > len(sqliteSchemas) {
+			return fmt.Errorf("database schema version %d, binary schema version %d: %w", version, len(sqliteSchemas), ErrSchemaTooNew)
+		}
+
+		for i, schema := range sqliteSchemas[version:] {
+			_, err = tx.Exec(schema)
+			if err != nil {
+				return err
+			}
+
+			if migrate, ok := sqliteMigrations[version+i+1]; ok {
+				err = migrate(tx)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sqlite schema at path %s: %w", dbPath, err)
+	}
+
+	return s, nil
+}
+
+// Close the database
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database can serve reads
+func (s *SQLite) Ping() error {
+	err := s.db.Ping()
+	if err != nil {
+		return fmt.Errorf("failed to read from sqlite: %w", err)
+	}
+
+	return nil
+}
+
+// sqliteQuerier is either the database or one of its transactions
+type sqliteQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// update runs fn in a transaction that is committed when fn succeeds
+func (s *SQLite) update(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// withActor copy of the database auditing the changes made through it
+func (s *SQLite) withActor(actor string) Store {
+	return &SQLite{
+		db:    s.db,
+		actor: actor,
+	}
+}
+
+// updateAudited runs fn in a transaction that also adds the audit entries fn
+// records when the database is audited
+func (s *SQLite) updateAudited(fn func(tx *sql.Tx, audit *auditLog) error) error {
+	return s.update(func(tx *sql.Tx) error {
+		audit := newAuditLog(s.actor, func(id uuid.UUID) (*User, error) {
+			return sqliteGetUserByID(tx, id)
+		})
+
+		err := fn(tx, audit)
+		if err != nil {
+			return err
+		}
+
+		return audit.write(func(entry *AuditEntry) error {
+			return sqliteAddAuditEntry(tx, entry)
+		})
+	})
+}
+
+// sqliteMigrateUserDevices is migrateUserDevices of the users in the database
+func sqliteMigrateUserDevices(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, data FROM users")
+	if err != nil {
+		return err
+	}
+
+	upgraded := make(map[string][]byte)
+	for rows.Next() {
+		var id string
+		var data []byte
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		data, ok, err := upgradeUserDevicesJSON(data)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to migrate the devices of user id %s: %w", id, err)
+		}
+
+		if ok {
+			upgraded[id] = data
+		}
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for id, data := range upgraded {
+		_, err = tx.Exec("UPDATE users SET data = ? WHERE id = ?", data, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sqliteNotFound converts the missing row error to ErrNotFound
+func sqliteNotFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// sqliteScan each row of rows with its only column being a JSON record
+func sqliteScan(rows *sql.Rows, each func(data []byte) error) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		var data []byte
+		err := rows.Scan(&data)
+		if err != nil {
+			return err
+		}
+
+		err = each(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// AddUser to the database
+func (s *SQLite) AddUser(user *User) error {
+	return s.updateAudited(func(tx *sql.Tx, audit *auditLog) error {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE name_key = ?", normalizeUsername(user.Name)).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return fmt.Errorf("user %s %w", user.Name, ErrAlreadyExists)
+		}
+
+		err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", user.ID.String()).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return fmt.Errorf("id user %s %w", user.ID.String(), ErrAlreadyExists)
+		}
+
+		err = sqliteSetUser(tx, user)
+		if err != nil {
+			return err
+		}
+
+		return audit.user(AuditCreate, nil, user)
+	})
+}
+
+// GetUser from the database by its case-insensitive username
+func (s *SQLite) GetUser(username string) (*User, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT data FROM users WHERE name_key = ?", normalizeUsername(username)).Scan(&data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user value for username %s: %w", username, sqliteNotFound(err))
+	}
+
+	user := &User{}
+	err = json.Unmarshal(data, user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user value for username %s: %w", username, err)
+	}
+
+	return user, nil
+}
+
+// GetUserByID from the database
+func (s *SQLite) GetUserByID(id uuid.UUID) (*User, error) {
+	return sqliteGetUserByID(s.db, id)
+}
+
+func sqliteGetUserByID(tx sqliteQuerier, id uuid.UUID) (*User, error) {
+	var data []byte
+	err := tx.QueryRow("SELECT data FROM users WHERE id = ?", id.String()).Scan(&data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user value for id user %s: %w", id.String(), sqliteNotFound(err))
+	}
+
+	user := &User{}
+	err = json.Unmarshal(data, user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user value for id user %s: %w", id.String(), err)
+	}
+
+	return user, nil
+}
+
+func sqliteSetUser(tx *sql.Tx, user *User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal user: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO users (id, name_key, data) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET name_key = excluded.name_key, data = excluded.data",
+		user.ID.String(),
+		normalizeUsername(user.Name),
+		data,
+	)
+
+	return err
+}
+
+// ListUsers from the database
+func (s *SQLite) ListUsers() (users []*User, err error) {
+	rows, err := s.db.Query("SELECT data FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	err = sqliteScan(rows, func(data []byte) error {
+		user := &User{}
+		err := json.Unmarshal(data, user)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal user value: %w", err)
+		}
+
+		users = append(users, user)
+
+		return nil
+	})
+
+	return users, err
+}
+
+// RemoveUser from the database, refusing with ErrUserHasMedications when the
+// user still has medications unless cascade is set, in which case the
+// medications and doses of the user are removed as well
+func (s *SQLite) RemoveUser(user *User, cascade bool) error {
+	return s.updateAudited(func(tx *sql.Tx, audit *auditLog) error {
+		user, err := sqliteGetUserByID(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		medications, err := sqliteListMedicationsForUser(tx, user)
+		if err != nil {
+			return err
+		}
+
+		if len(medications) > 0 && !cascade {
+			return fmt.Errorf("user %s has %d medications: %w", user.Name, len(medications), ErrUserHasMedications)
+		}
+
+		for _, medication := range medications {
+			err = audit.record(AuditDelete, AuditRecordMedication, medication.ID.String(), user.ID, user.Name, medication, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, table := range []string{"medications", "medication_history", "doses", "notifications"} {
+			_, err = tx.Exec("DELETE FROM "+table+" WHERE id_user = ?", user.ID.String())
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec("DELETE FROM users WHERE id = ?", user.ID.String())
+		if err != nil {
+			return err
+		}
+
+		return audit.user(AuditDelete, user, nil)
+	})
+}
+
+// RenameUser in the database
+func (s *SQLite) RenameUser(user *User, newName string) error {
+	return s.updateUser(user, func(tx *sql.Tx, user *User) error {
+		var id string
+		err := tx.QueryRow("SELECT id FROM users WHERE name_key = ?", normalizeUsername(newName)).Scan(&id)
+		if err == nil && id != user.ID.String() {
+			return fmt.Errorf("user %s %w", newName, ErrAlreadyExists)
+		}
+
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		user.Name = newName
+
+		return nil
+	})
+}
+
+// AddUserDevice to the pushover devices of a user
+func (s *SQLite) AddUserDevice(user *User, name string, device *PushoverDevice) error {
+	return s.updateUserAudited(user, func(tx *sql.Tx, audit *auditLog, user *User) error {
+		err := addUserDevice(user, name, device)
+		if err != nil {
+			return err
+		}
+
+		return audit.device(AuditCreate, user, "", name)
+	})
+}
+
+// UpdateUserDevice of a user
+func (s *SQLite) UpdateUserDevice(user *User, name string, device *PushoverDevice) error {
+	return s.updateUser(user, func(tx *sql.Tx, user *User) error {
+		return updateUserDevice(user, name, device)
+	})
+}
+
+// RenameUserDevice of a user along with every medication reminding it
+func (s *SQLite) RenameUserDevice(user *User, device, newDevice string) error {
+	return s.updateUserAudited(user, func(tx *sql.Tx, audit *auditLog, user *User) error {
+		medications, err := sqliteListMedicationsForUser(tx, user)
+		if err != nil {
+			return err
+		}
+
+		err = audit.device(AuditUpdate, user, device, newDevice)
+		if err != nil {
+			return err
+		}
+
+		medications, err = audit.updateMedications(medications, func() ([]*Medication, error) {
+			return renameUserDevice(user, medications, device, newDevice)
+		})
+		if err != nil {
+			return err
+		}
+
+		return sqliteSetMedications(tx, medications)
+	})
+}
+
+// RemoveUserDevice of a user and from every medication reminding it, refusing
+// with ErrDeviceInUse when a medication would be left without any device
+func (s *SQLite) RemoveUserDevice(user *User, device string) error {
+	return s.updateUserAudited(user, func(tx *sql.Tx, audit *auditLog, user *User) error {
+		medications, err := sqliteListMedicationsForUser(tx, user)
+		if err != nil {
+			return err
+		}
+
+		err = audit.device(AuditDelete, user, device, "")
+		if err != nil {
+			return err
+		}
+
+		medications, err = audit.updateMedications(medications, func() ([]*Medication, error) {
+			return removeUserDevice(user, medications, device)
+		})
+		if err != nil {
+			return err
+		}
+
+		return sqliteSetMedications(tx, medications)
+	})
+}
+
+// PauseUser reminders for all of the user's medications until resumeAt, or
+// until resumed when resumeAt is nil
+func (s *SQLite) PauseUser(user *User, resumeAt *time.Time) error {
+	return s.updateUser(user, func(tx *sql.Tx, user *User) error {
+		user.Pause = Pause{
+			Paused:   true,
+			ResumeAt: resumeAt,
+		}
+
+		return nil
+	})
+}
+
+// ResumeUser reminders for all of the user's medications
+func (s *SQLite) ResumeUser(user *User) error {
+	return s.updateUser(user, func(tx *sql.Tx, user *User) error {
+		user.Pause = Pause{}
+
+		return nil
+	})
+}
+
+// updateUser applies update to the stored copy of user in a transaction,
+// recording the change, and copies the result back into user once it is saved
+func (s *SQLite) updateUser(user *User, update func(tx *sql.Tx, user *User) error) error {
+	return s.updateUserAudited(user, func(tx *sql.Tx, audit *auditLog, user *User) error {
+		return audit.updateUser(user, func() error {
+			return update(tx, user)
+		})
+	})
+}
+
+// updateUserAudited is updateUser leaving recording the change to update
+func (s *SQLite) updateUserAudited(user *User, update func(tx *sql.Tx, audit *auditLog, user *User) error) error {
+	return s.updateAudited(func(tx *sql.Tx, audit *auditLog) error {
+		existing, err := sqliteGetUserByID(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = update(tx, audit, existing)
+		if err != nil {
+			return err
+		}
+
+		err = sqliteSetUser(tx, existing)
+		if err != nil {
+			return err
+		}
+
+		*user = *existing
+
+		return nil
+	})
+}
+
+// AddMedication to the database
+func (s *SQLite) AddMedication(medication *Medication) error {
+	return s.updateAudited(func(tx *sql.Tx, audit *auditLog) error {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM medications WHERE id = ?", medication.ID.String()).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return fmt.Errorf("medication %s %w", medication.ID.String(), ErrAlreadyExists)
+		}
+
+		err = sqliteSetMedication(tx, medication)
+		if err != nil {
+			return err
+		}
+
+		return audit.medication(AuditCreate, nil, medication)
+	})
+}
+
+// GetMedication from the database by its ID alone
+func (s *SQLite) GetMedication(id uuid.UUID) (*Medication, error) {
+	var data []byte
+	err := s.db.QueryRow("SELECT data FROM medications WHERE id = ?", id.String()).Scan(&data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get medication value for medication %s: %w", id.String(), sqliteNotFound(err))
+	}
+
+	return unmarshalMedication(id, data)
+}
+
+// GetMedicationForUser from the database
+func (s *SQLite) GetMedicationForUser(user *User, id uuid.UUID) (*Medication, error) {
+	return sqliteGetMedicationForUser(s.db, user.ID, id)
+}
+
+func sqliteGetMedicationForUser(tx sqliteQuerier, idUser, id uuid.UUID) (*Medication, error) {
+	var data []byte
+	err := tx.QueryRow("SELECT data FROM medications WHERE id = ? AND id_user = ?", id.String(), idUser.String()).Scan(&data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get medication value for medication %s: %w", id.String(), sqliteNotFound(err))
+	}
+
+	return unmarshalMedication(id, data)
+}
+
+func unmarshalMedication(id uuid.UUID, data []byte) (*Medication, error) {
+	medication := &Medication{}
+	err := json.Unmarshal(data, medication)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal medication value for medication %s: %w", id.String(), err)
+	}
+
+	return medication, nil
+}
+
+// ListMedicationsForUser from the database
+func (s *SQLite) ListMedicationsForUser(user *User) ([]*Medication, error) {
+	return sqliteListMedicationsForUser(s.db, user)
+}
+
+func sqliteListMedicationsForUser(tx sqliteQuerier, user *User) (medications []*Medication, err error) {
+	rows, err := tx.Query("SELECT data FROM medications WHERE id_user = ? ORDER BY id", user.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	err = sqliteScan(rows, func(data []byte) error {
+		medication := &Medication{}
+		err := json.Unmarshal(data, medication)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal medication value for id user %s: %w", user.ID.String(), err)
+		}
+
+		medications = append(medications, medication)
+
+		return nil
+	})
+
+	return medications, err
+}
+
+func sqliteSetMedications(tx *sql.Tx, medications []*Medication) error {
+	for _, medication := range medications {
+		err := sqliteSetMedication(tx, medication)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sqliteSetMedication(tx *sql.Tx, medication *Medication) error {
+	data, err := json.Marshal(medication)
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal medication: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO medications (id, id_user, data) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET id_user = excluded.id_user, data = excluded.data",
+		medication.ID.String(),
+		medication.IDUser.String(),
+		data,
+	)
+
+	return err
+}
+
+// UpdateMedication that already exists in the database, keeping the version
+// it replaces in the medication's history attributed to author
+func (s *SQLite) UpdateMedication(medication *Medication, author string) error {
+	return s.updateAudited(func(tx *sql.Tx, audit *auditLog) error {
+		existing, err := sqliteGetMedicationForUser(tx, medication.IDUser, medication.ID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		err = sqliteAddMedicationVersion(tx, &MedicationVersion{
+			Medication: *existing,
+			ReplacedAt: now,
+			ReplacedBy: author,
+		})
+		if err != nil {
+			return err
+		}
+
+		medication.CreatedAt = existing.CreatedAt
+		medication.UpdatedAt = now
+
+		err = sqliteSetMedication(tx, medication)
+		if err != nil {
+			return err
+		}
+
+		return audit.medication(AuditUpdate, existing, medication)
+	})
+}
+
+// PauseMedication reminders until resumeAt, or until resumed when resumeAt is nil
+func (s *SQLite) PauseMedication(medication *Medication, resumeAt *time.Time) error {
+	return s.updateMedication(medication, func(medication *Medication) {
+		medication.Pause = Pause{
+			Paused:   true,
+			ResumeAt: resumeAt,
+		}
+	})
+}
+
+// ResumeMedication reminders
+func (s *SQLite) ResumeMedication(medication *Medication) error {
+	return s.updateMedication(medication, func(medication *Medication) {
+		medication.Pause = Pause{}
+	})
+}
+
+// updateMedication applies update to the stored copy of medication in a
+// transaction and copies the result back into medication once it is saved
+func (s *SQLite) updateMedication(medication *Medication, update func(medication *Medication)) error {
+	return s.updateAudited(func(tx *sql.Tx, audit *auditLog) error {
+		existing, err := sqliteGetMedicationForUser(tx, medication.IDUser, medication.ID)
+		if err != nil {
+			return err
+		}
+
+		err = audit.updateMedication(existing, func() error {
+			update(existing)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = sqliteSetMedication(tx, existing)
+		if err != nil {
+			return err
+		}
+
+		*medication = *existing
+
+		return nil
+	})
+}
+
+// AddMedicationVersion to the history of a medication as it is
+func (s *SQLite) AddMedicationVersion(version *MedicationVersion) error {
+	return s.updateAudited(func(tx *sql.Tx, audit *auditLog) error {
+		err := sqliteAddMedicationVersion(tx, version)
+		if err != nil {
+			return err
+		}
+
+		return audit.medicationVersion(version)
+	})
+}
+
+func sqliteAddMedicationVersion(tx *sql.Tx, version *MedicationVersion) error {
+	data, err := json.Marshal(version)
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal medication version: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO medication_history (id_medication, id_user, replaced_at, data) VALUES (?, ?, ?, ?)",
+		version.ID.String(),
+		version.IDUser.String(),
+		version.ReplacedAt.UnixNano(),
+		data,
+	)
+
+	return err
+}
+
+// ListMedicationHistory of prior versions of a medication, oldest first
+func (s *SQLite) ListMedicationHistory(medication *Medication) (versions []*MedicationVersion, err error) {
+	rows, err := s.db.Query(
+		"SELECT data FROM medication_history WHERE id_medication = ? AND id_user = ? ORDER BY replaced_at",
+		medication.ID.String(),
+		medication.IDUser.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sqliteScan(rows, func(data []byte) error {
+		version := &MedicationVersion{}
+		err := json.Unmarshal(data, version)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal medication version for medication %s: %w", medication.ID.String(), err)
+		}
+
+		versions = append(versions, version)
+
+		return nil
+	})
+
+	return versions, err
+}
+
+// RemoveMedication and its history from the database
+func (s *SQLite) RemoveMedication(medication *Medication) error {
+	return s.updateAudited(func(tx *sql.Tx, audit *auditLog) error {
+		existing, err := sqliteGetMedicationForUser(tx, medication.IDUser, medication.ID)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(
+			"DELETE FROM medications WHERE id = ? AND id_user = ?",
+			medication.ID.String(),
+			medication.IDUser.String(),
+		)
+		if err != nil {
+			return err
+		}
+
+		err = sqliteAffected(result, "medication", medication.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			"DELETE FROM medication_history WHERE id_medication = ? AND id_user = ?",
+			medication.ID.String(),
+			medication.IDUser.String(),
+		)
+		if err != nil {
+			return err
+		}
+
+		return audit.medication(AuditDelete, existing, nil)
+	})
+}
+
+// AddDose to the database
+func (s *SQLite) AddDose(dose *Dose) error {
+	return s.updateAudited(func(tx *sql.Tx, audit *auditLog) error {
+		data, err := json.Marshal(dose)
+		if err != nil {
+			return fmt.Errorf("failed to JSON marshal dose: %w", err)
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO doses (id, id_user, scheduled_at, data) VALUES (?, ?, ?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data",
+			dose.ID.String(),
+			dose.IDUser.String(),
+			dose.ScheduledAt.UnixNano(),
+			data,
+		)
+		if err != nil {
+			return err
+		}
+
+		return audit.dose(AuditCreate, nil, dose)
+	})
+}
+
+// UpdateDose that already exists in the database
+func (s *SQLite) UpdateDose(dose *Dose) error {
+	return s.updateDose(dose, false)
+}
+
+// UpdatePendingDose that is still pending in the database
+func (s *SQLite) UpdatePendingDose(dose *Dose) error {
+	return s.updateDose(dose, true)
+}
+
+func (s *SQLite) updateDose(dose *Dose, pendingOnly bool) error {
+	return s.updateAudited(func(tx *sql.Tx, audit *auditLog) error {
+		var existing []byte
+		err := tx.QueryRow(
+			"SELECT data FROM doses WHERE id = ? AND id_user = ? AND scheduled_at = ?",
+			dose.ID.String(),
+			dose.IDUser.String(),
+			dose.ScheduledAt.UnixNano(),
+		).Scan(&existing)
+		if err != nil {
+			return fmt.Errorf("failed to get dose %s: %w", dose.ID.String(), sqliteNotFound(err))
+		}
+
+		before := &Dose{}
+		err = json.Unmarshal(existing, before)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal dose %s: %w", dose.ID.String(), err)
+		}
+
+		err = checkPending(before, pendingOnly)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(dose)
+		if err != nil {
+			return fmt.Errorf("failed to JSON marshal dose: %w", err)
+		}
+
+		_, err = tx.Exec("UPDATE doses SET data = ? WHERE id = ?", data, dose.ID.String())
+		if err != nil {
+			return err
+		}
+
+		return audit.dose(AuditUpdate, before, dose)
+	})
+}
+
+// ListDosesForUser from the database that were scheduled at or after since
+func (s *SQLite) ListDosesForUser(user *User, since time.Time) (doses []*Dose, err error) {
+	rows, err := s.db.Query(
+		"SELECT data FROM doses WHERE id_user = ? AND scheduled_at >= ? ORDER BY scheduled_at, id",
+		user.ID.String(),
+		since.UnixNano(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sqliteScan(rows, func(data []byte) error {
+		dose := &Dose{}
+		err := json.Unmarshal(data, dose)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal dose value for id user %s: %w", user.ID.String(), err)
+		}
+
+		doses = append(doses, dose)
+
+		return nil
+	})
+
+	return doses, err
+}
+
+// AddAuditEntry to the database
+func (s *SQLite) AddAuditEntry(entry *AuditEntry) error {
+	return s.update(func(tx *sql.Tx) error {
+		return sqliteAddAuditEntry(tx, entry)
+	})
+}
+
+func sqliteAddAuditEntry(tx *sql.Tx, entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal audit entry: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO audit (id, time, data) VALUES (?, ?, ?)",
+		entry.ID.String(),
+		entry.Time.UnixNano(),
+		data,
+	)
+
+	return err
+}
+
+// ListAuditEntries from the database matching filter, oldest first
+func (s *SQLite) ListAuditEntries(filter AuditFilter) (entries []*AuditEntry, err error) {
+	rows, err := s.db.Query(
+		"SELECT data FROM audit WHERE time >= ? ORDER BY time, rowid",
+		filter.since().UnixNano(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sqliteScan(rows, func(data []byte) error {
+		entry := &AuditEntry{}
+		err := json.Unmarshal(data, entry)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal audit entry: %w", err)
+		}
+
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// AddNotification to the outbound queue in the database
+func (s *SQLite) AddNotification(notification *Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal notification: %w", err)
+	}
+
+	_, err = s.db.Exec(
+		"INSERT INTO notifications (id, id_user, created_at, data) VALUES (?, ?, ?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data",
+		notification.ID.String(),
+		notification.IDUser.String(),
+		notification.CreatedAt.UnixNano(),
+		data,
+	)
+
+	return err
+}
+
+// UpdateNotification that is still in the outbound queue in the database
+func (s *SQLite) UpdateNotification(notification *Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal notification: %w", err)
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE notifications SET data = ? WHERE id = ?",
+		data,
+		notification.ID.String(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return sqliteAffected(result, "notification", notification.ID)
+}
+
+// ListNotifications in the outbound queue in the database, oldest first
+func (s *SQLite) ListNotifications() (notifications []*Notification, err error) {
+	rows, err := s.db.Query("SELECT data FROM notifications ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+
+	err = sqliteScan(rows, func(data []byte) error {
+		notification := &Notification{}
+		err := json.Unmarshal(data, notification)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal notification: %w", err)
+		}
+
+		notifications = append(notifications, notification)
+
+		return nil
+	})
+
+	return notifications, err
+}
+
+// RemoveNotification from the outbound queue in the database
+func (s *SQLite) RemoveNotification(notification *Notification) error {
+	result, err := s.db.Exec("DELETE FROM notifications WHERE id = ?", notification.ID.String())
+	if err != nil {
+		return err
+	}
+
+	return sqliteAffected(result, "notification", notification.ID)
+}
+
+// sqliteAffected turns a statement that affected no rows into ErrNotFound
+func sqliteAffected(result sql.Result, record string, id uuid.UUID) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("failed to get %s %s: %w", record, id.String(), ErrNotFound)
+	}
+
+	return nil
+}
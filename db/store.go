@@ -0,0 +1,91 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotFound occurs when getting or updating a record that doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists occurs when adding a record that conflicts with an
+	// existing one
+	ErrAlreadyExists = errors.New("already exists")
+)
+
+// Store of users, their medications and doses
+type Store interface {
+	// Ping checks that the store can serve reads
+	Ping() error
+	// Close the store
+	Close() error
+	// withActor is a copy of the store sharing its data that adds an
+	// AuditEntry attributed to actor for every change made through it, in
+	// the same transaction as the change
+	withActor(actor string) Store
+
+	AddUser(user *User) error
+	// GetUser by its case-insensitive username
+	GetUser(username string) (*User, error)
+	GetUserByID(id uuid.UUID) (*User, error)
+	ListUsers() ([]*User, error)
+	// RemoveUser refusing with ErrUserHasMedications when the user still has
+	// medications unless cascade is set, in which case the medications of the
+	// user are removed as well. Doses and queued notifications of the user are
+	// always removed.
+	RemoveUser(user *User, cascade bool) error
+	RenameUser(user *User, newName string) error
+	// AddUserDevice named name, which must have a key
+	AddUserDevice(user *User, name string, device *PushoverDevice) error
+	// UpdateUserDevice named name, failing with ErrNotFound when the user has
+	// no such device
+	UpdateUserDevice(user *User, name string, device *PushoverDevice) error
+	// RenameUserDevice along with every medication reminding it
+	RenameUserDevice(user *User, device, newDevice string) error
+	// RemoveUserDevice and from every medication reminding it, refusing with
+	// ErrDeviceInUse when a medication would be left without any device
+	RemoveUserDevice(user *User, device string) error
+	// PauseUser until resumeAt, or until resumed when resumeAt is nil
+	PauseUser(user *User, resumeAt *time.Time) error
+	ResumeUser(user *User) error
+
+	AddMedication(medication *Medication) error
+	GetMedication(id uuid.UUID) (*Medication, error)
+	GetMedicationForUser(user *User, id uuid.UUID) (*Medication, error)
+	ListMedicationsForUser(user *User) ([]*Medication, error)
+	// UpdateMedication keeping the version it replaces in the medication's
+	// history attributed to author
+	UpdateMedication(medication *Medication, author string) error
+	// PauseMedication until resumeAt, or until resumed when resumeAt is nil
+	PauseMedication(medication *Medication, resumeAt *time.Time) error
+	ResumeMedication(medication *Medication) error
+	// AddMedicationVersion to the history of a medication as it is, e.g. when
+	// importing it
+	AddMedicationVersion(version *MedicationVersion) error
+	// ListMedicationHistory of prior versions of a medication, oldest first
+	ListMedicationHistory(medication *Medication) ([]*MedicationVersion, error)
+	// RemoveMedication and its history
+	RemoveMedication(medication *Medication) error
+
+	AddDose(dose *Dose) error
+	UpdateDose(dose *Dose) error
+	// UpdatePendingDose only while the stored dose is still pending, failing
+	// with ErrDoseResolved otherwise
+	UpdatePendingDose(dose *Dose) error
+	// ListDosesForUser that were scheduled at or after since, oldest first
+	ListDosesForUser(user *User, since time.Time) ([]*Dose, error)
+
+	// AddAuditEntry to the append-only audit trail
+	AddAuditEntry(entry *AuditEntry) error
+	// ListAuditEntries matching filter, oldest first
+	ListAuditEntries(filter AuditFilter) ([]*AuditEntry, error)
+
+	// AddNotification to the outbound queue
+	AddNotification(notification *Notification) error
+	UpdateNotification(notification *Notification) error
+	// ListNotifications in the outbound queue, oldest first
+	ListNotifications() ([]*Notification, error)
+	RemoveNotification(notification *Notification) error
+}
@@ -0,0 +1,50 @@
+package db_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/db/dbtest"
+)
+
+func TestBadger(t *testing.T) {
+	dbtest.TestStore(t, func(t *testing.T) db.Store {
+		b, err := db.NewBadger(t.TempDir(), time.Hour, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return b
+	})
+}
+
+func TestEncryptedBadger(t *testing.T) {
+	dbtest.TestStore(t, func(t *testing.T) db.Store {
+		b, err := db.NewBadger(t.TempDir(), time.Hour, bytes.Repeat([]byte{0x42}, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return b
+	})
+}
+
+func TestMemory(t *testing.T) {
+	dbtest.TestStore(t, func(t *testing.T) db.Store {
+		return db.NewMemory()
+	})
+}
+
+func TestSQLite(t *testing.T) {
+	dbtest.TestStore(t, func(t *testing.T) db.Store {
+		s, err := db.NewSQLite(filepath.Join(t.TempDir(), "meditime.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s
+	})
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,16 +9,102 @@ import (
 
 // User information
 type User struct {
-	ID                   uuid.UUID         `json:"id"`
-	Name                 string            `json:"name"`
-	PushoverDeviceTokens map[string]string `json:"pushover_device_tokens"`
-	CreatedAt            time.Time         `json:"created_at"`
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	// PushoverDevices reminded by device name
+	PushoverDevices map[string]*PushoverDevice `json:"pushover_devices"`
+	CreatedAt       time.Time                  `json:"created_at"`
+	Pause
 }
 
+// PushoverKeyKind is whether a pushover key is of a user or a group
+type PushoverKeyKind string
+
+const (
+	// PushoverKeyUser is the key of a pushover user
+	PushoverKeyUser PushoverKeyKind = "user"
+	// PushoverKeyGroup is the key of a pushover delivery group
+	PushoverKeyGroup PushoverKeyKind = "group"
+)
+
+// PushoverDevice of a user is the pushover user or group key it reminds,
+// the pushover devices of the key it is restricted to and what pushover
+// reported when the key was last validated
+type PushoverDevice struct {
+	Key string `json:"key"`
+	// Kind of the key, a user until validating it reports a group
+	Kind PushoverKeyKind `json:"kind"`
+	// Restrict reminders to these devices of the pushover user, every active
+	// device of the user or group is reminded when empty
+	Restrict []string `json:"restrict,omitempty"`
+	// Devices that are active for the key
+	Devices []string `json:"devices,omitempty"`
+	// ValidatedAt is zero for devices that were never validated
+	ValidatedAt time.Time `json:"validated_at"`
+	// Disabled devices are not reminded since pushover reported their key
+	// as invalid for DisabledReason
+	Disabled       bool   `json:"disabled,omitempty"`
+	DisabledReason string `json:"disabled_reason,omitempty"`
+}
+
+// HasDevice reports whether the user has the named device
+func (u *User) HasDevice(device string) bool {
+	_, ok := u.PushoverDevices[device]
+
+	return ok
+}
+
+// DeviceKey of the named device, empty when the user doesn't have it
+func (u *User) DeviceKey(device string) string {
+	details, ok := u.PushoverDevices[device]
+	if !ok {
+		return ""
+	}
+
+	return details.Key
+}
+
+// DeviceDisabled reports whether the named device was disabled
+func (u *User) DeviceDisabled(device string) bool {
+	details, ok := u.PushoverDevices[device]
+
+	return ok && details.Disabled
+}
+
+// DeviceRestrictions of the named device, nil when every pushover device of
+// its key is reminded
+func (u *User) DeviceRestrictions(device string) []string {
+	details, ok := u.PushoverDevices[device]
+	if !ok {
+		return nil
+	}
+
+	return details.Restrict
+}
+
+const (
+	badgerPrefixKeyUserID   = "user_id:"
+	badgerPrefixKeyUsername = "user_name:"
+)
+
 func (u *User) badgerKey() []byte {
-	return badgerKeyForUsername(u.Name)
+	return badgerKeyForUserID(u.ID)
+}
+
+func badgerKeyForUserID(id uuid.UUID) []byte {
+	return append([]byte(badgerPrefixKeyUserID), id[:]...)
 }
 
+// badgerKeyForUsername of the unique index from a username to its user ID,
+// usernames are compared case-insensitively
 func badgerKeyForUsername(username string) []byte {
-	return append([]byte("user:"), []byte(username)...)
+	return append([]byte(badgerPrefixKeyUsername), []byte(normalizeUsername(username))...)
 }
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(username)
+}
+
+// badgerLegacyPrefixKeyUser of users keyed by their username before users
+// were keyed by ID
+var badgerLegacyPrefixKeyUser = []byte("user:")
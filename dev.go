@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/pushover/pushovertest"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeUser of the fake pushover server given as key:device,device
+type fakeUser struct {
+	key     string
+	devices []string
+}
+
+func parseFakeUser(value string) (fakeUser, error) {
+	key, devices := value, ""
+	if i := strings.Index(value, ":"); i >= 0 {
+		key, devices = value[:i], value[i+1:]
+	}
+
+	if key == "" {
+		return fakeUser{}, fmt.Errorf("invalid user '%s', must be key:device,device", value)
+	}
+
+	user := fakeUser{key: key}
+	for _, device := range strings.Split(devices, ",") {
+		if device != "" {
+			user.devices = append(user.devices, device)
+		}
+	}
+
+	return user, nil
+}
+
+var devCommand = &command{
+	name:    "dev",
+	summary: "Tools for local development and testing",
+	subcommands: []*command{
+		{
+			name:    "fake-pushover",
+			summary: "Serve a fake pushover API in memory, point meditime at it with -pushover-url http://<address>/1/",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				address := flagSet.String("address", "127.0.0.1:8081", "address for the fake pushover API to listen on")
+				token := flagSet.String("token", "", "only accept this pushover API token, any token when empty")
+
+				var users []fakeUser
+				flagSet.Func("user", "user key and devices to accept as key:device,device, may be repeated, any user is accepted when none are given", func(value string) error {
+					user, err := parseFakeUser(value)
+					if err != nil {
+						return err
+					}
+
+					users = append(users, user)
+					return nil
+				})
+
+				return func() error {
+					fake := pushovertest.NewServer(*token)
+					for _, user := range users {
+						fake.AddUser(user.key, user.devices...)
+					}
+
+					fake.OnMessage = func(message *pushovertest.Message) {
+						logger.WithFields(logrus.Fields{
+							"request":  message.Request,
+							"receipt":  message.Receipt,
+							"user":     message.User,
+							"device":   message.Device,
+							"priority": message.Priority,
+						}).Info(message.Message)
+					}
+
+					server := &http.Server{
+						Addr:    *address,
+						Handler: fake,
+					}
+
+					serverErr := make(chan error, 1)
+					go func() {
+						serverErr <- server.ListenAndServe()
+					}()
+
+					logger.WithField("listen_address", *address).Info(
+						"fake pushover API listening, acknowledge with POST /fake/receipts/<receipt>/acknowledge, " +
+							"expire with POST /fake/receipts/<receipt>/expire, fail with POST /fake/fail?status=500&count=1, " +
+							"limit with POST /fake/limit?limit=10 and list messages with GET /fake/messages",
+					)
+
+					select {
+					case <-c.ctx.Done():
+					case err := <-serverErr:
+						return err
+					}
+
+					shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+					defer cancel()
+
+					err := server.Shutdown(shutdownCtx)
+					if err != nil && !errors.Is(err, http.ErrServerClosed) {
+						return err
+					}
+
+					return nil
+				}
+			},
+		},
+	},
+}
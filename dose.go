@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"github.com/google/uuid"
+)
+
+var doseHeader = []string{"ID", "MEDICATION", "QUANTITY", "STATUS", "SCHEDULED", "RESOLVED"}
+
+func doseRow(dose *db.Dose, medications map[uuid.UUID]string) []string {
+	medication, ok := medications[dose.IDMedication]
+	if !ok {
+		medication = dose.IDMedication.String()
+	}
+
+	resolvedAt := ""
+	if dose.ResolvedAt != nil {
+		resolvedAt = dose.ResolvedAt.Format(time.RFC3339)
+	}
+
+	return []string{
+		dose.ID.String(),
+		medication,
+		strconv.FormatUint(uint64(dose.Quantity), 10),
+		string(dose.Status),
+		dose.ScheduledAt.Format(time.RFC3339),
+		resolvedAt,
+	}
+}
+
+// medicationNames of the user by ID
+func (c *cli) medicationNames(user *db.User) (map[uuid.UUID]string, error) {
+	b, err := c.db()
+	if err != nil {
+		return nil, err
+	}
+
+	medications, err := b.ListMedicationsForUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[uuid.UUID]string, len(medications))
+	for _, medication := range medications {
+		names[medication.ID] = medication.Name
+	}
+
+	return names, nil
+}
+
+// pendingDose of the user by ID, or the latest pending one of the medication
+// when id is empty, or of any medication when both are empty
+func (c *cli) pendingDose(user *db.User, id, medicationID string) (*db.Dose, error) {
+	b, err := c.db()
+	if err != nil {
+		return nil, err
+	}
+
+	doses, err := b.ListDosesForUser(user, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	if id != "" {
+		for _, dose := range doses {
+			if dose.ID.String() != id {
+				continue
+			}
+
+			if dose.Status != db.DoseStatusPending {
+				return nil, fmt.Errorf("dose %s is already %s", id, dose.Status)
+			}
+
+			return dose, nil
+		}
+
+		return nil, fmt.Errorf("dose %s of user %s %w", id, user.Name, db.ErrNotFound)
+	}
+
+	// doses are listed oldest first
+	for i := len(doses) - 1; i >= 0; i-- {
+		dose := doses[i]
+		if dose.Status == db.DoseStatusPending && (medicationID == "" || dose.IDMedication.String() == medicationID) {
+			return dose, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no pending dose of user %s %w", user.Name, db.ErrNotFound)
+}
+
+// recordDoseCommand resolving a pending dose with the status and cancelling
+// its reminders. Only one process can open a badger store, so with it the
+// command fails with db.ErrLocked while the daemon runs, the sqlite store
+// allows recording doses alongside the daemon.
+func recordDoseCommand(name string, status db.DoseStatus) *command {
+	return &command{
+		name:    name,
+		summary: fmt.Sprintf("Record a pending dose as %s and cancel its reminders", status),
+		setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+			username := flagSet.String("username", "", "name of the user")
+			id := flagSet.String("id", "", "id of the dose, the latest pending one when empty")
+			medicationID := flagSet.String("medication", "", "id of the medication whose latest pending dose to record when -id is empty, any medication when empty")
+			output := outputFlag(flagSet)
+
+			return func() error {
+				user, err := c.promptUser(username)
+				if err != nil {
+					return err
+				}
+
+				dose, err := c.pendingDose(user, *id, *medicationID)
+				if err != nil {
+					return err
+				}
+
+				b, err := c.db()
+				if err != nil {
+					return err
+				}
+
+				now := time.Now()
+				dose.Status = status
+				dose.ResolvedAt = &now
+
+				// the daemon may have resolved the dose since it was read
+				err = b.UpdatePendingDose(dose)
+				if err != nil {
+					return err
+				}
+
+				entry := logger.WithField("dose_id", dose.ID.String())
+
+				// the daemon cancels them as well once it notices the dose was
+				// recorded, so failing to cancel them here is only a warning
+				client, err := newPushoverClient(c.config)
+				if err != nil {
+					entry.WithError(err).Warn("failed to cancel the reminders of the dose")
+				} else if failed := cancelReceipts(c.ctx, client, entry, dose.Receipts, nil); failed > 0 {
+					entry.Warnf("failed to cancel %d reminders of the dose, the daemon cancels them once it notices the dose was recorded", failed)
+				}
+
+				medications, err := c.medicationNames(user)
+				if err != nil {
+					return err
+				}
+
+				return c.print(*output, dose, doseHeader, [][]string{doseRow(dose, medications)})
+			}
+		},
+	}
+}
+
+var doseCommand = &command{
+	name:    "dose",
+	summary: "Inspect and record the scheduled doses of a user",
+	subcommands: []*command{
+		{
+			name:    "list",
+			summary: "List the scheduled doses of a user, oldest first",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				since := flagSet.String("since", "168h", "only list doses scheduled since a duration ago, a date or an RFC 3339 timestamp, every dose when empty")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					sinceTime, err := c.parseSince(*since)
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					doses, err := b.ListDosesForUser(user, sinceTime)
+					if err != nil {
+						return err
+					}
+
+					medications, err := c.medicationNames(user)
+					if err != nil {
+						return err
+					}
+
+					rows := make([][]string, 0, len(doses))
+					for _, dose := range doses {
+						rows = append(rows, doseRow(dose, medications))
+					}
+
+					if doses == nil {
+						doses = []*db.Dose{}
+					}
+
+					return c.print(*output, doses, doseHeader, rows)
+				}
+			},
+		},
+		recordDoseCommand("take", db.DoseStatusTaken),
+		recordDoseCommand("skip", db.DoseStatusSkipped),
+	},
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"github.com/google/uuid"
+)
+
+// addPendingDose of the medication reminded through pushover on every device
+// of the user, returning it along with its receipts
+func addPendingDose(t *testing.T, b db.Store, client *pushover.Client, user *db.User, medication *db.Medication, scheduledAt time.Time) *db.Dose {
+	t.Helper()
+
+	dose := &db.Dose{
+		IDUser:       user.ID,
+		IDMedication: medication.ID,
+		ID:           uuid.New(),
+		Quantity:     1,
+		Status:       db.DoseStatusPending,
+		ScheduledAt:  scheduledAt,
+	}
+
+	for _, device := range []string{"phone", "tablet"} {
+		response, err := client.SendMessage(context.Background(), &pushover.Message{
+			Message:  "take 1 dose(s) of aspirin",
+			Priority: pushover.PriorityEmergency,
+			Device:   device,
+			Retry:    time.Minute,
+			Expire:   time.Hour,
+		}, "alice-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dose.Receipts = append(dose.Receipts, response.Receipt)
+	}
+
+	err := b.AddDose(dose)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dose
+}
+
+func TestDoseTake(t *testing.T) {
+	_, url := newFakePushover(t)
+	client := newPushover(t, url)
+	c, stdout := newTestCLI(t, url)
+
+	b, err := c.db()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := addUser(t, b)
+	medication := addMedication(t, b, user, "phone", "tablet")
+	older := addPendingDose(t, b, client, user, medication, time.Now().Add(-time.Hour))
+	latest := addPendingDose(t, b, client, user, medication, time.Now())
+
+	err = runCLI(c, "dose", "take", "-username", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doses, err := b.ListDosesForUser(user, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doses) != 2 || doses[0].Status != db.DoseStatusPending || doses[1].Status != db.DoseStatusTaken || doses[1].ResolvedAt == nil {
+		t.Fatalf("expected only the latest dose to be taken, got %+v and %+v", doses[0], doses[1])
+	}
+
+	if !strings.Contains(stdout.String(), latest.ID.String()) {
+		t.Fatalf("expected the taken dose to be printed, got %s", stdout.String())
+	}
+
+	expectCancelled(t, client, latest.Receipts...)
+
+	for _, receipt := range older.Receipts {
+		details, err := client.GetReceipt(context.Background(), receipt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if details.Expired {
+			t.Fatalf("expected the reminders of the older dose to keep retrying, got %+v", details)
+		}
+	}
+}
+
+func TestDoseSkip(t *testing.T) {
+	_, url := newFakePushover(t)
+	client := newPushover(t, url)
+	c, _ := newTestCLI(t, url)
+
+	b, err := c.db()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := addUser(t, b)
+	medication := addMedication(t, b, user, "phone", "tablet")
+	dose := addPendingDose(t, b, client, user, medication, time.Now())
+
+	err = runCLI(c, "dose", "skip", "-username", "alice", "-id", dose.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doses, err := b.ListDosesForUser(user, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doses) != 1 || doses[0].Status != db.DoseStatusSkipped {
+		t.Fatalf("expected the dose to be skipped, got %+v", doses)
+	}
+
+	expectCancelled(t, client, dose.Receipts...)
+
+	err = runCLI(c, "dose", "take", "-username", "alice", "-id", dose.ID.String())
+	if err == nil || !strings.Contains(err.Error(), "already skipped") {
+		t.Fatalf("expected taking a skipped dose to fail, got %v", err)
+	}
+
+	err = runCLI(c, "dose", "take", "-username", "alice")
+	if err == nil {
+		t.Fatal("expected taking a dose without any pending one to fail")
+	}
+}
+
+func TestDoseTakeWhileDaemonRuns(t *testing.T) {
+	for _, store := range []string{storeBadger, storeSQLite} {
+		_, url := newFakePushover(t)
+		config := &storeConfig{store: store, dir: t.TempDir(), pushoverURL: url}
+
+		// the daemon keeps the store open
+		daemon := newCLI(context.Background(), config)
+		b, err := daemon.db()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user := addUser(t, b)
+		medication := addMedication(t, b, user, "phone", "tablet")
+		dose := addPendingDose(t, b, newPushover(t, url), user, medication, time.Now())
+
+		err = runAlongsideDaemon(config, "dose", "take", "-username", "alice", "-id", dose.ID.String())
+
+		switch store {
+		case storeBadger:
+			if !errors.Is(err, db.ErrLocked) {
+				t.Fatalf("expected recording a dose in a badger store the daemon has open to fail with %v, got %v", db.ErrLocked, err)
+			}
+
+		case storeSQLite:
+			if err != nil {
+				t.Fatalf("expected recording a dose in a sqlite store the daemon has open to work, got %v", err)
+			}
+
+			doses, err := b.ListDosesForUser(user, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(doses) != 1 || doses[0].Status != db.DoseStatusTaken {
+				t.Fatalf("expected the daemon to see the dose taken, got %+v", doses)
+			}
+		}
+
+		daemon.close()
+	}
+}
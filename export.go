@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"git.0xdad.com/tblyler/meditime/db"
+)
+
+var importHeader = []string{"RECORD", "IMPORTED", "SKIPPED"}
+
+var exportCommand = &command{
+	name:    "export",
+	summary: "Export all users, devices, medications, history and doses as JSON",
+	setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+		file := flagSet.String("file", "-", "file to write the export to, - for STDOUT")
+
+		return func() error {
+			b, err := c.db()
+			if err != nil {
+				return err
+			}
+
+			export, err := db.NewExport(b)
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = c.stdout
+			if *file != "-" {
+				f, err := os.OpenFile(*file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+				if err != nil {
+					return fmt.Errorf("failed to create export file: %w", err)
+				}
+
+				defer f.Close()
+				w = f
+			}
+
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+
+			err = encoder.Encode(export)
+			if err != nil {
+				return fmt.Errorf("failed to write export: %w", err)
+			}
+
+			if f, ok := w.(*os.File); ok {
+				return f.Close()
+			}
+
+			return nil
+		}
+	},
+}
+
+var importCommand = &command{
+	name:    "import",
+	summary: "Import a JSON export, validating all of it before anything is written",
+	setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+		file := flagSet.String("file", "-", "file to read the export from, - for STDIN")
+		mode := flagSet.String("mode", string(db.ImportMerge), "merge to only add what isn't in the store yet, replace to remove everything in the store first")
+		newIDs := flagSet.Bool("new-ids", false, "give every imported user, medication and dose a new ID")
+		dryRun := flagSet.Bool("dry-run", false, "only validate the export and report what would be imported")
+		output := outputFlag(flagSet)
+
+		return func() error {
+			var r io.Reader = os.Stdin
+			if *file != "-" {
+				f, err := os.Open(*file)
+				if err != nil {
+					return fmt.Errorf("failed to open export file: %w", err)
+				}
+
+				defer f.Close()
+				r = f
+			}
+
+			export, err := db.ReadExport(r)
+			if err != nil {
+				return fmt.Errorf("failed to parse export: %w", err)
+			}
+
+			b, err := c.db()
+			if err != nil {
+				return err
+			}
+
+			result, err := export.Import(b, db.ImportOptions{
+				Mode:   db.ImportMode(*mode),
+				NewIDs: *newIDs,
+				DryRun: *dryRun,
+			})
+
+			var importErr *db.ImportError
+			if errors.As(err, &importErr) {
+				for _, problem := range importErr.Problems {
+					fmt.Fprintln(c.stderr, problem)
+				}
+
+				return fmt.Errorf("nothing was imported, the export has %d problems", len(importErr.Problems))
+			}
+
+			if err != nil {
+				return err
+			}
+
+			return c.print(*output, result, importHeader, [][]string{
+				{"users", strconv.Itoa(result.Users), strconv.Itoa(result.SkippedUsers)},
+				{"medications", strconv.Itoa(result.Medications), strconv.Itoa(result.SkippedMedications)},
+				{"medication versions", strconv.Itoa(result.MedicationVersions), "0"},
+				{"doses", strconv.Itoa(result.Doses), "0"},
+			})
+		}
+	},
+}
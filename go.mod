@@ -4,8 +4,13 @@ go 1.16
 
 require (
 	github.com/dgraph-io/badger v1.6.2
-	github.com/dgraph-io/ristretto v0.0.4-0.20210122082011-bb5d392ed82d // indirect
+	github.com/dgraph-io/badger/v3 v3.2103.5
 	github.com/google/uuid v1.2.0
-	github.com/gregdel/pushover v1.1.0
+	github.com/mattn/go-sqlite3 v1.14.17
+	github.com/minio/minio-go/v7 v7.0.12
+	github.com/prometheus/client_golang v1.11.0
 	github.com/robfig/cron/v3 v3.0.1
+	github.com/sirupsen/logrus v1.8.1
+	golang.org/x/term v0.0.0-20210503060354-a79de5458b56
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	healthCheckInterval        = time.Minute
+	scheduleGracePeriod        = time.Minute * 5
+	maxConsecutiveSendFailures = 3
+)
+
+const (
+	alertSendFailures = "send_failures"
+	alertSchedule     = "schedule"
+	alertStartup      = "startup"
+	// alertQuota is suffixed with every warn threshold of the pushover quota
+	alertQuota           = "quota"
+	alertDisabledDevices = "disabled_devices"
+	alertValidation      = "device_validation"
+)
+
+type scheduledEntry struct {
+	description string
+	schedule    cron.Schedule
+	expected    time.Time
+}
+
+// health of the daemon reported by the health endpoints and watched by the
+// dead-man's switch
+type health struct {
+	b     db.Store
+	queue *queue
+
+	lock                sync.Mutex
+	adminDeviceToken    string
+	started             bool
+	entries             map[cron.EntryID]*scheduledEntry
+	consecutiveFailures int
+	alerted             map[string]bool
+}
+
+func newHealth(b db.Store, queue *queue, adminDeviceToken string) *health {
+	return &health{
+		b:                b,
+		queue:            queue,
+		adminDeviceToken: adminDeviceToken,
+		entries:          make(map[cron.EntryID]*scheduledEntry),
+		alerted:          make(map[string]bool),
+	}
+}
+
+func (h *health) setAdminDeviceToken(adminDeviceToken string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.adminDeviceToken = adminDeviceToken
+}
+
+func (h *health) getAdminDeviceToken() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.adminDeviceToken
+}
+
+// watch a cron entry so it can be reported when it fails to fire on time
+func (h *health) watch(id cron.EntryID, schedule cron.Schedule, description string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.entries[id] = &scheduledEntry{
+		description: description,
+		schedule:    schedule,
+	}
+}
+
+// start expecting the watched entries to fire from now on
+func (h *health) start(now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, entry := range h.entries {
+		entry.expected = entry.schedule.Next(now)
+	}
+
+	h.started = true
+}
+
+// fired marks the cron entry as having run at the given time
+func (h *health) fired(id cron.EntryID, now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	entry, ok := h.entries[id]
+	if !ok {
+		return
+	}
+
+	entry.expected = entry.schedule.Next(now)
+}
+
+// sent records the outcome of a reminder delivery
+func (h *health) sent(err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if err == nil {
+		h.consecutiveFailures = 0
+		return
+	}
+
+	h.consecutiveFailures++
+}
+
+// overdue entries that have not fired within the grace period of their
+// expected time
+func (h *health) overdue(now time.Time) (descriptions []string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if !h.started {
+		return nil
+	}
+
+	for _, entry := range h.entries {
+		if now.Sub(entry.expected) > scheduleGracePeriod {
+			descriptions = append(descriptions, entry.description)
+		}
+	}
+
+	sort.Strings(descriptions)
+
+	return descriptions
+}
+
+// monitor the scheduler and deliveries, alerting the admin device once each
+// time a problem starts
+func (h *health) monitor(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			overdue := h.overdue(now)
+			h.check(alertSchedule, len(overdue) > 0, fmt.Sprintf(
+				"meditime scheduler has not fired %d entries on time: %v",
+				len(overdue),
+				overdue,
+			))
+
+			h.lock.Lock()
+			consecutiveFailures := h.consecutiveFailures
+			h.lock.Unlock()
+
+			h.check(alertSendFailures, consecutiveFailures >= maxConsecutiveSendFailures, fmt.Sprintf(
+				"meditime failed to send the last %d reminders",
+				consecutiveFailures,
+			))
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check whether an alert is failing, queueing a notification to the admin
+// device once each time it starts failing
+func (h *health) check(alert string, failing bool, message string) {
+	h.lock.Lock()
+	alerted := h.alerted[alert]
+	h.alerted[alert] = failing
+	h.lock.Unlock()
+
+	if !failing || alerted {
+		return
+	}
+
+	h.alert(alert, message)
+}
+
+// alert the admin device through the queue
+func (h *health) alert(alert, message string) {
+	entry := logger.WithField("alert", alert)
+	entry.Error(message)
+
+	if h.getAdminDeviceToken() == "" {
+		return
+	}
+
+	err := h.queue.enqueue(&db.Notification{
+		Title:    "meditime alert",
+		Message:  message,
+		Priority: int(pushover.PriorityHigh),
+	})
+	if err != nil {
+		entry.WithError(err).Error("failed to queue alert to admin device")
+	}
+}
+
+func (h *health) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"scheduler": "ok",
+	}
+
+	if overdue := h.overdue(time.Now()); len(overdue) > 0 {
+		checks["scheduler"] = fmt.Sprintf("%d entries overdue", len(overdue))
+	}
+
+	writeChecks(w, checks)
+}
+
+func (h *health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"database":  "ok",
+		"scheduler": "ok",
+	}
+
+	if err := h.b.Ping(); err != nil {
+		checks["database"] = err.Error()
+	}
+
+	h.lock.Lock()
+	if !h.started {
+		checks["scheduler"] = "not started"
+	}
+	h.lock.Unlock()
+
+	writeChecks(w, checks)
+}
+
+func writeChecks(w http.ResponseWriter, checks map[string]string) {
+	status := http.StatusOK
+	for _, check := range checks {
+		if check != "ok" {
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": http.StatusText(status),
+		"checks": checks,
+	})
+	if err != nil {
+		logger.WithError(err).Warn("failed to write health checks")
+	}
+}
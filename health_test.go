@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"github.com/robfig/cron/v3"
+)
+
+// unreachableStore fails every ping
+type unreachableStore struct {
+	db.Store
+}
+
+func (s *unreachableStore) Ping() error {
+	return errors.New("database is unreachable")
+}
+
+// getChecks from the health handler, returning its status code and checks
+func getChecks(t *testing.T, handler http.HandlerFunc) (int, map[string]string) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("expected a JSON response, got %s", contentType)
+	}
+
+	response := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{}
+
+	err := json.NewDecoder(recorder.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Status != http.StatusText(recorder.Code) {
+		t.Fatalf("expected status %s to match the status code %d", response.Status, recorder.Code)
+	}
+
+	return recorder.Code, response.Checks
+}
+
+func TestHealthz(t *testing.T) {
+	h := newHealth(db.NewMemory(), nil, "")
+	h.watch(1, cron.Every(time.Minute), "reminder")
+
+	// entries aren't expected to fire before the scheduler started
+	code, checks := getChecks(t, h.handleHealthz)
+	if code != http.StatusOK || !reflect.DeepEqual(checks, map[string]string{"scheduler": "ok"}) {
+		t.Fatalf("expected a healthy scheduler before it started, got %d %v", code, checks)
+	}
+
+	h.start(time.Now())
+
+	code, checks = getChecks(t, h.handleHealthz)
+	if code != http.StatusOK || checks["scheduler"] != "ok" {
+		t.Fatalf("expected a healthy scheduler, got %d %v", code, checks)
+	}
+
+	// the entry was expected long before the grace period
+	h.start(time.Now().Add(-scheduleGracePeriod * 2))
+
+	code, checks = getChecks(t, h.handleHealthz)
+	if code != http.StatusServiceUnavailable || checks["scheduler"] != "1 entries overdue" {
+		t.Fatalf("expected an overdue entry to be unhealthy, got %d %v", code, checks)
+	}
+
+	h.fired(1, time.Now())
+
+	code, _ = getChecks(t, h.handleHealthz)
+	if code != http.StatusOK {
+		t.Fatalf("expected the scheduler to be healthy once the entry fired, got %d", code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	b := db.NewMemory()
+	h := newHealth(b, nil, "")
+
+	code, checks := getChecks(t, h.handleReadyz)
+	if code != http.StatusServiceUnavailable || checks["database"] != "ok" || checks["scheduler"] != "not started" {
+		t.Fatalf("expected not to be ready before the scheduler started, got %d %v", code, checks)
+	}
+
+	h.start(time.Now())
+
+	code, checks = getChecks(t, h.handleReadyz)
+	if code != http.StatusOK || !reflect.DeepEqual(checks, map[string]string{"database": "ok", "scheduler": "ok"}) {
+		t.Fatalf("expected to be ready, got %d %v", code, checks)
+	}
+
+	h.b = &unreachableStore{Store: b}
+
+	code, checks = getChecks(t, h.handleReadyz)
+	if code != http.StatusServiceUnavailable || checks["database"] != "database is unreachable" || checks["scheduler"] != "ok" {
+		t.Fatalf("expected not to be ready without the database, got %d %v", code, checks)
+	}
+}
@@ -1,339 +1,399 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"time"
 
 	"git.0xdad.com/tblyler/meditime/config"
 	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/metrics"
+	"git.0xdad.com/tblyler/meditime/pushover"
 	"github.com/google/uuid"
-	"github.com/gregdel/pushover"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
 )
 
-func errLog(messages ...interface{}) {
-	fmt.Fprintln(os.Stderr, messages...)
-}
+// daemonActor the changes made by the daemon are attributed to
+const daemonActor = "meditime"
 
-func log(messages ...interface{}) {
-	fmt.Println(messages...)
-}
+var logger = logrus.New()
 
-func help() {
-}
+func configureLogger(config config.Config) error {
+	level, err := config.LogLevel()
+	if err != nil {
+		return err
+	}
 
-func run(ctx context.Context, b *db.Badger, pushoverClient *pushover.Pushover) error {
-	cron := cron.New()
+	logLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %s: %w", level, err)
+	}
 
-	users, err := b.ListUsers()
+	format, err := config.LogFormat()
 	if err != nil {
 		return err
 	}
 
-	for _, user := range users {
-		medications, err := b.ListMedicationsForUser(user)
-		if err != nil {
-			return err
-		}
-
-		for _, medication := range medications {
-			_, err = cron.AddFunc(medication.IntervalCrontab, func() {
-				for _, device := range medication.IntervalPushoverDevices {
-					deviceToken, ok := user.PushoverDeviceTokens[device]
-					if !ok {
-						errLog(fmt.Sprintf(
-							"invalid device name %s for id medication %s for id user %s",
-							device,
-							medication.ID.String(),
-							user.ID.String(),
-						))
-						continue
-					}
-
-					_, err := pushoverClient.SendMessage(
-						&pushover.Message{
-							Message:  fmt.Sprintf("take %d dose(s) of %s", medication.IntervalQuantity, medication.Name),
-							Priority: pushover.PriorityEmergency,
-							Retry:    time.Minute * 5,
-							Expire:   time.Hour * 24,
-						},
-						pushover.NewRecipient(deviceToken),
-					)
-					if err != nil {
-						errLog(fmt.Sprintf(
-							"failed to send message to id user's (%s) device (%s): %v",
-							user.ID.String(),
-							device,
-							err,
-						))
-					}
-				}
-			})
-			if err != nil {
-				return fmt.Errorf("failed to add medication ID %s to cron: %w", medication.ID.String(), err)
-			}
-		}
+	switch format {
+	case "text":
+		logger.SetFormatter(&logrus.TextFormatter{})
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format %s, must be text or json", format)
 	}
 
-	cron.Start()
-	<-ctx.Done()
-	<-cron.Stop().Done()
+	logger.SetLevel(logLevel)
 
-	return ctx.Err()
+	return nil
 }
 
-func main() {
-	lenArgs := len(os.Args)
-	if lenArgs <= 1 {
-		help()
-		errLog("must supply at least one argument")
-		os.Exit(1)
+func run(ctx context.Context, config config.Config, b db.Store, pushoverClient *pushover.Client, hangup <-chan os.Signal) error {
+	listenAddress, err := config.ListenAddress()
+	if err != nil {
+		return err
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer cancel()
+	adminDeviceToken, err := config.AdminPushoverDeviceToken()
+	if err != nil {
+		return err
+	}
 
-	err := func() error {
-		inputScanner := bufio.NewScanner(os.Stdin)
-		config := config.Env{}
+	timeZone, err := config.DefaultTimeZone()
+	if err != nil {
+		return err
+	}
 
-		badgerPath, err := config.BadgerPath()
-		if err != nil {
-			return err
-		}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return fmt.Errorf("invalid default time zone %s: %w", timeZone, err)
+	}
 
-		pushoverAPIToken, err := config.PushoverAPIToken()
-		if err != nil {
-			return err
-		}
+	notificationRetry, err := config.NotificationRetry()
+	if err != nil {
+		return err
+	}
 
-		b, err := db.NewBadger(badgerPath)
-		if err != nil {
-			return err
-		}
+	notificationExpire, err := config.NotificationExpire()
+	if err != nil {
+		return err
+	}
 
-		defer b.Close()
+	notificationSound, err := config.NotificationSound()
+	if err != nil {
+		return err
+	}
 
-		pushoverClient := pushover.New(pushoverAPIToken)
+	queueWorkers, err := config.QueueWorkers()
+	if err != nil {
+		return err
+	}
 
-		switch os.Args[1] {
-		case "run":
-			return run(ctx, b, pushoverClient)
+	queueMaxAttempts, err := config.QueueMaxAttempts()
+	if err != nil {
+		return err
+	}
 
-		case "user":
-			if lenArgs < 3 {
-				return errors.New("must supply an argument to the user command")
-			}
+	queueBackoff, err := config.QueueBackoff()
+	if err != nil {
+		return err
+	}
 
-			switch os.Args[2] {
-			case "add":
-				fmt.Print("username: ")
-				inputScanner.Scan()
+	queueMaxBackoff, err := config.QueueMaxBackoff()
+	if err != nil {
+		return err
+	}
 
-				username := string(bytes.TrimSpace(inputScanner.Bytes()))
-				if username == "" {
-					return fmt.Errorf("failed to get username from STDIN prompt: %w", inputScanner.Err())
-				}
+	quotaWarnThresholds, err := config.QuotaWarnThresholds()
+	if err != nil {
+		return err
+	}
 
-				fmt.Print("pushover device token: ")
-				inputScanner.Scan()
+	quotaReserve, err := config.QuotaReserve()
+	if err != nil {
+		return err
+	}
 
-				deviceToken := string(bytes.TrimSpace(inputScanner.Bytes()))
-				if deviceToken == "" {
-					return fmt.Errorf("no pushover device token provided: %w", inputScanner.Err())
-				}
+	quotaPolicy, err := config.QuotaPolicy()
+	if err != nil {
+		return err
+	}
 
-				id := uuid.New()
-
-				err = b.AddUser(&db.User{
-					ID:   id,
-					Name: username,
-					PushoverDeviceTokens: map[string]string{
-						"default": deviceToken,
-					},
-					CreatedAt: time.Now(),
-				})
-				if err != nil {
-					return fmt.Errorf("failed to insert username %s: %w", username, err)
-				}
+	quotaFallbackURL, err := config.QuotaFallbackURL()
+	if err != nil {
+		return err
+	}
 
-				log("created user id", id)
+	validateInterval, err := config.PushoverValidateInterval()
+	if err != nil {
+		return err
+	}
 
-			case "get":
-				fmt.Print("username: ")
-				inputScanner.Scan()
+	quota, err := newPushoverQuota(quotaWarnThresholds, quotaReserve, quotaPolicy, quotaFallbackURL)
+	if err != nil {
+		return err
+	}
 
-				username := string(bytes.TrimSpace(inputScanner.Bytes()))
-				if username == "" {
-					return fmt.Errorf("failed to get username from STDIN prompt: %w", inputScanner.Err())
-				}
+	rotatingPushoverClient := &rotatingPushover{
+		client: pushoverClient,
+	}
 
-				user, err := b.GetUser(username)
-				if err != nil {
-					return err
-				}
+	queue, err := newQueue(b, rotatingPushoverClient, queueWorkers, queueMaxAttempts, queueBackoff, queueMaxBackoff)
+	if err != nil {
+		return err
+	}
 
-				if user == nil {
-					return fmt.Errorf("username %s does not exist", username)
-				}
+	ctx, cancel := context.WithCancel(ctx)
+
+	health := newHealth(b, queue, adminDeviceToken)
+	reminders := &reminders{
+		b:              b,
+		pushoverClient: rotatingPushoverClient,
+		queue:          queue,
+		retry:          notificationRetry,
+		expire:         notificationExpire,
+		sound:          notificationSound,
+		doses:          make(map[uuid.UUID]*watchedDose),
+	}
 
-				log(user)
+	queue.health = health
+	queue.reminders = reminders
+	queue.quota = quota
+	quota.health = health
 
-			case "list":
-				users, err := b.ListUsers()
-				if err != nil {
-					return err
-				}
+	// stop delivering and following receipts before the database is closed
+	defer func() {
+		cancel()
+		queue.wait()
+		reminders.wg.Wait()
+	}()
 
-				for _, user := range users {
-					fmt.Println(user)
-				}
-			}
+	if sizer, ok := db.Unwrap(b).(metrics.Sizer); ok {
+		err = metrics.RegisterBadgerSize(sizer)
+		if err != nil {
+			return fmt.Errorf("failed to register badger metrics: %w", err)
+		}
+	}
 
-		case "medication":
-			if lenArgs < 3 {
-				return errors.New("must supply an argument to the user command")
-			}
+	scheduler := cron.New(cron.WithLocation(location))
 
-			switch os.Args[2] {
-			case "add":
-				fmt.Print("username: ")
-				inputScanner.Scan()
+	users, err := b.ListUsers()
+	if err != nil {
+		return err
+	}
 
-				username := string(bytes.TrimSpace(inputScanner.Bytes()))
-				if username == "" {
-					return fmt.Errorf("failed to get username from STDIN prompt: %w", inputScanner.Err())
-				}
+	notifications, err := b.ListNotifications()
+	if err != nil {
+		return err
+	}
 
-				user, err := b.GetUser(username)
-				if err != nil {
-					return fmt.Errorf("failed to lookup username %s: %w", username, err)
-				}
+	// reminders left in the queue keep their doses from being missed
+	queued := make(map[uuid.UUID]int)
+	for _, notification := range notifications {
+		if notification.Status == db.NotificationStatusPending && !notification.IsAlert() {
+			queued[notification.IDDose]++
+		}
+	}
 
-				if user == nil {
-					return fmt.Errorf("username %s doesn't exist", username)
-				}
+	report := &startupReport{}
+	for _, user := range users {
+		user := user
 
-				fmt.Print("name: ")
-				inputScanner.Scan()
+		err = reminders.resume(ctx, user, queued)
+		if err != nil {
+			return fmt.Errorf("failed to resume pending doses for id user %s: %w", user.ID.String(), err)
+		}
 
-				name := string(bytes.TrimSpace(inputScanner.Bytes()))
-				if name == "" {
-					return fmt.Errorf("failed to get medication name from STDIN prompt: %w", inputScanner.Err())
-				}
+		medications, err := b.ListMedicationsForUser(user)
+		if err != nil {
+			return err
+		}
 
-				fmt.Print("cron schedule: ")
-				inputScanner.Scan()
+		for _, medication := range medications {
+			medication := medication
 
-				crontab := string(bytes.TrimSpace(inputScanner.Bytes()))
-				if crontab == "" {
-					return fmt.Errorf("failed to get cron schedule from STDIN prompt: %w", inputScanner.Err())
+			problem := validateMedication(user, medication)
+			if problem != nil {
+				report.problems = append(report.problems, problem)
+				if problem.skipped {
+					continue
 				}
+			}
 
-				fmt.Print("interval quantity: ")
-				inputScanner.Scan()
+			var entryID cron.EntryID
+			entryID, err = scheduler.AddFunc(medication.IntervalCrontab, func() {
+				health.fired(entryID, time.Now().In(location))
+				reminders.send(ctx, entryID, user, medication)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to add medication ID %s to cron: %w", medication.ID.String(), err)
+			}
 
-				intervalQuantity, err := strconv.ParseUint(string(bytes.TrimSpace(inputScanner.Bytes())), 10, 64)
-				if intervalQuantity == 0 || err != nil {
-					return fmt.Errorf("failed to get interval quantity from STDIN prompt: %w", inputScanner.Err())
-				}
+			report.scheduled++
+
+			logger.WithFields(logrus.Fields{
+				"cron_entry_id": entryID,
+				"user_id":       user.ID.String(),
+				"medication_id": medication.ID.String(),
+				"crontab":       medication.IntervalCrontab,
+			}).Info("scheduled medication")
+
+			health.watch(entryID, scheduler.Entry(entryID).Schedule, fmt.Sprintf(
+				"medication %s (%s) for user %s",
+				medication.Name,
+				medication.ID.String(),
+				user.Name,
+			))
+		}
+	}
 
-				fmt.Print("interval pushover device token name: ")
-				inputScanner.Scan()
+	report.send(health)
 
-				intervalPushoverDevice := string(bytes.TrimSpace(inputScanner.Bytes()))
-				if _, ok := user.PushoverDeviceTokens[intervalPushoverDevice]; !ok {
-					return fmt.Errorf("the '%s' pushover device token name doesn't exist for user %s", intervalPushoverDevice, user.Name)
-				}
+	err = scheduleBackups(ctx, config, scheduler, health, b)
+	if err != nil {
+		return err
+	}
 
-				medication := &db.Medication{
-					IDUser:                  user.ID,
-					ID:                      uuid.New(),
-					Name:                    name,
-					IntervalCrontab:         crontab,
-					IntervalQuantity:        uint(intervalQuantity),
-					IntervalPushoverDevices: []string{intervalPushoverDevice},
-					CreatedAt:               time.Now(),
-				}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", health.handleHealthz)
+	mux.HandleFunc("/readyz", health.handleReadyz)
 
-				err = b.AddMedication(medication)
-				if err != nil {
-					return err
-				}
+	server := &http.Server{
+		Addr:    listenAddress,
+		Handler: mux,
+	}
 
-				log(medication)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
 
-			case "remove":
-				fmt.Print("username: ")
-				inputScanner.Scan()
+	logger.WithField("listen_address", listenAddress).Info("http server listening")
 
-				username := string(bytes.TrimSpace(inputScanner.Bytes()))
-				if username == "" {
-					return fmt.Errorf("failed to get username from STDIN prompt: %w", inputScanner.Err())
-				}
+	scheduler.Start()
+	health.start(time.Now().In(location))
 
-				user, err := b.GetUser(username)
-				if err != nil {
-					return fmt.Errorf("failed to lookup username %s: %w", username, err)
-				}
+	queue.run(ctx)
+	go health.monitor(ctx)
+	go reloadOnHangup(ctx, hangup, config, rotatingPushoverClient, health)
+	go validateDevices(ctx, b, rotatingPushoverClient, health, validateInterval)
 
-				if user == nil {
-					return fmt.Errorf("username %s doesn't exist", username)
-				}
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
 
-				fmt.Print("medication id: ")
-				inputScanner.Scan()
+	case err = <-serverErr:
+		err = fmt.Errorf("http server on %s failed: %w", listenAddress, err)
+	}
 
-				medicationID := uuid.MustParse(string(bytes.TrimSpace(inputScanner.Bytes())))
-				err = b.RemoveMedication(&db.Medication{
-					IDUser: user.ID,
-					ID:     medicationID,
-				})
-				if err != nil {
-					return err
-				}
+	<-scheduler.Stop().Done()
 
-			case "list":
-				fmt.Print("username: ")
-				inputScanner.Scan()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer shutdownCancel()
 
-				username := string(bytes.TrimSpace(inputScanner.Bytes()))
-				if username == "" {
-					return fmt.Errorf("failed to get username from STDIN prompt: %w", inputScanner.Err())
-				}
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		logger.WithError(shutdownErr).Warn("failed to shutdown http server")
+	}
 
-				user, err := b.GetUser(username)
-				if err != nil {
-					return fmt.Errorf("failed to lookup username %s: %w", username, err)
-				}
+	return err
+}
 
-				if user == nil {
-					return fmt.Errorf("username %s doesn't exist", username)
-				}
+var runCommand = &command{
+	name:    "run",
+	summary: "Run the daemon that sends the medication reminders",
+	setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+		return func() error {
+			// a SIGHUP while starting up is handled once the daemon started
+			// rather than terminating it
+			hangup, stopHangup := notifyHangup()
+			defer stopHangup()
+
+			pushoverClient, err := newPushoverClient(c.config)
+			if err != nil {
+				return err
+			}
 
-				medications, err := b.ListMedicationsForUser(user)
-				if err != nil {
-					return err
-				}
+			c.actor = daemonActor
 
-				for _, medication := range medications {
-					log(medication)
-				}
+			b, err := c.db()
+			if err != nil {
+				return err
 			}
+
+			return run(c.ctx, c.config, b, pushoverClient, hangup)
 		}
+	},
+}
 
-		return nil
+// rootFlags are parsed on a flag set of their own, leaving out the flags
+// dependencies register on flag.CommandLine
+var rootFlags = flag.NewFlagSet("meditime", flag.ExitOnError)
+
+var rootCommand = &command{
+	name:    "meditime",
+	summary: "Medication reminders delivered through pushover",
+	flags:   rootFlags,
+	subcommands: []*command{
+		runCommand,
+		userCommand,
+		medicationCommand,
+		doseCommand,
+		queueCommand,
+		dbCommand,
+		exportCommand,
+		importCommand,
+		backupCommand,
+		restoreCommand,
+		auditCommand,
+		devCommand,
+	},
+}
+
+func main() {
+	flags := config.NewFlags(rootFlags)
+	rootFlags.Usage = func() {
+		rootCommand.usage(rootFlags.Output(), []string{rootCommand.name})
+	}
+
+	// ExitOnError exits on a failure to parse
+	_ = rootFlags.Parse(os.Args[1:])
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	err := func() error {
+		config, err := config.Load(flags)
+		if err != nil {
+			return err
+		}
+
+		err = configureLogger(config)
+		if err != nil {
+			return err
+		}
+
+		c := newCLI(ctx, config)
+		defer c.close()
+
+		return rootCommand.execute(c, nil, rootFlags.Args())
 	}()
 
-	if err != nil {
-		errLog(err.Error())
+	if err != nil && !isHelp(err) {
+		logger.Error(err.Error())
+
+		var exit *exitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
+
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/config"
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"git.0xdad.com/tblyler/meditime/pushover/pushovertest"
+	"github.com/google/uuid"
+)
+
+// testConfig of the CLI in tests, keeping everything in memory and talking
+// to a fake pushover server
+type testConfig struct {
+	config.Defaults
+	pushoverURL string
+}
+
+func (c *testConfig) Store() (string, error) {
+	return storeMemory, nil
+}
+
+func (c *testConfig) PushoverAPIToken() (string, error) {
+	return "token", nil
+}
+
+func (c *testConfig) PushoverURL() (string, error) {
+	return c.pushoverURL, nil
+}
+
+func (c *testConfig) Actor() (string, error) {
+	return "tester", nil
+}
+
+// newFakePushover accepting the API token "token" and the user key
+// "alice-key" with a phone and a tablet
+func newFakePushover(t *testing.T) (*pushovertest.Server, string) {
+	t.Helper()
+
+	fake := pushovertest.NewServer("token")
+	fake.AddUser("alice-key", "phone", "tablet")
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return fake, server.URL + "/1/"
+}
+
+func newPushover(t *testing.T, url string) *pushover.Client {
+	t.Helper()
+
+	client, err := pushover.New("token", url, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+// storeConfig of a store kept in a directory
+type storeConfig struct {
+	config.Defaults
+	store       string
+	dir         string
+	pushoverURL string
+}
+
+func (c *storeConfig) Store() (string, error) {
+	return c.store, nil
+}
+
+func (c *storeConfig) BadgerPath() (string, error) {
+	return filepath.Join(c.dir, "badger"), nil
+}
+
+func (c *storeConfig) SQLitePath() (string, error) {
+	return filepath.Join(c.dir, "meditime.db"), nil
+}
+
+func (c *storeConfig) PushoverAPIToken() (string, error) {
+	return "token", nil
+}
+
+func (c *storeConfig) PushoverURL() (string, error) {
+	return c.pushoverURL, nil
+}
+
+func (c *storeConfig) Actor() (string, error) {
+	return "tester", nil
+}
+
+// runAlongsideDaemon the command against the store of config, which the
+// daemon may have open
+func runAlongsideDaemon(config *storeConfig, args ...string) error {
+	c := newCLI(context.Background(), config)
+	c.stdout = &bytes.Buffer{}
+	c.stderr = &bytes.Buffer{}
+	c.interactive = false
+	defer c.close()
+
+	return runCLI(c, args...)
+}
+
+// newTestCLI with an empty memory store, its output is collected in stdout
+func newTestCLI(t *testing.T, url string) (*cli, *bytes.Buffer) {
+	t.Helper()
+
+	stdout := &bytes.Buffer{}
+
+	c := newCLI(context.Background(), &testConfig{pushoverURL: url})
+	c.stdout = stdout
+	c.stderr = &bytes.Buffer{}
+	c.interactive = false
+	c.store = db.NewMemory()
+
+	return c, stdout
+}
+
+func runCLI(c *cli, args ...string) error {
+	return rootCommand.execute(c, nil, args)
+}
+
+// testDaemon is the queue and reminders of run against a fake pushover
+// server, following receipts every few milliseconds
+type testDaemon struct {
+	b         db.Store
+	client    *pushover.Client
+	queue     *queue
+	reminders *reminders
+	health    *health
+	quota     *pushoverQuota
+	ctx       context.Context
+}
+
+func newTestDaemon(t *testing.T, b db.Store, url string, quota *pushoverQuota) *testDaemon {
+	t.Helper()
+
+	pollInterval := receiptPollInterval
+	receiptPollInterval = time.Millisecond * 20
+	t.Cleanup(func() {
+		receiptPollInterval = pollInterval
+	})
+
+	if quota == nil {
+		var err error
+		quota, err = newPushoverQuota("", 0, quotaPolicySend, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := newPushover(t, url)
+	rotating := &rotatingPushover{client: client}
+
+	q, err := newQueue(b, rotating, 2, 3, time.Millisecond*10, time.Millisecond*40)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	health := newHealth(b, q, "alice-key")
+	reminders := &reminders{
+		b:              b,
+		pushoverClient: rotating,
+		queue:          q,
+		retry:          time.Minute,
+		expire:         time.Hour,
+		doses:          make(map[uuid.UUID]*watchedDose),
+	}
+
+	q.health = health
+	q.reminders = reminders
+	q.quota = quota
+	quota.health = health
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		q.wait()
+		reminders.wg.Wait()
+	})
+
+	return &testDaemon{
+		b:         b,
+		client:    client,
+		queue:     q,
+		reminders: reminders,
+		health:    health,
+		quota:     quota,
+		ctx:       ctx,
+	}
+}
+
+// addUser alice with a phone and a tablet device restricted to the pushover
+// devices of the same name
+func addUser(t *testing.T, b db.Store) *db.User {
+	t.Helper()
+
+	user := &db.User{
+		ID:   uuid.New(),
+		Name: "alice",
+		PushoverDevices: map[string]*db.PushoverDevice{
+			"phone":  {Key: "alice-key", Kind: db.PushoverKeyUser, Restrict: []string{"phone"}},
+			"tablet": {Key: "alice-key", Kind: db.PushoverKeyUser, Restrict: []string{"tablet"}},
+		},
+		CreatedAt: time.Now(),
+	}
+
+	err := b.AddUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func addMedication(t *testing.T, b db.Store, user *db.User, devices ...string) *db.Medication {
+	t.Helper()
+
+	medication := &db.Medication{
+		IDUser:                  user.ID,
+		ID:                      uuid.New(),
+		Name:                    "aspirin",
+		IntervalCrontab:         "0 8 * * *",
+		IntervalQuantity:        1,
+		IntervalPushoverDevices: devices,
+		CreatedAt:               time.Now(),
+	}
+
+	err := b.AddMedication(medication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return medication
+}
+
+// eventually fails the test unless condition holds within a few seconds
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+// expectCancelled receipts, which the fake reports as expired
+func expectCancelled(t *testing.T, client *pushover.Client, receipts ...string) {
+	t.Helper()
+
+	for _, receipt := range receipts {
+		details, err := client.GetReceipt(context.Background(), receipt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !details.Expired || details.Acknowledged {
+			t.Fatalf("expected receipt %s to be cancelled, got %+v", receipt, details)
+		}
+	}
+}
+
+func TestRootUsage(t *testing.T) {
+	usage := &bytes.Buffer{}
+	rootCommand.usage(usage, []string{rootCommand.name})
+
+	// registered on flag.CommandLine by glog, a dependency of badger
+	for _, name := range []string{"log_dir", "logtostderr", "vmodule", "stderrthreshold"} {
+		if strings.Contains(usage.String(), "-"+name) {
+			t.Fatalf("expected the root usage to leave out the -%s flag of a dependency, got:\n%s", name, usage)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+var medicationHeader = []string{"ID", "NAME", "SCHEDULE", "QUANTITY", "DEVICES", "CRITICAL", "STATUS", "CREATED"}
+
+func medicationRow(medication *db.Medication) []string {
+	return []string{
+		medication.ID.String(),
+		medication.Name,
+		medication.IntervalCrontab,
+		strconv.FormatUint(uint64(medication.IntervalQuantity), 10),
+		strings.Join(medication.IntervalPushoverDevices, ","),
+		strconv.FormatBool(medication.Critical),
+		pauseStatus(medication.Pause, time.Now()),
+		medication.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+var medicationHistoryHeader = []string{"REPLACED", "BY", "NAME", "SCHEDULE", "QUANTITY", "DEVICES"}
+
+func medicationHistoryRow(version *db.MedicationVersion) []string {
+	row := medicationRow(&version.Medication)
+
+	return append([]string{version.ReplacedAt.Format(time.RFC3339), version.ReplacedBy}, row[1:5]...)
+}
+
+// validateSchedule as a standard cron expression
+func validateSchedule(schedule string) error {
+	_, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return fmt.Errorf("invalid cron schedule '%s': %w", schedule, err)
+	}
+
+	return nil
+}
+
+// promptMedication for the id flag and look the user's medication up
+func (c *cli) promptMedication(user *db.User, id *string) (*db.Medication, error) {
+	err := c.prompt(id, "id", "medication id")
+	if err != nil {
+		return nil, err
+	}
+
+	medicationID, err := uuid.Parse(*id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid medication id %s: %w", *id, err)
+	}
+
+	b, err := c.db()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.GetMedicationForUser(user, medicationID)
+}
+
+var medicationCommand = &command{
+	name:    "medication",
+	summary: "Manage the medications of a user",
+	subcommands: []*command{
+		{
+			name:    "add",
+			summary: "Add a medication to a user",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				name := flagSet.String("name", "", "name of the medication")
+				schedule := flagSet.String("schedule", "", "cron schedule of the reminders, e.g. '0 8 * * *'")
+				quantity := flagSet.String("quantity", "", "number of doses to take at every reminder")
+				device := flagSet.String("device", "", "name of the user's pushover device to remind")
+				critical := flagSet.Bool("critical", false, "always remind through pushover, even once its quota runs low")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(name, "name", "name")
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(schedule, "schedule", "cron schedule")
+					if err != nil {
+						return err
+					}
+
+					err = validateSchedule(*schedule)
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(quantity, "quantity", "interval quantity")
+					if err != nil {
+						return err
+					}
+
+					intervalQuantity, err := strconv.ParseUint(*quantity, 10, 64)
+					if intervalQuantity == 0 || err != nil {
+						return fmt.Errorf("interval quantity must be a positive integer, got '%s'", *quantity)
+					}
+
+					err = c.prompt(device, "device", "interval pushover device token name")
+					if err != nil {
+						return err
+					}
+
+					if !user.HasDevice(*device) {
+						return fmt.Errorf("the '%s' pushover device token name doesn't exist for user %s", *device, user.Name)
+					}
+
+					medication := &db.Medication{
+						IDUser:                  user.ID,
+						ID:                      uuid.New(),
+						Name:                    *name,
+						IntervalCrontab:         *schedule,
+						IntervalQuantity:        uint(intervalQuantity),
+						IntervalPushoverDevices: []string{*device},
+						Critical:                *critical,
+						CreatedAt:               time.Now(),
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					err = b.AddMedication(medication)
+					if err != nil {
+						return err
+					}
+
+					return c.print(*output, medication, medicationHeader, [][]string{medicationRow(medication)})
+				}
+			},
+		},
+		{
+			name:    "remove",
+			summary: "Remove a medication from a user",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				id := flagSet.String("id", "", "ID of the medication")
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(id, "id", "medication id")
+					if err != nil {
+						return err
+					}
+
+					medicationID, err := uuid.Parse(*id)
+					if err != nil {
+						return fmt.Errorf("invalid medication id %s: %w", *id, err)
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					return b.RemoveMedication(&db.Medication{
+						IDUser: user.ID,
+						ID:     medicationID,
+					})
+				}
+			},
+		},
+		{
+			name:    "edit",
+			summary: "Edit a medication in place, keeping the previous version in its history",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				id := flagSet.String("id", "", "ID of the medication")
+				name := flagSet.String("name", "", "new name of the medication")
+				schedule := flagSet.String("schedule", "", "new cron schedule of the reminders")
+				quantity := flagSet.String("quantity", "", "new number of doses to take at every reminder")
+				devices := flagSet.String("devices", "", "new comma separated names of the user's pushover devices to remind")
+				critical := flagSet.String("critical", "", "whether to always remind through pushover, even once its quota runs low, true or false")
+				author := flagSet.String("author", "", "who is making the change, defaults to the actor")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					medication, err := c.promptMedication(user, id)
+					if err != nil {
+						return err
+					}
+
+					if *name == "" && *schedule == "" && *quantity == "" && *devices == "" && *critical == "" {
+						return errors.New("nothing to change, give at least one of -name, -schedule, -quantity, -devices or -critical")
+					}
+
+					if *name != "" {
+						medication.Name = *name
+					}
+
+					if *schedule != "" {
+						err = validateSchedule(*schedule)
+						if err != nil {
+							return err
+						}
+
+						medication.IntervalCrontab = *schedule
+					}
+
+					if *quantity != "" {
+						intervalQuantity, err := strconv.ParseUint(*quantity, 10, 64)
+						if intervalQuantity == 0 || err != nil {
+							return fmt.Errorf("interval quantity must be a positive integer, got '%s'", *quantity)
+						}
+
+						medication.IntervalQuantity = uint(intervalQuantity)
+					}
+
+					if *devices != "" {
+						medication.IntervalPushoverDevices = splitPushoverDevices(*devices)
+						if len(medication.IntervalPushoverDevices) == 0 {
+							return fmt.Errorf("no pushover device token names in '%s'", *devices)
+						}
+
+						for _, device := range medication.IntervalPushoverDevices {
+							if !user.HasDevice(device) {
+								return fmt.Errorf("the '%s' pushover device token name doesn't exist for user %s", device, user.Name)
+							}
+						}
+					}
+
+					if *critical != "" {
+						medication.Critical, err = strconv.ParseBool(*critical)
+						if err != nil {
+							return fmt.Errorf("critical must be true or false, got '%s'", *critical)
+						}
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					if *author == "" {
+						*author, err = c.config.Actor()
+						if err != nil {
+							return err
+						}
+					}
+
+					err = b.UpdateMedication(medication, *author)
+					if err != nil {
+						return err
+					}
+
+					return c.print(*output, medication, medicationHeader, [][]string{medicationRow(medication)})
+				}
+			},
+		},
+		medicationPauseCommand,
+		medicationResumeCommand,
+		{
+			name:    "history",
+			summary: "List the prior versions of a medication",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				id := flagSet.String("id", "", "ID of the medication")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					medication, err := c.promptMedication(user, id)
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					versions, err := b.ListMedicationHistory(medication)
+					if err != nil {
+						return err
+					}
+
+					rows := make([][]string, 0, len(versions))
+					for _, version := range versions {
+						rows = append(rows, medicationHistoryRow(version))
+					}
+
+					if versions == nil {
+						versions = []*db.MedicationVersion{}
+					}
+
+					return c.print(*output, versions, medicationHistoryHeader, rows)
+				}
+			},
+		},
+		{
+			name:    "list",
+			summary: "List the medications of a user",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					medications, err := b.ListMedicationsForUser(user)
+					if err != nil {
+						return err
+					}
+
+					rows := make([][]string, 0, len(medications))
+					for _, medication := range medications {
+						rows = append(rows, medicationRow(medication))
+					}
+
+					if medications == nil {
+						medications = []*db.Medication{}
+					}
+
+					return c.print(*output, medications, medicationHeader, rows)
+				}
+			},
+		},
+	},
+}
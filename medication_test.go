@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMedicationEditDevices(t *testing.T) {
+	_, url := newFakePushover(t)
+	c, _ := newTestCLI(t, url)
+
+	b, err := c.db()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := addUser(t, b)
+	medication := addMedication(t, b, user, "phone")
+
+	err = runCLI(c, "medication", "edit", "-username", "alice", "-id", medication.ID.String(), "-devices", "phone, tablet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited, err := b.GetMedicationForUser(user, medication.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(edited.IntervalPushoverDevices, []string{"phone", "tablet"}) {
+		t.Fatalf("expected the devices to be trimmed, got %q", edited.IntervalPushoverDevices)
+	}
+
+	for devices, problem := range map[string]string{
+		"phone, watch": "'watch' pushover device token name doesn't exist",
+		" , ":          "no pushover device token names",
+	} {
+		err = runCLI(c, "medication", "edit", "-username", "alice", "-id", medication.ID.String(), "-devices", devices)
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Fatalf("expected editing the devices to %q to fail with %q, got %v", devices, problem, err)
+		}
+	}
+}
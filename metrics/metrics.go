@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "meditime"
+
+var (
+	// RemindersScheduled counts reminders handed to a notifier by the scheduler
+	RemindersScheduled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reminders_scheduled_total",
+		Help:      "Number of reminders handed to a notifier by the scheduler.",
+	}, []string{"notifier"})
+
+	// RemindersSent counts reminders a notifier accepted
+	RemindersSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reminders_sent_total",
+		Help:      "Number of reminders successfully sent by a notifier.",
+	}, []string{"notifier"})
+
+	// RemindersFailed counts reminders a notifier failed to send
+	RemindersFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reminders_failed_total",
+		Help:      "Number of reminders a notifier failed to send.",
+	}, []string{"notifier"})
+
+	// RemindersExpired counts reminders that expired without being acknowledged
+	RemindersExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reminders_expired_total",
+		Help:      "Number of reminders that expired without being acknowledged.",
+	}, []string{"notifier"})
+
+	// RemindersCancelled counts reminders cancelled since their dose was
+	// recorded or acknowledged on another device
+	RemindersCancelled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reminders_cancelled_total",
+		Help:      "Number of reminders cancelled since their dose was recorded or acknowledged on another device.",
+	}, []string{"notifier"})
+
+	// SendDuration of a single reminder delivery attempt
+	SendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "send_duration_seconds",
+		Help:      "Time taken by a notifier to send a reminder.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"notifier"})
+
+	// AcknowledgementDuration between sending a reminder and it being acknowledged
+	AcknowledgementDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "acknowledgement_duration_seconds",
+		Help:      "Time between a reminder being sent and it being acknowledged.",
+		Buckets:   []float64{30, 60, 120, 300, 600, 900, 1800, 3600, 7200, 14400, 28800, 86400},
+	}, []string{"notifier"})
+
+	// Adherence ratio of taken doses to resolved doses per user
+	Adherence = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "adherence_ratio",
+		Help:      "Ratio of taken doses to resolved (taken or missed) doses over the trailing adherence window.",
+	}, []string{"user_id"})
+
+	// BadgerGCRuns counts value log garbage collection attempts by result
+	BadgerGCRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "badger",
+		Name:      "gc_runs_total",
+		Help:      "Number of badger value log garbage collection runs by result.",
+	}, []string{"result"})
+
+	// Backups counts scheduled backups by result
+	Backups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backups_total",
+		Help:      "Number of scheduled database backups by result.",
+	}, []string{"result"})
+
+	// QueueLength of notifications in the outbound queue by status
+	QueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "length",
+		Help:      "Number of notifications in the outbound queue by status.",
+	}, []string{"status"})
+
+	// QueueRetries counts failed delivery attempts of queued notifications
+	// that will be retried
+	QueueRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "retries_total",
+		Help:      "Number of failed delivery attempts of queued notifications that will be retried.",
+	})
+
+	// QueueDeadLettered counts queued notifications that were given up on
+	QueueDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "queue",
+		Name:      "dead_lettered_total",
+		Help:      "Number of queued notifications that were given up on and dead-lettered.",
+	})
+
+	// RemindersSuppressed counts non-critical reminders that were not sent
+	// because the pushover quota ran low
+	RemindersSuppressed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reminders_suppressed_total",
+		Help:      "Number of non-critical reminders that were not sent because the pushover quota ran low.",
+	}, []string{"notifier"})
+
+	// PushoverQuotaLimit of messages per month as last reported by pushover
+	PushoverQuotaLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "pushover_quota",
+		Name:      "limit",
+		Help:      "Number of pushover messages the application may send every month.",
+	})
+
+	// PushoverQuotaRemaining messages until the quota resets as last reported
+	// by pushover
+	PushoverQuotaRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "pushover_quota",
+		Name:      "remaining",
+		Help:      "Number of pushover messages remaining until the monthly quota resets.",
+	})
+
+	// PushoverQuotaReset time of the monthly quota
+	PushoverQuotaReset = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "pushover_quota",
+		Name:      "reset_timestamp_seconds",
+		Help:      "Unix time the pushover monthly quota resets at.",
+	})
+
+	// LastBackup time of the last successful scheduled backup
+	LastBackup = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_backup_timestamp_seconds",
+		Help:      "Unix time of the last successful scheduled database backup.",
+	})
+)
+
+// Sizer reports the on disk size of a database
+type Sizer interface {
+	Size() (lsm, vlog int64)
+}
+
+// RegisterBadgerSize exposes the LSM and value log sizes of the given database
+func RegisterBadgerSize(sizer Sizer) error {
+	err := prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "badger",
+		Name:      "lsm_size_bytes",
+		Help:      "Size of the badger LSM tree in bytes.",
+	}, func() float64 {
+		lsm, _ := sizer.Size()
+		return float64(lsm)
+	}))
+	if err != nil {
+		return err
+	}
+
+	return prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "badger",
+		Name:      "vlog_size_bytes",
+		Help:      "Size of the badger value log in bytes.",
+	}, func() float64 {
+		_, vlog := sizer.Size()
+		return float64(vlog)
+	}))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+)
+
+const resumeDateLayout = "2006-01-02"
+
+func pauseStatus(pause db.Pause, now time.Time) string {
+	if !pause.IsPaused(now) {
+		return "active"
+	}
+
+	if pause.ResumeAt == nil {
+		return "paused"
+	}
+
+	return "paused until " + pause.ResumeAt.Format(time.RFC3339)
+}
+
+// resumeAt parses the -until flag as either an RFC 3339 timestamp or a date
+// that is resumed at midnight in the default time zone
+func (c *cli) resumeAt(until string) (*time.Time, error) {
+	if until == "" {
+		return nil, nil
+	}
+
+	resumeAt, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		resumeAt, err = c.parseResumeDate(until)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !resumeAt.After(time.Now()) {
+		return nil, fmt.Errorf("resume time %s is in the past", resumeAt.Format(time.RFC3339))
+	}
+
+	return &resumeAt, nil
+}
+
+// parseResumeDate at midnight in the default time zone
+func (c *cli) parseResumeDate(until string) (time.Time, error) {
+	timeZone, err := c.config.DefaultTimeZone()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid default time zone %s: %w", timeZone, err)
+	}
+
+	resumeAt, err := time.ParseInLocation(resumeDateLayout, until, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid resume time '%s', must be a %s date or an RFC 3339 timestamp", until, resumeDateLayout)
+	}
+
+	return resumeAt, nil
+}
+
+func untilFlag(flagSet *flag.FlagSet) *string {
+	return flagSet.String("until", "", "automatically resume at this date (2006-01-02) or RFC 3339 timestamp")
+}
+
+var userPauseCommand = &command{
+	name:    "pause",
+	summary: "Pause the reminders of all of a user's medications",
+	setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+		username := flagSet.String("username", "", "name of the user")
+		until := untilFlag(flagSet)
+
+		return func() error {
+			user, err := c.promptUser(username)
+			if err != nil {
+				return err
+			}
+
+			resumeAt, err := c.resumeAt(*until)
+			if err != nil {
+				return err
+			}
+
+			b, err := c.db()
+			if err != nil {
+				return err
+			}
+
+			return b.PauseUser(user, resumeAt)
+		}
+	},
+}
+
+var userResumeCommand = &command{
+	name:    "resume",
+	summary: "Resume the reminders of a paused user",
+	setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+		username := flagSet.String("username", "", "name of the user")
+
+		return func() error {
+			user, err := c.promptUser(username)
+			if err != nil {
+				return err
+			}
+
+			b, err := c.db()
+			if err != nil {
+				return err
+			}
+
+			return b.ResumeUser(user)
+		}
+	},
+}
+
+var medicationPauseCommand = &command{
+	name:    "pause",
+	summary: "Pause the reminders of a medication",
+	setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+		username := flagSet.String("username", "", "name of the user")
+		id := flagSet.String("id", "", "ID of the medication")
+		until := untilFlag(flagSet)
+
+		return func() error {
+			user, err := c.promptUser(username)
+			if err != nil {
+				return err
+			}
+
+			medication, err := c.promptMedication(user, id)
+			if err != nil {
+				return err
+			}
+
+			resumeAt, err := c.resumeAt(*until)
+			if err != nil {
+				return err
+			}
+
+			b, err := c.db()
+			if err != nil {
+				return err
+			}
+
+			return b.PauseMedication(medication, resumeAt)
+		}
+	},
+}
+
+var medicationResumeCommand = &command{
+	name:    "resume",
+	summary: "Resume the reminders of a paused medication",
+	setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+		username := flagSet.String("username", "", "name of the user")
+		id := flagSet.String("id", "", "ID of the medication")
+
+		return func() error {
+			user, err := c.promptUser(username)
+			if err != nil {
+				return err
+			}
+
+			medication, err := c.promptMedication(user, id)
+			if err != nil {
+				return err
+			}
+
+			b, err := c.db()
+			if err != nil {
+				return err
+			}
+
+			return b.ResumeMedication(medication)
+		}
+	},
+}
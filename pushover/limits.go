@@ -0,0 +1,42 @@
+package pushover
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits of the messages an application may send every month, as reported
+// by the X-Limit-App-* headers of sent messages
+type Limits struct {
+	// Limit of messages per month
+	Limit int
+	// Remaining messages until Reset
+	Remaining int
+	// Reset of the remaining messages to the limit
+	Reset time.Time
+}
+
+// parseLimits from the headers, nil when any of them is missing or invalid
+func parseLimits(headers http.Header) *Limits {
+	limit, err := strconv.Atoi(headers.Get("X-Limit-App-Limit"))
+	if err != nil {
+		return nil
+	}
+
+	remaining, err := strconv.Atoi(headers.Get("X-Limit-App-Remaining"))
+	if err != nil {
+		return nil
+	}
+
+	reset, err := strconv.ParseInt(headers.Get("X-Limit-App-Reset"), 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	return &Limits{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Unix(reset, 0),
+	}
+}
@@ -0,0 +1,157 @@
+package pushover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// Priority of a message
+type Priority int
+
+const (
+	// PriorityLowest messages generate no notification
+	PriorityLowest Priority = -2
+	// PriorityLow messages are delivered quietly
+	PriorityLow Priority = -1
+	// PriorityNormal messages follow the user's quiet hours
+	PriorityNormal Priority = 0
+	// PriorityHigh messages bypass the user's quiet hours
+	PriorityHigh Priority = 1
+	// PriorityEmergency messages repeat until they are acknowledged or expire
+	PriorityEmergency Priority = 2
+)
+
+const (
+	// MaxMessageLength in characters
+	MaxMessageLength = 1024
+	// MaxTitleLength in characters
+	MaxTitleLength = 250
+	// MinRetry interval of emergency messages
+	MinRetry = time.Second * 30
+)
+
+var (
+	// ErrMessageEmpty occurs when a message has no text
+	ErrMessageEmpty = errors.New("pushover message is empty")
+	// ErrMessageTooLong occurs when a message exceeds MaxMessageLength
+	ErrMessageTooLong = fmt.Errorf("pushover message is longer than %d characters", MaxMessageLength)
+	// ErrTitleTooLong occurs when a message title exceeds MaxTitleLength
+	ErrTitleTooLong = fmt.Errorf("pushover message title is longer than %d characters", MaxTitleLength)
+	// ErrInvalidPriority occurs when a message priority is out of range
+	ErrInvalidPriority = errors.New("pushover message priority is invalid")
+	// ErrInvalidRetry occurs when an emergency message retries too often
+	ErrInvalidRetry = fmt.Errorf("pushover emergency message retry must be at least %s", MinRetry)
+	// ErrInvalidExpire occurs when an emergency message never expires
+	ErrInvalidExpire = errors.New("pushover emergency message expire must be positive")
+)
+
+// Message sent to a user or group
+type Message struct {
+	Title    string
+	Message  string
+	Priority Priority
+	Sound    string
+	// Device names of the recipient to send to separated by commas, all of
+	// their devices when empty
+	Device string
+	// Retry and Expire of emergency messages
+	Retry  time.Duration
+	Expire time.Duration
+}
+
+func (m *Message) validate() error {
+	if m.Message == "" {
+		return ErrMessageEmpty
+	}
+
+	if utf8.RuneCountInString(m.Message) > MaxMessageLength {
+		return ErrMessageTooLong
+	}
+
+	if utf8.RuneCountInString(m.Title) > MaxTitleLength {
+		return ErrTitleTooLong
+	}
+
+	if m.Priority < PriorityLowest || m.Priority > PriorityEmergency {
+		return ErrInvalidPriority
+	}
+
+	if m.Priority == PriorityEmergency {
+		if m.Retry < MinRetry {
+			return ErrInvalidRetry
+		}
+
+		if m.Expire <= 0 {
+			return ErrInvalidExpire
+		}
+	}
+
+	return nil
+}
+
+func (m *Message) params(recipient string) url.Values {
+	params := url.Values{}
+	params.Set("user", recipient)
+	params.Set("message", m.Message)
+	params.Set("priority", strconv.Itoa(int(m.Priority)))
+
+	if m.Title != "" {
+		params.Set("title", m.Title)
+	}
+
+	if m.Sound != "" {
+		params.Set("sound", m.Sound)
+	}
+
+	if m.Device != "" {
+		params.Set("device", m.Device)
+	}
+
+	if m.Priority == PriorityEmergency {
+		params.Set("retry", strconv.Itoa(int(m.Retry/time.Second)))
+		params.Set("expire", strconv.Itoa(int(m.Expire/time.Second)))
+	}
+
+	return params
+}
+
+// Response of a sent message
+type Response struct {
+	response
+	// Receipt of emergency messages to follow their acknowledgement with
+	Receipt string `json:"receipt"`
+	// Limits of the application, nil when the API did not send them
+	Limits *Limits `json:"-"`
+}
+
+// ID of the request assigned by the pushover API
+func (r *Response) ID() string {
+	return r.Request
+}
+
+// SendMessage to the recipient, a user or group key
+func (c *Client) SendMessage(ctx context.Context, message *Message, recipient string) (*Response, error) {
+	if recipient == "" {
+		return nil, ErrRecipientEmpty
+	}
+
+	err := message.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Response{}
+	headers, err := c.do(ctx, http.MethodPost, "messages.json", message.params(recipient), result)
+	if headers != nil {
+		result.Limits = parseLimits(headers)
+	}
+
+	// the response is returned along with API errors for its limits
+	return result, err
+}
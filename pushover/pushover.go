@@ -0,0 +1,241 @@
+package pushover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultURL of the pushover API
+	DefaultURL = "https://api.pushover.net/1/"
+	// DefaultTimeout of every request to the pushover API
+	DefaultTimeout = time.Second * 10
+
+	// maxResponseSize read from the pushover API, its responses are tiny
+	maxResponseSize = 1 << 20
+)
+
+var (
+	// ErrTokenEmpty occurs when a client is created without an API token
+	ErrTokenEmpty = errors.New("pushover API token is empty")
+	// ErrRecipientEmpty occurs when a request has no user or group key
+	ErrRecipientEmpty = errors.New("pushover recipient is empty")
+	// ErrReceiptEmpty occurs when a receipt request has no receipt
+	ErrReceiptEmpty = errors.New("pushover receipt is empty")
+)
+
+// APIError returned by the pushover API for a request it did not accept
+type APIError struct {
+	StatusCode int
+	Request    string
+	Errors     []string
+	// Invalid parameters of the request pushover reported, e.g. token, user
+	// or device
+	Invalid []string
+}
+
+func (e *APIError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("pushover API responded with HTTP %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("pushover API responded with HTTP %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+// Temporary is true when the same request may succeed later, i.e. the API
+// failed or the rate limit was hit rather than the request being invalid
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// InvalidRecipient is true when pushover rejected the user or group key or
+// the device of the request, rather than the application or the request
+func (e *APIError) InvalidRecipient() bool {
+	recipient := false
+	for _, param := range e.Invalid {
+		switch param {
+		case "user", "device":
+			recipient = true
+		default:
+			return false
+		}
+	}
+
+	return recipient
+}
+
+// InvalidRecipient is true when a request failed with err since pushover
+// rejected its user or group key or device
+func InvalidRecipient(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.InvalidRecipient()
+}
+
+// Temporary is true when a request that failed with err may succeed when it
+// is sent again, i.e. it did not fail because it is invalid
+func Temporary(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+
+	for _, invalid := range []error{
+		ErrRecipientEmpty,
+		ErrReceiptEmpty,
+		ErrMessageEmpty,
+		ErrMessageTooLong,
+		ErrTitleTooLong,
+		ErrInvalidPriority,
+		ErrInvalidRetry,
+		ErrInvalidExpire,
+	} {
+		if errors.Is(err, invalid) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Client of the pushover API for an application token
+type Client struct {
+	token      string
+	baseURL    *url.URL
+	httpClient *http.Client
+}
+
+// New client for the application token sending requests to the API at
+// baseURL, every request is cancelled after timeout
+func New(token, baseURL string, timeout time.Duration) (*Client, error) {
+	if token == "" {
+		return nil, ErrTokenEmpty
+	}
+
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pushover URL %s: %w", baseURL, err)
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("invalid pushover URL %s, must be an http or https URL", baseURL)
+	}
+
+	if timeout <= 0 {
+		return nil, fmt.Errorf("invalid pushover timeout %s, must be positive", timeout)
+	}
+
+	// endpoints are resolved relative to the base URL
+	if !strings.HasSuffix(parsed.Path, "/") {
+		parsed.Path += "/"
+	}
+
+	return &Client{
+		token:   token,
+		baseURL: parsed,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}, nil
+}
+
+// response fields shared by every pushover API endpoint
+type response struct {
+	Status  int      `json:"status"`
+	Request string   `json:"request"`
+	Errors  []string `json:"errors"`
+	// Token, User and Device are "invalid" when pushover rejected them
+	Token  string `json:"token"`
+	User   string `json:"user"`
+	Device string `json:"device"`
+}
+
+// invalid parameters pushover reported in the response
+func (r *response) invalid() []string {
+	var invalid []string
+	for _, param := range []struct {
+		name  string
+		value string
+	}{
+		{"token", r.Token},
+		{"user", r.User},
+		{"device", r.Device},
+	} {
+		if param.value == "invalid" {
+			invalid = append(invalid, param.name)
+		}
+	}
+
+	return invalid
+}
+
+func (r *response) apiStatus() *response {
+	return r
+}
+
+type apiResponse interface {
+	apiStatus() *response
+}
+
+// do a request to the endpoint relative to the base URL, decoding the JSON
+// response into result
+func (c *Client) do(ctx context.Context, method, endpoint string, params url.Values, result apiResponse) (http.Header, error) {
+	endpointURL, err := c.baseURL.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pushover endpoint %s: %w", endpoint, err)
+	}
+
+	params.Set("token", c.token)
+
+	var body io.Reader
+	if method == http.MethodGet {
+		endpointURL.RawQuery = params.Encode()
+	} else {
+		body = strings.NewReader(params.Encode())
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, endpointURL.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pushover request: %w", err)
+	}
+
+	if body != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		// the token is part of GET URLs, which end up in the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = endpoint
+		}
+
+		return nil, fmt.Errorf("failed to send pushover request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(result)
+	status := result.apiStatus()
+
+	if resp.StatusCode != http.StatusOK || (decodeErr == nil && status.Status != 1) {
+		return resp.Header, &APIError{
+			StatusCode: resp.StatusCode,
+			Request:    status.Request,
+			Errors:     status.Errors,
+			Invalid:    status.invalid(),
+		}
+	}
+
+	if decodeErr != nil {
+		return resp.Header, fmt.Errorf("failed to decode pushover response: %w", decodeErr)
+	}
+
+	return resp.Header, nil
+}
@@ -0,0 +1,285 @@
+package pushover_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"git.0xdad.com/tblyler/meditime/pushover/pushovertest"
+)
+
+func newClient(t *testing.T) (*pushover.Client, *pushovertest.Server) {
+	fake := pushovertest.NewServer("token")
+	fake.AddUser("user", "phone", "tablet")
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := pushover.New("token", server.URL+"/1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, fake
+}
+
+func emergency() *pushover.Message {
+	return &pushover.Message{
+		Message:  "take 1 dose(s) of aspirin",
+		Priority: pushover.PriorityEmergency,
+		Retry:    time.Minute,
+		Expire:   time.Hour,
+	}
+}
+
+func TestReceipt(t *testing.T) {
+	client, fake := newClient(t)
+	ctx := context.Background()
+
+	response, err := client.SendMessage(ctx, emergency(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Receipt == "" {
+		t.Fatal("emergency message has no receipt")
+	}
+
+	messages := fake.Messages()
+	if len(messages) != 1 || messages[0].Receipt != response.Receipt || messages[0].Retry != 60 || messages[0].Expire != 3600 {
+		t.Fatalf("unexpected messages %+v", messages)
+	}
+
+	receipt, err := client.GetReceipt(ctx, response.Receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if receipt.Acknowledged || receipt.Expired || receipt.ExpiresAt == nil {
+		t.Fatalf("unexpected receipt of a new message %+v", receipt)
+	}
+
+	err = fake.Acknowledge(response.Receipt, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err = client.GetReceipt(ctx, response.Receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !receipt.Acknowledged || receipt.AcknowledgedAt == nil || receipt.AcknowledgedBy != "user" || receipt.AcknowledgedByDevice != "phone" {
+		t.Fatalf("unexpected receipt of an acknowledged message %+v", receipt)
+	}
+}
+
+func TestCancelReceipt(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := context.Background()
+
+	response, err := client.SendMessage(ctx, emergency(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.CancelReceipt(ctx, response.Receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := client.GetReceipt(ctx, response.Receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if receipt.Acknowledged || !receipt.Expired {
+		t.Fatalf("unexpected receipt of a cancelled message %+v", receipt)
+	}
+
+	err = client.CancelReceipt(ctx, "missing")
+	var apiErr *pushover.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected not found cancelling a missing receipt, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	client, _ := newClient(t)
+	ctx := context.Background()
+
+	validation, err := client.Validate(ctx, "user", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if validation.Group || len(validation.Devices) != 2 {
+		t.Fatalf("unexpected validation %+v", validation)
+	}
+
+	_, err = client.Validate(ctx, "user", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		recipient string
+		device    string
+		invalid   string
+	}{
+		{"stranger", "", "user"},
+		{"user", "watch", "device"},
+	} {
+		_, err = client.Validate(ctx, test.recipient, test.device)
+
+		var apiErr *pushover.APIError
+		if !errors.As(err, &apiErr) || apiErr.Temporary() {
+			t.Fatalf("expected a permanent API error validating %s %s, got %v", test.recipient, test.device, err)
+		}
+
+		if !pushover.InvalidRecipient(err) || len(apiErr.Invalid) != 1 || apiErr.Invalid[0] != test.invalid {
+			t.Fatalf("expected the %s to be invalid validating %s %s, got %v", test.invalid, test.recipient, test.device, apiErr.Invalid)
+		}
+	}
+}
+
+func TestDevices(t *testing.T) {
+	client, fake := newClient(t)
+	ctx := context.Background()
+
+	message := &pushover.Message{Message: "hello", Device: "phone,tablet"}
+
+	_, err := client.SendMessage(ctx, message, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := fake.Messages()
+	if len(messages) != 1 || messages[0].Device != "phone,tablet" {
+		t.Fatalf("expected a message to the phone and tablet, got %+v", messages)
+	}
+
+	message.Device = "phone,watch"
+
+	_, err = client.SendMessage(ctx, message, "user")
+	var apiErr *pushover.APIError
+	if !errors.As(err, &apiErr) || apiErr.Temporary() {
+		t.Fatalf("expected a permanent API error sending to an unknown device, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	client, fake := newClient(t)
+	ctx := context.Background()
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	fake.SetLimit(2, reset)
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		response, err := client.SendMessage(ctx, &pushover.Message{Message: "hello"}, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response.Limits == nil || response.Limits.Limit != 2 || response.Limits.Remaining != remaining || !response.Limits.Reset.Equal(reset) {
+			t.Fatalf("unexpected limits %+v with %d remaining", response.Limits, remaining)
+		}
+	}
+
+	response, err := client.SendMessage(ctx, &pushover.Message{Message: "hello"}, "user")
+
+	var apiErr *pushover.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || !apiErr.Temporary() {
+		t.Fatalf("expected a temporary rate limit error, got %v", err)
+	}
+
+	if response.Limits == nil || response.Limits.Remaining != 0 {
+		t.Fatalf("unexpected limits %+v of a rate limited message", response.Limits)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	client, fake := newClient(t)
+	ctx := context.Background()
+
+	fake.Fail(http.StatusInternalServerError, 2)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.SendMessage(ctx, &pushover.Message{Message: "hello"}, "user")
+
+		var apiErr *pushover.APIError
+		if !errors.As(err, &apiErr) || !apiErr.Temporary() {
+			t.Fatalf("expected a temporary error, got %v", err)
+		}
+	}
+
+	_, err := client.SendMessage(ctx, &pushover.Message{Message: "hello"}, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.Messages()) != 1 {
+		t.Fatalf("expected only the message after the failures, got %+v", fake.Messages())
+	}
+}
+
+func TestInvalidMessage(t *testing.T) {
+	client, fake := newClient(t)
+	ctx := context.Background()
+
+	message := emergency()
+	message.Retry = time.Second
+
+	_, err := client.SendMessage(ctx, message, "user")
+	if !errors.Is(err, pushover.ErrInvalidRetry) {
+		t.Fatalf("expected %v, got %v", pushover.ErrInvalidRetry, err)
+	}
+
+	_, err = client.SendMessage(ctx, &pushover.Message{}, "user")
+	if !errors.Is(err, pushover.ErrMessageEmpty) {
+		t.Fatalf("expected %v, got %v", pushover.ErrMessageEmpty, err)
+	}
+
+	if len(fake.Messages()) != 0 {
+		t.Fatalf("invalid messages were sent %+v", fake.Messages())
+	}
+}
+
+func TestContext(t *testing.T) {
+	client, _ := newClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.SendMessage(ctx, &pushover.Message{Message: "hello"}, "user")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestWrongToken(t *testing.T) {
+	_, fake := newClient(t)
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := pushover.New("wrong", server.URL+"/1/", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.SendMessage(context.Background(), &pushover.Message{Message: "hello"}, "user")
+
+	var apiErr *pushover.APIError
+	if !errors.As(err, &apiErr) || apiErr.Temporary() {
+		t.Fatalf("expected a permanent API error, got %v", err)
+	}
+
+	if pushover.InvalidRecipient(err) || len(apiErr.Invalid) != 1 || apiErr.Invalid[0] != "token" {
+		t.Fatalf("expected only the token to be invalid, got %v", apiErr.Invalid)
+	}
+}
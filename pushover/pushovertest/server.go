@@ -0,0 +1,613 @@
+// Package pushovertest is a fake pushover API keeping everything in memory,
+// for end to end tests and local development without sending notifications
+package pushovertest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrReceiptNotFound occurs when a receipt was never handed out
+	ErrReceiptNotFound = errors.New("receipt not found")
+)
+
+// Message received by the fake server
+type Message struct {
+	Request  string    `json:"request"`
+	Receipt  string    `json:"receipt,omitempty"`
+	User     string    `json:"user"`
+	Device   string    `json:"device,omitempty"`
+	Title    string    `json:"title,omitempty"`
+	Message  string    `json:"message"`
+	Priority int       `json:"priority"`
+	Sound    string    `json:"sound,omitempty"`
+	Retry    int       `json:"retry,omitempty"`
+	Expire   int       `json:"expire,omitempty"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// receipt of an emergency message
+type receipt struct {
+	message              *Message
+	acknowledgedAt       time.Time
+	acknowledgedBy       string
+	acknowledgedByDevice string
+	expiresAt            time.Time
+	cancelled            bool
+}
+
+func (r *receipt) expired(now time.Time) bool {
+	return r.acknowledgedAt.IsZero() && (r.cancelled || !now.Before(r.expiresAt))
+}
+
+// failure injected into the next requests
+type failure struct {
+	statusCode int
+	count      int
+}
+
+// Server is a fake pushover API serving the messages, receipts, cancel and
+// validate endpoints under /1/ and the controls of the fake under /fake/
+type Server struct {
+	// OnMessage is called with every message that is accepted, if set, and
+	// must not call the Server
+	OnMessage func(*Message)
+
+	lock      sync.Mutex
+	token     string
+	users     map[string][]string
+	messages  []*Message
+	receipts  map[string]*receipt
+	failure   *failure
+	limit     int
+	remaining int
+	reset     time.Time
+	mux       *http.ServeMux
+}
+
+// NewServer accepting the application token, any token when it is empty.
+// Every user key is valid until users are added with AddUser.
+func NewServer(token string) *Server {
+	s := &Server{
+		token:    token,
+		users:    make(map[string][]string),
+		receipts: make(map[string]*receipt),
+		mux:      http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/1/messages.json", s.handleMessages)
+	s.mux.HandleFunc("/1/receipts/", s.handleReceipts)
+	s.mux.HandleFunc("/1/users/validate.json", s.handleValidate)
+	s.mux.HandleFunc("/fake/messages", s.handleFakeMessages)
+	s.mux.HandleFunc("/fake/receipts/", s.handleFakeReceipts)
+	s.mux.HandleFunc("/fake/fail", s.handleFakeFail)
+	s.mux.HandleFunc("/fake/limit", s.handleFakeLimit)
+
+	return s
+}
+
+// AddUser with a key and the names of their devices
+func (s *Server) AddUser(key string, devices ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.users[key] = devices
+}
+
+// Messages accepted so far, oldest first
+func (s *Server) Messages() []Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	messages := make([]Message, 0, len(s.messages))
+	for _, message := range s.messages {
+		messages = append(messages, *message)
+	}
+
+	return messages
+}
+
+// Acknowledge the emergency message of the receipt by the user's device
+func (s *Server) Acknowledge(receiptID, device string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r, ok := s.receipts[receiptID]
+	if !ok {
+		return fmt.Errorf("failed to acknowledge %s: %w", receiptID, ErrReceiptNotFound)
+	}
+
+	if r.acknowledgedAt.IsZero() {
+		r.acknowledgedAt = time.Now()
+		r.acknowledgedBy = r.message.User
+		r.acknowledgedByDevice = device
+	}
+
+	return nil
+}
+
+// Expire the emergency message of the receipt now
+func (s *Server) Expire(receiptID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r, ok := s.receipts[receiptID]
+	if !ok {
+		return fmt.Errorf("failed to expire %s: %w", receiptID, ErrReceiptNotFound)
+	}
+
+	r.expiresAt = time.Now()
+
+	return nil
+}
+
+// Fail the next count requests to the API with the HTTP status code
+func (s *Server) Fail(statusCode, count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.failure = &failure{
+		statusCode: statusCode,
+		count:      count,
+	}
+}
+
+// SetLimit of messages until reset, after which messages are rejected with
+// HTTP 429. A limit of 0 removes it.
+func (s *Server) SetLimit(limit int, reset time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.limit = limit
+	s.remaining = limit
+	s.reset = reset
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// apiResponse fields shared by every endpoint
+type apiResponse struct {
+	Status  int      `json:"status"`
+	Request string   `json:"request"`
+	Errors  []string `json:"errors,omitempty"`
+	// Token, User and Device are "invalid" when they were rejected
+	Token  string `json:"token,omitempty"`
+	User   string `json:"user,omitempty"`
+	Device string `json:"device,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, errs ...string) {
+	writeInvalid(w, statusCode, "", errs...)
+}
+
+// writeInvalid error response marking the rejected parameter as invalid,
+// which is either token, user, device or empty
+func writeInvalid(w http.ResponseWriter, statusCode int, param string, errs ...string) {
+	response := apiResponse{
+		Status:  0,
+		Request: uuid.New().String(),
+		Errors:  errs,
+	}
+
+	switch param {
+	case "token":
+		response.Token = "invalid"
+	case "user":
+		response.User = "invalid"
+	case "device":
+		response.Device = "invalid"
+	}
+
+	writeJSON(w, statusCode, response)
+}
+
+// accept the request when its method and token are valid and no failure is
+// injected, writing the error response otherwise. The lock must be held.
+func (s *Server) accept(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method must be %s", method))
+		return false
+	}
+
+	if s.failure != nil {
+		statusCode := s.failure.statusCode
+		s.failure.count--
+		if s.failure.count <= 0 {
+			s.failure = nil
+		}
+
+		writeError(w, statusCode, "injected failure")
+		return false
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	token := r.Form.Get("token")
+	if token == "" || (s.token != "" && token != s.token) {
+		writeInvalid(w, http.StatusBadRequest, "token", "application token is invalid")
+		return false
+	}
+
+	return true
+}
+
+// validUser and comma separated devices, any device when it is empty. The
+// parameter that is invalid is returned along with the errors. The lock must
+// be held.
+func (s *Server) validUser(user, device string) (devices []string, invalid string, errs []string) {
+	if user == "" {
+		return nil, "user", []string{"user key is invalid"}
+	}
+
+	if len(s.users) == 0 {
+		if device != "" {
+			return []string{device}, "", nil
+		}
+
+		return []string{}, "", nil
+	}
+
+	devices, ok := s.users[user]
+	if !ok {
+		return nil, "user", []string{"user key is invalid"}
+	}
+
+	if device == "" {
+		return devices, "", nil
+	}
+
+	for _, requested := range strings.Split(device, ",") {
+		valid := false
+		for _, name := range devices {
+			if name == requested {
+				valid = true
+				break
+			}
+		}
+
+		if !valid {
+			return nil, "device", []string{"device name is not valid for user"}
+		}
+	}
+
+	return devices, "", nil
+}
+
+func (s *Server) limitHeaders(w http.ResponseWriter) {
+	if s.limit == 0 {
+		return
+	}
+
+	w.Header().Set("X-Limit-App-Limit", strconv.Itoa(s.limit))
+	w.Header().Set("X-Limit-App-Remaining", strconv.Itoa(s.remaining))
+	w.Header().Set("X-Limit-App-Reset", strconv.FormatInt(s.reset.Unix(), 10))
+}
+
+func formInt(r *http.Request, name string) (int, error) {
+	value := r.Form.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is invalid", name)
+	}
+
+	return i, nil
+}
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.accept(w, r, http.MethodPost) {
+		return
+	}
+
+	now := time.Now()
+	if s.limit != 0 && !now.Before(s.reset) {
+		s.remaining = s.limit
+		s.reset = now.AddDate(0, 1, 0)
+	}
+
+	if s.limit != 0 && s.remaining <= 0 {
+		s.limitHeaders(w)
+		writeError(w, http.StatusTooManyRequests, "application is over its message limit")
+		return
+	}
+
+	message := &Message{
+		Request: uuid.New().String(),
+		User:    r.Form.Get("user"),
+		Device:  r.Form.Get("device"),
+		Title:   r.Form.Get("title"),
+		Message: r.Form.Get("message"),
+		Sound:   r.Form.Get("sound"),
+		SentAt:  now,
+	}
+
+	_, invalid, errs := s.validUser(message.User, message.Device)
+
+	if message.Message == "" {
+		errs = append(errs, "message cannot be blank")
+	}
+
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{
+		{"priority", &message.Priority},
+		{"retry", &message.Retry},
+		{"expire", &message.Expire},
+	} {
+		i, err := formInt(r, field.name)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+
+		*field.value = i
+	}
+
+	if message.Priority < -2 || message.Priority > 2 {
+		errs = append(errs, "priority is invalid")
+	}
+
+	if message.Priority == 2 && (message.Retry < 30 || message.Expire <= 0) {
+		errs = append(errs, "emergency messages must have a retry of at least 30 and a positive expire")
+	}
+
+	if errs != nil {
+		writeInvalid(w, http.StatusBadRequest, invalid, errs...)
+		return
+	}
+
+	if message.Priority == 2 {
+		message.Receipt = uuid.New().String()
+		s.receipts[message.Receipt] = &receipt{
+			message:   message,
+			expiresAt: now.Add(time.Duration(message.Expire) * time.Second),
+		}
+	}
+
+	s.messages = append(s.messages, message)
+	if s.limit != 0 {
+		s.remaining--
+	}
+
+	if s.OnMessage != nil {
+		s.OnMessage(message)
+	}
+
+	s.limitHeaders(w)
+	writeJSON(w, http.StatusOK, struct {
+		apiResponse
+		Receipt string `json:"receipt,omitempty"`
+	}{
+		apiResponse: apiResponse{
+			Status:  1,
+			Request: message.Request,
+		},
+		Receipt: message.Receipt,
+	})
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (s *Server) handleReceipts(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/1/receipts/")
+	cancel := strings.HasSuffix(path, "/cancel.json")
+
+	method := http.MethodGet
+	if cancel {
+		method = http.MethodPost
+	}
+
+	if !s.accept(w, r, method) {
+		return
+	}
+
+	receiptID := strings.TrimSuffix(strings.TrimSuffix(path, "/cancel.json"), ".json")
+	rec, ok := s.receipts[receiptID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "receipt not found; may be invalid or expired")
+		return
+	}
+
+	response := apiResponse{
+		Status:  1,
+		Request: uuid.New().String(),
+	}
+
+	if cancel {
+		rec.cancelled = true
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	now := time.Now()
+	expired := rec.expired(now)
+
+	lastDeliveredAt := rec.message.SentAt
+	if !rec.acknowledgedAt.IsZero() {
+		lastDeliveredAt = rec.acknowledgedAt
+	} else if !expired {
+		lastDeliveredAt = now
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		apiResponse
+		Acknowledged         int    `json:"acknowledged"`
+		AcknowledgedAt       int64  `json:"acknowledged_at"`
+		AcknowledgedBy       string `json:"acknowledged_by"`
+		AcknowledgedByDevice string `json:"acknowledged_by_device"`
+		LastDeliveredAt      int64  `json:"last_delivered_at"`
+		Expired              int    `json:"expired"`
+		ExpiresAt            int64  `json:"expires_at"`
+	}{
+		apiResponse:          response,
+		Acknowledged:         boolInt(!rec.acknowledgedAt.IsZero()),
+		AcknowledgedAt:       unix(rec.acknowledgedAt),
+		AcknowledgedBy:       rec.acknowledgedBy,
+		AcknowledgedByDevice: rec.acknowledgedByDevice,
+		LastDeliveredAt:      unix(lastDeliveredAt),
+		Expired:              boolInt(expired),
+		ExpiresAt:            unix(rec.expiresAt),
+	})
+}
+
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.accept(w, r, http.MethodPost) {
+		return
+	}
+
+	devices, invalid, errs := s.validUser(r.Form.Get("user"), r.Form.Get("device"))
+	if errs != nil {
+		writeInvalid(w, http.StatusBadRequest, invalid, errs...)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		apiResponse
+		Group    int      `json:"group"`
+		Devices  []string `json:"devices"`
+		Licenses []string `json:"licenses"`
+	}{
+		apiResponse: apiResponse{
+			Status:  1,
+			Request: uuid.New().String(),
+		},
+		Devices:  devices,
+		Licenses: []string{},
+	})
+}
+
+// handleFakeMessages lists the accepted messages as JSON
+func (s *Server) handleFakeMessages(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Messages())
+}
+
+// handleFakeReceipts acknowledges a receipt with a POST to
+// /fake/receipts/<receipt>/acknowledge?device=<device> or expires it with a
+// POST to /fake/receipts/<receipt>/expire
+func (s *Server) handleFakeReceipts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method must be POST")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/fake/receipts/")
+
+	var err error
+	switch {
+	case strings.HasSuffix(path, "/acknowledge"):
+		err = s.Acknowledge(strings.TrimSuffix(path, "/acknowledge"), r.URL.Query().Get("device"))
+	case strings.HasSuffix(path, "/expire"):
+		err = s.Expire(strings.TrimSuffix(path, "/expire"))
+	default:
+		writeError(w, http.StatusNotFound, "unknown receipt action")
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiResponse{Status: 1})
+}
+
+// handleFakeFail injects failures with a POST to
+// /fake/fail?status=<HTTP status>&count=<requests>
+func (s *Server) handleFakeFail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method must be POST")
+		return
+	}
+
+	statusCode, err := strconv.Atoi(r.URL.Query().Get("status"))
+	if err != nil || statusCode < 400 || statusCode > 599 {
+		writeError(w, http.StatusBadRequest, "status must be an HTTP error status")
+		return
+	}
+
+	count := 1
+	if value := r.URL.Query().Get("count"); value != "" {
+		count, err = strconv.Atoi(value)
+		if err != nil || count < 1 {
+			writeError(w, http.StatusBadRequest, "count must be positive")
+			return
+		}
+	}
+
+	s.Fail(statusCode, count)
+	writeJSON(w, http.StatusOK, apiResponse{Status: 1})
+}
+
+// handleFakeLimit sets the message limit with a POST to
+// /fake/limit?limit=<messages>&reset=<duration from now>
+func (s *Server) handleFakeLimit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method must be POST")
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 0 {
+		writeError(w, http.StatusBadRequest, "limit must be a number of messages")
+		return
+	}
+
+	reset := time.Hour * 24 * 30
+	if value := r.URL.Query().Get("reset"); value != "" {
+		reset, err = time.ParseDuration(value)
+		if err != nil || reset <= 0 {
+			writeError(w, http.StatusBadRequest, "reset must be a positive duration")
+			return
+		}
+	}
+
+	s.SetLimit(limit, time.Now().Add(reset))
+	writeJSON(w, http.StatusOK, apiResponse{Status: 1})
+}
@@ -0,0 +1,76 @@
+package pushover
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Receipt of an emergency message
+type Receipt struct {
+	Acknowledged         bool
+	AcknowledgedAt       *time.Time
+	AcknowledgedBy       string
+	AcknowledgedByDevice string
+	LastDeliveredAt      *time.Time
+	Expired              bool
+	ExpiresAt            *time.Time
+}
+
+// receiptResponse as it is encoded by the pushover API, with booleans as
+// integers and times as unix timestamps that are 0 when unset
+type receiptResponse struct {
+	response
+	Acknowledged         int    `json:"acknowledged"`
+	AcknowledgedAt       int64  `json:"acknowledged_at"`
+	AcknowledgedBy       string `json:"acknowledged_by"`
+	AcknowledgedByDevice string `json:"acknowledged_by_device"`
+	LastDeliveredAt      int64  `json:"last_delivered_at"`
+	Expired              int    `json:"expired"`
+	ExpiresAt            int64  `json:"expires_at"`
+}
+
+func unixTime(timestamp int64) *time.Time {
+	if timestamp == 0 {
+		return nil
+	}
+
+	t := time.Unix(timestamp, 0)
+	return &t
+}
+
+// GetReceipt of an emergency message
+func (c *Client) GetReceipt(ctx context.Context, receipt string) (*Receipt, error) {
+	if receipt == "" {
+		return nil, ErrReceiptEmpty
+	}
+
+	result := &receiptResponse{}
+	_, err := c.do(ctx, http.MethodGet, "receipts/"+url.PathEscape(receipt)+".json", url.Values{}, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Receipt{
+		Acknowledged:         result.Acknowledged == 1,
+		AcknowledgedAt:       unixTime(result.AcknowledgedAt),
+		AcknowledgedBy:       result.AcknowledgedBy,
+		AcknowledgedByDevice: result.AcknowledgedByDevice,
+		LastDeliveredAt:      unixTime(result.LastDeliveredAt),
+		Expired:              result.Expired == 1,
+		ExpiresAt:            unixTime(result.ExpiresAt),
+	}, nil
+}
+
+// CancelReceipt stops the retries of an emergency message that is not
+// acknowledged yet
+func (c *Client) CancelReceipt(ctx context.Context, receipt string) error {
+	if receipt == "" {
+		return ErrReceiptEmpty
+	}
+
+	_, err := c.do(ctx, http.MethodPost, "receipts/"+url.PathEscape(receipt)+"/cancel.json", url.Values{}, &response{})
+
+	return err
+}
@@ -0,0 +1,51 @@
+package pushover
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Validation of a user or group key
+type Validation struct {
+	// Group is true when the key is a delivery group rather than a user
+	Group bool
+	// Devices of the user that are active
+	Devices []string
+	// Licenses of the user, e.g. iOS or Android
+	Licenses []string
+}
+
+type validationResponse struct {
+	response
+	Group    int      `json:"group"`
+	Devices  []string `json:"devices"`
+	Licenses []string `json:"licenses"`
+}
+
+// Validate the recipient, a user or group key, and optionally one of its
+// devices. An invalid recipient or device is an *APIError that is not
+// Temporary.
+func (c *Client) Validate(ctx context.Context, recipient, device string) (*Validation, error) {
+	if recipient == "" {
+		return nil, ErrRecipientEmpty
+	}
+
+	params := url.Values{}
+	params.Set("user", recipient)
+	if device != "" {
+		params.Set("device", device)
+	}
+
+	result := &validationResponse{}
+	_, err := c.do(ctx, http.MethodPost, "users/validate.json", params, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Validation{
+		Group:    result.Group == 1,
+		Devices:  result.Devices,
+		Licenses: result.Licenses,
+	}, nil
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/metrics"
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// queuePollInterval between looking for notifications that are due, which
+// also picks up notifications retried through the CLI while the daemon runs.
+// Only the sqlite store allows that, badger is locked by the daemon.
+const queuePollInterval = time.Second * 5
+
+// errNotificationDropped occurs when a queued notification can't be delivered
+// anymore, e.g. because its dose was resolved in the meantime
+var errNotificationDropped = errors.New("notification dropped")
+
+// queue of outbound notifications kept in the store until they are delivered
+// by a pool of workers, retrying failed attempts with exponential backoff
+type queue struct {
+	b              db.Store
+	pushoverClient *rotatingPushover
+	health         *health
+	reminders      *reminders
+	quota          *pushoverQuota
+	workers        int
+	maxAttempts    int
+	backoff        time.Duration
+	maxBackoff     time.Duration
+
+	wake chan struct{}
+	wg   sync.WaitGroup
+
+	lock     sync.Mutex
+	inFlight map[uuid.UUID]bool
+	// finished notifications stay in flight until the dispatcher listed the
+	// queue after they finished, so a stale listing doesn't deliver them twice
+	finished map[uuid.UUID]bool
+	random   *rand.Rand
+}
+
+func newQueue(b db.Store, pushoverClient *rotatingPushover, workers, maxAttempts int, backoff, maxBackoff time.Duration) (*queue, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("invalid queue workers %d, must be at least 1", workers)
+	}
+
+	if maxAttempts < 1 {
+		return nil, fmt.Errorf("invalid queue max attempts %d, must be at least 1", maxAttempts)
+	}
+
+	if backoff <= 0 || maxBackoff < backoff {
+		return nil, fmt.Errorf("invalid queue backoff %s and max backoff %s, backoff must be positive and at most the max backoff", backoff, maxBackoff)
+	}
+
+	return &queue{
+		b:              b,
+		pushoverClient: pushoverClient,
+		workers:        workers,
+		maxAttempts:    maxAttempts,
+		backoff:        backoff,
+		maxBackoff:     maxBackoff,
+		wake:           make(chan struct{}, 1),
+		inFlight:       make(map[uuid.UUID]bool),
+		finished:       make(map[uuid.UUID]bool),
+		random:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// enqueue a notification to be delivered as soon as a worker is free
+func (q *queue) enqueue(notification *db.Notification) error {
+	now := time.Now()
+
+	notification.ID = uuid.New()
+	notification.Status = db.NotificationStatusPending
+	notification.CreatedAt = now
+	notification.NextAttemptAt = now
+
+	err := q.b.AddNotification(notification)
+	if err != nil {
+		return fmt.Errorf("failed to queue notification: %w", err)
+	}
+
+	q.notify()
+
+	return nil
+}
+
+// notify the dispatcher to look for due notifications now
+func (q *queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// retryIn returns the backoff after the given number of failed attempts,
+// doubling from the configured backoff up to the max backoff with jitter
+func (q *queue) retryIn(attempts int) time.Duration {
+	backoff := q.backoff
+	for i := 1; i < attempts && backoff < q.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > q.maxBackoff {
+		backoff = q.maxBackoff
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// somewhere between half and all of the backoff so failures that
+	// happened together are not all retried together
+	return backoff/2 + time.Duration(q.random.Int63n(int64(backoff/2)+1))
+}
+
+// run the dispatcher and workers until ctx is done
+func (q *queue) run(ctx context.Context) {
+	jobs := make(chan *db.Notification)
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+
+			for notification := range jobs {
+				q.deliver(ctx, notification)
+
+				q.lock.Lock()
+				q.finished[notification.ID] = true
+				q.lock.Unlock()
+			}
+		}()
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		defer close(jobs)
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+			case <-q.wake:
+				if !timer.Stop() {
+					<-timer.C
+				}
+			case <-ctx.Done():
+				return
+			}
+
+			timer.Reset(q.dispatch(ctx, jobs))
+		}
+	}()
+}
+
+// dispatch the due notifications to the workers, returning how long to wait
+// until the next one is due
+func (q *queue) dispatch(ctx context.Context, jobs chan<- *db.Notification) time.Duration {
+	q.lock.Lock()
+	finished := q.finished
+	q.finished = make(map[uuid.UUID]bool)
+	q.lock.Unlock()
+
+	notifications, err := q.b.ListNotifications()
+
+	q.lock.Lock()
+	for id := range finished {
+		if err == nil {
+			delete(q.inFlight, id)
+		} else {
+			q.finished[id] = true
+		}
+	}
+	q.lock.Unlock()
+
+	if err != nil {
+		logger.WithError(err).Error("failed to list queued notifications")
+		return queuePollInterval
+	}
+
+	lengths := map[db.NotificationStatus]int{
+		db.NotificationStatusPending: 0,
+		db.NotificationStatusDead:    0,
+	}
+
+	now := time.Now()
+	wait := queuePollInterval
+
+	for _, notification := range notifications {
+		lengths[notification.Status]++
+
+		if notification.Status != db.NotificationStatusPending {
+			continue
+		}
+
+		if due := notification.NextAttemptAt.Sub(now); due > 0 {
+			if due < wait {
+				wait = due
+			}
+
+			continue
+		}
+
+		q.lock.Lock()
+		inFlight := q.inFlight[notification.ID]
+		q.inFlight[notification.ID] = true
+		q.lock.Unlock()
+
+		if inFlight {
+			continue
+		}
+
+		select {
+		case jobs <- notification:
+		case <-ctx.Done():
+			return wait
+		}
+	}
+
+	for status, length := range lengths {
+		metrics.QueueLength.WithLabelValues(string(status)).Set(float64(length))
+	}
+
+	return wait
+}
+
+// deliver a notification, removing it from the queue once it is sent or
+// can't be sent anymore and scheduling a retry otherwise
+func (q *queue) deliver(ctx context.Context, notification *db.Notification) {
+	entry := logger.WithField("notification_id", notification.ID.String())
+	if !notification.IsAlert() {
+		entry = entry.WithFields(logrus.Fields{
+			"user_id": notification.IDUser.String(),
+			"dose_id": notification.IDDose.String(),
+			"device":  notification.Device,
+		})
+	}
+
+	recipient, devices, err := q.recipient(notification)
+	if errors.Is(err, errNotificationDropped) {
+		entry.WithError(err).Warn("dropping queued notification")
+		q.remove(entry, notification)
+
+		if !notification.IsAlert() {
+			q.reminders.undeliverable(notification)
+		}
+
+		return
+	}
+
+	if err != nil {
+		q.failed(entry, notification, err)
+		return
+	}
+
+	if !notification.IsAlert() && !notification.Critical && q.quota.low(time.Now()) {
+		switch q.quota.policy {
+		case quotaPolicySuppress:
+			metrics.RemindersSuppressed.WithLabelValues(notifierPushover).Inc()
+			entry.Warn("suppressed non-critical reminder, the pushover quota is low")
+			q.remove(entry, notification)
+			q.reminders.undeliverable(notification)
+			return
+
+		case quotaPolicyFallback:
+			q.deliverFallback(ctx, entry, notification)
+			return
+		}
+	}
+
+	// messages sent without any quota left are rejected by pushover anyway
+	if reset, ok := q.quota.exhausted(time.Now()); ok {
+		q.failed(entry, notification, fmt.Errorf("pushover quota is used up until %s", reset.Format(time.RFC3339)))
+		return
+	}
+
+	message := &pushover.Message{
+		Title:    notification.Title,
+		Message:  notification.Message,
+		Priority: pushover.Priority(notification.Priority),
+		Sound:    notification.Sound,
+		Device:   devices,
+		Retry:    notification.Retry,
+		Expire:   notification.Expire,
+	}
+
+	start := time.Now()
+	response, err := q.pushoverClient.get().SendMessage(ctx, message, recipient)
+	if response != nil {
+		q.quota.update(response.Limits)
+	}
+
+	if !notification.IsAlert() {
+		metrics.SendDuration.WithLabelValues(notifierPushover).Observe(time.Since(start).Seconds())
+		q.health.sent(err)
+	}
+
+	// the attempt is repeated once the daemon is started again
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		if !notification.IsAlert() {
+			metrics.RemindersFailed.WithLabelValues(notifierPushover).Inc()
+		}
+
+		q.failed(entry, notification, err)
+		return
+	}
+
+	entry = entry.WithField("pushover_request_id", response.ID())
+	if notification.IsAlert() {
+		entry.Info("sent alert to admin device")
+	} else {
+		metrics.RemindersSent.WithLabelValues(notifierPushover).Inc()
+		entry.WithField("receipt", response.Receipt).Info("sent reminder")
+	}
+
+	q.remove(entry, notification)
+
+	if !notification.IsAlert() {
+		q.reminders.delivered(notification, response.Receipt)
+	}
+}
+
+// deliverFallback reminder to the fallback webhook instead of pushover, it
+// has no receipt to acknowledge
+func (q *queue) deliverFallback(ctx context.Context, entry *logrus.Entry, notification *db.Notification) {
+	user, err := q.b.GetUserByID(notification.IDUser)
+	if err != nil {
+		q.failed(entry, notification, err)
+		return
+	}
+
+	start := time.Now()
+	err = q.quota.fallback.send(ctx, user, notification)
+	metrics.SendDuration.WithLabelValues(notifierWebhook).Observe(time.Since(start).Seconds())
+
+	// the attempt is repeated once the daemon is started again
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		metrics.RemindersFailed.WithLabelValues(notifierWebhook).Inc()
+		q.failed(entry, notification, err)
+		return
+	}
+
+	metrics.RemindersSent.WithLabelValues(notifierWebhook).Inc()
+	entry.Info("sent non-critical reminder to the fallback webhook, the pushover quota is low")
+
+	q.remove(entry, notification)
+	q.reminders.delivered(notification, "")
+}
+
+// recipient key of the notification and the comma separated pushover devices
+// of it to send to, failing with errNotificationDropped when it can't be
+// delivered anymore
+func (q *queue) recipient(notification *db.Notification) (string, string, error) {
+	if notification.IsAlert() {
+		adminDeviceToken := q.health.getAdminDeviceToken()
+		if adminDeviceToken == "" {
+			return "", "", fmt.Errorf("no admin pushover device token is configured: %w", errNotificationDropped)
+		}
+
+		return adminDeviceToken, "", nil
+	}
+
+	if !q.reminders.pending(notification.IDDose) {
+		return "", "", fmt.Errorf("dose is no longer pending: %w", errNotificationDropped)
+	}
+
+	user, err := q.b.GetUserByID(notification.IDUser)
+	if errors.Is(err, db.ErrNotFound) {
+		return "", "", fmt.Errorf("user no longer exists: %w", errNotificationDropped)
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	device, ok := user.PushoverDevices[notification.Device]
+	if !ok {
+		return "", "", fmt.Errorf("device %s no longer exists: %w", notification.Device, errNotificationDropped)
+	}
+
+	if device.Disabled {
+		return "", "", fmt.Errorf("device %s is disabled: %w", notification.Device, errNotificationDropped)
+	}
+
+	return device.Key, strings.Join(device.Restrict, ","), nil
+}
+
+// failed attempt to deliver the notification, which is retried until it
+// reaches the max attempts unless it can never succeed
+func (q *queue) failed(entry *logrus.Entry, notification *db.Notification, err error) {
+	notification.Attempts++
+	notification.LastError = err.Error()
+
+	entry = entry.WithError(err).WithField("attempts", notification.Attempts)
+
+	if notification.Attempts >= q.maxAttempts || !pushover.Temporary(err) {
+		notification.Status = db.NotificationStatusDead
+		metrics.QueueDeadLettered.Inc()
+		entry.Error("failed to send notification, dead-lettered it")
+
+		if !notification.IsAlert() {
+			q.reminders.undeliverable(notification)
+		}
+	} else {
+		retryIn := q.retryIn(notification.Attempts)
+		notification.NextAttemptAt = time.Now().Add(retryIn)
+		metrics.QueueRetries.Inc()
+		entry.WithField("retry_in", retryIn.String()).Warn("failed to send notification, retrying it")
+	}
+
+	err = q.b.UpdateNotification(notification)
+	if err != nil {
+		entry.WithError(err).Error("failed to update queued notification")
+		return
+	}
+
+	// the dispatcher may be waiting longer than the backoff
+	q.notify()
+}
+
+func (q *queue) remove(entry *logrus.Entry, notification *db.Notification) {
+	err := q.b.RemoveNotification(notification)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		entry.WithError(err).Error("failed to remove notification from the queue")
+	}
+}
+
+// wait for the dispatcher and workers to stop after the context of run is done
+func (q *queue) wait() {
+	q.wg.Wait()
+}
+
+var queueHeader = []string{"ID", "STATUS", "ATTEMPTS", "NEXT ATTEMPT", "USER", "DEVICE", "LAST ERROR"}
+
+// queueRow of a notification, with the name of its user from usernames
+func queueRow(notification *db.Notification, usernames map[uuid.UUID]string) []string {
+	user := "admin"
+	if !notification.IsAlert() {
+		user = usernames[notification.IDUser]
+	}
+
+	return []string{
+		notification.ID.String(),
+		string(notification.Status),
+		strconv.Itoa(notification.Attempts),
+		notification.NextAttemptAt.Format(time.RFC3339),
+		user,
+		notification.Device,
+		notification.LastError,
+	}
+}
+
+// selectNotifications in the queue by ID, or by status when id is empty and
+// status isn't, or all of them
+func (c *cli) selectNotifications(id string, status db.NotificationStatus) ([]*db.Notification, error) {
+	b, err := c.db()
+	if err != nil {
+		return nil, err
+	}
+
+	notifications, err := b.ListNotifications()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []*db.Notification
+	for _, notification := range notifications {
+		switch {
+		case id != "":
+			if notification.ID.String() != id {
+				continue
+			}
+		case status != "":
+			if notification.Status != status {
+				continue
+			}
+		}
+
+		selected = append(selected, notification)
+	}
+
+	if id != "" && len(selected) == 0 {
+		return nil, fmt.Errorf("queued notification %s: %w", id, db.ErrNotFound)
+	}
+
+	return selected, nil
+}
+
+func parseNotificationStatus(status string) (db.NotificationStatus, error) {
+	switch db.NotificationStatus(status) {
+	case "", db.NotificationStatusPending, db.NotificationStatusDead:
+		return db.NotificationStatus(status), nil
+	default:
+		return "", fmt.Errorf("invalid status %s, must be %s or %s", status, db.NotificationStatusPending, db.NotificationStatusDead)
+	}
+}
+
+var queueCommand = &command{
+	name:    "queue",
+	summary: "Inspect and manage the queue of outbound notifications",
+	subcommands: []*command{
+		{
+			name:    "list",
+			summary: "List the queued notifications oldest first",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				status := flagSet.String("status", "", "only list notifications with this status, pending or dead")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					notificationStatus, err := parseNotificationStatus(*status)
+					if err != nil {
+						return err
+					}
+
+					notifications, err := c.selectNotifications("", notificationStatus)
+					if err != nil {
+						return err
+					}
+
+					if notifications == nil {
+						notifications = []*db.Notification{}
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					users, err := b.ListUsers()
+					if err != nil {
+						return err
+					}
+
+					usernames := make(map[uuid.UUID]string, len(users))
+					for _, user := range users {
+						usernames[user.ID] = user.Name
+					}
+
+					rows := make([][]string, 0, len(notifications))
+					for _, notification := range notifications {
+						rows = append(rows, queueRow(notification, usernames))
+					}
+
+					return c.print(*output, notifications, queueHeader, rows)
+				}
+			},
+		},
+		{
+			name:    "retry",
+			summary: "Retry dead-lettered notifications now, which are dropped when their dose is no longer pending. Stop the daemon first with the badger store",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				id := flagSet.String("id", "", "ID of the notification to retry, every dead-lettered notification when empty")
+
+				return func() error {
+					notifications, err := c.selectNotifications(*id, db.NotificationStatusDead)
+					if err != nil {
+						return err
+					}
+
+					// a pending notification is already retried by the daemon
+					for _, notification := range notifications {
+						if notification.Status != db.NotificationStatusDead {
+							return fmt.Errorf("queued notification %s is %s, only dead-lettered notifications can be retried", notification.ID.String(), notification.Status)
+						}
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					now := time.Now()
+					for _, notification := range notifications {
+						notification.Status = db.NotificationStatusPending
+						notification.Attempts = 0
+						notification.NextAttemptAt = now
+
+						err = b.UpdateNotification(notification)
+						if err != nil {
+							return err
+						}
+					}
+
+					fmt.Fprintf(c.stdout, "retrying %d notifications\n", len(notifications))
+
+					return nil
+				}
+			},
+		},
+		{
+			name:    "purge",
+			summary: "Remove dead-lettered notifications from the queue. Stop the daemon first with the badger store",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				id := flagSet.String("id", "", "ID of the notification to remove, whatever its status")
+				all := flagSet.Bool("all", false, "remove every notification, including the pending ones")
+
+				return func() error {
+					status := db.NotificationStatusDead
+					if *all {
+						status = ""
+					}
+
+					notifications, err := c.selectNotifications(*id, status)
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					for _, notification := range notifications {
+						err = b.RemoveNotification(notification)
+						if err != nil {
+							return err
+						}
+					}
+
+					fmt.Fprintf(c.stdout, "purged %d notifications\n", len(notifications))
+
+					return nil
+				}
+			},
+		},
+	},
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"github.com/google/uuid"
+)
+
+func TestQueueRetryIn(t *testing.T) {
+	q, err := newQueue(db.NewMemory(), nil, 1, 3, time.Second, time.Second*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for attempts, backoff := range map[int]time.Duration{
+		1: time.Second,
+		2: time.Second * 2,
+		3: time.Second * 4,
+		4: time.Second * 8,
+		5: time.Second * 10,
+		6: time.Second * 10,
+	} {
+		for i := 0; i < 100; i++ {
+			retryIn := q.retryIn(attempts)
+			if retryIn < backoff/2 || retryIn > backoff {
+				t.Fatalf("expected the retry after %d attempts to be between %s and %s, got %s", attempts, backoff/2, backoff, retryIn)
+			}
+		}
+	}
+}
+
+// enqueueAlert to the admin device, which is alice in the test daemon
+func enqueueAlert(t *testing.T, daemon *testDaemon, message string) *db.Notification {
+	t.Helper()
+
+	notification := &db.Notification{
+		Title:    "meditime alert",
+		Message:  message,
+		Priority: int(pushover.PriorityHigh),
+	}
+
+	err := daemon.queue.enqueue(notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return notification
+}
+
+func queued(t *testing.T, b db.Store) []*db.Notification {
+	t.Helper()
+
+	notifications, err := b.ListNotifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return notifications
+}
+
+// expectDeadLettered notification after the attempts
+func expectDeadLettered(t *testing.T, b db.Store, attempts int) {
+	t.Helper()
+
+	var notifications []*db.Notification
+	eventually(t, "the notification to be dead-lettered", func() bool {
+		notifications = queued(t, b)
+		return len(notifications) == 1 && notifications[0].Status == db.NotificationStatusDead
+	})
+
+	if notifications[0].Attempts != attempts || notifications[0].LastError == "" {
+		t.Fatalf("expected the notification to be dead-lettered after %d attempts with its last error, got %+v", attempts, notifications[0])
+	}
+}
+
+func TestQueueRetriesTemporaryFailures(t *testing.T) {
+	fake, url := newFakePushover(t)
+	b := db.NewMemory()
+	daemon := newTestDaemon(t, b, url, nil)
+
+	fake.Fail(http.StatusInternalServerError, 2)
+	enqueueAlert(t, daemon, "retried")
+	daemon.queue.run(daemon.ctx)
+
+	eventually(t, "the notification to be delivered", func() bool {
+		return len(queued(t, b)) == 0
+	})
+
+	messages := fake.Messages()
+	if len(messages) != 1 || messages[0].Message != "retried" {
+		t.Fatalf("expected the notification to be delivered once, got %+v", messages)
+	}
+}
+
+func TestQueueDeadLettersAfterMaxAttempts(t *testing.T) {
+	fake, url := newFakePushover(t)
+	b := db.NewMemory()
+	daemon := newTestDaemon(t, b, url, nil)
+
+	fake.Fail(http.StatusServiceUnavailable, 3)
+	enqueueAlert(t, daemon, "given up on")
+	daemon.queue.run(daemon.ctx)
+
+	expectDeadLettered(t, b, 3)
+
+	if messages := fake.Messages(); len(messages) != 0 {
+		t.Fatalf("expected nothing to be delivered, got %+v", messages)
+	}
+}
+
+func TestQueueDeadLettersPermanentFailures(t *testing.T) {
+	fake, url := newFakePushover(t)
+	b := db.NewMemory()
+	daemon := newTestDaemon(t, b, url, nil)
+
+	fake.Fail(http.StatusBadRequest, 1)
+	enqueueAlert(t, daemon, "rejected")
+	daemon.queue.run(daemon.ctx)
+
+	expectDeadLettered(t, b, 1)
+}
+
+func TestQueueResumesAfterRestart(t *testing.T) {
+	fake, url := newFakePushover(t)
+	b := db.NewMemory()
+
+	// queued by a daemon that stopped before delivering them, one of them
+	// after a failed attempt
+	stopped := newTestDaemon(t, b, url, nil)
+	enqueueAlert(t, stopped, "first")
+	retried := enqueueAlert(t, stopped, "second")
+
+	retried.Attempts = 2
+	retried.LastError = "pushover is down"
+
+	err := b.UpdateNotification(retried)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemon := newTestDaemon(t, b, url, nil)
+	daemon.queue.run(daemon.ctx)
+
+	eventually(t, "the notifications to be delivered", func() bool {
+		return len(queued(t, b)) == 0
+	})
+
+	if messages := fake.Messages(); len(messages) != 2 {
+		t.Fatalf("expected both notifications to be delivered, got %+v", messages)
+	}
+}
+
+func TestQueueRetryCommand(t *testing.T) {
+	_, url := newFakePushover(t)
+	c, stdout := newTestCLI(t, url)
+
+	b, err := c.db()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := &db.Notification{
+		ID:            uuid.New(),
+		Status:        db.NotificationStatusPending,
+		Attempts:      1,
+		NextAttemptAt: time.Now().Add(time.Hour),
+	}
+
+	dead := &db.Notification{
+		ID:        uuid.New(),
+		Status:    db.NotificationStatusDead,
+		Attempts:  3,
+		LastError: "pushover is down",
+	}
+
+	for _, notification := range []*db.Notification{pending, dead} {
+		err = b.AddNotification(notification)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = runCLI(c, "queue", "retry", "-id", pending.ID.String())
+	if err == nil || !strings.Contains(err.Error(), "only dead-lettered") {
+		t.Fatalf("expected retrying a pending notification to fail, got %v", err)
+	}
+
+	err = runCLI(c, "queue", "retry", "-id", dead.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(stdout.String(), "retrying 1 notifications") {
+		t.Fatalf("expected the dead notification to be retried, got %s", stdout.String())
+	}
+
+	for _, notification := range queued(t, b) {
+		if notification.Status != db.NotificationStatusPending {
+			t.Fatalf("expected every notification to be pending, got %+v", notification)
+		}
+
+		if notification.ID == pending.ID && (notification.Attempts != 1 || notification.NextAttemptAt.Before(time.Now())) {
+			t.Fatalf("expected the pending notification to be left alone, got %+v", notification)
+		}
+
+		if notification.ID == dead.ID && notification.Attempts != 0 {
+			t.Fatalf("expected the retried notification to start over, got %+v", notification)
+		}
+	}
+}
+
+func TestQueueRetryWhileDaemonRuns(t *testing.T) {
+	for _, store := range []string{storeBadger, storeSQLite} {
+		config := &storeConfig{store: store, dir: t.TempDir()}
+
+		// the daemon keeps the store open
+		daemon := newCLI(context.Background(), config)
+		b, err := daemon.db()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dead := &db.Notification{
+			ID:       uuid.New(),
+			Status:   db.NotificationStatusDead,
+			Attempts: 3,
+		}
+
+		err = b.AddNotification(dead)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = runAlongsideDaemon(config, "queue", "retry", "-id", dead.ID.String())
+
+		switch store {
+		case storeBadger:
+			if !errors.Is(err, db.ErrLocked) || !strings.Contains(err.Error(), "stop 'meditime run' first") {
+				t.Fatalf("expected retrying in a badger store the daemon has open to fail with %v, got %v", db.ErrLocked, err)
+			}
+
+		case storeSQLite:
+			if err != nil {
+				t.Fatalf("expected retrying in a sqlite store the daemon has open to work, got %v", err)
+			}
+
+			if notifications := queued(t, b); len(notifications) != 1 || notifications[0].Status != db.NotificationStatusPending {
+				t.Fatalf("expected the daemon to see the notification retried, got %+v", notifications)
+			}
+		}
+
+		daemon.close()
+	}
+}
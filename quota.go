@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/metrics"
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"github.com/google/uuid"
+)
+
+const (
+	// quotaPolicySend keeps sending non-critical reminders through pushover
+	// until the quota is used up
+	quotaPolicySend = "send"
+	// quotaPolicySuppress drops non-critical reminders once the quota is low
+	quotaPolicySuppress = "suppress"
+	// quotaPolicyFallback posts non-critical reminders to the fallback
+	// webhook once the quota is low
+	quotaPolicyFallback = "fallback"
+
+	notifierWebhook = "webhook"
+	webhookTimeout  = time.Second * 10
+)
+
+// pushoverQuota of messages per month as reported by pushover with every
+// sent message, warning the admin device as it is used up
+type pushoverQuota struct {
+	health     *health
+	thresholds []int
+	reserve    int
+	policy     string
+	fallback   *webhook
+
+	lock   sync.Mutex
+	limits *pushover.Limits
+}
+
+func newPushoverQuota(thresholds string, reserve int, policy, fallbackURL string) (*pushoverQuota, error) {
+	quota := &pushoverQuota{
+		reserve: reserve,
+		policy:  policy,
+	}
+
+	for _, threshold := range strings.Split(thresholds, ",") {
+		threshold = strings.TrimSpace(threshold)
+		if threshold == "" {
+			continue
+		}
+
+		percent, err := strconv.Atoi(threshold)
+		if err != nil || percent < 1 || percent > 100 {
+			return nil, fmt.Errorf("invalid quota warn threshold '%s', must be a percentage between 1 and 100", threshold)
+		}
+
+		quota.thresholds = append(quota.thresholds, percent)
+	}
+
+	sort.Ints(quota.thresholds)
+
+	if reserve < 0 {
+		return nil, fmt.Errorf("invalid quota reserve %d, must not be negative", reserve)
+	}
+
+	switch policy {
+	case quotaPolicySend, quotaPolicySuppress:
+	case quotaPolicyFallback:
+		parsed, err := url.Parse(fallbackURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("invalid quota fallback URL '%s', the %s policy requires an http or https URL", fallbackURL, quotaPolicyFallback)
+		}
+
+		quota.fallback = &webhook{
+			url: fallbackURL,
+			httpClient: &http.Client{
+				Timeout: webhookTimeout,
+			},
+		}
+	default:
+		return nil, fmt.Errorf("invalid quota policy %s, must be %s, %s or %s", policy, quotaPolicySend, quotaPolicySuppress, quotaPolicyFallback)
+	}
+
+	return quota, nil
+}
+
+// update the quota with the limits of a sent message, warning the admin
+// device once for every threshold the usage crossed
+func (p *pushoverQuota) update(limits *pushover.Limits) {
+	if limits == nil || limits.Limit <= 0 {
+		return
+	}
+
+	p.lock.Lock()
+	p.limits = limits
+	p.lock.Unlock()
+
+	metrics.PushoverQuotaLimit.Set(float64(limits.Limit))
+	metrics.PushoverQuotaRemaining.Set(float64(limits.Remaining))
+	metrics.PushoverQuotaReset.Set(float64(limits.Reset.Unix()))
+
+	used := (limits.Limit - limits.Remaining) * 100 / limits.Limit
+
+	// thresholds that are no longer crossed after the reset warn again
+	for _, threshold := range p.thresholds {
+		p.health.check(fmt.Sprintf("%s_%d", alertQuota, threshold), used >= threshold, fmt.Sprintf(
+			"meditime used %d%% of its monthly pushover quota, %d of %d messages remain until %s",
+			used,
+			limits.Remaining,
+			limits.Limit,
+			limits.Reset.Format(time.RFC3339),
+		))
+	}
+}
+
+// low is true while no more than the reserve of messages remain until the
+// quota resets
+func (p *pushoverQuota) low(now time.Time) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.limits != nil && now.Before(p.limits.Reset) && p.limits.Remaining <= p.reserve
+}
+
+// exhausted returns the time the quota resets at while no messages remain
+func (p *pushoverQuota) exhausted(now time.Time) (time.Time, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.limits == nil || !now.Before(p.limits.Reset) || p.limits.Remaining > 0 {
+		return time.Time{}, false
+	}
+
+	return p.limits.Reset, true
+}
+
+// webhook notifier posting reminders as JSON, the fallback for non-critical
+// reminders once the pushover quota is low
+type webhook struct {
+	url        string
+	httpClient *http.Client
+}
+
+// webhookReminder posted to the webhook
+type webhookReminder struct {
+	IDUser   uuid.UUID `json:"id_user"`
+	IDDose   uuid.UUID `json:"id_dose"`
+	User     string    `json:"user"`
+	Device   string    `json:"device"`
+	Title    string    `json:"title,omitempty"`
+	Message  string    `json:"message"`
+	Priority int       `json:"priority"`
+}
+
+func (w *webhook) send(ctx context.Context, user *db.User, notification *db.Notification) error {
+	body, err := json.Marshal(&webhookReminder{
+		IDUser:   notification.IDUser,
+		IDDose:   notification.IDDose,
+		User:     user.Name,
+		Device:   notification.Device,
+		Title:    notification.Title,
+		Message:  notification.Message,
+		Priority: notification.Priority,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to JSON marshal webhook reminder: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := w.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook request: %w", err)
+	}
+	defer response.Body.Close()
+
+	// drained so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(response.Body, 1<<20))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with HTTP %d", response.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"git.0xdad.com/tblyler/meditime/pushover/pushovertest"
+)
+
+// useQuota of the fake by sending count messages, updating the quota of the
+// daemon with the limits pushover reports for each of them
+func useQuota(t *testing.T, daemon *testDaemon, count int) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		response, err := daemon.client.SendMessage(context.Background(), &pushover.Message{Message: "using the quota"}, "alice-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		daemon.quota.update(response.Limits)
+	}
+}
+
+// quotaAlerts queued for the admin device
+func quotaAlerts(t *testing.T, b db.Store) []string {
+	t.Helper()
+
+	var alerts []string
+	for _, notification := range queued(t, b) {
+		if notification.IsAlert() && strings.Contains(notification.Message, "pushover quota") {
+			alerts = append(alerts, notification.Message)
+		}
+	}
+
+	return alerts
+}
+
+func newTestQuota(t *testing.T, thresholds string, reserve int, policy, fallbackURL string) *pushoverQuota {
+	t.Helper()
+
+	quota, err := newPushoverQuota(thresholds, reserve, policy, fallbackURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return quota
+}
+
+func TestQuotaWarnings(t *testing.T) {
+	fake, url := newFakePushover(t)
+	b := db.NewMemory()
+	daemon := newTestDaemon(t, b, url, newTestQuota(t, "50,90", 0, quotaPolicySend, ""))
+
+	fake.SetLimit(10, time.Now().Add(time.Hour))
+
+	useQuota(t, daemon, 4)
+	if alerts := quotaAlerts(t, b); len(alerts) != 0 {
+		t.Fatalf("expected no warning below the first threshold, got %v", alerts)
+	}
+
+	useQuota(t, daemon, 1)
+	alerts := quotaAlerts(t, b)
+	if len(alerts) != 1 || !strings.Contains(alerts[0], "used 50%") || !strings.Contains(alerts[0], "5 of 10 messages remain") {
+		t.Fatalf("expected a warning at 50%%, got %v", alerts)
+	}
+
+	useQuota(t, daemon, 3)
+	if alerts := quotaAlerts(t, b); len(alerts) != 1 {
+		t.Fatalf("expected to be warned once per threshold, got %v", alerts)
+	}
+
+	useQuota(t, daemon, 1)
+	alerts = quotaAlerts(t, b)
+	if len(alerts) != 2 || !strings.Contains(alerts[1], "used 90%") {
+		t.Fatalf("expected a warning at 90%%, got %v", alerts)
+	}
+
+	if daemon.quota.low(time.Now()) {
+		t.Fatal("expected the quota not to be low without a reserve while messages remain")
+	}
+
+	// the thresholds warn again once they are crossed after the reset
+	fake.SetLimit(10, time.Now().Add(time.Hour))
+	useQuota(t, daemon, 5)
+
+	if alerts := quotaAlerts(t, b); len(alerts) != 3 {
+		t.Fatalf("expected to be warned again after the reset, got %v", alerts)
+	}
+}
+
+// remindWithLowQuota of the medication once the quota of the daemon is down
+// to its reserve of 2 messages, returning the messages pushover received for
+// the reminder
+func remindWithLowQuota(t *testing.T, fake *pushovertest.Server, daemon *testDaemon, critical bool) []pushovertest.Message {
+	t.Helper()
+
+	fake.SetLimit(10, time.Now().Add(time.Hour))
+	useQuota(t, daemon, 7)
+
+	if daemon.quota.low(time.Now()) {
+		t.Fatal("expected the quota not to be low above the reserve")
+	}
+
+	useQuota(t, daemon, 1)
+
+	if !daemon.quota.low(time.Now()) {
+		t.Fatal("expected the quota to be low at the reserve")
+	}
+
+	user := addUser(t, daemon.b)
+	medication := addMedication(t, daemon.b, user, "phone")
+	medication.Critical = critical
+
+	err := daemon.b.UpdateMedication(medication, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used := len(fake.Messages())
+
+	daemon.queue.run(daemon.ctx)
+	daemon.reminders.send(daemon.ctx, 1, user, medication)
+
+	eventually(t, "the reminder to leave the queue", func() bool {
+		return len(queued(t, daemon.b)) == 0
+	})
+
+	return fake.Messages()[used:]
+}
+
+func TestQuotaPolicySend(t *testing.T) {
+	fake, url := newFakePushover(t)
+	daemon := newTestDaemon(t, db.NewMemory(), url, newTestQuota(t, "", 2, quotaPolicySend, ""))
+
+	if sent := remindWithLowQuota(t, fake, daemon, false); len(sent) != 1 {
+		t.Fatalf("expected the reminder to be sent through pushover, got %+v", sent)
+	}
+}
+
+func TestQuotaPolicySuppress(t *testing.T) {
+	fake, url := newFakePushover(t)
+	daemon := newTestDaemon(t, db.NewMemory(), url, newTestQuota(t, "", 2, quotaPolicySuppress, ""))
+
+	if sent := remindWithLowQuota(t, fake, daemon, false); len(sent) != 0 {
+		t.Fatalf("expected the non-critical reminder to be suppressed, got %+v", sent)
+	}
+}
+
+func TestQuotaPolicySuppressCritical(t *testing.T) {
+	fake, url := newFakePushover(t)
+	daemon := newTestDaemon(t, db.NewMemory(), url, newTestQuota(t, "", 2, quotaPolicySuppress, ""))
+
+	if sent := remindWithLowQuota(t, fake, daemon, true); len(sent) != 1 {
+		t.Fatalf("expected the critical reminder to be sent from the reserve, got %+v", sent)
+	}
+}
+
+func TestQuotaPolicyFallback(t *testing.T) {
+	var lock sync.Mutex
+	var reminders []webhookReminder
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reminder := webhookReminder{}
+
+		err := json.NewDecoder(r.Body).Decode(&reminder)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		lock.Lock()
+		reminders = append(reminders, reminder)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	fake, url := newFakePushover(t)
+	daemon := newTestDaemon(t, db.NewMemory(), url, newTestQuota(t, "", 2, quotaPolicyFallback, server.URL))
+
+	if sent := remindWithLowQuota(t, fake, daemon, false); len(sent) != 0 {
+		t.Fatalf("expected the non-critical reminder not to be sent through pushover, got %+v", sent)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(reminders) != 1 || reminders[0].User != "alice" || reminders[0].Device != "phone" || reminders[0].Message == "" {
+		t.Fatalf("expected the reminder to be posted to the fallback webhook, got %+v", reminders)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/metrics"
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	notifierPushover = "pushover"
+
+	adherenceWindow = time.Hour * 24 * 7
+)
+
+// receiptPollInterval between following the receipts of a pending dose
+var receiptPollInterval = time.Minute
+
+// reminders queues medication reminders and follows their receipts until
+// they are acknowledged or expire
+type reminders struct {
+	b              db.Store
+	pushoverClient *rotatingPushover
+	queue          *queue
+	retry          time.Duration
+	expire         time.Duration
+	sound          string
+	wg             sync.WaitGroup
+
+	lock  sync.Mutex
+	doses map[uuid.UUID]*watchedDose
+}
+
+// watchedDose that is pending until its reminders are acknowledged or expire,
+// or it is recorded through the CLI
+type watchedDose struct {
+	user *db.User
+	dose *db.Dose
+	// queued reminders of the dose that were neither delivered nor given up
+	// on yet, the dose can't be missed while there are any
+	queued int
+	// unreceipted reminders were delivered without a receipt to follow, e.g.
+	// by the fallback webhook, the dose can't be missed until they would have
+	// expired
+	unreceipted bool
+}
+
+// current state of the user and medication, which may have been edited,
+// paused or removed since they were scheduled
+func (r *reminders) current(medication *db.Medication) (*db.User, *db.Medication, error) {
+	currentMedication, err := r.b.GetMedication(medication.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	currentUser, err := r.b.GetUserByID(currentMedication.IDUser)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return currentUser, currentMedication, nil
+}
+
+func (r *reminders) send(ctx context.Context, entryID cron.EntryID, user *db.User, medication *db.Medication) {
+	now := time.Now()
+
+	currentUser, currentMedication, err := r.current(medication)
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"cron_entry_id": entryID,
+			"user_id":       user.ID.String(),
+			"medication_id": medication.ID.String(),
+		}).Warn("skipping reminder for medication that no longer exists")
+		return
+	}
+
+	user, medication = currentUser, currentMedication
+
+	if user.IsPaused(now) || medication.IsPaused(now) {
+		logger.WithFields(logrus.Fields{
+			"cron_entry_id": entryID,
+			"user_id":       user.ID.String(),
+			"medication_id": medication.ID.String(),
+		}).Info("skipping reminder for paused medication")
+		return
+	}
+
+	dose := &db.Dose{
+		IDUser:       user.ID,
+		IDMedication: medication.ID,
+		ID:           uuid.New(),
+		Quantity:     medication.IntervalQuantity,
+		Status:       db.DoseStatusPending,
+		ScheduledAt:  now,
+	}
+
+	entry := logger.WithFields(logrus.Fields{
+		"cron_entry_id": entryID,
+		"user_id":       user.ID.String(),
+		"medication_id": medication.ID.String(),
+		"dose_id":       dose.ID.String(),
+	})
+
+	entry.Info("queueing reminder")
+
+	var notifications []*db.Notification
+	for _, device := range medication.IntervalPushoverDevices {
+		if !user.HasDevice(device) {
+			entry.WithField("device", device).Error("invalid device name for medication")
+			continue
+		}
+
+		if user.DeviceDisabled(device) {
+			entry.WithField("device", device).Warn("skipping device disabled since pushover rejected it")
+			continue
+		}
+
+		metrics.RemindersScheduled.WithLabelValues(notifierPushover).Inc()
+
+		notifications = append(notifications, &db.Notification{
+			IDUser:   user.ID,
+			IDDose:   dose.ID,
+			Device:   device,
+			Message:  fmt.Sprintf("take %d dose(s) of %s", medication.IntervalQuantity, medication.Name),
+			Priority: int(pushover.PriorityEmergency),
+			Sound:    r.sound,
+			Retry:    r.retry,
+			Expire:   r.expire,
+			Critical: medication.Critical,
+		})
+	}
+
+	// without a single device to remind there is nothing left to acknowledge
+	if len(notifications) == 0 {
+		dose.Status = db.DoseStatusMissed
+		dose.ResolvedAt = &now
+	}
+
+	err = r.b.AddDose(dose)
+	if err != nil {
+		entry.WithError(err).Error("failed to record dose")
+		return
+	}
+
+	if dose.Status != db.DoseStatusPending {
+		r.updateAdherence(user)
+		return
+	}
+
+	// the dose is watched first so the queue finds it pending
+	watched := r.track(user, dose, len(notifications))
+
+	for _, notification := range notifications {
+		err = r.queue.enqueue(notification)
+		if err != nil {
+			entry.WithError(err).WithField("device", notification.Device).Error("failed to queue reminder")
+			r.undeliverable(notification)
+		}
+	}
+
+	r.watch(ctx, user, watched)
+}
+
+// resume following the receipts of doses that were still pending when the
+// daemon last stopped, queued holds the number of their reminders left in
+// the queue
+func (r *reminders) resume(ctx context.Context, user *db.User, queued map[uuid.UUID]int) error {
+	doses, err := r.b.ListDosesForUser(user, time.Now().Add(-r.expire))
+	if err != nil {
+		return err
+	}
+
+	for _, dose := range doses {
+		if dose.Status == db.DoseStatusPending {
+			r.watch(ctx, user, r.track(user, dose, queued[dose.ID]))
+		}
+	}
+
+	r.updateAdherence(user)
+
+	return nil
+}
+
+// track a pending dose with the number of its reminders in the queue
+func (r *reminders) track(user *db.User, dose *db.Dose, queued int) *watchedDose {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	watched := &watchedDose{
+		user:   user,
+		dose:   dose,
+		queued: queued,
+	}
+
+	r.doses[dose.ID] = watched
+
+	return watched
+}
+
+// pending is true while the dose is watched, i.e. its reminders are still
+// worth delivering
+func (r *reminders) pending(idDose uuid.UUID) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, ok := r.doses[idDose]
+
+	return ok
+}
+
+// delivered reminder of a watched dose, whose receipt is followed from now on
+// unless it is empty
+func (r *reminders) delivered(notification *db.Notification, receipt string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	watched, ok := r.doses[notification.IDDose]
+	if !ok {
+		return
+	}
+
+	watched.queued--
+
+	if receipt == "" {
+		watched.unreceipted = true
+		return
+	}
+
+	watched.dose.Receipts = append(watched.dose.Receipts, receipt)
+
+	// a dose recorded through the CLI in the meantime must not be reverted to
+	// pending, its receipts are cancelled once the watch notices it
+	err := r.b.UpdatePendingDose(watched.dose)
+	if err != nil && !errors.Is(err, db.ErrDoseResolved) {
+		logger.WithError(err).WithFields(logrus.Fields{
+			"user_id": notification.IDUser.String(),
+			"dose_id": notification.IDDose.String(),
+		}).Error("failed to record reminder receipt")
+	}
+}
+
+// storedStatus of the dose, which differs from the watched one once the dose
+// is recorded through the CLI
+func (r *reminders) storedStatus(user *db.User, dose *db.Dose) (db.DoseStatus, error) {
+	doses, err := r.b.ListDosesForUser(user, dose.ScheduledAt)
+	if err != nil {
+		return "", err
+	}
+
+	for _, stored := range doses {
+		if stored.ID == dose.ID {
+			return stored.Status, nil
+		}
+	}
+
+	return "", fmt.Errorf("dose %s %w", dose.ID.String(), db.ErrNotFound)
+}
+
+// undeliverable reminder of a watched dose that was given up on
+func (r *reminders) undeliverable(notification *db.Notification) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	watched, ok := r.doses[notification.IDDose]
+	if !ok {
+		return
+	}
+
+	watched.queued--
+}
+
+func (r *reminders) watch(ctx context.Context, user *db.User, watched *watchedDose) {
+	dose := watched.dose
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		entry := logger.WithFields(logrus.Fields{
+			"user_id":       user.ID.String(),
+			"medication_id": dose.IDMedication.String(),
+			"dose_id":       dose.ID.String(),
+		})
+
+		ticker := time.NewTicker(receiptPollInterval)
+		defer ticker.Stop()
+
+		expired := make(map[string]bool)
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			status, err := r.storedStatus(user, dose)
+			if err != nil {
+				entry.WithError(err).Warn("failed to get the stored status of the dose")
+			}
+
+			if err == nil && status != db.DoseStatusPending {
+				entry.WithField("status", status).Info("dose was recorded, cancelling its reminders")
+				r.recorded(ctx, entry, user, dose, expired)
+				return
+			}
+
+			r.lock.Lock()
+			receipts := append([]string(nil), dose.Receipts...)
+			queued := watched.queued
+			unreceipted := watched.unreceipted
+			r.lock.Unlock()
+
+			for _, receipt := range receipts {
+				if expired[receipt] {
+					continue
+				}
+
+				receiptEntry := entry.WithField("receipt", receipt)
+
+				details, err := r.pushoverClient.get().GetReceipt(ctx, receipt)
+				if err != nil {
+					receiptEntry.WithError(err).Warn("failed to get pushover receipt")
+					continue
+				}
+
+				if details.Acknowledged {
+					acknowledgedAt := time.Now()
+					if details.AcknowledgedAt != nil {
+						acknowledgedAt = *details.AcknowledgedAt
+					}
+
+					metrics.AcknowledgementDuration.WithLabelValues(notifierPushover).Observe(
+						acknowledgedAt.Sub(dose.ScheduledAt).Seconds(),
+					)
+
+					receiptEntry.WithField("acknowledged_by", details.AcknowledgedBy).Info("reminder acknowledged")
+
+					r.resolve(user, dose, db.DoseStatusTaken, acknowledgedAt)
+
+					// the reminders of the other devices keep retrying otherwise
+					expired[receipt] = true
+					cancelReceipts(ctx, r.pushoverClient.get(), entry, receipts, expired)
+					return
+				}
+
+				if details.Expired {
+					expired[receipt] = true
+					metrics.RemindersExpired.WithLabelValues(notifierPushover).Inc()
+					receiptEntry.Warn("reminder expired unacknowledged")
+				}
+			}
+
+			// reminders still in the queue may be acknowledged once delivered,
+			// ones without a receipt are given until they would have expired
+			if queued == 0 && len(expired) == len(receipts) && (!unreceipted || time.Since(dose.ScheduledAt) >= r.expire) {
+				r.resolve(user, dose, db.DoseStatusMissed, time.Now())
+				return
+			}
+		}
+	}()
+}
+
+func (r *reminders) resolve(user *db.User, dose *db.Dose, status db.DoseStatus, resolvedAt time.Time) {
+	// reminders of the dose left in the queue are dropped from now on
+	r.lock.Lock()
+	delete(r.doses, dose.ID)
+	dose.Status = status
+	dose.ResolvedAt = &resolvedAt
+	r.lock.Unlock()
+
+	entry := logger.WithFields(logrus.Fields{
+		"user_id":       user.ID.String(),
+		"medication_id": dose.IDMedication.String(),
+		"dose_id":       dose.ID.String(),
+		"status":        status,
+	})
+
+	// a dose recorded through the CLI in the meantime keeps its status
+	err := r.b.UpdatePendingDose(dose)
+	if errors.Is(err, db.ErrDoseResolved) {
+		entry.WithError(err).Info("dose was recorded in the meantime")
+		return
+	}
+
+	if err != nil {
+		entry.WithError(err).Error("failed to update dose")
+		return
+	}
+
+	entry.Info("dose resolved")
+
+	r.updateAdherence(user)
+}
+
+// recorded dose that was resolved through the CLI, which is no longer watched
+// and whose reminders are cancelled unless they expired
+func (r *reminders) recorded(ctx context.Context, entry *logrus.Entry, user *db.User, dose *db.Dose, expired map[string]bool) {
+	r.lock.Lock()
+	delete(r.doses, dose.ID)
+	receipts := append([]string(nil), dose.Receipts...)
+	r.lock.Unlock()
+
+	cancelReceipts(ctx, r.pushoverClient.get(), entry, receipts, expired)
+
+	r.updateAdherence(user)
+}
+
+// cancelReceipts of emergency reminders that may still be retrying, skipping
+// the ones in done. It returns the number of receipts that failed to cancel.
+func cancelReceipts(ctx context.Context, client *pushover.Client, entry *logrus.Entry, receipts []string, done map[string]bool) int {
+	failed := 0
+	for _, receipt := range receipts {
+		if done[receipt] {
+			continue
+		}
+
+		err := client.CancelReceipt(ctx, receipt)
+		if err != nil {
+			failed++
+			entry.WithError(err).WithField("receipt", receipt).Warn("failed to cancel reminder")
+			continue
+		}
+
+		metrics.RemindersCancelled.WithLabelValues(notifierPushover).Inc()
+		entry.WithField("receipt", receipt).Info("cancelled reminder")
+	}
+
+	return failed
+}
+
+func (r *reminders) updateAdherence(user *db.User) {
+	doses, err := r.b.ListDosesForUser(user, time.Now().Add(-adherenceWindow))
+	if err != nil {
+		logger.WithError(err).WithField("user_id", user.ID.String()).Error("failed to list doses for adherence")
+		return
+	}
+
+	taken, resolved := 0, 0
+	for _, dose := range doses {
+		switch dose.Status {
+		case db.DoseStatusTaken:
+			taken++
+			resolved++
+		case db.DoseStatusMissed:
+			resolved++
+		}
+	}
+
+	if resolved == 0 {
+		return
+	}
+
+	metrics.Adherence.WithLabelValues(user.ID.String()).Set(float64(taken) / float64(resolved))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+)
+
+// sendReminder of the medication through the daemon, returning its dose once
+// every reminder was delivered
+func sendReminder(t *testing.T, daemon *testDaemon, user *db.User, medication *db.Medication) *db.Dose {
+	t.Helper()
+
+	daemon.queue.run(daemon.ctx)
+	daemon.reminders.send(daemon.ctx, 1, user, medication)
+
+	var dose *db.Dose
+	eventually(t, "the reminders to be delivered", func() bool {
+		doses, err := daemon.b.ListDosesForUser(user, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(doses) != 1 || len(doses[0].Receipts) != len(medication.IntervalPushoverDevices) {
+			return false
+		}
+
+		dose = doses[0]
+		return true
+	})
+
+	return dose
+}
+
+func storedDose(t *testing.T, b db.Store, user *db.User) *db.Dose {
+	t.Helper()
+
+	doses, err := b.ListDosesForUser(user, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doses) != 1 {
+		t.Fatalf("expected a single dose, got %d", len(doses))
+	}
+
+	return doses[0]
+}
+
+func TestAcknowledgedReminderCancelsTheOthers(t *testing.T) {
+	fake, url := newFakePushover(t)
+	b := db.NewMemory()
+	daemon := newTestDaemon(t, b, url, nil)
+
+	user := addUser(t, b)
+	medication := addMedication(t, b, user, "phone", "tablet")
+	dose := sendReminder(t, daemon, user, medication)
+
+	devices := make(map[string]bool)
+	for _, message := range fake.Messages() {
+		devices[message.Device] = true
+	}
+
+	if len(devices) != 2 || !devices["phone"] || !devices["tablet"] {
+		t.Fatalf("expected a reminder to each restricted device, got %+v", fake.Messages())
+	}
+
+	err := fake.Acknowledge(dose.Receipts[0], "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "the dose to be taken", func() bool {
+		return storedDose(t, b, user).Status == db.DoseStatusTaken
+	})
+
+	eventually(t, "the dose to no longer be watched", func() bool {
+		return !daemon.reminders.pending(dose.ID)
+	})
+
+	daemon.reminders.wg.Wait()
+	expectCancelled(t, daemon.client, dose.Receipts[1])
+}
+
+func TestRecordedDoseCancelsReminders(t *testing.T) {
+	_, url := newFakePushover(t)
+	b := db.NewMemory()
+	daemon := newTestDaemon(t, b, url, nil)
+
+	user := addUser(t, b)
+	medication := addMedication(t, b, user, "phone", "tablet")
+	dose := sendReminder(t, daemon, user, medication)
+
+	// recorded through the CLI behind the daemon's back
+	resolvedAt := time.Now()
+	dose.Status = db.DoseStatusSkipped
+	dose.ResolvedAt = &resolvedAt
+
+	err := b.UpdateDose(dose)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daemon.reminders.wg.Wait()
+
+	if daemon.reminders.pending(dose.ID) {
+		t.Fatal("expected the recorded dose to no longer be watched")
+	}
+
+	if status := storedDose(t, b, user).Status; status != db.DoseStatusSkipped {
+		t.Fatalf("expected the recorded dose to stay skipped, got %s", status)
+	}
+
+	expectCancelled(t, daemon.client, dose.Receipts...)
+}
+
+func TestExpiredRemindersMissTheDose(t *testing.T) {
+	fake, url := newFakePushover(t)
+	b := db.NewMemory()
+	daemon := newTestDaemon(t, b, url, nil)
+
+	user := addUser(t, b)
+	medication := addMedication(t, b, user, "phone", "tablet")
+	dose := sendReminder(t, daemon, user, medication)
+
+	for _, receipt := range dose.Receipts {
+		err := fake.Expire(receipt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	daemon.reminders.wg.Wait()
+
+	stored := storedDose(t, b, user)
+	if stored.Status != db.DoseStatusMissed || stored.ResolvedAt == nil {
+		t.Fatalf("expected the dose to be missed, got %+v", stored)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"git.0xdad.com/tblyler/meditime/config"
+	"git.0xdad.com/tblyler/meditime/pushover"
+)
+
+// rotatingPushover hands out the current pushover client, which is replaced
+// whenever the API token is rotated
+type rotatingPushover struct {
+	lock   sync.RWMutex
+	client *pushover.Client
+}
+
+// newPushoverClient for the configured API token, URL and timeout
+func newPushoverClient(config config.Config) (*pushover.Client, error) {
+	token, err := config.PushoverAPIToken()
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := config.PushoverURL()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := config.PushoverTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	return pushover.New(token, url, timeout)
+}
+
+func (r *rotatingPushover) get() *pushover.Client {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.client
+}
+
+func (r *rotatingPushover) rotate(client *pushover.Client) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.client = client
+}
+
+// notifyHangup registers for SIGHUP so it no longer terminates the process,
+// the signals received are handled by reloadOnHangup once the daemon started
+func notifyHangup() (hangup chan os.Signal, stop func()) {
+	hangup = make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	return hangup, func() {
+		signal.Stop(hangup)
+	}
+}
+
+// reloadOnHangup re-reads the config and rotates the secrets that are in use
+// every time the process receives SIGHUP
+func reloadOnHangup(ctx context.Context, hangup <-chan os.Signal, config config.Config, pushoverClient *rotatingPushover, health *health) {
+	for {
+		select {
+		case <-hangup:
+			err := config.Reload()
+			if err != nil {
+				logger.WithError(err).Error("failed to reload config")
+				continue
+			}
+
+			client, err := newPushoverClient(config)
+			if err != nil {
+				logger.WithError(err).Error("failed to reload pushover client")
+				continue
+			}
+
+			adminDeviceToken, err := config.AdminPushoverDeviceToken()
+			if err != nil {
+				logger.WithError(err).Error("failed to reload admin pushover device token")
+				continue
+			}
+
+			pushoverClient.rotate(client)
+			health.setAdminDeviceToken(adminDeviceToken)
+
+			logger.Info("reloaded config and rotated pushover tokens")
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
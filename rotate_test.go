@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+
+	"git.0xdad.com/tblyler/meditime/db"
+)
+
+// reloadingConfig that hands out another admin device token once reloaded
+type reloadingConfig struct {
+	testConfig
+	lock     sync.Mutex
+	reloaded bool
+}
+
+func (c *reloadingConfig) Reload() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.reloaded = true
+
+	return nil
+}
+
+func (c *reloadingConfig) AdminPushoverDeviceToken() (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.reloaded {
+		return "rotated-key", nil
+	}
+
+	return "alice-key", nil
+}
+
+func TestHangupDuringStartup(t *testing.T) {
+	_, url := newFakePushover(t)
+	config := &reloadingConfig{testConfig: testConfig{pushoverURL: url}}
+
+	hangup, stop := notifyHangup()
+	defer stop()
+
+	// received before the daemon handles them, which would terminate the
+	// test binary if it wasn't registered
+	err := syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newPushover(t, url)
+	pushoverClient := &rotatingPushover{client: client}
+	health := newHealth(db.NewMemory(), nil, "alice-key")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go reloadOnHangup(ctx, hangup, config, pushoverClient, health)
+
+	eventually(t, "the config to be reloaded", func() bool {
+		return health.getAdminDeviceToken() == "rotated-key"
+	})
+
+	if pushoverClient.get() == client {
+		t.Fatal("expected the pushover client to be rotated")
+	}
+}
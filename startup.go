@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"github.com/sirupsen/logrus"
+)
+
+// startupProblem of a medication found while scheduling it
+type startupProblem struct {
+	user       *db.User
+	medication *db.Medication
+	problems   []string
+	// skipped when the medication can't be scheduled at all, otherwise it is
+	// scheduled with the problems left
+	skipped bool
+}
+
+func (p *startupProblem) String() string {
+	action := "scheduled with problems"
+	if p.skipped {
+		action = "skipped"
+	}
+
+	return fmt.Sprintf("%s for %s %s: %s", p.medication.Name, p.user.Name, action, strings.Join(p.problems, "; "))
+}
+
+// validateMedication before scheduling it, returning nil when it has no
+// problems
+func validateMedication(user *db.User, medication *db.Medication) *startupProblem {
+	problem := &startupProblem{
+		user:       user,
+		medication: medication,
+	}
+
+	if err := validateSchedule(medication.IntervalCrontab); err != nil {
+		problem.problems = append(problem.problems, err.Error())
+		problem.skipped = true
+	}
+
+	if medication.IntervalQuantity == 0 {
+		problem.problems = append(problem.problems, "interval quantity must be positive")
+		problem.skipped = true
+	}
+
+	var missing, disabled []string
+	for _, device := range medication.IntervalPushoverDevices {
+		if !user.HasDevice(device) {
+			missing = append(missing, device)
+		} else if user.DeviceDisabled(device) {
+			disabled = append(disabled, device)
+		}
+	}
+
+	if len(medication.IntervalPushoverDevices) == 0 {
+		problem.problems = append(problem.problems, "reminds no devices")
+	} else if len(missing) > 0 {
+		problem.problems = append(problem.problems, fmt.Sprintf("reminds devices the user doesn't have: %s", strings.Join(missing, ", ")))
+	}
+
+	if len(disabled) > 0 {
+		problem.problems = append(problem.problems, fmt.Sprintf("reminds devices disabled since pushover rejected them: %s", strings.Join(disabled, ", ")))
+	}
+
+	// a medication is only worth scheduling when a reminder reaches someone,
+	// disabled devices are still scheduled since they may be enabled again
+	if len(missing) == len(medication.IntervalPushoverDevices) {
+		problem.skipped = true
+	}
+
+	if len(problem.problems) == 0 {
+		return nil
+	}
+
+	return problem
+}
+
+// startupReport of the medications scheduled by run
+type startupReport struct {
+	scheduled int
+	problems  []*startupProblem
+}
+
+func (r *startupReport) skipped() int {
+	count := 0
+	for _, problem := range r.problems {
+		if problem.skipped {
+			count++
+		}
+	}
+
+	return count
+}
+
+// log the report and alert the admin device when any medication has problems
+func (r *startupReport) send(health *health) {
+	for _, problem := range r.problems {
+		entry := logger.WithFields(logrus.Fields{
+			"user_id":       problem.user.ID.String(),
+			"medication_id": problem.medication.ID.String(),
+			"problems":      problem.problems,
+		})
+
+		if problem.skipped {
+			entry.Error("skipped invalid medication")
+		} else {
+			entry.Warn("scheduled medication with problems")
+		}
+	}
+
+	skipped := r.skipped()
+
+	logger.WithFields(logrus.Fields{
+		"scheduled": r.scheduled,
+		"skipped":   skipped,
+		"problems":  len(r.problems),
+	}).Info("validated medications")
+
+	if len(r.problems) == 0 {
+		return
+	}
+
+	descriptions := make([]string, 0, len(r.problems))
+	for _, problem := range r.problems {
+		descriptions = append(descriptions, problem.String())
+	}
+
+	health.check(alertStartup, true, fmt.Sprintf(
+		"meditime scheduled %d medications and skipped %d:\n%s",
+		r.scheduled,
+		skipped,
+		strings.Join(descriptions, "\n"),
+	))
+}
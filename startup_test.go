@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"git.0xdad.com/tblyler/meditime/db"
+)
+
+func TestValidateMedication(t *testing.T) {
+	user := &db.User{
+		Name: "alice",
+		PushoverDevices: map[string]*db.PushoverDevice{
+			"phone":  {Key: "alice-key", Kind: db.PushoverKeyUser},
+			"tablet": {Key: "alice-key", Kind: db.PushoverKeyUser, Disabled: true, DisabledReason: "user key is invalid"},
+		},
+	}
+
+	for _, test := range []struct {
+		name     string
+		schedule string
+		quantity uint
+		devices  []string
+		problems []string
+		skipped  bool
+	}{
+		{
+			name:     "valid",
+			schedule: "0 8 * * *",
+			quantity: 1,
+			devices:  []string{"phone"},
+		},
+		{
+			name:     "invalid schedule",
+			schedule: "never",
+			quantity: 1,
+			devices:  []string{"phone"},
+			problems: []string{"invalid cron schedule 'never': expected exactly 5 fields, found 1: [never]"},
+			skipped:  true,
+		},
+		{
+			name:     "zero quantity",
+			schedule: "0 8 * * *",
+			devices:  []string{"phone"},
+			problems: []string{"interval quantity must be positive"},
+			skipped:  true,
+		},
+		{
+			name:     "no devices",
+			schedule: "0 8 * * *",
+			quantity: 1,
+			problems: []string{"reminds no devices"},
+			skipped:  true,
+		},
+		{
+			name:     "only missing devices",
+			schedule: "0 8 * * *",
+			quantity: 1,
+			devices:  []string{"watch", "laptop"},
+			problems: []string{"reminds devices the user doesn't have: watch, laptop"},
+			skipped:  true,
+		},
+		{
+			name:     "some missing devices",
+			schedule: "0 8 * * *",
+			quantity: 1,
+			devices:  []string{"phone", "watch"},
+			problems: []string{"reminds devices the user doesn't have: watch"},
+		},
+		{
+			name:     "disabled device",
+			schedule: "0 8 * * *",
+			quantity: 1,
+			devices:  []string{"tablet"},
+			problems: []string{"reminds devices disabled since pushover rejected them: tablet"},
+		},
+		{
+			name:     "every problem",
+			schedule: "never",
+			devices:  []string{"tablet", "watch"},
+			problems: []string{
+				"invalid cron schedule 'never': expected exactly 5 fields, found 1: [never]",
+				"interval quantity must be positive",
+				"reminds devices the user doesn't have: watch",
+				"reminds devices disabled since pushover rejected them: tablet",
+			},
+			skipped: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			medication := &db.Medication{
+				Name:                    "aspirin",
+				IntervalCrontab:         test.schedule,
+				IntervalQuantity:        test.quantity,
+				IntervalPushoverDevices: test.devices,
+			}
+
+			problem := validateMedication(user, medication)
+			if test.problems == nil {
+				if problem != nil {
+					t.Fatalf("expected no problems, got %s", problem)
+				}
+
+				return
+			}
+
+			if problem == nil {
+				t.Fatalf("expected problems %v, got none", test.problems)
+			}
+
+			if !reflect.DeepEqual(problem.problems, test.problems) || problem.skipped != test.skipped {
+				t.Fatalf("expected problems %v skipped %t, got %v skipped %t", test.problems, test.skipped, problem.problems, problem.skipped)
+			}
+		})
+	}
+}
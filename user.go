@@ -0,0 +1,479 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"github.com/google/uuid"
+)
+
+var userHeader = []string{"ID", "NAME", "DEVICES", "STATUS", "CREATED"}
+
+func userRow(user *db.User) []string {
+	return []string{
+		user.ID.String(),
+		user.Name,
+		strings.Join(deviceNames(user), ","),
+		pauseStatus(user.Pause, time.Now()),
+		user.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+var userCommand = &command{
+	name:    "user",
+	summary: "Manage users and their pushover devices",
+	subcommands: []*command{
+		{
+			name:    "add",
+			summary: "Add a user with a pushover device",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				device := flagSet.String("device", "default", "name of the user's first pushover device")
+				deviceToken := flagSet.String("device-token", "", "pushover user or group key of the user's first device")
+				pushoverDevices := flagSet.String("pushover-devices", "", "comma separated names of the pushover devices of the key to remind, all of them when empty")
+				noValidate := flagSet.Bool("no-validate", false, "add the device token without validating it with pushover")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					err := c.prompt(username, "username", "username")
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(deviceToken, "device-token", "pushover user or group key")
+					if err != nil {
+						return err
+					}
+
+					details, err := c.validateDeviceToken(*deviceToken, splitPushoverDevices(*pushoverDevices), *noValidate)
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					user := &db.User{
+						ID:   uuid.New(),
+						Name: *username,
+						PushoverDevices: map[string]*db.PushoverDevice{
+							*device: details,
+						},
+						CreatedAt: time.Now(),
+					}
+
+					err = b.AddUser(user)
+					if err != nil {
+						return fmt.Errorf("failed to insert username %s: %w", *username, err)
+					}
+
+					return c.print(*output, user, userHeader, [][]string{userRow(user)})
+				}
+			},
+		},
+		{
+			name:    "get",
+			summary: "Show a user",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					return c.print(*output, user, userHeader, [][]string{userRow(user)})
+				}
+			},
+		},
+		{
+			name:    "list",
+			summary: "List every user",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				output := outputFlag(flagSet)
+
+				return func() error {
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					users, err := b.ListUsers()
+					if err != nil {
+						return err
+					}
+
+					rows := make([][]string, 0, len(users))
+					for _, user := range users {
+						rows = append(rows, userRow(user))
+					}
+
+					if users == nil {
+						users = []*db.User{}
+					}
+
+					return c.print(*output, users, userHeader, rows)
+				}
+			},
+		},
+		{
+			name:    "remove",
+			summary: "Remove a user",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				cascade := flagSet.Bool("cascade", false, "also remove the user's medications and doses instead of refusing")
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					err = b.RemoveUser(user, *cascade)
+					if errors.Is(err, db.ErrUserHasMedications) {
+						return fmt.Errorf("%w, remove them first or use -cascade", err)
+					}
+
+					return err
+				}
+			},
+		},
+		{
+			name:    "rename",
+			summary: "Rename a user",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "current name of the user")
+				newUsername := flagSet.String("new-username", "", "new name of the user")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(newUsername, "new-username", "new username")
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					err = b.RenameUser(user, *newUsername)
+					if err != nil {
+						return err
+					}
+
+					return c.print(*output, user, userHeader, [][]string{userRow(user)})
+				}
+			},
+		},
+		userPauseCommand,
+		userResumeCommand,
+		userDeviceCommand,
+	},
+}
+
+var deviceHeader = []string{"NAME", "KEY", "TYPE", "PUSHOVER DEVICES", "REMINDS", "STATUS", "VALIDATED"}
+
+type device struct {
+	Name string `json:"name"`
+	*db.PushoverDevice
+}
+
+// devices of the user sorted by name
+func devices(user *db.User) []device {
+	devices := make([]device, 0, len(user.PushoverDevices))
+	for _, name := range deviceNames(user) {
+		devices = append(devices, device{
+			Name:           name,
+			PushoverDevice: user.PushoverDevices[name],
+		})
+	}
+
+	return devices
+}
+
+func deviceRow(device device) []string {
+	reminds := "all"
+	if len(device.Restrict) > 0 {
+		reminds = strings.Join(device.Restrict, ",")
+	}
+
+	if device.ValidatedAt.IsZero() {
+		return []string{device.Name, device.Key, string(device.Kind), "", reminds, "not validated", ""}
+	}
+
+	status := "active"
+	if device.Disabled {
+		status = "disabled: " + device.DisabledReason
+	}
+
+	return []string{
+		device.Name,
+		device.Key,
+		string(device.Kind),
+		strings.Join(device.Devices, ","),
+		reminds,
+		status,
+		device.ValidatedAt.Format(time.RFC3339),
+	}
+}
+
+// splitPushoverDevices from a comma separated list of their names
+func splitPushoverDevices(value string) []string {
+	var devices []string
+	for _, device := range strings.Split(value, ",") {
+		device = strings.TrimSpace(device)
+		if device != "" {
+			devices = append(devices, device)
+		}
+	}
+
+	return devices
+}
+
+var userDeviceCommand = &command{
+	name:    "device",
+	summary: "Manage the pushover devices of a user",
+	subcommands: []*command{
+		{
+			name:    "add",
+			summary: "Add a pushover device to a user",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				name := flagSet.String("device", "", "name of the device")
+				token := flagSet.String("token", "", "pushover user or group key of the device")
+				pushoverDevices := flagSet.String("pushover-devices", "", "comma separated names of the pushover devices of the key to remind, all of them when empty")
+				noValidate := flagSet.Bool("no-validate", false, "add the device token without validating it with pushover")
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(name, "device", "device name")
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(token, "token", "pushover user or group key")
+					if err != nil {
+						return err
+					}
+
+					details, err := c.validateDeviceToken(*token, splitPushoverDevices(*pushoverDevices), *noValidate)
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					return b.AddUserDevice(user, *name, details)
+				}
+			},
+		},
+		{
+			name:    "remove",
+			summary: "Remove a pushover device from a user and the medications reminding it",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				name := flagSet.String("device", "", "name of the device")
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(name, "device", "device name")
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					err = b.RemoveUserDevice(user, *name)
+					if errors.Is(err, db.ErrDeviceInUse) {
+						return fmt.Errorf("%w, give the medication another device first", err)
+					}
+
+					return err
+				}
+			},
+		},
+		{
+			name:    "rename",
+			summary: "Rename a pushover device of a user and the medications reminding it",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				name := flagSet.String("device", "", "current name of the device")
+				newName := flagSet.String("new-device", "", "new name of the device")
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(name, "device", "device name")
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(newName, "new-device", "new device name")
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					return b.RenameUserDevice(user, *name, *newName)
+				}
+			},
+		},
+		{
+			name:    "restrict",
+			summary: "Restrict the pushover devices a device of a user reminds, every one of its key when none are given",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				name := flagSet.String("device", "", "name of the device")
+				pushoverDevices := flagSet.String("pushover-devices", "", "comma separated names of the pushover devices of the key to remind, all of them when empty")
+				noValidate := flagSet.Bool("no-validate", false, "restrict the device without validating it with pushover")
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					err = c.prompt(name, "device", "device name")
+					if err != nil {
+						return err
+					}
+
+					previous, ok := user.PushoverDevices[*name]
+					if !ok {
+						return fmt.Errorf("device %s of user %s %w", *name, user.Name, db.ErrNotFound)
+					}
+
+					restrict := splitPushoverDevices(*pushoverDevices)
+
+					details, err := c.validateDeviceToken(previous.Key, restrict, *noValidate)
+					if err != nil {
+						return err
+					}
+
+					// the last validation is kept when it is skipped
+					if *noValidate {
+						details = &db.PushoverDevice{}
+						*details = *previous
+						details.Restrict = restrict
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					return b.UpdateUserDevice(user, *name, details)
+				}
+			},
+		},
+		{
+			name:    "list",
+			summary: "List the pushover devices of a user",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					devices := devices(user)
+
+					rows := make([][]string, 0, len(devices))
+					for _, device := range devices {
+						rows = append(rows, deviceRow(device))
+					}
+
+					return c.print(*output, devices, deviceHeader, rows)
+				}
+			},
+		},
+		{
+			name:    "validate",
+			summary: "Validate the pushover devices of a user now, disabling the ones pushover rejects and enabling the ones it accepts again",
+			setup: func(c *cli, flagSet *flag.FlagSet) func() error {
+				username := flagSet.String("username", "", "name of the user")
+				name := flagSet.String("device", "", "name of the device, every device of the user when empty")
+				output := outputFlag(flagSet)
+
+				return func() error {
+					user, err := c.promptUser(username)
+					if err != nil {
+						return err
+					}
+
+					names := deviceNames(user)
+					if *name != "" {
+						names = []string{*name}
+					}
+
+					client, err := newPushoverClient(c.config)
+					if err != nil {
+						return err
+					}
+
+					b, err := c.db()
+					if err != nil {
+						return err
+					}
+
+					_, err = validateUserDevices(c.ctx, b, client, user, names)
+					if err != nil {
+						return err
+					}
+
+					devices := devices(user)
+
+					rows := make([][]string, 0, len(devices))
+					for _, device := range devices {
+						rows = append(rows, deviceRow(device))
+					}
+
+					return c.print(*output, devices, deviceHeader, rows)
+				}
+			},
+		},
+	},
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"github.com/sirupsen/logrus"
+)
+
+// errGroupRestricted occurs when a group key is restricted to devices, which
+// only user keys have
+var errGroupRestricted = errors.New("pushover group keys can't be restricted to devices")
+
+// invalidToken is true when pushover rejected the key or device validated
+// with err, rather than the application, the request or the validation
+// itself failing
+func invalidToken(err error) bool {
+	return pushover.InvalidRecipient(err) || errors.Is(err, errGroupRestricted)
+}
+
+// validateDeviceToken with pushover along with the pushover devices it is
+// restricted to, returning the details to store for it
+func validateDeviceToken(ctx context.Context, client *pushover.Client, token string, restrict []string) (*db.PushoverDevice, error) {
+	validation, err := client.Validate(ctx, token, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if validation.Group && len(restrict) > 0 {
+		return nil, errGroupRestricted
+	}
+
+	for _, device := range restrict {
+		_, err = client.Validate(ctx, token, device)
+		if err != nil {
+			return nil, fmt.Errorf("failed to validate pushover device %s: %w", device, err)
+		}
+	}
+
+	kind := db.PushoverKeyUser
+	if validation.Group {
+		kind = db.PushoverKeyGroup
+	}
+
+	return &db.PushoverDevice{
+		Key:         token,
+		Kind:        kind,
+		Restrict:    restrict,
+		Devices:     validation.Devices,
+		ValidatedAt: time.Now(),
+	}, nil
+}
+
+// sameDevice details apart from when they were validated, as long as both or
+// neither were validated
+func sameDevice(a, b *db.PushoverDevice) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	if a.ValidatedAt.IsZero() != b.ValidatedAt.IsZero() {
+		return false
+	}
+
+	x, y := *a, *b
+	x.ValidatedAt, y.ValidatedAt = time.Time{}, time.Time{}
+
+	return reflect.DeepEqual(x, y)
+}
+
+// validateUserDevices with pushover and store their details when they
+// changed, disabling the devices pushover rejects and enabling the ones it
+// accepts again. It returns the devices that were disabled. Any other failure
+// to validate a device, e.g. of the application token, stops the validation
+// before the remaining devices are validated.
+func validateUserDevices(ctx context.Context, b db.Store, client *pushover.Client, user *db.User, devices []string) ([]string, error) {
+	var disabled []string
+
+	for _, device := range devices {
+		previous, ok := user.PushoverDevices[device]
+		if !ok {
+			return disabled, fmt.Errorf("device %s of user %s %w", device, user.Name, db.ErrNotFound)
+		}
+
+		entry := logger.WithFields(logrus.Fields{
+			"user_id": user.ID.String(),
+			"device":  device,
+		})
+
+		details, err := validateDeviceToken(ctx, client, previous.Key, previous.Restrict)
+		switch {
+		case invalidToken(err):
+			// what pushover last reported for the key is kept
+			details = &db.PushoverDevice{
+				Key:            previous.Key,
+				Kind:           previous.Kind,
+				Restrict:       previous.Restrict,
+				Devices:        previous.Devices,
+				ValidatedAt:    time.Now(),
+				Disabled:       true,
+				DisabledReason: err.Error(),
+			}
+
+			if !previous.Disabled {
+				disabled = append(disabled, device)
+				entry.WithError(err).Warn("disabling device rejected by pushover")
+			}
+
+		case err != nil:
+			return disabled, fmt.Errorf("failed to validate device %s of user %s: %w", device, user.Name, err)
+
+		case previous.Disabled:
+			entry.Info("enabling device accepted by pushover again")
+		}
+
+		if sameDevice(previous, details) {
+			continue
+		}
+
+		err = b.UpdateUserDevice(user, device, details)
+		if err != nil {
+			return disabled, err
+		}
+	}
+
+	return disabled, nil
+}
+
+// deviceNames of the user sorted by name
+func deviceNames(user *db.User) []string {
+	devices := make([]string, 0, len(user.PushoverDevices))
+	for device := range user.PushoverDevices {
+		devices = append(devices, device)
+	}
+
+	sort.Strings(devices)
+
+	return devices
+}
+
+// validateDevices of every user once started and then every interval,
+// alerting the admin device whenever devices are disabled
+func validateDevices(ctx context.Context, b db.Store, pushoverClient *rotatingPushover, health *health, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		disabled, err := validateRound(ctx, b, pushoverClient.get())
+		if ctx.Err() != nil {
+			return
+		}
+
+		// the round is given up on as a whole so a problem of the application
+		// doesn't disable every device
+		health.check(alertValidation, err != nil, fmt.Sprintf("meditime failed to validate the pushover devices, the remaining ones are left as they are: %v", err))
+
+		if len(disabled) > 0 {
+			health.alert(alertDisabledDevices, fmt.Sprintf(
+				"meditime disabled %d devices pushover reported as invalid: %s",
+				len(disabled),
+				strings.Join(disabled, ", "),
+			))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// validateRound of the devices of every user, returning the ones that were
+// disabled as "<device> of <user>" until a device failed to validate
+func validateRound(ctx context.Context, b db.Store, client *pushover.Client) ([]string, error) {
+	users, err := b.ListUsers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users to validate their devices: %w", err)
+	}
+
+	var disabled []string
+	for _, user := range users {
+		userDisabled, err := validateUserDevices(ctx, b, client, user, deviceNames(user))
+		for _, device := range userDisabled {
+			disabled = append(disabled, fmt.Sprintf("%s of %s", device, user.Name))
+		}
+
+		if err != nil {
+			return disabled, err
+		}
+	}
+
+	return disabled, nil
+}
+
+// validateDeviceToken of a device being added unless skip is set, in which
+// case the device is taken to be of a user key and left unvalidated
+func (c *cli) validateDeviceToken(token string, restrict []string, skip bool) (*db.PushoverDevice, error) {
+	if skip {
+		return &db.PushoverDevice{
+			Key:      token,
+			Kind:     db.PushoverKeyUser,
+			Restrict: restrict,
+		}, nil
+	}
+
+	client, err := newPushoverClient(c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := validateDeviceToken(c.ctx, client, token, restrict)
+	if invalidToken(err) {
+		return nil, fmt.Errorf("pushover rejected the device key: %w", err)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate the device key, skip it with -no-validate: %w", err)
+	}
+
+	return details, nil
+}
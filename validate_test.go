@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"git.0xdad.com/tblyler/meditime/db"
+	"git.0xdad.com/tblyler/meditime/pushover"
+	"github.com/google/uuid"
+)
+
+// addValidatedUser bob with a laptop device keyed by token that every
+// pushover device of the key is reminded on
+func addValidatedUser(t *testing.T, b db.Store, token string) *db.User {
+	t.Helper()
+
+	user := &db.User{
+		ID:   uuid.New(),
+		Name: "bob",
+		PushoverDevices: map[string]*db.PushoverDevice{
+			"laptop": {Key: token, Kind: db.PushoverKeyUser},
+		},
+		CreatedAt: time.Now(),
+	}
+
+	err := b.AddUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func storedUser(t *testing.T, b db.Store, name string) *db.User {
+	t.Helper()
+
+	user, err := b.GetUser(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestValidateUserDevicesAccepted(t *testing.T) {
+	_, url := newFakePushover(t)
+	b := db.NewMemory()
+
+	user := addUser(t, b)
+	disabled, err := validateUserDevices(context.Background(), b, newPushover(t, url), user, deviceNames(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(disabled) != 0 {
+		t.Fatalf("expected no device to be disabled, got %v", disabled)
+	}
+
+	user = storedUser(t, b, "alice")
+	for _, device := range []string{"phone", "tablet"} {
+		details := user.PushoverDevices[device]
+		if details == nil || details.Disabled || details.ValidatedAt.IsZero() || len(details.Devices) != 2 {
+			t.Fatalf("expected the details of %s to be stored, got %+v", device, details)
+		}
+
+		if len(details.Restrict) != 1 || details.Restrict[0] != device {
+			t.Fatalf("expected %s to stay restricted, got %v", device, details.Restrict)
+		}
+	}
+}
+
+func TestValidateUserDevicesRejected(t *testing.T) {
+	fake, url := newFakePushover(t)
+	b := db.NewMemory()
+	client := newPushover(t, url)
+
+	user := addValidatedUser(t, b, "bob-key")
+	disabled, err := validateUserDevices(context.Background(), b, client, user, deviceNames(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(disabled) != 1 || disabled[0] != "laptop" {
+		t.Fatalf("expected the laptop to be disabled, got %v", disabled)
+	}
+
+	user = storedUser(t, b, "bob")
+	if !user.DeviceDisabled("laptop") || user.PushoverDevices["laptop"].DisabledReason == "" {
+		t.Fatalf("expected the laptop to be disabled with a reason, got %+v", user.PushoverDevices["laptop"])
+	}
+
+	// an already disabled device isn't reported again
+	disabled, err = validateUserDevices(context.Background(), b, client, user, deviceNames(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(disabled) != 0 {
+		t.Fatalf("expected no newly disabled device, got %v", disabled)
+	}
+
+	fake.AddUser("bob-key", "laptop")
+
+	disabled, err = validateUserDevices(context.Background(), b, client, storedUser(t, b, "bob"), deviceNames(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(disabled) != 0 {
+		t.Fatalf("expected no device to be disabled, got %v", disabled)
+	}
+
+	details := storedUser(t, b, "bob").PushoverDevices["laptop"]
+	if details == nil || details.Disabled || details.DisabledReason != "" || len(details.Devices) != 1 {
+		t.Fatalf("expected the laptop to be enabled again, got %+v", details)
+	}
+}
+
+func TestValidateUserDevicesWrongAppToken(t *testing.T) {
+	_, url := newFakePushover(t)
+	b := db.NewMemory()
+
+	client, err := pushover.New("wrong-token", url, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addUser(t, b)
+	addValidatedUser(t, b, "bob-key")
+
+	disabled, err := validateRound(context.Background(), b, client)
+	if err == nil {
+		t.Fatal("expected validating with a wrong application token to fail")
+	}
+
+	if pushover.InvalidRecipient(err) {
+		t.Fatalf("expected the application token rather than a key to be rejected, got %v", err)
+	}
+
+	if len(disabled) != 0 {
+		t.Fatalf("expected no device to be disabled, got %v", disabled)
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		for device, details := range storedUser(t, b, name).PushoverDevices {
+			if details.Disabled || !details.ValidatedAt.IsZero() {
+				t.Fatalf("expected device %s of %s to be left as it was, got %+v", device, name, details)
+			}
+		}
+	}
+}
+
+func TestValidateDevicesAlertsOnFailure(t *testing.T) {
+	_, url := newFakePushover(t)
+	b := db.NewMemory()
+	daemon := newTestDaemon(t, b, url, nil)
+
+	client, err := pushover.New("wrong-token", url, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := addUser(t, b)
+
+	ctx, cancel := context.WithCancel(daemon.ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		validateDevices(ctx, b, &rotatingPushover{client: client}, daemon.health, time.Hour)
+	}()
+
+	var notifications []*db.Notification
+	eventually(t, "the admin to be alerted", func() bool {
+		notifications, err = b.ListNotifications()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return len(notifications) > 0
+	})
+
+	cancel()
+	<-done
+
+	if len(notifications) != 1 || !strings.Contains(notifications[0].Message, "failed to validate") {
+		t.Fatalf("expected a single alert about the failed validation, got %+v", notifications)
+	}
+
+	if stored := storedUser(t, b, user.Name); stored.DeviceDisabled("phone") || stored.DeviceDisabled("tablet") {
+		t.Fatalf("expected no device to be disabled, got %+v", stored.PushoverDevices)
+	}
+}